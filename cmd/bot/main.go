@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
@@ -26,8 +32,9 @@ func main() {
 	// Check if using dev mode (mock provisioner for testing)
 	devMode := os.Getenv("DEV_MODE") == "true"
 
-	// For LocalProvisioner (production), WIREGUARD_INTERFACE, SERVER_ENDPOINT, and DNS_IPS are required
-	if !devMode {
+	// For LocalProvisioner (production), WIREGUARD_INTERFACE, SERVER_ENDPOINT, and DNS_IPS are required,
+	// unless servers are listed in WIREGUARD_SERVERS, their settings are validated by provisioners then
+	if !devMode && os.Getenv("WIREGUARD_SERVERS") == "" {
 		wgInterface := os.Getenv("WIREGUARD_INTERFACE")
 		if wgInterface == "" {
 			log.Fatal("WIREGUARD_INTERFACE environment variable is required")
@@ -44,15 +51,17 @@ func main() {
 		}
 	}
 
-	staticQRCode := os.Getenv("STATIC_QR_CODE")
-	if staticQRCode == "" {
-		log.Fatal("STATIC_QR_CODE environment variable is required")
-	}
-
+	// Static payment QR isn't used when payments are made with Telegram Payments invoices
 	paymentQRPath := os.Getenv("PAYMENT_QR_PATH")
-	if paymentQRPath == "" {
+	if paymentQRPath == "" && os.Getenv("TELEGRAM_PAYMENT_TOKEN") == "" {
 		log.Fatal("PAYMENT_QR_PATH environment variable is required")
 	}
+	// Fail fast instead of at first payment
+	if paymentQRPath != "" {
+		if err := validateImageFile(paymentQRPath); err != nil {
+			log.Fatalf("invalid PAYMENT_QR_PATH: %s", err.Error())
+		}
+	}
 
 	// Initialize storage
 	dsn := os.Getenv("DATABASE_DSN")
@@ -72,11 +81,70 @@ func main() {
 		log.Fatalf("failed to run migrations: %s", err.Error())
 	}
 
+	// Bank requisites for dynamic payment QR (optional, static QR is used when unset)
+	requisites := billing.PaymentRequisites{
+		Name:        os.Getenv("PAYMENT_RECIPIENT_NAME"),
+		PersonalAcc: os.Getenv("PAYMENT_RECIPIENT_ACCOUNT"),
+		BankName:    os.Getenv("PAYMENT_BANK_NAME"),
+		BIC:         os.Getenv("PAYMENT_BANK_BIC"),
+		CorrespAcc:  os.Getenv("PAYMENT_BANK_CORR_ACCOUNT"),
+		PayeeINN:    os.Getenv("PAYMENT_RECIPIENT_INN"),
+	}
+	if requisites.Enabled() {
+		log.Printf("Dynamic payment QR enabled for recipient: %s", requisites.Name)
+	}
+
+	pricing, err := billing.LoadPricingConfig()
+	if err != nil {
+		log.Fatalf("invalid pricing configuration: %s", err.Error())
+	}
+	log.Printf("Pricing: %d kopecks per device, discounts by days %v",
+		pricing.PricePerDevice, pricing.Discounts)
+
+	plans, err := billing.LoadPlansConfig()
+	if err != nil {
+		log.Fatalf("invalid plans configuration: %s", err.Error())
+	}
+	log.Printf("Plans: durations %v days, up to %d devices", plans.Durations, plans.MaxDevices)
+
+	// Per-user cap on payments awaiting admin review
+	maxPendingReviews := billing.DefaultMaxPendingReviewsPerUser
+	if v := os.Getenv("MAX_PENDING_PAYMENTS_PER_USER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_PENDING_PAYMENTS_PER_USER value: %s", v)
+		}
+		maxPendingReviews = n
+	}
+
+	// Days after subscription end before it expires, shared by billing and scheduler
+	gracePeriodDays := billing.DefaultGracePeriodDays
+	if v := os.Getenv("GRACE_PERIOD_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid GRACE_PERIOD_DAYS value: %s", v)
+		}
+		gracePeriodDays = n
+	}
+
+	// All services share repository clock, so stored timestamps and time checks agree
+	clock := repo.Clock()
+
 	// Initialize billing service
-	billingService := billing.NewService(repo, staticQRCode)
+	billingService := billing.NewService(repo, clock, requisites, pricing, plans, maxPendingReviews, gracePeriodDays)
+
+	// Per-user cap on active devices across all subscriptions
+	maxDevicesPerUser := access.DefaultMaxDevicesPerUser
+	if v := os.Getenv("MAX_DEVICES_PER_USER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_DEVICES_PER_USER value: %s", v)
+		}
+		maxDevicesPerUser = n
+	}
 
 	// Initialize access service
-	accessService := access.NewService(repo)
+	accessService := access.NewService(repo, clock, maxDevicesPerUser)
 
 	// Initialize telegram bot
 	tg, err := telegram.NewBot(token, repo, billingService, accessService, paymentQRPath)
@@ -84,8 +152,50 @@ func main() {
 		log.Fatalf("failed to create telegram bot: %s", err.Error())
 	}
 
+	// Unfinished payments expiration
+	paymentTTL := scheduler.DefaultPaymentTTL()
+	if v := os.Getenv("PAYMENT_CREATED_TTL_HOURS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid PAYMENT_CREATED_TTL_HOURS value: %s", v)
+		}
+		paymentTTL.Created = time.Duration(n) * time.Hour
+	}
+	if v := os.Getenv("PAYMENT_REVIEW_TTL_HOURS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid PAYMENT_REVIEW_TTL_HOURS value: %s", v)
+		}
+		paymentTTL.PendingReview = time.Duration(n) * time.Hour
+	}
+
+	// Days after grace period end before devices are revoked
+	deviceCleanupDays := scheduler.DefaultDeviceCleanupDays
+	if v := os.Getenv("DEVICE_CLEANUP_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid DEVICE_CLEANUP_DAYS value: %s", v)
+		}
+		deviceCleanupDays = n
+	}
+
+	// Days before subscription end it's marked expiring
+	expiringDays := scheduler.DefaultExpiringDays
+	if v := os.Getenv("EXPIRING_STATUS_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid EXPIRING_STATUS_DAYS value: %s", v)
+		}
+		expiringDays = n
+	}
+
+	reminderDays, err := scheduler.LoadExpiryReminderDays()
+	if err != nil {
+		log.Fatalf("invalid expiry reminders configuration: %s", err.Error())
+	}
+
 	// Initialize scheduler
-	schedulerService := scheduler.NewService(repo, tg)
+	schedulerService := scheduler.NewService(repo, tg, clock, paymentTTL, gracePeriodDays, deviceCleanupDays, expiringDays, reminderDays)
 
 	// Start scheduler in background
 	go schedulerService.Start(ctx)
@@ -112,3 +222,21 @@ func main() {
 	}()
 	<-done
 }
+
+// validateImageFile checks that path points to a readable PNG or JPEG image
+func validateImageFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s is not a PNG or JPEG image: %w", path, err)
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return fmt.Errorf("%s is an empty %s image", path, format)
+	}
+	return nil
+}
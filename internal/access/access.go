@@ -2,26 +2,45 @@ package access
 
 import (
 	"context"
-	"fmt"
-	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/skoret/wireguard-bot/internal/storage"
 )
 
+// Reason tells why a device can't be provisioned, the bot turns it into a message in user's language
+type Reason string
+
+const (
+	ReasonNoSubscription  Reason = "no_subscription"
+	ReasonExpired         Reason = "subscription_expired"
+	ReasonPaused          Reason = "subscription_paused"
+	ReasonDeviceLimit     Reason = "device_limit"
+	ReasonUserDeviceLimit Reason = "user_device_limit"
+)
+
 type CheckResult struct {
 	CanProvision bool
-	Reason       string
+	Reason       Reason
+	// Devices and Limit are set for device limit reasons
+	Devices int
+	Limit   int
 }
 
+// DefaultMaxDevicesPerUser is the default cap on active devices per user across all subscriptions
+const DefaultMaxDevicesPerUser = 10
+
 type Service struct {
-	repo *storage.Repository
+	repo              *storage.Repository
+	maxDevicesPerUser int // 0 disables the per-user cap
+	clock             storage.Clock
 }
 
-func NewService(repo *storage.Repository) *Service {
+func NewService(repo *storage.Repository, clock storage.Clock, maxDevicesPerUser int) *Service {
 	return &Service{
-		repo: repo,
+		repo:              repo,
+		clock:             clock,
+		maxDevicesPerUser: maxDevicesPerUser,
 	}
 }
 
@@ -36,29 +55,29 @@ func (s *Service) CanProvisionDevice(ctx context.Context, userID int64) (*CheckR
 	if subscription == nil {
 		return &CheckResult{
 			CanProvision: false,
-			Reason:       "У вас нет активной подписки. Оформите оплату через меню бота.",
+			Reason:       ReasonNoSubscription,
 		}, nil
 	}
 
 	// Check subscription status
-	now := time.Now()
+	now := s.clock.Now()
 	switch subscription.Status {
 	case storage.SubscriptionStatusExpired:
 		return &CheckResult{
 			CanProvision: false,
-			Reason:       "Ваша подписка истекла. Оформите продление через меню бота.",
+			Reason:       ReasonExpired,
 		}, nil
 	case storage.SubscriptionStatusPaused:
 		// In grace period
 		if subscription.GracePeriodEndsAt != nil && now.After(*subscription.GracePeriodEndsAt) {
 			return &CheckResult{
 				CanProvision: false,
-				Reason:       "Ваша подписка истекла. Оформите продление через меню бота.",
+				Reason:       ReasonExpired,
 			}, nil
 		}
 		return &CheckResult{
 			CanProvision: false,
-			Reason:       "Ваша подписка приостановлена. Оформите продление через меню бота.",
+			Reason:       ReasonPaused,
 		}, nil
 	}
 
@@ -66,7 +85,7 @@ func (s *Service) CanProvisionDevice(ctx context.Context, userID int64) (*CheckR
 	if now.After(subscription.EndsAt) {
 		return &CheckResult{
 			CanProvision: false,
-			Reason:       "Ваша подписка истекла. Оформите продление через меню бота.",
+			Reason:       ReasonExpired,
 		}, nil
 	}
 
@@ -79,16 +98,45 @@ func (s *Service) CanProvisionDevice(ctx context.Context, userID int64) (*CheckR
 	if deviceCount >= subscription.DeviceLimit {
 		return &CheckResult{
 			CanProvision: false,
-			Reason: fmt.Sprintf("Достигнут лимит устройств (%d/%d). Отзовите одно из устройств или оформите продление с большим количеством устройств.",
-				deviceCount, subscription.DeviceLimit),
+			Reason:       ReasonDeviceLimit,
+			Devices:      deviceCount,
+			Limit:        subscription.DeviceLimit,
 		}, nil
 	}
 
+	// Check per-user device cap across all subscriptions
+	if s.maxDevicesPerUser > 0 {
+		userDeviceCount, err := s.repo.CountActiveDevicesByUser(ctx, userID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to count user devices")
+		}
+
+		if userDeviceCount >= s.maxDevicesPerUser {
+			return &CheckResult{
+				CanProvision: false,
+				Reason:       ReasonUserDeviceLimit,
+				Devices:      userDeviceCount,
+				Limit:        s.maxDevicesPerUser,
+			}, nil
+		}
+	}
+
 	return &CheckResult{
 		CanProvision: true,
-		Reason:       "",
 	}, nil
 }
 
 
 
+
+// RemainingDeviceSlots returns how many more devices can be added to subscription
+func (s *Service) RemainingDeviceSlots(ctx context.Context, subscription *storage.Subscription) (int, error) {
+	deviceCount, err := s.repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count devices")
+	}
+	if deviceCount >= subscription.DeviceLimit {
+		return 0, nil
+	}
+	return subscription.DeviceLimit - deviceCount, nil
+}
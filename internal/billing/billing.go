@@ -5,8 +5,8 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"math"
-	"time"
 
 	"github.com/pkg/errors"
 
@@ -14,24 +14,54 @@ import (
 )
 
 const (
-	BasePricePerDevice = 10000 // 100 RUB in kopecks
+	BasePricePerDevice = 10000 // 100 RUB in kopecks, default price
+
+	// DefaultMaxPendingReviewsPerUser is the default cap on user's payments awaiting admin review
+	DefaultMaxPendingReviewsPerUser = 1
+
+	// DefaultGracePeriodDays is the default number of days after subscription end before it expires
+	DefaultGracePeriodDays = 3
+)
+
+var (
+	// ErrPaymentCancelled is returned when reviewing a payment cancelled by user
+	ErrPaymentCancelled = errors.New("payment was cancelled by user")
+	// ErrPaymentAlreadyProcessed is returned when reviewing a payment that is not pending review anymore
+	ErrPaymentAlreadyProcessed = errors.New("payment is already processed")
+	// ErrSecondApprovalRequired is returned when the first approval of a large payment was recorded
+	// and payment awaits approval by another admin
+	ErrSecondApprovalRequired = errors.New("payment requires approval by another admin")
+	// ErrSameApprover is returned when admin who gave the first approval tries to give the second one
+	ErrSameApprover = errors.New("payment was already approved by this admin")
+	// ErrPendingReviewLimit is returned when user already has maximum number of payments awaiting review
+	ErrPendingReviewLimit = errors.New("too many payments awaiting review")
 )
 
 type Service struct {
-	repo          *storage.Repository
-	staticQRCode  string // Static QR code for all payments
+	repo              *storage.Repository
+	requisites        PaymentRequisites // Bank requisites for dynamic payment QR
+	pricing           PricingConfig
+	plans             PlansConfig
+	maxPendingReviews int // 0 disables the per-user cap
+	gracePeriodDays   int // Days after subscription end before it expires
+	clock             storage.Clock
 }
 
-func NewService(repo *storage.Repository, staticQRCode string) *Service {
+func NewService(repo *storage.Repository, clock storage.Clock, requisites PaymentRequisites, pricing PricingConfig, plans PlansConfig, maxPendingReviews int, gracePeriodDays int) *Service {
 	return &Service{
-		repo:         repo,
-		staticQRCode: staticQRCode,
+		repo:              repo,
+		clock:             clock,
+		requisites:        requisites,
+		pricing:           pricing,
+		plans:             plans,
+		maxPendingReviews: maxPendingReviews,
+		gracePeriodDays:   gracePeriodDays,
 	}
 }
 
-// GetStaticQRCode returns the static QR code for payments
-func (s *Service) GetStaticQRCode() string {
-	return s.staticQRCode
+// Plans returns allowed subscription plans
+func (s *Service) Plans() PlansConfig {
+	return s.plans
 }
 
 // GenerateReferenceCode generates a unique reference code for payment
@@ -45,44 +75,117 @@ func (s *Service) GenerateReferenceCode() (string, error) {
 
 // CalculatePrice calculates the price based on duration and device count
 func (s *Service) CalculatePrice(durationDays, deviceCount int) int {
-	var multiplier float64
-	switch durationDays {
-	case 30:
-		multiplier = 1.0
-	case 90:
-		multiplier = 0.95
-	case 180:
-		multiplier = 0.90
-	default:
-		multiplier = 1.0
-	}
+	multiplier := s.pricing.multiplier(durationDays)
 
-	basePrice := BasePricePerDevice * deviceCount
+	basePrice := s.pricing.PricePerDevice * deviceCount
 	return int(math.Round(float64(basePrice) * multiplier))
 }
 
-// CreatePaymentAttempt creates a new payment attempt
-func (s *Service) CreatePaymentAttempt(ctx context.Context, userID int64, durationDays, deviceCount int) (*storage.Payment, error) {
+// CreatePaymentAttempt creates a new payment attempt, applying promo code discount if promoCode is not empty.
+// serverID is the WireGuard server the first device is provisioned on after approval, empty for the default one.
+//
+// User has at most one payment attempt in created status: if the latest one has the same plan,
+// server and promo code, it is returned as is, so walking the payment flow again doesn't produce
+// a new reference code and comment. Otherwise a new payment is created and all older
+// created payments of the user are cancelled
+func (s *Service) CreatePaymentAttempt(ctx context.Context, userID int64, durationDays, deviceCount int, promoCode, serverID string) (*storage.Payment, error) {
 	// Validate inputs
-	if durationDays != 30 && durationDays != 90 && durationDays != 180 {
-		return nil, errors.New("invalid duration: must be 30, 90, or 180 days")
+	if !s.plans.AllowsDuration(durationDays) {
+		return nil, fmt.Errorf("invalid duration: must be one of %v days", s.plans.Durations)
+	}
+	if !s.plans.AllowsDeviceCount(deviceCount) {
+		return nil, fmt.Errorf("invalid device count: must be between 1 and %d", s.plans.MaxDevices)
+	}
+
+	existing, err := s.reusablePaymentAttempt(ctx, userID, durationDays, deviceCount, promoCode, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
 	}
-	if deviceCount < 1 || deviceCount > 5 {
-		return nil, errors.New("invalid device count: must be between 1 and 5")
+
+	payment, err := s.createPaymentAttempt(ctx, userID, durationDays, deviceCount, promoCode, serverID)
+	if err != nil {
+		return nil, err
 	}
 
+	cancelled, err := s.repo.CancelStalePaymentsForUser(ctx, userID, payment.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to cancel stale payments")
+	}
+	if cancelled > 0 {
+		log.Printf("Cancelled %d stale payment attempt(s) of user %d", cancelled, userID)
+	}
+	return payment, nil
+}
+
+// reusablePaymentAttempt returns user's latest created payment if it matches requested plan, server and promo code
+func (s *Service) reusablePaymentAttempt(ctx context.Context, userID int64, durationDays, deviceCount int, promoCode, serverID string) (*storage.Payment, error) {
+	payments, err := s.repo.GetPaymentsByUserIDAndStatus(ctx, userID, storage.PaymentStatusCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get created payments")
+	}
+	if len(payments) == 0 {
+		return nil, nil
+	}
+
+	latest := payments[len(payments)-1]
+	if latest.DurationDays != durationDays || latest.DeviceCount != deviceCount || latest.ServerID != serverID {
+		return nil, nil
+	}
+	if promoCode != "" {
+		if latest.PromoCode != NormalizePromoCode(promoCode) {
+			return nil, nil
+		}
+	} else if latest.PromoCode != "" || latest.Amount != s.CalculatePrice(durationDays, deviceCount) {
+		// Prices may have changed since the payment was created
+		return nil, nil
+	}
+	return latest, nil
+}
+
+// maxPaymentCodeAttempts is how many times payment creation is retried with fresh
+// reference code and comment when generated ones collide with existing payments
+const maxPaymentCodeAttempts = 5
+
+// generatePaymentComment is replaced in tests to force comment collisions
+var generatePaymentComment = GeneratePaymentComment
+
+func (s *Service) createPaymentAttempt(ctx context.Context, userID int64, durationDays, deviceCount int, promoCode, serverID string) (*storage.Payment, error) {
+	amount := s.CalculatePrice(durationDays, deviceCount)
+
+	var promo *storage.PromoCode
+	if promoCode != "" {
+		var err error
+		promo, err = s.ValidatePromoCode(ctx, promoCode)
+		if err != nil {
+			return nil, err
+		}
+		amount = ApplyPromoCode(amount, promo)
+	}
+
+	for attempt := 1; ; attempt++ {
+		payment, err := s.insertPaymentAttempt(ctx, userID, durationDays, deviceCount, amount, promo, serverID)
+		if !errors.Is(err, storage.ErrDuplicatePaymentCode) || attempt == maxPaymentCodeAttempts {
+			return payment, err
+		}
+		log.Printf("Payment code collision for user %d, regenerating (attempt %d)", userID, attempt)
+	}
+}
+
+// insertPaymentAttempt stores payment with freshly generated reference code and comment
+func (s *Service) insertPaymentAttempt(ctx context.Context, userID int64, durationDays, deviceCount, amount int, promo *storage.PromoCode, serverID string) (*storage.Payment, error) {
 	referenceCode, err := s.GenerateReferenceCode()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to generate reference code")
 	}
 
-	paymentComment, err := GeneratePaymentComment()
+	paymentComment, err := generatePaymentComment()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to generate payment comment")
 	}
 
-	amount := s.CalculatePrice(durationDays, deviceCount)
-
 	payment := &storage.Payment{
 		UserID:         userID,
 		DurationDays:   durationDays,
@@ -91,6 +194,18 @@ func (s *Service) CreatePaymentAttempt(ctx context.Context, userID int64, durati
 		ReferenceCode:  referenceCode,
 		PaymentComment: paymentComment,
 		Status:         storage.PaymentStatusCreated,
+		ServerID:       serverID,
+	}
+
+	if promo != nil {
+		payment.PromoCode = promo.Code
+		if err := s.repo.CreatePaymentWithPromoCode(ctx, payment); err != nil {
+			if errors.Is(err, storage.ErrPromoCodeUnavailable) {
+				return nil, ErrInvalidPromoCode
+			}
+			return nil, errors.Wrap(err, "failed to create payment")
+		}
+		return payment, nil
 	}
 
 	if err := s.repo.CreatePayment(ctx, payment); err != nil {
@@ -100,17 +215,56 @@ func (s *Service) CreatePaymentAttempt(ctx context.Context, userID int64, durati
 	return payment, nil
 }
 
-// AttachProofAndMoveToPendingReview attaches proof file and moves payment to pending review
-func (s *Service) AttachProofAndMoveToPendingReview(ctx context.Context, paymentID int64, proofFileID string) error {
-	if err := s.repo.AttachProofToPayment(ctx, paymentID, proofFileID); err != nil {
+// CancelPaymentAttempt cancels payment attempt that user hasn't confirmed yet
+func (s *Service) CancelPaymentAttempt(ctx context.Context, paymentID int64) error {
+	cancelled, err := s.repo.CancelCreatedPayment(ctx, paymentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to cancel payment")
+	}
+	if !cancelled {
+		return errors.New("payment is not in created status")
+	}
+	return nil
+}
+
+// CheckPendingReviewLimit returns ErrPendingReviewLimit if user can't submit another payment for review
+// until admins process the ones already submitted
+func (s *Service) CheckPendingReviewLimit(ctx context.Context, userID int64) error {
+	if s.maxPendingReviews == 0 {
+		return nil
+	}
+	pending := 0
+	for _, status := range []storage.PaymentStatus{storage.PaymentStatusPendingReview, storage.PaymentStatusPendingSecondApproval} {
+		payments, err := s.repo.GetPaymentsByUserIDAndStatus(ctx, userID, status)
+		if err != nil {
+			return errors.Wrap(err, "failed to get payments")
+		}
+		pending += len(payments)
+	}
+	if pending >= s.maxPendingReviews {
+		return ErrPendingReviewLimit
+	}
+	return nil
+}
+
+// AttachProofAndMoveToPendingReview attaches proof file (photo or document) and moves payment to pending review
+func (s *Service) AttachProofAndMoveToPendingReview(ctx context.Context, paymentID int64, proofFileID string, isDocument bool) error {
+	attached, err := s.repo.AttachProofToPayment(ctx, paymentID, proofFileID, isDocument)
+	if err != nil {
 		return errors.Wrap(err, "failed to attach proof to payment")
 	}
+	if !attached {
+		return ErrPaymentAlreadyProcessed
+	}
 	return nil
 }
 
 // AdminApprovePayment approves a payment and creates/extends subscription
-// It verifies payment amount, payment comment match, and uploaded proof
-func (s *Service) AdminApprovePayment(ctx context.Context, paymentID int64, reviewedBy string, verifiedComment string) error {
+// It verifies payment amount, payment comment match, and uploaded proof.
+// verifiedAmount is the actually received amount in kopecks, 0 if reviewer didn't enter it.
+// Approval with received amount different from payment amount fails with ErrPaymentMismatch,
+// unless allowAmountMismatch is set
+func (s *Service) AdminApprovePayment(ctx context.Context, paymentID int64, reviewedBy string, verifiedComment string, verifiedAmount int, allowAmountMismatch bool) error {
 	payment, err := s.repo.GetPaymentByID(ctx, paymentID)
 	if err != nil {
 		return errors.Wrap(err, "failed to get payment")
@@ -118,8 +272,8 @@ func (s *Service) AdminApprovePayment(ctx context.Context, paymentID int64, revi
 	if payment == nil {
 		return errors.New("payment not found")
 	}
-	if payment.Status != storage.PaymentStatusPendingReview {
-		return fmt.Errorf("payment is not in pending_review status: %s", payment.Status)
+	if err := paymentStatusError(payment.Status); err != nil {
+		return err
 	}
 
 	// Verify payment comment match
@@ -127,52 +281,73 @@ func (s *Service) AdminApprovePayment(ctx context.Context, paymentID int64, revi
 		return fmt.Errorf("payment comment mismatch: expected '%s', got '%s'. Payment without correct comment MUST NOT be approved", payment.PaymentComment, verifiedComment)
 	}
 
-	// Note: Proof verification is optional in simplified flow
-	// Admin can approve without proof if they verify payment manually
-
-	// Update payment status
-	if err := s.repo.UpdatePaymentStatus(ctx, paymentID, storage.PaymentStatusApproved, &reviewedBy); err != nil {
-		return errors.Wrap(err, "failed to update payment status")
+	// Verify received amount, partial payment must not be approved by mistake
+	if verifiedAmount < 0 {
+		return errors.Wrapf(ErrPaymentMismatch, "received amount %d is negative", verifiedAmount)
 	}
-
-	// Get or create active subscription
-	activeSub, err := s.repo.GetActiveSubscriptionByUserID(ctx, payment.UserID)
-	if err != nil {
-		return errors.Wrap(err, "failed to get active subscription")
+	amountMismatch := verifiedAmount != 0 && verifiedAmount != payment.Amount
+	if amountMismatch && !allowAmountMismatch {
+		return &AmountMismatchError{Received: verifiedAmount, Expected: payment.Amount}
 	}
 
-	now := time.Now()
-	if activeSub != nil {
-		// Extend existing subscription
-		if err := s.repo.ExtendSubscription(ctx, activeSub.ID, payment.DurationDays, payment.Amount); err != nil {
-			return errors.Wrap(err, "failed to extend subscription")
+	// Note: Proof verification is optional in simplified flow
+	// Admin can approve without proof if they verify payment manually
+
+	// Large payments need approvals from two different admins
+	if s.pricing.requiresSecondApproval(payment.Amount) {
+		if payment.Status == storage.PaymentStatusPendingReview {
+			recorded, err := s.repo.RecordFirstApproval(ctx, paymentID, reviewedBy)
+			if err != nil {
+				return errors.Wrap(err, "failed to record first approval")
+			}
+			if !recorded {
+				return ErrPaymentAlreadyProcessed
+			}
+			log.Printf("Payment %d got first approval from %s, awaiting second admin", paymentID, reviewedBy)
+			s.recordAudit(ctx, reviewedBy, storage.AuditActionFirstApproval, payment, "")
+			return ErrSecondApprovalRequired
 		}
-	} else {
-		// Create new subscription
-		endsAt := now.AddDate(0, 0, payment.DurationDays)
-		gracePeriodEndsAt := endsAt.AddDate(0, 0, 3)
-
-		subscription := &storage.Subscription{
-			UserID:            payment.UserID,
-			DurationDays:      payment.DurationDays,
-			DeviceLimit:       payment.DeviceCount,
-			Amount:            payment.Amount,
-			Status:            storage.SubscriptionStatusActive,
-			StartsAt:          now,
-			EndsAt:            endsAt,
-			GracePeriodEndsAt: &gracePeriodEndsAt,
+		if payment.FirstApprovedBy == reviewedBy {
+			return ErrSameApprover
 		}
+	}
 
-		if err := s.repo.CreateSubscription(ctx, subscription); err != nil {
-			return errors.Wrap(err, "failed to create subscription")
+	// Update payment status and create/extend subscription atomically,
+	// status is checked again in case payment was cancelled or reviewed meanwhile
+	if err := s.repo.ApprovePayment(ctx, paymentID, reviewedBy, s.gracePeriodDays); err != nil {
+		var statusErr *storage.PaymentStatusError
+		if errors.As(err, &statusErr) {
+			// Payment still awaiting second approval was refused because first approval is this admin's
+			if statusErr.Status == storage.PaymentStatusPendingSecondApproval {
+				return ErrSameApprover
+			}
+			return paymentStatusError(statusErr.Status)
 		}
+		return errors.Wrap(err, "failed to approve payment")
+	}
+	received := 0
+	if amountMismatch {
+		received = verifiedAmount
 	}
+	s.recordApprovalAudit(ctx, reviewedBy, payment, planDetails(payment), received)
 
 	return nil
 }
 
-// AdminRejectPayment rejects a payment
-func (s *Service) AdminRejectPayment(ctx context.Context, paymentID int64, reviewedBy string) error {
+// paymentStatusError returns error describing why payment in given status can't be reviewed, nil if it can
+func paymentStatusError(status storage.PaymentStatus) error {
+	switch status {
+	case storage.PaymentStatusPendingReview, storage.PaymentStatusPendingSecondApproval:
+		return nil
+	case storage.PaymentStatusCancelled:
+		return ErrPaymentCancelled
+	default:
+		return errors.Wrapf(ErrPaymentAlreadyProcessed, "payment is in %s status", status)
+	}
+}
+
+// AdminRejectPayment rejects a payment with optional reason
+func (s *Service) AdminRejectPayment(ctx context.Context, paymentID int64, reviewedBy string, reason string) error {
 	payment, err := s.repo.GetPaymentByID(ctx, paymentID)
 	if err != nil {
 		return errors.Wrap(err, "failed to get payment")
@@ -180,17 +355,62 @@ func (s *Service) AdminRejectPayment(ctx context.Context, paymentID int64, revie
 	if payment == nil {
 		return errors.New("payment not found")
 	}
-	if payment.Status != storage.PaymentStatusPendingReview {
-		return fmt.Errorf("payment is not in pending_review status: %s", payment.Status)
+	if err := paymentStatusError(payment.Status); err != nil {
+		return err
 	}
 
-	if err := s.repo.UpdatePaymentStatus(ctx, paymentID, storage.PaymentStatusRejected, &reviewedBy); err != nil {
+	if err := s.repo.RejectPayment(ctx, paymentID, &reviewedBy, reason); err != nil {
+		var statusErr *storage.PaymentStatusError
+		if errors.As(err, &statusErr) {
+			return paymentStatusError(statusErr.Status)
+		}
 		return errors.Wrap(err, "failed to update payment status")
 	}
+	s.recordAudit(ctx, reviewedBy, storage.AuditActionRejectPayment, payment, reason)
 
 	return nil
 }
 
+// recordAudit records admin action on payment in the audit log. The action has already
+// been committed at this point, so failure to record it is only logged
+func (s *Service) recordAudit(ctx context.Context, actor string, action storage.AuditAction, payment *storage.Payment, details string) {
+	s.saveAudit(ctx, payment, &storage.AuditEntry{
+		Actor:   actor,
+		Action:  action,
+		Details: details,
+	})
+}
+
+// recordApprovalAudit records payment approval with payment amount in the audit log,
+// received is the amount actually received when it differs from payment amount, 0 otherwise.
+// Amounts are stored in kopecks and formatted by the reader
+func (s *Service) recordApprovalAudit(ctx context.Context, actor string, payment *storage.Payment, details string, received int) {
+	entry := &storage.AuditEntry{
+		Actor:   actor,
+		Action:  storage.AuditActionApprovePayment,
+		Details: details,
+		Amount:  &payment.Amount,
+	}
+	if received != 0 {
+		entry.ReceivedAmount = &received
+	}
+	s.saveAudit(ctx, payment, entry)
+}
+
+// saveAudit records audit entry of payment, failure is only logged
+func (s *Service) saveAudit(ctx context.Context, payment *storage.Payment, entry *storage.AuditEntry) {
+	entry.PaymentID = &payment.ID
+	entry.UserID = &payment.UserID
+	if err := s.repo.RecordAudit(ctx, entry); err != nil {
+		log.Printf("failed to record audit entry %s of payment %d by %s: %v", entry.Action, payment.ID, entry.Actor, err)
+	}
+}
+
+// planDetails describes payment plan for the audit log
+func planDetails(payment *storage.Payment) string {
+	return fmt.Sprintf("%d days, %d devices", payment.DurationDays, payment.DeviceCount)
+}
+
 // GetPendingPayments returns all payments pending review
 func (s *Service) GetPendingPayments(ctx context.Context) ([]*storage.Payment, error) {
 	payments, err := s.repo.GetPendingPayments(ctx)
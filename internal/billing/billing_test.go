@@ -0,0 +1,347 @@
+package billing
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// newTestService returns service with default pricing and plans backed by in-memory database
+func newTestService(t *testing.T) (*Service, *storage.Repository) {
+	t.Helper()
+	repo, err := storage.NewRepository(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := repo.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	service := NewService(repo, repo.Clock(), PaymentRequisites{}, DefaultPricingConfig(), DefaultPlansConfig(),
+		DefaultMaxPendingReviewsPerUser, DefaultGracePeriodDays)
+	return service, repo
+}
+
+// createTestUser creates user with given Telegram ID
+func createTestUser(t *testing.T, repo *storage.Repository, telegramID int64) *storage.User {
+	t.Helper()
+	user, err := repo.GetOrCreateUser(context.Background(), telegramID, "user")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func TestAdminApproveCancelledPayment(t *testing.T) {
+	s, repo := newTestService(t)
+	ctx := context.Background()
+	user := createTestUser(t, repo, 1)
+	payment, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	if err := s.CancelPaymentAttempt(ctx, payment.ID); err != nil {
+		t.Fatalf("failed to cancel payment: %v", err)
+	}
+
+	err = s.AdminApprovePayment(ctx, payment.ID, "admin", payment.PaymentComment, 0, false)
+	if !errors.Is(err, ErrPaymentCancelled) {
+		t.Fatalf("AdminApprovePayment() = %v, want ErrPaymentCancelled", err)
+	}
+	subscription, err := repo.GetActiveSubscriptionByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	if subscription != nil {
+		t.Errorf("subscription %d created for cancelled payment", subscription.ID)
+	}
+}
+
+func TestCreatePaymentAttemptReusesOrCancelsCreated(t *testing.T) {
+	s, repo := newTestService(t)
+	ctx := context.Background()
+	user := createTestUser(t, repo, 1)
+
+	first, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	again, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	if again.ID != first.ID || again.ReferenceCode != first.ReferenceCode || again.PaymentComment != first.PaymentComment {
+		t.Errorf("same plan created payment %d, want payment %d reused", again.ID, first.ID)
+	}
+
+	other, err := s.CreatePaymentAttempt(ctx, user.ID, 90, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	if other.ID == first.ID {
+		t.Fatalf("other plan reused payment %d", first.ID)
+	}
+	assertPaymentStatus(t, repo, first.ID, storage.PaymentStatusCancelled)
+	assertPaymentStatus(t, repo, other.ID, storage.PaymentStatusCreated)
+
+	// Payments already submitted for review aren't touched by a new attempt
+	if err := s.AttachProofAndMoveToPendingReview(ctx, other.ID, "proof", false); err != nil {
+		t.Fatalf("failed to submit payment: %v", err)
+	}
+	next, err := s.CreatePaymentAttempt(ctx, user.ID, 90, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	if next.ID == other.ID {
+		t.Fatalf("submitted payment %d reused", other.ID)
+	}
+	assertPaymentStatus(t, repo, other.ID, storage.PaymentStatusPendingReview)
+	assertPaymentStatus(t, repo, next.ID, storage.PaymentStatusCreated)
+}
+
+func TestCreatePaymentAttemptRetriesCommentCollision(t *testing.T) {
+	s, repo := newTestService(t)
+	ctx := context.Background()
+	user := createTestUser(t, repo, 1)
+	taken, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+
+	// Comments collide with the existing payment twice, then a free one is generated
+	defer func(generate func() (string, error)) { generatePaymentComment = generate }(generatePaymentComment)
+	calls := 0
+	generatePaymentComment = func() (string, error) {
+		calls++
+		if calls <= 2 {
+			return taken.PaymentComment, nil
+		}
+		return "тихий лес 42", nil
+	}
+	other := createTestUser(t, repo, 2)
+	payment, err := s.CreatePaymentAttempt(ctx, other.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("payment creation wasn't retried: %v", err)
+	}
+	if payment.PaymentComment != "тихий лес 42" || calls != 3 {
+		t.Errorf("payment comment %q after %d attempts, want free comment after 3", payment.PaymentComment, calls)
+	}
+
+	// Collisions on every attempt give up after maxPaymentCodeAttempts
+	calls = 0
+	generatePaymentComment = func() (string, error) {
+		calls++
+		return taken.PaymentComment, nil
+	}
+	third := createTestUser(t, repo, 3)
+	if _, err := s.CreatePaymentAttempt(ctx, third.ID, 30, 1, "", ""); !errors.Is(err, storage.ErrDuplicatePaymentCode) {
+		t.Fatalf("CreatePaymentAttempt() = %v, want ErrDuplicatePaymentCode", err)
+	}
+	if calls != maxPaymentCodeAttempts {
+		t.Errorf("%d attempts, want %d", calls, maxPaymentCodeAttempts)
+	}
+}
+
+func TestAdminApproveAmountMismatch(t *testing.T) {
+	s, repo := newTestService(t)
+	ctx := context.Background()
+	user := createTestUser(t, repo, 1)
+	payment, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	if err := s.AttachProofAndMoveToPendingReview(ctx, payment.ID, "proof", false); err != nil {
+		t.Fatalf("failed to submit payment: %v", err)
+	}
+
+	received := payment.Amount - 100
+	err = s.AdminApprovePayment(ctx, payment.ID, "admin", payment.PaymentComment, received, false)
+	var mismatch *AmountMismatchError
+	if !errors.As(err, &mismatch) || !errors.Is(err, ErrPaymentMismatch) {
+		t.Fatalf("AdminApprovePayment() = %v, want AmountMismatchError", err)
+	}
+	if mismatch.Received != received || mismatch.Expected != payment.Amount {
+		t.Errorf("mismatch %+v, want received %d, expected %d", mismatch, received, payment.Amount)
+	}
+
+	// Approval despite mismatch records both amounts in kopecks
+	if err := s.AdminApprovePayment(ctx, payment.ID, "admin", payment.PaymentComment, received, true); err != nil {
+		t.Fatalf("AdminApprovePayment() = %v", err)
+	}
+	entries, err := repo.GetAuditEntries(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to get audit entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Amount == nil || *entries[0].Amount != payment.Amount ||
+		entries[0].ReceivedAmount == nil || *entries[0].ReceivedAmount != received {
+		t.Fatalf("audit entries %+v, want approval with amount %d received %d", entries, payment.Amount, received)
+	}
+	if strings.Contains(entries[0].Details, "руб") {
+		t.Errorf("audit details %q contain formatted currency", entries[0].Details)
+	}
+}
+
+func TestFixedPromoDiscountKeepsMinPaymentAmount(t *testing.T) {
+	s, repo := newTestService(t)
+	ctx := context.Background()
+	price := s.CalculatePrice(30, 1)
+	for i, discount := range []int{price, price + 10000} {
+		code := fmt.Sprintf("FREE-%d", i)
+		if _, err := s.CreatePromoCode(ctx, code, 0, discount, 0, 0); err != nil {
+			t.Fatalf("CreatePromoCode() = %v", err)
+		}
+		user := createTestUser(t, repo, int64(i+1))
+		payment, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, code, "")
+		if err != nil {
+			t.Fatalf("CreatePaymentAttempt() = %v", err)
+		}
+		if payment.Amount != MinPaymentAmount {
+			t.Errorf("payment amount with discount %d off price %d is %d, want %d", discount, price, payment.Amount, MinPaymentAmount)
+		}
+	}
+
+	// Discount never raises price that is lower than the minimum
+	if got := ApplyPromoCode(50, &storage.PromoCode{DiscountAmount: 100}); got != 50 {
+		t.Errorf("ApplyPromoCode(50) = %d, want 50", got)
+	}
+}
+
+// signedYooMoneyForm returns YooMoney notification form signed with secret
+func signedYooMoneyForm(secret, label, amount, withdrawAmount string) url.Values {
+	form := url.Values{
+		"notification_type": {"p2p-incoming"},
+		"operation_id":      {"1234567"},
+		"amount":            {amount},
+		"withdraw_amount":   {withdrawAmount},
+		"currency":          {"643"},
+		"datetime":          {"2024-01-01T00:00:00Z"},
+		"sender":            {"41001000040"},
+		"codepro":           {"false"},
+		"label":             {label},
+	}
+	sum := sha1.Sum([]byte(strings.Join([]string{
+		form.Get("notification_type"), form.Get("operation_id"), form.Get("amount"), form.Get("currency"),
+		form.Get("datetime"), form.Get("sender"), form.Get("codepro"), secret, form.Get("label"),
+	}, "&")))
+	form.Set("sha1_hash", hex.EncodeToString(sum[:]))
+	return form
+}
+
+func TestYooMoneyNotificationIgnoresTamperedWithdrawAmount(t *testing.T) {
+	s, repo := newTestService(t)
+	ctx := context.Background()
+	user := createTestUser(t, repo, 1)
+	payment, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	if err := s.AttachProofAndMoveToPendingReview(ctx, payment.ID, "proof", false); err != nil {
+		t.Fatalf("failed to submit payment: %v", err)
+	}
+	provider, err := NewWebhookProvider(WebhookProviderYooMoney, "secret")
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	// withdraw_amount isn't signed, so it's raised to the price without breaking the signature
+	half := fmt.Sprintf("%.2f", float64(payment.Amount)/200.0)
+	full := fmt.Sprintf("%.2f", float64(payment.Amount)/100.0)
+	notification, err := provider.ParseNotification(signedYooMoneyForm("secret", payment.PaymentComment, half, full))
+	if err != nil {
+		t.Fatalf("ParseNotification() = %v", err)
+	}
+	if notification.Amount != payment.Amount/2 {
+		t.Errorf("notification amount %d, want signed amount %d", notification.Amount, payment.Amount/2)
+	}
+	if _, err := s.ConfirmWebhookPayment(ctx, provider.Name(), notification); !errors.Is(err, ErrPaymentMismatch) {
+		t.Fatalf("ConfirmWebhookPayment() = %v, want ErrPaymentMismatch", err)
+	}
+	assertPaymentStatus(t, repo, payment.ID, storage.PaymentStatusPendingReview)
+
+	// Credited amount smaller by provider fee is full payment
+	credited := fmt.Sprintf("%.2f", float64(payment.Amount)*0.97/100.0)
+	notification, err = provider.ParseNotification(signedYooMoneyForm("secret", payment.PaymentComment, credited, full))
+	if err != nil {
+		t.Fatalf("ParseNotification() = %v", err)
+	}
+	if _, err := s.ConfirmWebhookPayment(ctx, provider.Name(), notification); err != nil {
+		t.Fatalf("ConfirmWebhookPayment() = %v", err)
+	}
+	assertPaymentStatus(t, repo, payment.ID, storage.PaymentStatusApproved)
+}
+
+func TestWebhookPaymentBeforeProofIsApproved(t *testing.T) {
+	s, repo := newTestService(t)
+	ctx := context.Background()
+	user := createTestUser(t, repo, 1)
+	payment, err := s.CreatePaymentAttempt(ctx, user.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	provider, err := NewWebhookProvider(WebhookProviderYooMoney, "secret")
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	// Transfer arrives while payment still waits for proof
+	amount := fmt.Sprintf("%.2f", float64(payment.Amount)/100.0)
+	notification, err := provider.ParseNotification(signedYooMoneyForm("secret", payment.PaymentComment, amount, amount))
+	if err != nil {
+		t.Fatalf("ParseNotification() = %v", err)
+	}
+	if _, err := s.ConfirmWebhookPayment(ctx, provider.Name(), notification); err != nil {
+		t.Fatalf("ConfirmWebhookPayment() = %v", err)
+	}
+	assertPaymentStatus(t, repo, payment.ID, storage.PaymentStatusApproved)
+
+	// Partial transfer before proof leaves payment in review for admins
+	other := createTestUser(t, repo, 2)
+	partial, err := s.CreatePaymentAttempt(ctx, other.ID, 30, 1, "", "")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	half := fmt.Sprintf("%.2f", float64(partial.Amount)/200.0)
+	notification, err = provider.ParseNotification(signedYooMoneyForm("secret", partial.PaymentComment, half, half))
+	if err != nil {
+		t.Fatalf("ParseNotification() = %v", err)
+	}
+	if _, err := s.ConfirmWebhookPayment(ctx, provider.Name(), notification); !errors.Is(err, ErrPaymentMismatch) {
+		t.Fatalf("ConfirmWebhookPayment() = %v, want ErrPaymentMismatch", err)
+	}
+	assertPaymentStatus(t, repo, partial.ID, storage.PaymentStatusPendingReview)
+}
+
+func TestYooMoneyNotificationRejectsSubKopeckAmount(t *testing.T) {
+	provider, err := NewWebhookProvider(WebhookProviderYooMoney, "secret")
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	for _, amount := range []string{"0.001", "0.004", "0", "-1.00"} {
+		if _, err := provider.ParseNotification(signedYooMoneyForm("secret", "label", amount, "100.00")); err == nil {
+			t.Errorf("ParseNotification() accepted amount %s", amount)
+		}
+	}
+	if _, err := ParseRubles("0.001"); err == nil {
+		t.Errorf("ParseRubles() accepted amount rounding to 0 kopecks")
+	}
+}
+
+// assertPaymentStatus fails test if payment isn't in given status
+func assertPaymentStatus(t *testing.T, repo *storage.Repository, paymentID int64, want storage.PaymentStatus) {
+	t.Helper()
+	payment, err := repo.GetPaymentByID(context.Background(), paymentID)
+	if err != nil {
+		t.Fatalf("failed to get payment %d: %v", paymentID, err)
+	}
+	if payment.Status != want {
+		t.Errorf("payment %d status %s, want %s", paymentID, payment.Status, want)
+	}
+}
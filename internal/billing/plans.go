@@ -0,0 +1,101 @@
+package billing
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxPlanDevices is the upper bound for PlansConfig.MaxDevices: every device count is
+// offered as a separate button, and Telegram limits inline keyboard size
+const MaxPlanDevices = 50
+
+// MaxPlanDurationDays is the upper bound for PlansConfig.Durations, it keeps plan callback data
+// within Telegram limit
+const MaxPlanDurationDays = 3650
+
+// PlansConfig describes allowed subscription plans
+type PlansConfig struct {
+	Durations  []int // Allowed subscription durations, in days
+	MaxDevices int   // Maximum device count per subscription
+}
+
+// DefaultPlansConfig returns default plans: 30/90/180 days, up to 5 devices
+func DefaultPlansConfig() PlansConfig {
+	return PlansConfig{
+		Durations:  []int{30, 90, 180},
+		MaxDevices: 5,
+	}
+}
+
+// LoadPlansConfig reads plans from PLAN_DURATIONS (comma-separated days) and PLAN_MAX_DEVICES
+// environment variables, falling back to defaults for unset values
+func LoadPlansConfig() (PlansConfig, error) {
+	cfg := DefaultPlansConfig()
+
+	if v := os.Getenv("PLAN_DURATIONS"); v != "" {
+		var durations []int
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			days, err := strconv.Atoi(d)
+			if err != nil {
+				return cfg, errors.Wrapf(err, "invalid PLAN_DURATIONS value: %s", v)
+			}
+			durations = append(durations, days)
+		}
+		cfg.Durations = durations
+	}
+	if v := os.Getenv("PLAN_MAX_DEVICES"); v != "" {
+		maxDevices, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, errors.Wrapf(err, "invalid PLAN_MAX_DEVICES value: %s", v)
+		}
+		cfg.MaxDevices = maxDevices
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that plans are sane
+func (c PlansConfig) Validate() error {
+	if len(c.Durations) == 0 {
+		return errors.New("at least one subscription duration is required")
+	}
+	seen := make(map[int]bool, len(c.Durations))
+	for _, d := range c.Durations {
+		if d <= 0 || d > MaxPlanDurationDays {
+			return errors.Errorf("subscription duration must be in [1, %d], got %d", MaxPlanDurationDays, d)
+		}
+		if seen[d] {
+			return errors.Errorf("duplicate subscription duration: %d", d)
+		}
+		seen[d] = true
+	}
+	if c.MaxDevices < 1 || c.MaxDevices > MaxPlanDevices {
+		return errors.Errorf("max devices must be in [1, %d], got %d", MaxPlanDevices, c.MaxDevices)
+	}
+	return nil
+}
+
+// AllowsDuration reports whether subscription duration is allowed
+func (c PlansConfig) AllowsDuration(durationDays int) bool {
+	for _, d := range c.Durations {
+		if d == durationDays {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsDeviceCount reports whether device count is allowed
+func (c PlansConfig) AllowsDeviceCount(deviceCount int) bool {
+	return deviceCount >= 1 && deviceCount <= c.MaxDevices
+}
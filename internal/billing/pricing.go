@@ -0,0 +1,122 @@
+package billing
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PricingConfig describes subscription prices
+type PricingConfig struct {
+	PricePerDevice int             // Price per device for 30 days, in kopecks
+	Discounts      map[int]float64 // Subscription duration in days -> discount, in percent
+
+	// SecondApprovalThreshold is the payment amount, in kopecks, from which two different admins
+	// must approve the payment. Zero disables the two-person rule
+	SecondApprovalThreshold int
+}
+
+// DefaultPricingConfig returns default prices: 100 RUB per device, 5% off for 90 days, 10% off for 180 days
+func DefaultPricingConfig() PricingConfig {
+	return PricingConfig{
+		PricePerDevice: BasePricePerDevice,
+		Discounts:      map[int]float64{90: 5, 180: 10},
+	}
+}
+
+// discountPrefix and discountSuffix surround subscription duration in names of discount
+// environment variables, e.g. DISCOUNT_365D
+const (
+	discountPrefix = "DISCOUNT_"
+	discountSuffix = "D"
+)
+
+// LoadPricingConfig reads pricing from PRICE_PER_DEVICE_KOPECKS, DISCOUNT_<days>D (e.g. DISCOUNT_90D) and
+// SECOND_APPROVAL_THRESHOLD_KOPECKS environment variables, falling back to defaults for unset values.
+// Durations without discount are priced at full price
+func LoadPricingConfig() (PricingConfig, error) {
+	cfg := DefaultPricingConfig()
+
+	if v := os.Getenv("PRICE_PER_DEVICE_KOPECKS"); v != "" {
+		price, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, errors.Wrapf(err, "invalid PRICE_PER_DEVICE_KOPECKS value: %s", v)
+		}
+		cfg.PricePerDevice = price
+	}
+	for _, env := range os.Environ() {
+		kv := strings.SplitN(env, "=", 2)
+		if !strings.HasPrefix(kv[0], discountPrefix) || !strings.HasSuffix(kv[0], discountSuffix) || kv[1] == "" {
+			continue
+		}
+		days, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(kv[0], discountPrefix), discountSuffix))
+		if err != nil || days <= 0 {
+			return cfg, errors.Errorf("invalid %s: subscription duration must be a positive number of days", kv[0])
+		}
+		discount, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return cfg, errors.Wrapf(err, "invalid %s value: %s", kv[0], kv[1])
+		}
+		cfg.Discounts[days] = discount
+	}
+	if v := os.Getenv("SECOND_APPROVAL_THRESHOLD_KOPECKS"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, errors.Wrapf(err, "invalid SECOND_APPROVAL_THRESHOLD_KOPECKS value: %s", v)
+		}
+		cfg.SecondApprovalThreshold = threshold
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that prices and discounts are sane
+func (c PricingConfig) Validate() error {
+	if c.PricePerDevice <= 0 {
+		return errors.Errorf("price per device must be positive, got %d", c.PricePerDevice)
+	}
+	for days, discount := range c.Discounts {
+		if discount < 0 || discount >= 100 {
+			return errors.Errorf("%d days discount must be in [0, 100) percent, got %.2f", days, discount)
+		}
+	}
+	if c.SecondApprovalThreshold < 0 {
+		return errors.Errorf("second approval threshold must not be negative, got %d", c.SecondApprovalThreshold)
+	}
+	return nil
+}
+
+// multiplier returns price multiplier for subscription duration, 1 for durations without discount
+func (c PricingConfig) multiplier(durationDays int) float64 {
+	return 1 - c.Discounts[durationDays]/100
+}
+
+// requiresSecondApproval reports whether payment of given amount must be approved by two admins
+func (c PricingConfig) requiresSecondApproval(amount int) bool {
+	return c.SecondApprovalThreshold > 0 && amount >= c.SecondApprovalThreshold
+}
+
+// ParseRubles parses rubles amount like "300", "300.50" or "300,50" into kopecks.
+// Trailing dot is ignored, so amount at the end of a sentence is accepted too
+func ParseRubles(s string) (int, error) {
+	s = strings.TrimRight(strings.Replace(strings.TrimSpace(s), ",", ".", 1), ".")
+	rubles, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(rubles) || math.IsInf(rubles, 0) {
+		return 0, errors.New("amount must be a number")
+	}
+	// Amounts below half a kopeck round to 0, which means "not entered" for payment review
+	kopecks := int(math.Round(rubles * 100))
+	if kopecks <= 0 {
+		return 0, errors.New("amount must be positive")
+	}
+	return kopecks, nil
+}
@@ -0,0 +1,102 @@
+package billing
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// ErrInvalidPromoCode is returned when promo code doesn't exist, expired or reached its usage limit
+var ErrInvalidPromoCode = errors.New("promo code not found or no longer valid")
+
+// MinPaymentAmount is the lowest amount in kopecks promo code discount can bring price to:
+// zero amount can't be paid by transfer, Telegram invoice or confirmed by YooMoney webhook
+const MinPaymentAmount = 100
+
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9-]{1,32}$`)
+
+// NormalizePromoCode returns promo code in canonical (upper) case
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ValidatePromoCode looks up promo code and checks that it can still be redeemed
+func (s *Service) ValidatePromoCode(ctx context.Context, code string) (*storage.PromoCode, error) {
+	code = NormalizePromoCode(code)
+	if !promoCodePattern.MatchString(code) {
+		return nil, ErrInvalidPromoCode
+	}
+
+	promo, err := s.repo.GetPromoCode(ctx, code)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get promo code")
+	}
+	if promo == nil {
+		return nil, ErrInvalidPromoCode
+	}
+	if promo.ExpiresAt != nil && !promo.ExpiresAt.After(s.clock.Now()) {
+		return nil, ErrInvalidPromoCode
+	}
+	if promo.UsageLimit > 0 && promo.UsedCount >= promo.UsageLimit {
+		return nil, ErrInvalidPromoCode
+	}
+	return promo, nil
+}
+
+// ApplyPromoCode returns amount with promo code discount applied, not lower than MinPaymentAmount
+func ApplyPromoCode(amount int, promo *storage.PromoCode) int {
+	if promo == nil {
+		return amount
+	}
+	discounted := amount
+	if promo.DiscountPercent > 0 {
+		discounted = amount * (100 - promo.DiscountPercent) / 100
+	}
+	discounted -= promo.DiscountAmount
+	if discounted < MinPaymentAmount {
+		// Price itself may be lower than the minimum, discount never raises it
+		if amount < MinPaymentAmount {
+			return amount
+		}
+		return MinPaymentAmount
+	}
+	return discounted
+}
+
+// CreatePromoCode creates a promo code with either percent or fixed (kopecks) discount.
+// Zero usageLimit means unlimited usage, zero validDays means no expiry
+func (s *Service) CreatePromoCode(ctx context.Context, code string, discountPercent, discountAmount, usageLimit, validDays int) (*storage.PromoCode, error) {
+	code = NormalizePromoCode(code)
+	if !promoCodePattern.MatchString(code) {
+		return nil, errors.New("promo code must be 1-32 characters: latin letters, digits or '-'")
+	}
+	if (discountPercent > 0) == (discountAmount > 0) {
+		return nil, errors.New("exactly one of percent or fixed discount must be set")
+	}
+	if discountPercent < 0 || discountPercent >= 100 {
+		return nil, errors.Errorf("discount percent must be in [1, 99], got %d", discountPercent)
+	}
+	if discountAmount < 0 || usageLimit < 0 || validDays < 0 {
+		return nil, errors.New("discount, usage limit and validity must not be negative")
+	}
+
+	promo := &storage.PromoCode{
+		Code:            code,
+		DiscountPercent: discountPercent,
+		DiscountAmount:  discountAmount,
+		UsageLimit:      usageLimit,
+	}
+	if validDays > 0 {
+		expiresAt := s.clock.Now().AddDate(0, 0, validDays)
+		promo.ExpiresAt = &expiresAt
+	}
+
+	if err := s.repo.CreatePromoCode(ctx, promo); err != nil {
+		return nil, errors.Wrap(err, "failed to create promo code")
+	}
+	return promo, nil
+}
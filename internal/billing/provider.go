@@ -0,0 +1,84 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// ProviderCurrency is the currency of payments charged by payment providers,
+// payment amounts are in its minor units (kopecks)
+const ProviderCurrency = "RUB"
+
+// ErrPaymentMismatch is returned when received amount or currency differs from payment
+var ErrPaymentMismatch = errors.New("charged amount doesn't match payment")
+
+// AmountMismatchError is returned when amount received for payment differs from payment amount.
+// Amounts are in kopecks, errors.Is matches it with ErrPaymentMismatch
+type AmountMismatchError struct {
+	Received int
+	Expected int
+}
+
+func (e *AmountMismatchError) Error() string {
+	return fmt.Sprintf("%s: received %d kopecks, expected %d kopecks", ErrPaymentMismatch, e.Received, e.Expected)
+}
+
+// Is reports whether target is ErrPaymentMismatch
+func (e *AmountMismatchError) Is(target error) bool {
+	return target == ErrPaymentMismatch
+}
+
+// CheckProviderPayment verifies that user may pay the payment through payment provider: payment belongs
+// to the user, still awaits payment and amount and currency match it. It's called before user is charged
+func (s *Service) CheckProviderPayment(ctx context.Context, paymentID, userID int64, totalAmount int, currency string) (*storage.Payment, error) {
+	payment, err := s.repo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payment")
+	}
+	if payment == nil || payment.UserID != userID {
+		return nil, errors.Errorf("payment %d not found for user %d", paymentID, userID)
+	}
+	switch payment.Status {
+	case storage.PaymentStatusCreated:
+	case storage.PaymentStatusCancelled:
+		return nil, ErrPaymentCancelled
+	default:
+		return nil, errors.Wrapf(ErrPaymentAlreadyProcessed, "payment is in %s status", payment.Status)
+	}
+	if totalAmount != payment.Amount || currency != ProviderCurrency {
+		return nil, errors.Wrapf(ErrPaymentMismatch, "got %d %s, expected %d %s", totalAmount, currency, payment.Amount, ProviderCurrency)
+	}
+	return payment, nil
+}
+
+// ConfirmProviderPayment approves payment charged by payment provider and creates or extends subscription.
+// Provider has verified the payment, so neither admin review nor second approval is needed. Payment
+// is approved even if it has expired or was cancelled meanwhile, since user has already been charged.
+// provider is recorded as reviewer, chargeID is provider's payment ID needed for refunds
+func (s *Service) ConfirmProviderPayment(ctx context.Context, paymentID int64, provider, chargeID string, totalAmount int, currency string) (*storage.Payment, error) {
+	payment, err := s.repo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payment")
+	}
+	if payment == nil {
+		return nil, errors.Errorf("payment %d not found", paymentID)
+	}
+	if totalAmount != payment.Amount || currency != ProviderCurrency {
+		return nil, errors.Wrapf(ErrPaymentMismatch, "charge %s: got %d %s, expected %d %s", chargeID, totalAmount, currency, payment.Amount, ProviderCurrency)
+	}
+
+	if err := s.repo.ApprovePaidPayment(ctx, paymentID, provider, s.gracePeriodDays); err != nil {
+		var statusErr *storage.PaymentStatusError
+		if errors.As(err, &statusErr) {
+			return nil, errors.Wrapf(ErrPaymentAlreadyProcessed, "payment is in %s status", statusErr.Status)
+		}
+		return nil, errors.Wrap(err, "failed to approve payment")
+	}
+	s.recordApprovalAudit(ctx, provider, payment, fmt.Sprintf("%s, charge %s", planDetails(payment), chargeID), 0)
+
+	return payment, nil
+}
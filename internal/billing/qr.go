@@ -0,0 +1,65 @@
+package billing
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// PaymentRequisites holds bank transfer details used to build a dynamic payment QR
+// (GOST R 56042-2014 format supported by Russian banking apps)
+type PaymentRequisites struct {
+	Name        string // Recipient name
+	PersonalAcc string // Recipient account number
+	BankName    string // Recipient bank name
+	BIC         string // Bank identification code
+	CorrespAcc  string // Bank correspondent account
+	PayeeINN    string // Recipient INN (optional)
+}
+
+// Enabled reports whether all required requisites are set
+func (r PaymentRequisites) Enabled() bool {
+	return r.Name != "" && r.PersonalAcc != "" && r.BankName != "" && r.BIC != "" && r.CorrespAcc != ""
+}
+
+// DynamicQREnabled reports whether dynamic payment QR generation is configured
+func (s *Service) DynamicQREnabled() bool {
+	return s.requisites.Enabled()
+}
+
+// GeneratePaymentQRPayload builds a payment QR payload with embedded amount and payment comment,
+// so the user's banking app pre-fills both fields
+func (s *Service) GeneratePaymentQRPayload(payment *storage.Payment) (string, error) {
+	if !s.requisites.Enabled() {
+		return "", errors.New("dynamic payment QR is not configured")
+	}
+	if payment == nil {
+		return "", errors.New("payment is nil")
+	}
+
+	fields := []string{
+		"ST00012", // format version 0001, UTF-8 encoding
+		"Name=" + qrFieldValue(s.requisites.Name),
+		"PersonalAcc=" + qrFieldValue(s.requisites.PersonalAcc),
+		"BankName=" + qrFieldValue(s.requisites.BankName),
+		"BIC=" + qrFieldValue(s.requisites.BIC),
+		"CorrespAcc=" + qrFieldValue(s.requisites.CorrespAcc),
+	}
+	if s.requisites.PayeeINN != "" {
+		fields = append(fields, "PayeeINN="+qrFieldValue(s.requisites.PayeeINN))
+	}
+	fields = append(fields,
+		"Sum="+strconv.Itoa(payment.Amount), // in kopecks
+		"Purpose="+qrFieldValue(payment.PaymentComment),
+	)
+
+	return strings.Join(fields, "|"), nil
+}
+
+// qrFieldValue strips the field separator from a payload value
+func qrFieldValue(value string) string {
+	return strings.TrimSpace(strings.ReplaceAll(value, "|", " "))
+}
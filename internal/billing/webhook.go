@@ -0,0 +1,161 @@
+package billing
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// WebhookProviderYooMoney is YooMoney HTTP notifications provider
+const WebhookProviderYooMoney = "yoomoney"
+
+// yooMoneyCurrencyRUB is ISO 4217 numeric code of rubles used in YooMoney notifications
+const yooMoneyCurrencyRUB = "643"
+
+// yooMoneyMaxFeePercent is the largest YooMoney fee deducted from incoming transfers,
+// card payments to a wallet are credited with 3% fee deducted
+const yooMoneyMaxFeePercent = 3
+
+var (
+	// ErrInvalidSignature is returned when webhook notification signature doesn't match provider secret
+	ErrInvalidSignature = errors.New("invalid notification signature")
+	// ErrPaymentNotFound is returned when webhook notification doesn't match any payment
+	ErrPaymentNotFound = errors.New("payment not found")
+)
+
+// PaymentNotification is an incoming transfer reported by payment provider webhook
+type PaymentNotification struct {
+	OperationID string // Provider's operation ID
+	Comment     string // Payment comment (label) the transfer was made with
+	Amount      int    // Amount credited to recipient in kopecks, covered by notification signature
+	MaxFee      int    // Largest provider fee in percent that may have been deducted from paid amount
+	Accepted    bool   // Whether money was credited, protected transfers need to be accepted by recipient first
+}
+
+// WebhookProvider verifies and parses payment provider webhook notifications
+type WebhookProvider interface {
+	// Name is recorded as reviewer of payments approved by the provider
+	Name() string
+	// ParseNotification verifies signature of notification form and parses it
+	ParseNotification(form url.Values) (*PaymentNotification, error)
+}
+
+// NewWebhookProvider returns webhook provider with given name, secret is used to verify notifications
+func NewWebhookProvider(name, secret string) (WebhookProvider, error) {
+	if secret == "" {
+		return nil, errors.New("webhook secret is required")
+	}
+	switch strings.ToLower(name) {
+	case WebhookProviderYooMoney:
+		return &yooMoneyProvider{secret: secret}, nil
+	default:
+		return nil, errors.Errorf("unknown webhook provider %q", name)
+	}
+}
+
+// yooMoneyProvider handles YooMoney wallet HTTP notifications
+type yooMoneyProvider struct {
+	secret string
+}
+
+func (p *yooMoneyProvider) Name() string {
+	return WebhookProviderYooMoney
+}
+
+// ParseNotification checks sha1_hash of notification fields and notification secret,
+// as described in YooMoney HTTP notifications documentation
+func (p *yooMoneyProvider) ParseNotification(form url.Values) (*PaymentNotification, error) {
+	signed := strings.Join([]string{
+		form.Get("notification_type"),
+		form.Get("operation_id"),
+		form.Get("amount"),
+		form.Get("currency"),
+		form.Get("datetime"),
+		form.Get("sender"),
+		form.Get("codepro"),
+		p.secret,
+		form.Get("label"),
+	}, "&")
+	sum := sha1.Sum([]byte(signed))
+	expected := hex.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(form.Get("sha1_hash")))) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	if currency := form.Get("currency"); currency != yooMoneyCurrencyRUB {
+		return nil, errors.Wrapf(ErrPaymentMismatch, "unsupported currency %s", currency)
+	}
+	// withdraw_amount is what sender paid, but it isn't signed, so only credited amount is trusted
+	amount, err := ParseRubles(form.Get("amount"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid amount %q", form.Get("amount"))
+	}
+	return &PaymentNotification{
+		OperationID: form.Get("operation_id"),
+		Comment:     strings.TrimSpace(form.Get("label")),
+		Amount:      amount,
+		MaxFee:      yooMoneyMaxFeePercent,
+		Accepted:    form.Get("unaccepted") != "true" && form.Get("codepro") != "true",
+	}, nil
+}
+
+// ConfirmWebhookPayment approves payment in review matched by comment of the transfer reported by provider.
+// Approval goes through AdminApprovePayment with provider as reviewer and amount reported by provider,
+// so amount must match the payment and large payments still need approval by an admin. Credited amount
+// smaller than the payment by no more than provider fee counts as full payment. Payment paid before user
+// has uploaded proof is moved to review first, the notification stands for proof. Returned payment is set when it was matched
+func (s *Service) ConfirmWebhookPayment(ctx context.Context, provider string, notification *PaymentNotification) (*storage.Payment, error) {
+	if notification.Comment == "" {
+		return nil, errors.Wrap(ErrPaymentNotFound, "notification has no payment comment")
+	}
+	payment, err := s.repo.GetPaymentByComment(ctx, notification.Comment)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payment")
+	}
+	if payment == nil {
+		return nil, errors.Wrapf(ErrPaymentNotFound, "no payment with comment %q", notification.Comment)
+	}
+	if notification.Amount <= 0 {
+		return payment, errors.Wrapf(ErrPaymentMismatch, "notification amount %d is not positive", notification.Amount)
+	}
+	if !notification.Accepted {
+		return payment, errors.Errorf("transfer %s is not accepted yet", notification.OperationID)
+	}
+	if payment.Status == storage.PaymentStatusApproved {
+		return payment, ErrPaymentAlreadyProcessed
+	}
+	if payment.Status == storage.PaymentStatusCreated {
+		submitted, err := s.repo.SubmitPaymentWithoutProof(ctx, payment.ID)
+		if err != nil {
+			return payment, errors.Wrap(err, "failed to submit payment paid before proof")
+		}
+		if submitted {
+			log.Printf("Payment %d paid before proof upload, moved to review", payment.ID)
+			payment.Status = storage.PaymentStatusPendingReview
+		}
+		// Otherwise proof has just been uploaded or payment closed, approval checks status again
+	}
+
+	if err := s.AdminApprovePayment(ctx, payment.ID, provider, notification.Comment, paidAmount(notification, payment.Amount), false); err != nil {
+		return payment, err
+	}
+	return payment, nil
+}
+
+// paidAmount returns amount paid by sender: expected amount when notification amount is smaller
+// only by provider fee, notification amount otherwise
+func paidAmount(notification *PaymentNotification, expected int) int {
+	minCredited := expected * (100 - notification.MaxFee) / 100
+	if notification.Amount >= minCredited && notification.Amount <= expected {
+		return expected
+	}
+	return notification.Amount
+}
@@ -0,0 +1,88 @@
+package provisioning
+
+import (
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// planDNSPrefix prefixes environment variables with DNS of subscription plans, e.g. PLAN_DNS_180
+const planDNSPrefix = "PLAN_DNS_"
+
+// planDNS holds DNS servers of subscription plans
+type planDNS struct {
+	durations []int            // Plan durations with their own DNS, ascending
+	dns       map[int][]string // Plan duration in days -> DNS servers
+}
+
+// loadPlanDNS reads PLAN_DNS_<days> environment variables with DNS servers separated by commas,
+// e.g. PLAN_DNS_180=94.140.14.14,94.140.15.15 for ad-blocking DNS of 180 days plan
+func loadPlanDNS() (planDNS, error) {
+	plans := planDNS{dns: make(map[int][]string)}
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, planDNSPrefix) {
+			continue
+		}
+		kv := strings.SplitN(env, "=", 2)
+		days, err := strconv.Atoi(strings.TrimPrefix(kv[0], planDNSPrefix))
+		if err != nil || days <= 0 {
+			return plans, errors.Errorf("invalid %s: plan duration must be a positive number of days", kv[0])
+		}
+
+		var dns []string
+		for _, d := range strings.Split(kv[1], ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			if net.ParseIP(d) == nil {
+				return plans, errors.Errorf("invalid %s IP address: %s", kv[0], d)
+			}
+			dns = append(dns, d)
+		}
+		if len(dns) == 0 {
+			continue
+		}
+		plans.dns[days] = dns
+		plans.durations = append(plans.durations, days)
+	}
+	sort.Ints(plans.durations)
+	return plans, nil
+}
+
+// forDuration returns DNS of the longest plan with its own DNS not exceeding subscription duration,
+// nil if there is none. Extended subscriptions accumulate duration, so they get DNS of the longer plan
+func (p planDNS) forDuration(durationDays int) []string {
+	var dns []string
+	for _, days := range p.durations {
+		if days > durationDays {
+			break
+		}
+		dns = p.dns[days]
+	}
+	return dns
+}
+
+// dnsFor returns DNS servers for devices of subscription: plan DNS if it's configured for subscription duration,
+// server DNS otherwise
+func (p *LocalProvisioner) dnsFor(ctx context.Context, subscriptionID int64) ([]string, error) {
+	if len(p.planDNS.durations) == 0 {
+		return p.dns, nil
+	}
+	subscription, err := p.repo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get subscription")
+	}
+	if subscription == nil {
+		return p.dns, nil
+	}
+	if dns := p.planDNS.forDuration(subscription.DurationDays); dns != nil {
+		return dns, nil
+	}
+	return p.dns, nil
+}
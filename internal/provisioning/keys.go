@@ -0,0 +1,92 @@
+package provisioning
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPrivateKeyNotStored is returned when device config can't be rebuilt because
+// its private key isn't kept on the server
+var ErrPrivateKeyNotStored = errors.New("device private key is not stored")
+
+// KeyCipher encrypts client private keys stored in the database with AES-256-GCM
+type KeyCipher struct {
+	aead cipher.AEAD
+}
+
+// NewKeyCipherFromEnv returns cipher for private key storage if STORE_PRIVATE_KEYS is enabled,
+// nil otherwise. Storage can't be enabled without a valid PRIVATE_KEY_ENCRYPTION_KEY:
+// base64 encoded 32 byte key
+func NewKeyCipherFromEnv() (*KeyCipher, error) {
+	v := os.Getenv("STORE_PRIVATE_KEYS")
+	if v == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, errors.Errorf("invalid STORE_PRIVATE_KEYS value: %s", v)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	encoded := os.Getenv("PRIVATE_KEY_ENCRYPTION_KEY")
+	if encoded == "" {
+		return nil, errors.New("PRIVATE_KEY_ENCRYPTION_KEY is required when STORE_PRIVATE_KEYS is enabled")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid PRIVATE_KEY_ENCRYPTION_KEY, must be base64 encoded")
+	}
+	return NewKeyCipher(key)
+}
+
+// NewKeyCipher creates cipher with 32 byte key
+func NewKeyCipher(key []byte) (*KeyCipher, error) {
+	if len(key) != 32 {
+		return nil, errors.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCM")
+	}
+	return &KeyCipher{aead: aead}, nil
+}
+
+// Encrypt seals private key with random nonce, result is base64 encoded nonce followed by ciphertext
+func (c *KeyCipher) Encrypt(privateKey string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "failed to generate nonce")
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(privateKey), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens private key sealed by Encrypt
+func (c *KeyCipher) Decrypt(encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode encrypted key")
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("encrypted key is too short")
+	}
+	plain, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt key")
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,138 @@
+package provisioning
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// testCipherKey returns 32 byte encryption key filled with b
+func testCipherKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestKeyCipher(t *testing.T) *KeyCipher {
+	t.Helper()
+	c, err := NewKeyCipher(testCipherKey(1))
+	if err != nil {
+		t.Fatalf("NewKeyCipher() failed: %v", err)
+	}
+	return c
+}
+
+func TestKeyCipherRoundTrip(t *testing.T) {
+	c := newTestKeyCipher(t)
+	tests := []string{
+		"",
+		"yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+		"ключ",
+	}
+	for _, privateKey := range tests {
+		encrypted, err := c.Encrypt(privateKey)
+		if err != nil {
+			t.Fatalf("Encrypt(%q) failed: %v", privateKey, err)
+		}
+		if privateKey != "" && bytes.Contains([]byte(encrypted), []byte(privateKey)) {
+			t.Errorf("Encrypt(%q) = %q contains the key", privateKey, encrypted)
+		}
+		got, err := c.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decrypt(Encrypt(%q)) failed: %v", privateKey, err)
+		}
+		if got != privateKey {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q", privateKey, got)
+		}
+	}
+}
+
+func TestKeyCipherUsesFreshNonce(t *testing.T) {
+	c := newTestKeyCipher(t)
+	const privateKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	first, err := c.Encrypt(privateKey)
+	if err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	second, err := c.Encrypt(privateKey)
+	if err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	if first == second {
+		t.Fatalf("two encryptions of the same key are equal: %q", first)
+	}
+	firstSealed, _ := base64.StdEncoding.DecodeString(first)
+	secondSealed, _ := base64.StdEncoding.DecodeString(second)
+	nonceSize := c.aead.NonceSize()
+	if bytes.Equal(firstSealed[:nonceSize], secondSealed[:nonceSize]) {
+		t.Errorf("nonce %x is reused", firstSealed[:nonceSize])
+	}
+}
+
+func TestKeyCipherDecryptRejectsDamagedKey(t *testing.T) {
+	c := newTestKeyCipher(t)
+	encrypted, err := c.Encrypt("yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=")
+	if err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(encrypted)
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	otherKey, err := NewKeyCipher(testCipherKey(2))
+	if err != nil {
+		t.Fatalf("NewKeyCipher() failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		cipher    *KeyCipher
+		encrypted string
+	}{
+		{name: "tampered", cipher: c, encrypted: base64.StdEncoding.EncodeToString(tampered)},
+		{name: "truncated", cipher: c, encrypted: base64.StdEncoding.EncodeToString(sealed[:len(sealed)-1])},
+		{name: "shorter than nonce", cipher: c, encrypted: base64.StdEncoding.EncodeToString(sealed[:c.aead.NonceSize()-1])},
+		{name: "not base64", cipher: c, encrypted: "not base64!"},
+		{name: "wrong key", cipher: otherKey, encrypted: encrypted},
+	}
+	for _, tt := range tests {
+		if got, err := tt.cipher.Decrypt(tt.encrypted); err == nil {
+			t.Errorf("%s: Decrypt() = %q, want error", tt.name, got)
+		}
+	}
+}
+
+func TestNewKeyCipherRejectsWrongKeySize(t *testing.T) {
+	for _, size := range []int{0, 16, 24, 31, 33, 64} {
+		if _, err := NewKeyCipher(make([]byte, size)); err == nil {
+			t.Errorf("NewKeyCipher() accepted %d byte key", size)
+		}
+	}
+}
+
+func TestNewKeyCipherFromEnv(t *testing.T) {
+	validKey := base64.StdEncoding.EncodeToString(testCipherKey(1))
+	tests := []struct {
+		store      string
+		key        string
+		wantCipher bool
+		wantErr    bool
+	}{
+		{store: "", key: validKey},
+		{store: "false", key: validKey},
+		{store: "true", key: validKey, wantCipher: true},
+		{store: "true", key: "", wantErr: true},
+		{store: "true", key: "not base64!", wantErr: true},
+		{store: "true", key: base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: true},
+		{store: "maybe", key: validKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("STORE_PRIVATE_KEYS", tt.store)
+		t.Setenv("PRIVATE_KEY_ENCRYPTION_KEY", tt.key)
+		c, err := NewKeyCipherFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("STORE_PRIVATE_KEYS=%q, key %q: NewKeyCipherFromEnv() error = %v, want error %v", tt.store, tt.key, err, tt.wantErr)
+			continue
+		}
+		if (c != nil) != tt.wantCipher {
+			t.Errorf("STORE_PRIVATE_KEYS=%q, key %q: NewKeyCipherFromEnv() = %v, want cipher %v", tt.store, tt.key, c, tt.wantCipher)
+		}
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/pkg/errors"
@@ -20,16 +25,46 @@ import (
 	cfgs "github.com/skoret/wireguard-bot/internal/wireguard/configs"
 )
 
+// ErrIPPoolExhausted is returned when there are no free addresses left in the subnet or the configured pool
+var ErrIPPoolExhausted = errors.New("IP address pool exhausted")
+
+// ErrIPConflict is returned when address of configured peer is already allowed for another peer on the interface
+var ErrIPConflict = errors.New("IP address is already allowed for another peer")
+
+// ErrDeviceExists is returned when subscription already has an active device with the requested name
+// that can't be returned as is: its config can't be rebuilt or it has another public key
+var ErrDeviceExists = errors.New("device with this name already exists")
+
+// ErrDeviceLimitReached is returned when subscription already has as many active devices as its limit allows
+var ErrDeviceLimitReached = errors.New("device limit reached")
+
 // LocalProvisioner implements Provisioner interface for local WireGuard management
 type LocalProvisioner struct {
-	device string
-	dns    []string
-	client *wgctrl.Client
-	repo   *storage.Repository
+	server   string // Server ID stored with devices, addresses are allocated per server
+	device   string
+	endpoint string
+	dns      []string
+	planDNS  planDNS    // DNS of subscription plans, overrides server DNS
+	subnet   *net.IPNet // Subnet client IPv4 addresses are allocated from
+	subnet6  *net.IPNet // Subnet client IPv6 addresses are allocated from, nil if IPv6 is disabled
+	pool     ipRange    // Client IPv4 addresses are allocated within these bounds of the subnet
+	client   *wgctrl.Client
+	repo     *storage.Repository
+	keys     *KeyCipher // Encrypts stored client private keys, nil if keys aren't stored
+	clock    storage.Clock
+
+	// Interface public key put into client configs, read once and refreshed by health check and reconciliation
+	publicKey   wgtypes.Key
+	publicKeyMu sync.RWMutex
+
+	// Client config options
+	mtu        int
+	keepalive  int
+	allowedIPs []string
 }
 
-// NewLocalProvisioner creates a new local provisioner instance
-func NewLocalProvisioner(repo *storage.Repository) (*LocalProvisioner, error) {
+// NewLocalProvisioner creates a new local provisioner instance for the server
+func NewLocalProvisioner(repo *storage.Repository, server Server) (*LocalProvisioner, error) {
 	client, err := wgctrl.New()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create wgctrl client")
@@ -42,14 +77,18 @@ func NewLocalProvisioner(repo *storage.Repository) (*LocalProvisioner, error) {
 
 	log.Printf("--- known devices: ---")
 	for i, d := range devs {
-		log.Printf("#%d device: %+v", i, d)
+		// Device holds interface private key, never log it as a whole
+		log.Printf("#%d device: %s", i, describeDevice(d))
 	}
 	log.Printf("----------------------")
 
-	// Get and validate WIREGUARD_INTERFACE
-	wgInterface := os.Getenv("WIREGUARD_INTERFACE")
+	// Validate server interface and endpoint
+	wgInterface := server.Interface
 	if wgInterface == "" {
-		return nil, errors.New("WIREGUARD_INTERFACE environment variable is required")
+		return nil, errors.Errorf("%s environment variable is required", server.EnvName("INTERFACE"))
+	}
+	if server.Endpoint == "" {
+		return nil, errors.Errorf("%s environment variable is required", server.EnvName("ENDPOINT"))
 	}
 
 	// Verify that the interface exists
@@ -65,35 +104,196 @@ func NewLocalProvisioner(repo *storage.Repository) (*LocalProvisioner, error) {
 	}
 
 	log.Printf("Using WireGuard interface: %s", wgInterface)
+	var publicKey wgtypes.Key
+	for _, d := range devs {
+		if d.Name == wgInterface {
+			publicKey = d.PublicKey
+		}
+	}
 
-	// Get and validate DNS_IPS
-	dns := os.Getenv("DNS_IPS")
-	if dns == "" {
-		return nil, errors.New("DNS_IPS environment variable is required")
+	// Probe write access with an empty config, so missing privileges fail at startup
+	// rather than when the first user creates a device
+	if err := client.ConfigureDevice(wgInterface, wgtypes.Config{}); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, errors.Wrapf(err, "insufficient privileges for WireGuard interface '%s' (CAP_NET_ADMIN is required)", wgInterface)
+		}
+		return nil, errors.Wrapf(err, "WireGuard interface '%s' is not configurable", wgInterface)
 	}
-	var dnsList []string
-	dnsSplit := strings.Split(dns, ",")
-	for _, d := range dnsSplit {
-		d = strings.TrimSpace(d)
-		if d == "" {
-			continue
+
+	// DNS addresses are validated by LoadServers
+	if len(server.DNS) == 0 {
+		return nil, errors.Errorf("at least one valid %s is required", server.EnvName("DNS"))
+	}
+
+	// Get optional WG_MTU, WG_KEEPALIVE and WG_ALLOWED_IPS for client configs
+	mtu, err := intFromEnv("WG_MTU")
+	if err != nil {
+		return nil, err
+	}
+	keepalive, err := intFromEnv("WG_KEEPALIVE")
+	if err != nil {
+		return nil, err
+	}
+	var allowedIPs []string
+	if v := os.Getenv("WG_ALLOWED_IPS"); v != "" {
+		for _, cidr := range strings.Split(v, ",") {
+			cidr = strings.TrimSpace(cidr)
+			if cidr == "" {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, errors.Errorf("invalid WG_ALLOWED_IPS subnet: %s", cidr)
+			}
+			allowedIPs = append(allowedIPs, cidr)
 		}
-		// Basic validation: check if it's a valid IP
-		if net.ParseIP(d) == nil {
-			return nil, errors.Errorf("invalid DNS IP address: %s", d)
+		if len(allowedIPs) == 0 {
+			return nil, errors.New("at least one valid WG_ALLOWED_IPS subnet is required")
 		}
-		dnsList = append(dnsList, d)
 	}
-	if len(dnsList) == 0 {
-		return nil, errors.New("at least one valid DNS_IPS is required")
+
+	keys, err := NewKeyCipherFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		log.Printf("Client private keys are stored encrypted")
 	}
 
-	return &LocalProvisioner{
-		device: wgInterface,
-		dns:    dnsList,
-		client: client,
-		repo:   repo,
-	}, nil
+	plans, err := loadPlanDNS()
+	if err != nil {
+		return nil, err
+	}
+	for _, days := range plans.durations {
+		log.Printf("Subscriptions of %d days and longer use DNS %v", days, plans.dns[days])
+	}
+
+	p := &LocalProvisioner{
+		server:     server.ID,
+		device:     wgInterface,
+		endpoint:   server.Endpoint,
+		dns:        server.DNS,
+		planDNS:    plans,
+		client:     client,
+		publicKey:  publicKey,
+		repo:       repo,
+		keys:       keys,
+		mtu:        mtu,
+		keepalive:  keepalive,
+		allowedIPs: allowedIPs,
+		clock:      repo.Clock(),
+	}
+
+	// Get server subnet, defaults to the interface network
+	subnetEnv := server.EnvName("SUBNET")
+	if subnet := server.Subnet; subnet != "" {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s: %s", subnetEnv, subnet)
+		}
+		if ipNet.IP.To4() == nil {
+			return nil, errors.Errorf("%s must be an IPv4 subnet: %s", subnetEnv, subnet)
+		}
+		p.subnet = ipNet
+		// Clients must be reachable through the interface
+		if serverIP, err := p.getDeviceAddress(); err == nil && !ipNet.Contains(serverIP) {
+			return nil, errors.Errorf("%s %s doesn't contain interface address %s", subnetEnv, subnet, serverIP)
+		}
+	} else {
+		ipNet, err := p.getDeviceNetwork()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get interface subnet, set %s", subnetEnv)
+		}
+		p.subnet = ipNet
+	}
+	if p.pool, err = parsePool(server, p.subnet); err != nil {
+		return nil, err
+	}
+	log.Printf("Allocating client addresses from subnet: %s, pool %s", p.subnet, p.pool)
+
+	// Get optional server IPv6 subnet to assign IPv6 addresses too
+	if subnet6 := server.Subnet6; subnet6 != "" {
+		subnet6Env := server.EnvName("SUBNET6")
+		_, ipNet, err := net.ParseCIDR(subnet6)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s: %s", subnet6Env, subnet6)
+		}
+		if ipNet.IP.To4() != nil {
+			return nil, errors.Errorf("%s must be an IPv6 subnet: %s", subnet6Env, subnet6)
+		}
+		p.subnet6 = ipNet
+		log.Printf("Allocating client IPv6 addresses from subnet: %s", p.subnet6)
+	}
+
+	if p.allowedIPs == nil {
+		p.allowedIPs = []string{"0.0.0.0/0"}
+		if p.subnet6 != nil {
+			p.allowedIPs = append(p.allowedIPs, "::/0")
+		}
+	}
+
+	return p, nil
+}
+
+// intFromEnv reads optional non-negative integer environment variable, zero if unset
+func intFromEnv(key string) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid %s value: %s", key, v)
+	}
+	return n, nil
+}
+
+// ipRange is an inclusive range of IPv4 addresses, nil bound means subnet bound
+type ipRange struct {
+	start net.IP
+	end   net.IP
+}
+
+func (r ipRange) String() string {
+	start, end := "*", "*"
+	if r.start != nil {
+		start = r.start.String()
+	}
+	if r.end != nil {
+		end = r.end.String()
+	}
+	return start + "-" + end
+}
+
+// parsePool reads server address pool bounds, which must be host addresses of the subnet.
+// Addresses outside the pool are left for infrastructure and never allocated to clients
+func parsePool(server Server, subnet *net.IPNet) (ipRange, error) {
+	var pool ipRange
+	parse := func(setting, value string) (net.IP, error) {
+		if value == "" {
+			return nil, nil
+		}
+		ip := net.ParseIP(value).To4()
+		if ip == nil {
+			return nil, errors.Errorf("invalid %s IPv4 address: %s", server.EnvName(setting), value)
+		}
+		if err := validateClientIP(ip, subnet, nil); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", server.EnvName(setting))
+		}
+		return ip, nil
+	}
+
+	var err error
+	if pool.start, err = parse("POOL_START", server.PoolStart); err != nil {
+		return pool, err
+	}
+	if pool.end, err = parse("POOL_END", server.PoolEnd); err != nil {
+		return pool, err
+	}
+	if pool.start != nil && pool.end != nil && bytes.Compare(pool.start, pool.end) > 0 {
+		return pool, errors.Errorf("%s %s is after %s %s",
+			server.EnvName("POOL_START"), pool.start, server.EnvName("POOL_END"), pool.end)
+	}
+	return pool, nil
 }
 
 // getDeviceNames returns list of device names
@@ -113,8 +313,33 @@ func (p *LocalProvisioner) Close() error {
 	return nil
 }
 
+// HealthCheck verifies that the interface exists and has an address and that wg-quick,
+// used to persist peers, is available
+func (p *LocalProvisioner) HealthCheck(ctx context.Context) error {
+	device, err := p.client.Device(p.device)
+	if err != nil {
+		return errors.Wrapf(err, "WireGuard interface '%s' is not available", p.device)
+	}
+	p.refreshPublicKey(device)
+	ips, err := p.getInterfaceIPs()
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return errors.Errorf("WireGuard interface '%s' has no addresses", p.device)
+	}
+	if _, err := exec.LookPath("wg-quick"); err != nil {
+		return errors.Wrap(err, "wg-quick is required to save WireGuard config")
+	}
+	return nil
+}
+
 // CreateDeviceWithNewKeys creates a new device with generated keys
 func (p *LocalProvisioner) CreateDeviceWithNewKeys(ctx context.Context, userID, subscriptionID int64, deviceName string) (*ConfigResult, error) {
+	if result, err := p.existingDevice(ctx, subscriptionID, deviceName, ""); result != nil || err != nil {
+		return result, err
+	}
+
 	pri, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to generate private key")
@@ -128,7 +353,7 @@ func (p *LocalProvisioner) CreateDeviceWithNewKeys(ctx context.Context, userID,
 	}
 	defer tx.Rollback()
 
-	ipNet, err := p.getNextIPNetAtomic(ctx, tx)
+	ipNet, ipNet6, err := p.getNextIPNetAtomic(ctx, tx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get next IP")
 	}
@@ -149,46 +374,57 @@ func (p *LocalProvisioner) CreateDeviceWithNewKeys(ctx context.Context, userID,
 		DeviceName:     deviceName,
 		PeerPublicKey:  pub.String(),
 		AssignedIP:     ipNet.IP.String(),
+		ServerID:       p.server,
+	}
+	var assignedIPv6 *string
+	if ipNet6 != nil {
+		device.AssignedIPv6 = ipNet6.IP.String()
+		assignedIPv6 = &device.AssignedIPv6
 	}
 
-	// Insert device
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO devices (user_id, subscription_id, device_name, peer_public_key, assigned_ip, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
-		device.UserID, device.SubscriptionID, device.DeviceName, device.PeerPublicKey,
-		device.AssignedIP, storage.GetTime(),
-	)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to insert device")
+	// Private key is stored only encrypted and only if enabled
+	var encryptedKey *string
+	if p.keys != nil {
+		sealed, err := p.keys.Encrypt(pri.String())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt private key")
+		}
+		encryptedKey = &sealed
 	}
 
-	// Commit transaction before updating WireGuard interface
-	if err := tx.Commit(); err != nil {
-		return nil, errors.Wrap(err, "failed to commit transaction")
+	if err := insertDevice(ctx, tx, device, assignedIPv6, encryptedKey, false, p.clock.Now()); err != nil {
+		if errors.Is(err, storage.ErrDuplicateDeviceName) {
+			// Concurrent request created the device first
+			tx.Rollback()
+			return p.existingDevice(ctx, subscriptionID, deviceName, "")
+		}
+		return nil, err
 	}
 
 	// Create client config
-	cfgFile, err := p.createConfig(pri.String(), ipNet)
+	dns, err := p.dnsFor(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	addresses := clientAddresses(ipNet, ipNet6)
+	cfgFile, err := p.createConfig(pri.String(), addresses, dns)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create config")
 	}
 
-	// Update WireGuard device configuration
-	if err := p.updateDevice(pub, ipNet); err != nil {
-		// Log error but don't fail - device is already in DB
-		log.Printf("Warning: failed to update WireGuard device after DB commit: %v", err)
+	// Don't touch the interface if caller gave up (e.g. on shutdown), deferred rollback releases reserved IP
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "device creation cancelled")
 	}
-
-	// Get device ID from DB
-	device, err = p.repo.GetDeviceByPeerPublicKey(ctx, pub.String())
-	if err != nil || device == nil {
-		return nil, errors.New("failed to retrieve created device")
+	if err := p.addPeerAndCommit(tx, pub, addresses); err != nil {
+		return nil, err
 	}
 
 	return &ConfigResult{
 		ConfigReader: cfgFile,
 		PublicKey:    pub.String(),
 		AssignedIP:   ipNet.IP.String(),
+		AssignedIPv6: device.AssignedIPv6,
 	}, nil
 }
 
@@ -198,6 +434,9 @@ func (p *LocalProvisioner) CreateDeviceWithPublicKey(ctx context.Context, public
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse public key")
 	}
+	if result, err := p.existingDevice(ctx, subscriptionID, deviceName, pub.String()); result != nil || err != nil {
+		return result, err
+	}
 
 	// Atomically reserve IP through DB transaction
 	tx, err := p.repo.BeginTx(ctx)
@@ -206,7 +445,7 @@ func (p *LocalProvisioner) CreateDeviceWithPublicKey(ctx context.Context, public
 	}
 	defer tx.Rollback()
 
-	ipNet, err := p.getNextIPNetAtomic(ctx, tx)
+	ipNet, ipNet6, err := p.getNextIPNetAtomic(ctx, tx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get next IP")
 	}
@@ -227,38 +466,153 @@ func (p *LocalProvisioner) CreateDeviceWithPublicKey(ctx context.Context, public
 		DeviceName:     deviceName,
 		PeerPublicKey:  pub.String(),
 		AssignedIP:     ipNet.IP.String(),
+		ServerID:       p.server,
 	}
-
-	// Insert device
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO devices (user_id, subscription_id, device_name, peer_public_key, assigned_ip, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
-		device.UserID, device.SubscriptionID, device.DeviceName, device.PeerPublicKey,
-		device.AssignedIP, storage.GetTime(),
-	)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to insert device")
+	var assignedIPv6 *string
+	if ipNet6 != nil {
+		device.AssignedIPv6 = ipNet6.IP.String()
+		assignedIPv6 = &device.AssignedIPv6
 	}
 
-	// Commit transaction before updating WireGuard interface
-	if err := tx.Commit(); err != nil {
-		return nil, errors.Wrap(err, "failed to commit transaction")
+	// User holds private key of imported public key
+	if err := insertDevice(ctx, tx, device, assignedIPv6, nil, true, p.clock.Now()); err != nil {
+		if errors.Is(err, storage.ErrDuplicateDeviceName) {
+			tx.Rollback()
+			return p.existingDevice(ctx, subscriptionID, deviceName, pub.String())
+		}
+		return nil, err
 	}
 
 	// Create client config (without private key)
-	cfgFile, err := p.createConfig("", ipNet)
+	dns, err := p.dnsFor(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	addresses := clientAddresses(ipNet, ipNet6)
+	cfgFile, err := p.createConfig("", addresses, dns)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create config")
 	}
 
-	// Update WireGuard device configuration
-	if err := p.updateDevice(pub, ipNet); err != nil {
-		log.Printf("Warning: failed to update WireGuard device after DB commit: %v", err)
+	// Don't touch the interface if caller gave up (e.g. on shutdown), deferred rollback releases reserved IP
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "device creation cancelled")
+	}
+	if err := p.addPeerAndCommit(tx, pub, addresses); err != nil {
+		return nil, err
 	}
 
 	return &ConfigResult{
 		ConfigReader: cfgFile,
 		AssignedIP:   ipNet.IP.String(),
+		AssignedIPv6: device.AssignedIPv6,
+	}, nil
+}
+
+// existingDevice returns config of active device with the name in the subscription, nil if there is none,
+// so a retried or repeated creation returns the device instead of making a duplicate. publicKey is
+// the requested key of imported device, empty for generated keys. The peer is re-added, as previous
+// attempt may have failed after the device was stored
+func (p *LocalProvisioner) existingDevice(ctx context.Context, subscriptionID int64, deviceName, publicKey string) (*ConfigResult, error) {
+	device, err := p.repo.GetActiveDeviceByName(ctx, subscriptionID, deviceName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check existing device")
+	}
+	if device == nil {
+		return nil, nil
+	}
+	if device.ServerID != p.server || (publicKey != "" && device.PeerPublicKey != publicKey) {
+		return nil, errors.Wrapf(ErrDeviceExists, "subscription %d, device %s", subscriptionID, deviceName)
+	}
+
+	result, err := p.RecreateConfig(ctx, device)
+	if errors.Is(err, ErrPrivateKeyNotStored) {
+		return nil, errors.Wrapf(ErrDeviceExists, "subscription %d, device %s", subscriptionID, deviceName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.RestoreDevice(ctx, device.PeerPublicKey, device.AssignedIP, device.AssignedIPv6); err != nil {
+		log.Printf("Warning: failed to re-add peer of existing device %d: %v", device.ID, err)
+	}
+	log.Printf("Device %s of subscription %d already exists, returning device %d", deviceName, subscriptionID, device.ID)
+	return result, nil
+}
+
+// insertDevice inserts device record unless its subscription already has device_limit active devices.
+// Count and insert is a single statement, so concurrent requests can't exceed the limit
+func insertDevice(ctx context.Context, tx *sql.Tx, device *storage.Device, assignedIPv6, encryptedKey *string, imported bool, createdAt time.Time) error {
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO devices (user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, private_key_encrypted, imported_key, server_id, created_at)
+		 SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		 WHERE (SELECT COUNT(*) FROM devices WHERE subscription_id = ? AND revoked_at IS NULL)
+		     < (SELECT device_limit FROM subscriptions WHERE id = ?)`,
+		device.UserID, device.SubscriptionID, device.DeviceName, device.PeerPublicKey,
+		device.AssignedIP, assignedIPv6, encryptedKey, imported, device.ServerID, createdAt,
+		device.SubscriptionID, device.SubscriptionID,
+	)
+	if err != nil {
+		if storage.IsDuplicateDeviceName(err) {
+			return errors.Wrapf(storage.ErrDuplicateDeviceName, "device %s", device.DeviceName)
+		}
+		return errors.Wrap(err, "failed to insert device")
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to check inserted device")
+	}
+	if inserted == 0 {
+		return errors.Wrapf(ErrDeviceLimitReached, "subscription %d", device.SubscriptionID)
+	}
+	return nil
+}
+
+// RecreateConfig rebuilds config of existing device from its addresses and stored private key.
+// Config of device with imported public key is rebuilt without private key, the user holds it
+func (p *LocalProvisioner) RecreateConfig(ctx context.Context, device *storage.Device) (*ConfigResult, error) {
+	encrypted, imported, err := p.repo.GetDeviceKey(ctx, device.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get device key")
+	}
+	var pri string
+	if !imported {
+		if p.keys == nil || encrypted == "" {
+			return nil, ErrPrivateKeyNotStored
+		}
+		pri, err = p.keys.Decrypt(encrypted)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt private key of device %d", device.ID)
+		}
+	}
+
+	ip := net.ParseIP(device.AssignedIP).To4()
+	if ip == nil {
+		return nil, errors.Errorf("invalid assigned IP: %s", device.AssignedIP)
+	}
+	ipNet := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	var ipNet6 *net.IPNet
+	if device.AssignedIPv6 != "" {
+		ip6 := net.ParseIP(device.AssignedIPv6)
+		if ip6 == nil || ip6.To4() != nil {
+			return nil, errors.Errorf("invalid assigned IPv6: %s", device.AssignedIPv6)
+		}
+		ipNet6 = &net.IPNet{IP: ip6, Mask: net.CIDRMask(128, 128)}
+	}
+
+	dns, err := p.dnsFor(ctx, device.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	cfgFile, err := p.createConfig(pri, clientAddresses(ipNet, ipNet6), dns)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create config")
+	}
+	return &ConfigResult{
+		ConfigReader: cfgFile,
+		PublicKey:    device.PeerPublicKey,
+		AssignedIP:   device.AssignedIP,
+		AssignedIPv6: device.AssignedIPv6,
+		ImportedKey:  imported,
 	}, nil
 }
 
@@ -294,61 +648,184 @@ func (p *LocalProvisioner) RevokeDevice(ctx context.Context, peerPublicKey strin
 	return nil
 }
 
-// getNextIPNetAtomic gets next IP atomically within a transaction
-func (p *LocalProvisioner) getNextIPNetAtomic(ctx context.Context, tx *sql.Tx) (*net.IPNet, error) {
-	// Get latest assigned IP from DB (atomic within transaction)
-	var latestIPStr sql.NullString
-	err := tx.QueryRowContext(ctx,
-		`SELECT assigned_ip FROM devices WHERE revoked_at IS NULL ORDER BY assigned_ip DESC LIMIT 1`,
-	).Scan(&latestIPStr)
+// DeviceStats reads device peer statistics from the WireGuard interface
+func (p *LocalProvisioner) DeviceStats(ctx context.Context, peerPublicKey string) (*DeviceStats, error) {
+	pub, err := wgtypes.ParseKey(peerPublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key")
+	}
 
-	var lastIP net.IP
-	if err != nil && err != sql.ErrNoRows {
-		// Fallback to getting from WireGuard interface
-		lastIP, err = p.getLatestUsedIP()
-		if err != nil {
-			return nil, err
-		}
-	} else if latestIPStr.Valid {
-		// Parse IP from DB
-		lastIP = net.ParseIP(latestIPStr.String)
-		if lastIP == nil {
-			// Invalid IP in DB, fallback to WireGuard interface
-			lastIP, err = p.getLatestUsedIP()
-			if err != nil {
-				return nil, err
-			}
+	device, err := p.client.Device(p.device)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get WireGuard interface '%s'", p.device)
+	}
+	for _, peer := range device.Peers {
+		if peer.PublicKey == pub {
+			return &DeviceStats{
+				LastHandshake: peer.LastHandshakeTime,
+				ReceiveBytes:  peer.ReceiveBytes,
+				TransmitBytes: peer.TransmitBytes,
+			}, nil
 		}
-	} else {
-		// No IPs in DB, get base IP from WireGuard interface
-		lastIP, err = p.getDeviceAddress()
-		if err != nil {
-			return nil, err
+	}
+	return nil, ErrPeerNotFound
+}
+
+// Reconcile repairs desync between DB and interface, e.g. after interface config was restored from an old
+// backup or peers were removed by hand: peers of active devices missing on the interface are re-added,
+// orphan peers (e.g. left by crash between adding a peer and committing its device) are only reported
+func (p *LocalProvisioner) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	devices, err := p.repo.GetActiveDevicesByServer(ctx, p.server)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get active devices")
+	}
+	wgDevice, err := p.client.Device(p.device)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get WireGuard interface '%s'", p.device)
+	}
+	p.refreshPublicKey(wgDevice)
+
+	peers := make(map[string]bool, len(wgDevice.Peers))
+	for _, peer := range wgDevice.Peers {
+		peers[peer.PublicKey.String()] = true
+	}
+
+	report := &ReconcileReport{Checked: len(devices)}
+	for _, device := range devices {
+		if peers[device.PeerPublicKey] {
+			delete(peers, device.PeerPublicKey)
+			continue
 		}
+		if err := p.RestoreDevice(ctx, device.PeerPublicKey, device.AssignedIP, device.AssignedIPv6); err != nil {
+			log.Printf("failed to restore missing peer of device %d: %v", device.ID, err)
+			report.Failed = append(report.Failed, device)
+			continue
+		}
+		log.Printf("restored missing peer of device %d (%s)", device.ID, device.AssignedIP)
+		report.Restored = append(report.Restored, device)
+	}
+	for key := range peers {
+		report.Orphans = append(report.Orphans, key)
+	}
+	sort.Strings(report.Orphans)
+	return report, nil
+}
+
+func (p *LocalProvisioner) RestoreDevice(ctx context.Context, peerPublicKey, assignedIP, assignedIPv6 string) error {
+	pub, err := wgtypes.ParseKey(peerPublicKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse public key")
 	}
 
-	nextIPAddr := p.nextIP(lastIP, 1)
+	ip := net.ParseIP(assignedIP).To4()
+	if ip == nil {
+		return errors.Errorf("invalid assigned IP: %s", assignedIP)
+	}
+	ipNet := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
 
-	return &net.IPNet{
-		IP:   nextIPAddr,
-		Mask: net.IPv4Mask(255, 255, 255, 255),
-	}, nil
+	var ipNet6 *net.IPNet
+	if assignedIPv6 != "" {
+		ip6 := net.ParseIP(assignedIPv6)
+		if ip6 == nil || ip6.To4() != nil {
+			return errors.Errorf("invalid assigned IPv6: %s", assignedIPv6)
+		}
+		ipNet6 = &net.IPNet{IP: ip6, Mask: net.CIDRMask(128, 128)}
+	}
+
+	return p.updateDevice(pub, clientAddresses(ipNet, ipNet6))
 }
 
-// createConfig creates a client configuration file
-func (p *LocalProvisioner) createConfig(pri string, ipNet *net.IPNet) (io.Reader, error) {
+// getNextIPNetAtomic gets the lowest free IPv4 (and IPv6, if enabled) addresses in the server subnets
+// atomically within a transaction, reusing addresses freed by revoked devices
+func (p *LocalProvisioner) getNextIPNetAtomic(ctx context.Context, tx *sql.Tx) (*net.IPNet, *net.IPNet, error) {
+	used := make(map[string]bool)
+	var peerNets []net.IPNet
+
+	// Addresses of active devices of this server from DB (atomic within transaction)
+	rows, err := tx.QueryContext(ctx, `SELECT assigned_ip, assigned_ipv6 FROM devices WHERE revoked_at IS NULL AND server_id = ?`, p.server)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to query assigned IPs")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ipStr string
+		var ipv6Str sql.NullString
+		if err := rows.Scan(&ipStr, &ipv6Str); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to scan assigned IP")
+		}
+		if ip := net.ParseIP(ipStr); ip != nil {
+			used[ip.String()] = true
+		}
+		if ip := net.ParseIP(ipv6Str.String); ipv6Str.Valid && ip != nil {
+			used[ip.String()] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read assigned IPs")
+	}
+
+	// Peers configured on the interface, which may be missing in DB. Peer may be allowed a whole
+	// network, every address of which is rejected by checkAddressConflicts
 	device, err := p.client.Device(p.device)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get device "+p.device)
+		return nil, nil, errors.Wrap(err, "failed to get device "+p.device)
+	}
+	for _, peer := range device.Peers {
+		peerNets = append(peerNets, peer.AllowedIPs...)
+	}
+
+	// Server addresses
+	serverIPs, err := p.getInterfaceIPs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, ip := range serverIPs {
+		used[ip.String()] = true
+	}
+
+	ip, err := lowestFreeIP(p.subnet, p.pool, used, peerNets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateClientIP(ip, p.subnet, serverIPs); err != nil {
+		return nil, nil, err
 	}
+	ipNet := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
 
+	if p.subnet6 == nil {
+		return ipNet, nil, nil
+	}
+	ip6, err := lowestFreeIP(p.subnet6, ipRange{}, used, peerNets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateClientIP(ip6, p.subnet6, serverIPs); err != nil {
+		return nil, nil, err
+	}
+	return ipNet, &net.IPNet{IP: ip6, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// clientAddresses returns client addresses, skipping IPv6 if it's not assigned
+func clientAddresses(ipNet, ipNet6 *net.IPNet) []net.IPNet {
+	addresses := []net.IPNet{*ipNet}
+	if ipNet6 != nil {
+		addresses = append(addresses, *ipNet6)
+	}
+	return addresses
+}
+
+// createConfig creates a client configuration file with given DNS servers
+func (p *LocalProvisioner) createConfig(pri string, addresses []net.IPNet, dns []string) (io.Reader, error) {
 	clientConfig := cfgs.ClientConfig{
-		Address:    ipNet.String(),
+		Address:    joinIPNets(addresses),
 		PrivateKey: pri,
-		DNS:        p.dns,
-		PublicKey:  device.PublicKey.String(),
-		AllowedIPs: []string{"0.0.0.0/0"},
-		Endpoint:   os.Getenv("SERVER_ENDPOINT"),
+		DNS:        dns,
+		MTU:        p.mtu,
+		PublicKey:  p.serverPublicKey().String(),
+		AllowedIPs: p.allowedIPs,
+		Endpoint:   p.endpoint,
+
+		PersistentKeepalive: p.keepalive,
 	}
 
 	cfgFile, err := cfgs.ProcessClientConfig(clientConfig)
@@ -359,13 +836,69 @@ func (p *LocalProvisioner) createConfig(pri string, ipNet *net.IPNet) (io.Reader
 	return cfgFile, nil
 }
 
+// serverPublicKey returns cached public key of the interface
+func (p *LocalProvisioner) serverPublicKey() wgtypes.Key {
+	p.publicKeyMu.RLock()
+	defer p.publicKeyMu.RUnlock()
+	return p.publicKey
+}
+
+// refreshPublicKey updates cached interface public key from freshly read device, so configs get the new key
+// after the interface key was rotated
+func (p *LocalProvisioner) refreshPublicKey(device *wgtypes.Device) {
+	p.publicKeyMu.Lock()
+	defer p.publicKeyMu.Unlock()
+	if device.PublicKey != p.publicKey {
+		log.Printf("WireGuard interface '%s' public key changed to %s", p.device, device.PublicKey)
+		p.publicKey = device.PublicKey
+	}
+}
+
+// addPeerAndCommit adds new device peer to the interface and then commits device record, so user never gets
+// config of a device missing on the interface. If the peer can't be added, caller's deferred rollback drops
+// the record; if commit fails, the added peer is removed
+func (p *LocalProvisioner) addPeerAndCommit(tx *sql.Tx, pub wgtypes.Key, addresses []net.IPNet) error {
+	if err := p.updateDevice(pub, addresses); err != nil {
+		// Peer may be configured already if only saving config failed
+		p.removePeer(pub)
+		return errors.Wrap(err, "failed to add peer to WireGuard")
+	}
+	if err := tx.Commit(); err != nil {
+		p.removePeer(pub)
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// removePeer removes peer of device that failed to provision, failure is only logged:
+// such peer is reported as orphan by Reconcile
+func (p *LocalProvisioner) removePeer(pub wgtypes.Key) {
+	cfg := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{
+			{
+				PublicKey: pub,
+				Remove:    true,
+			},
+		},
+	}
+	if err := p.client.ConfigureDevice(p.device, cfg); err != nil {
+		log.Printf("failed to remove peer %s of device that failed to provision: %v", pub.String(), err)
+	}
+}
+
 // updateDevice updates WireGuard device configuration
-func (p *LocalProvisioner) updateDevice(pub wgtypes.Key, ipNet *net.IPNet) error {
+func (p *LocalProvisioner) updateDevice(pub wgtypes.Key, addresses []net.IPNet) error {
+	// WireGuard silently moves an allowed IP to the last peer configured with it,
+	// which would cut off the device currently using the address
+	if err := p.checkAddressConflicts(pub, addresses); err != nil {
+		return err
+	}
+
 	cfg := wgtypes.Config{
 		Peers: []wgtypes.PeerConfig{
 			{
 				PublicKey:  pub,
-				AllowedIPs: []net.IPNet{*ipNet},
+				AllowedIPs: addresses,
 			},
 		},
 	}
@@ -383,31 +916,25 @@ func (p *LocalProvisioner) updateDevice(pub wgtypes.Key, ipNet *net.IPNet) error
 	return nil
 }
 
-// getLatestUsedIP gets the latest used IP from WireGuard interface
-func (p *LocalProvisioner) getLatestUsedIP() (net.IP, error) {
+// checkAddressConflicts returns ErrIPConflict if any of addresses overlaps allowed IPs of a peer other than pub
+func (p *LocalProvisioner) checkAddressConflicts(pub wgtypes.Key, addresses []net.IPNet) error {
 	device, err := p.client.Device(p.device)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get device "+p.device)
+		return errors.Wrap(err, "failed to get device "+p.device)
 	}
-
-	lastIP, err := p.getDeviceAddress()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get address for device "+p.device)
-	}
-
 	for _, peer := range device.Peers {
-		for _, ipNet := range peer.AllowedIPs {
-			if bytes.Compare(ipNet.IP, lastIP) >= 0 {
-				lastIP = ipNet.IP
+		if peer.PublicKey == pub {
+			continue
+		}
+		for _, allowed := range peer.AllowedIPs {
+			for _, address := range addresses {
+				if allowed.Contains(address.IP) || address.Contains(allowed.IP) {
+					return errors.Wrapf(ErrIPConflict, "%s is allowed for peer %s", address.String(), peer.PublicKey.String())
+				}
 			}
 		}
 	}
-
-	if lastIP == nil {
-		return nil, errors.New("failed to get latest used ip for device " + p.device)
-	}
-
-	return lastIP, nil
+	return nil
 }
 
 // getDeviceAddress gets the base IP address of the WireGuard interface
@@ -431,16 +958,176 @@ func (p *LocalProvisioner) getDeviceAddress() (net.IP, error) {
 	return nil, errors.New("failed to get address for interface " + p.device)
 }
 
-// nextIP increments an IP address
-// Thanks to https://gist.github.com/udhos/b468fbfd376aa0b655b6b0c539a88c03
-func (p *LocalProvisioner) nextIP(ip net.IP, inc uint) net.IP {
-	i := ip.To4()
-	v := uint(i[0])<<24 + uint(i[1])<<16 + uint(i[2])<<8 + uint(i[3])
-	v += inc
-	v3 := byte(v & 0xFF)
-	v2 := byte((v >> 8) & 0xFF)
-	v1 := byte((v >> 16) & 0xFF)
-	v0 := byte((v >> 24) & 0xFF)
-	return net.IPv4(v0, v1, v2, v3)
+// getDeviceNetwork gets the IPv4 network of the WireGuard interface
+func (p *LocalProvisioner) getDeviceNetwork() (*net.IPNet, error) {
+	ife, err := net.InterfaceByName(p.device)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface "+p.device)
+	}
+
+	addrs, err := ife.Addrs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get address for interface "+p.device)
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		ones, bits := ipNet.Mask.Size()
+		mask := net.CIDRMask(ones-(bits-32), 32)
+		return &net.IPNet{
+			IP:   ipNet.IP.To4().Mask(mask),
+			Mask: mask,
+		}, nil
+	}
+
+	return nil, errors.New("failed to get network for interface " + p.device)
+}
+
+// getInterfaceIPs gets all addresses of the WireGuard interface
+func (p *LocalProvisioner) getInterfaceIPs() ([]net.IP, error) {
+	ife, err := net.InterfaceByName(p.device)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface "+p.device)
+	}
+
+	addrs, err := ife.Addrs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get address for interface "+p.device)
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// lowestFreeIP returns the lowest host address of the subnet within the pool missing in used set
+// and outside of taken networks
+func lowestFreeIP(subnet *net.IPNet, pool ipRange, used map[string]bool, taken []net.IPNet) (net.IP, error) {
+	ip := subnet.IP.Mask(subnet.Mask)
+	if pool.start != nil {
+		// The loop increments before the check, so start right below the first pool address
+		ip = decIP(pool.start)
+	}
+	for {
+		ip = incIP(ip)
+		if !subnet.Contains(ip) || isBroadcast(ip, subnet) {
+			return nil, errors.Wrapf(ErrIPPoolExhausted, "no free addresses in %s", subnet)
+		}
+		if pool.end != nil && bytes.Compare(ip.To16(), pool.end.To16()) > 0 {
+			return nil, errors.Wrapf(ErrIPPoolExhausted, "no free addresses in pool %s of %s", pool, subnet)
+		}
+		if network := containingNet(taken, ip); network != nil {
+			// Skip the rest of the network at once, it may be as large as the subnet
+			ip = lastIP(network)
+			continue
+		}
+		if !used[ip.String()] {
+			return ip, nil
+		}
+	}
+}
+
+// containingNet returns the first of networks that contains ip, nil if there is none
+func containingNet(networks []net.IPNet, ip net.IP) *net.IPNet {
+	for i := range networks {
+		if networks[i].Contains(ip) {
+			return &networks[i]
+		}
+	}
+	return nil
+}
+
+// lastIP returns the last address of the network
+func lastIP(network *net.IPNet) net.IP {
+	last := network.IP.Mask(network.Mask)
+	mask := network.Mask[len(network.Mask)-len(last):]
+	for i := range last {
+		last[i] |= ^mask[i]
+	}
+	return last
+}
+
+// validateClientIP checks that client IP is a host address of the subnet other than the server addresses
+func validateClientIP(ip net.IP, subnet *net.IPNet, serverIPs []net.IP) error {
+	if !subnet.Contains(ip) {
+		return errors.Errorf("IP %s is outside of subnet %s", ip, subnet)
+	}
+	if ip.Equal(subnet.IP.Mask(subnet.Mask)) {
+		return errors.Errorf("IP %s is the network address of %s", ip, subnet)
+	}
+	if isBroadcast(ip, subnet) {
+		return errors.Errorf("IP %s is the broadcast address of %s", ip, subnet)
+	}
+	for _, serverIP := range serverIPs {
+		if serverIP.Equal(ip) {
+			return errors.Errorf("IP %s is the server address", ip)
+		}
+	}
+	return nil
+}
+
+// isBroadcast reports whether ip is the broadcast address of an IPv4 subnet
+func isBroadcast(ip net.IP, subnet *net.IPNet) bool {
+	ip4 := ip.To4()
+	if ip4 == nil || subnet.IP.To4() == nil {
+		return false
+	}
+	mask := subnet.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	for i := range ip4 {
+		if ip4[i]|mask[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// decIP returns the previous IP address
+func decIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
+
+// incIP returns the next IP address
+func incIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// joinIPNets formats addresses for config Address field
+func joinIPNets(addresses []net.IPNet) string {
+	parts := make([]string, len(addresses))
+	for i, address := range addresses {
+		parts[i] = address.String()
+	}
+	return strings.Join(parts, ", ")
 }
 
+// describeDevice formats WireGuard device for logs without its private key
+func describeDevice(d *wgtypes.Device) string {
+	return fmt.Sprintf("name=%s type=%v public_key=%s listen_port=%d peers=%d",
+		d.Name, d.Type, d.PublicKey, d.ListenPort, len(d.Peers))
+}
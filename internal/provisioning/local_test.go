@@ -0,0 +1,180 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// newTestSubscription returns migrated repository on dsn and active subscription with given device limit
+func newTestSubscription(t *testing.T, dsn string, deviceLimit int) (*storage.Repository, *storage.Subscription) {
+	t.Helper()
+	ctx := context.Background()
+	repo, err := storage.NewRepository(dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := repo.Migrate(ctx); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	user, err := repo.GetOrCreateUser(ctx, 1, "user")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	now := repo.Clock().Now()
+	subscription := &storage.Subscription{
+		UserID:       user.ID,
+		DurationDays: 30,
+		DeviceLimit:  deviceLimit,
+		Status:       storage.SubscriptionStatusActive,
+		StartsAt:     now,
+		EndsAt:       now.AddDate(0, 0, 30),
+	}
+	if err := repo.CreateSubscription(ctx, subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	return repo, subscription
+}
+
+// testDevice returns n-th device of subscription with unique name, key and address
+func testDevice(subscription *storage.Subscription, n int) *storage.Device {
+	return &storage.Device{
+		UserID:         subscription.UserID,
+		SubscriptionID: subscription.ID,
+		DeviceName:     fmt.Sprintf("device_%d", n),
+		PeerPublicKey:  fmt.Sprintf("key%d", n),
+		AssignedIP:     fmt.Sprintf("10.0.0.%d", n+1),
+	}
+}
+
+// insertTestDevice inserts device in its own transaction
+func insertTestDevice(ctx context.Context, repo *storage.Repository, device *storage.Device) error {
+	tx, err := repo.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertDevice(ctx, tx, device, nil, nil, false, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func TestInsertDeviceLimit(t *testing.T) {
+	repo, subscription := newTestSubscription(t, ":memory:", 2)
+	ctx := context.Background()
+
+	for n := 1; n <= 2; n++ {
+		if err := insertTestDevice(ctx, repo, testDevice(subscription, n)); err != nil {
+			t.Fatalf("device %d within limit: %v", n, err)
+		}
+	}
+	if err := insertTestDevice(ctx, repo, testDevice(subscription, 3)); !errors.Is(err, ErrDeviceLimitReached) {
+		t.Fatalf("device over limit: %v, want ErrDeviceLimitReached", err)
+	}
+
+	// Revoked devices don't count
+	devices, err := repo.GetActiveDevicesByUserID(ctx, subscription.UserID)
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+	if err := repo.RevokeDevice(ctx, devices[0].ID); err != nil {
+		t.Fatalf("failed to revoke device: %v", err)
+	}
+	if err := insertTestDevice(ctx, repo, testDevice(subscription, 3)); err != nil {
+		t.Fatalf("device after revoke: %v", err)
+	}
+}
+
+func TestInsertDeviceConcurrent(t *testing.T) {
+	// File database, so each transaction has its own connection like in production
+	repo, subscription := newTestSubscription(t, filepath.Join(t.TempDir(), "bot.db"), 1)
+	ctx := context.Background()
+
+	const requests = 2
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = insertTestDevice(ctx, repo, testDevice(subscription, i+1))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of %d concurrent requests succeeded, want exactly one: %v", succeeded, requests, errs)
+	}
+	count, err := repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
+	if err != nil {
+		t.Fatalf("failed to count devices: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("subscription has %d devices, limit is 1", count)
+	}
+}
+
+func TestDescribeDeviceOmitsPrivateKey(t *testing.T) {
+	pri, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	device := &wgtypes.Device{Name: "wg0", PrivateKey: pri, PublicKey: pri.PublicKey(), ListenPort: 51820}
+
+	description := describeDevice(device)
+	if strings.Contains(description, pri.String()) {
+		t.Errorf("device description contains private key: %s", description)
+	}
+	if !strings.Contains(description, pri.PublicKey().String()) || !strings.Contains(description, "wg0") {
+		t.Errorf("device description has no name or public key: %s", description)
+	}
+}
+
+func TestLowestFreeIPSkipsPeerNetworks(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+	used := map[string]bool{"10.0.0.1": true}
+
+	// Peer allowed 10.0.0.0/30 owns .1-.3, and the next free address is after it
+	_, peer30, _ := net.ParseCIDR("10.0.0.0/30")
+	ip, err := lowestFreeIP(subnet, ipRange{}, used, []net.IPNet{*peer30})
+	if err != nil {
+		t.Fatalf("lowestFreeIP() = %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.4")) {
+		t.Errorf("lowestFreeIP() = %s, want 10.0.0.4", ip)
+	}
+
+	// Peer allowed the whole /24 leaves no address to allocate
+	_, peer24, _ := net.ParseCIDR("10.0.0.0/24")
+	if ip, err := lowestFreeIP(subnet, ipRange{}, used, []net.IPNet{*peer24}); !errors.Is(err, ErrIPPoolExhausted) {
+		t.Errorf("lowestFreeIP() = %s, %v, want ErrIPPoolExhausted", ip, err)
+	}
+
+	// Peer networks outside of the subnet don't matter
+	_, other, _ := net.ParseCIDR("192.168.1.0/24")
+	ip, err = lowestFreeIP(subnet, ipRange{}, used, []net.IPNet{*other})
+	if err != nil || !ip.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("lowestFreeIP() = %s, %v, want 10.0.0.2", ip, err)
+	}
+}
@@ -3,8 +3,19 @@ package provisioning
 import (
 	"context"
 	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
 )
 
+// ErrStatsUnsupported is returned by provisioners that can't read peer statistics
+var ErrStatsUnsupported = errors.New("device stats are not supported by provisioner")
+
+// ErrPeerNotFound is returned when device peer is missing on the WireGuard interface
+var ErrPeerNotFound = errors.New("peer not found on WireGuard interface")
+
 // DeviceConfig represents a device configuration that needs to be provisioned
 type DeviceConfig struct {
 	UserID        int64
@@ -19,6 +30,23 @@ type ConfigResult struct {
 	ConfigReader io.Reader
 	PublicKey    string // For new keys generation
 	AssignedIP   string
+	AssignedIPv6 string // Empty if IPv6 is disabled
+	ImportedKey  bool   // Config has no private key, device was created with user supplied public key
+}
+
+// DeviceStats holds peer statistics reported by WireGuard
+type DeviceStats struct {
+	LastHandshake time.Time // Zero if the device has never connected
+	ReceiveBytes  int64     // Received from the device
+	TransmitBytes int64     // Sent to the device
+}
+
+// ReconcileReport describes differences between active devices in DB and peers on the WireGuard interface
+type ReconcileReport struct {
+	Checked  int               // Active devices in DB
+	Restored []*storage.Device // Devices whose missing peers were re-added
+	Failed   []*storage.Device // Devices whose missing peers couldn't be re-added
+	Orphans  []string          // Public keys of peers without active device, left on the interface
 }
 
 // Provisioner is an interface for provisioning WireGuard devices
@@ -32,9 +60,30 @@ type Provisioner interface {
 	// Returns the client config and assigned IP
 	CreateDeviceWithPublicKey(ctx context.Context, publicKey string, userID, subscriptionID int64, deviceName string) (*ConfigResult, error)
 
+	// RecreateConfig rebuilds client config of an existing device
+	// Returns ErrPrivateKeyNotStored if the device private key isn't kept on the server,
+	// devices with imported public key get config without private key
+	RecreateConfig(ctx context.Context, device *storage.Device) (*ConfigResult, error)
+
 	// RevokeDevice removes a device from WireGuard
 	RevokeDevice(ctx context.Context, peerPublicKey string) error
 
+	// RestoreDevice re-adds an existing device peer to WireGuard with its assigned addresses
+	// Doesn't touch the database, assignedIPv6 may be empty
+	RestoreDevice(ctx context.Context, peerPublicKey, assignedIP, assignedIPv6 string) error
+
+	// DeviceStats returns last handshake time and transfer counters of device peer
+	// Returns ErrPeerNotFound if the peer is missing on the interface, ErrStatsUnsupported if stats can't be read
+	DeviceStats(ctx context.Context, peerPublicKey string) (*DeviceStats, error)
+
+	// Reconcile compares active devices in DB with peers on the interface, re-adds missing peers
+	// and reports peers unknown to DB without removing them
+	Reconcile(ctx context.Context) (*ReconcileReport, error)
+
+	// HealthCheck verifies the provisioner can reach and configure its WireGuard server
+	// without changing anything, so misconfiguration is found before a user provisions a device
+	HealthCheck(ctx context.Context) error
+
 	// Close closes the provisioner and releases resources
 	Close() error
 }
@@ -0,0 +1,130 @@
+package provisioning
+
+import (
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Server describes a WireGuard server devices can be provisioned on
+type Server struct {
+	ID        string // Stored with devices and payments, empty for the single server configured by legacy variables
+	Name      string // Shown to users on region selection
+	Endpoint  string // Server IP:port clients connect to
+	Interface string // Local WireGuard interface of the server
+	DNS       []string
+	Subnet    string // Client IPv4 subnet, defaults to the interface network
+	Subnet6   string // Client IPv6 subnet, empty if IPv6 is disabled
+	PoolStart string // First client IPv4 address to allocate, defaults to the first host address of the subnet
+	PoolEnd   string // Last client IPv4 address to allocate, defaults to the last host address of the subnet
+}
+
+// serverIDPattern restricts server IDs to characters usable in environment variable names and callback data
+var serverIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,16}$`)
+
+// legacyServerEnv maps server settings to variables of the single server setup
+var legacyServerEnv = map[string]string{
+	"NAME":       "",
+	"ENDPOINT":   "SERVER_ENDPOINT",
+	"INTERFACE":  "WIREGUARD_INTERFACE",
+	"DNS":        "DNS_IPS",
+	"SUBNET":     "WIREGUARD_SUBNET",
+	"SUBNET6":    "WIREGUARD_SUBNET6",
+	"POOL_START": "WIREGUARD_POOL_START",
+	"POOL_END":   "WIREGUARD_POOL_END",
+}
+
+// EnvName returns environment variable the server setting is read from
+func (s Server) EnvName(setting string) string {
+	if s.ID == "" {
+		return legacyServerEnv[setting]
+	}
+	return "WIREGUARD_SERVER_" + strings.ToUpper(s.ID) + "_" + setting
+}
+
+// LoadServers reads WireGuard servers from environment. WIREGUARD_SERVERS lists server IDs separated by commas,
+// each server is configured by WIREGUARD_SERVER_<ID>_NAME, _ENDPOINT, _INTERFACE, _DNS, _SUBNET, _SUBNET6,
+// _POOL_START and _POOL_END. Without WIREGUARD_SERVERS a single server is read from WIREGUARD_INTERFACE,
+// SERVER_ENDPOINT, DNS_IPS, WIREGUARD_SUBNET, WIREGUARD_SUBNET6, WIREGUARD_POOL_START and WIREGUARD_POOL_END.
+// The first server is the default one
+func LoadServers() ([]Server, error) {
+	list := os.Getenv("WIREGUARD_SERVERS")
+	if strings.TrimSpace(list) == "" {
+		server, err := loadServer("")
+		if err != nil {
+			return nil, err
+		}
+		return []Server{server}, nil
+	}
+
+	var servers []Server
+	ids := make(map[string]bool)
+	interfaces := make(map[string]string)
+	for _, id := range strings.Split(list, ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" {
+			continue
+		}
+		if !serverIDPattern.MatchString(id) {
+			return nil, errors.Errorf("invalid WIREGUARD_SERVERS server id: %s", id)
+		}
+		if ids[id] {
+			return nil, errors.Errorf("duplicate WIREGUARD_SERVERS server id: %s", id)
+		}
+		ids[id] = true
+
+		server, err := loadServer(id)
+		if err != nil {
+			return nil, err
+		}
+		if server.Interface != "" {
+			if other, ok := interfaces[server.Interface]; ok {
+				return nil, errors.Errorf("servers %s and %s use the same WireGuard interface %s", other, id, server.Interface)
+			}
+			interfaces[server.Interface] = id
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("at least one server id is required in WIREGUARD_SERVERS")
+	}
+	return servers, nil
+}
+
+// loadServer reads settings of a single server, presence of required settings is checked by provisioners
+func loadServer(id string) (Server, error) {
+	server := Server{ID: id}
+	get := func(setting string) string {
+		if key := server.EnvName(setting); key != "" {
+			return strings.TrimSpace(os.Getenv(key))
+		}
+		return ""
+	}
+
+	server.Name = get("NAME")
+	if server.Name == "" {
+		server.Name = id
+	}
+	server.Endpoint = get("ENDPOINT")
+	server.Interface = get("INTERFACE")
+	server.Subnet = get("SUBNET")
+	server.Subnet6 = get("SUBNET6")
+	server.PoolStart = get("POOL_START")
+	server.PoolEnd = get("POOL_END")
+
+	for _, d := range strings.Split(get("DNS"), ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		// Basic validation: check if it's a valid IP
+		if net.ParseIP(d) == nil {
+			return Server{}, errors.Errorf("invalid %s IP address: %s", server.EnvName("DNS"), d)
+		}
+		server.DNS = append(server.DNS, d)
+	}
+	return server, nil
+}
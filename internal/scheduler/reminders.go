@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// DefaultExpiryReminderDays returns default expiry reminder offsets: 7, 3 and 1 days before subscription end
+func DefaultExpiryReminderDays() []int {
+	return []int{7, 3, 1}
+}
+
+// LoadExpiryReminderDays reads expiry reminder offsets from EXPIRY_REMINDER_DAYS (comma-separated days
+// before subscription end), falling back to defaults when unset. Offsets are returned in descending order
+func LoadExpiryReminderDays() ([]int, error) {
+	v := os.Getenv("EXPIRY_REMINDER_DAYS")
+	if v == "" {
+		return DefaultExpiryReminderDays(), nil
+	}
+
+	seen := make(map[int]bool)
+	var days []int
+	for _, d := range strings.Split(v, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid EXPIRY_REMINDER_DAYS value: %s", v)
+		}
+		if n <= 0 {
+			return nil, errors.Errorf("expiry reminder offset must be positive, got %d", n)
+		}
+		if seen[n] {
+			return nil, errors.Errorf("duplicate expiry reminder offset: %d", n)
+		}
+		seen[n] = true
+		days = append(days, n)
+	}
+	if len(days) == 0 {
+		return nil, errors.Errorf("invalid EXPIRY_REMINDER_DAYS value: %s", v)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days, nil
+}
+
+// expiryReminderKind returns kind of reminder sent given number of days before subscription end
+func expiryReminderKind(days int) storage.ReminderKind {
+	return storage.ReminderKind(fmt.Sprintf("%s_%d", storage.ReminderKindExpiring, days))
+}
+
+// dueExpiryReminder returns the closest to subscription end offset whose threshold has passed,
+// 0 if none has passed yet or subscription already ended. Offsets must be in descending order.
+// Earlier reminders missed while scheduler was down are skipped, only the latest one is sent
+func dueExpiryReminder(offsets []int, endsAt, now time.Time) int {
+	if !now.Before(endsAt) {
+		return 0
+	}
+	due := 0
+	for _, days := range offsets {
+		if !now.Before(endsAt.AddDate(0, 0, -days)) {
+			due = days
+		}
+	}
+	return due
+}
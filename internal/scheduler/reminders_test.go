@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDueExpiryReminder(t *testing.T) {
+	endsAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	offsets := []int{7, 3, 1}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want int
+	}{
+		{name: "before first threshold", now: endsAt.AddDate(0, 0, -7).Add(-time.Minute), want: 0},
+		{name: "at first threshold", now: endsAt.AddDate(0, 0, -7), want: 7},
+		{name: "between thresholds", now: endsAt.AddDate(0, 0, -5), want: 7},
+		{name: "3 days threshold late in the day", now: endsAt.AddDate(0, 0, -3).Add(11 * time.Hour), want: 3},
+		{name: "missed thresholds skipped", now: endsAt.Add(-time.Hour), want: 1},
+		{name: "at subscription end", now: endsAt, want: 0},
+		{name: "after subscription end", now: endsAt.AddDate(0, 0, 1), want: 0},
+	}
+	for _, tt := range tests {
+		if got := dueExpiryReminder(offsets, endsAt, tt.now); got != tt.want {
+			t.Errorf("%s: dueExpiryReminder() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadExpiryReminderDays(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    []int
+		wantErr bool
+	}{
+		{env: "", want: []int{7, 3, 1}},
+		{env: "1,3,7", want: []int{7, 3, 1}},
+		{env: " 14 , 2 ,", want: []int{14, 2}},
+		{env: "5", want: []int{5}},
+		{env: "3,3", wantErr: true},
+		{env: "0", wantErr: true},
+		{env: "-1", wantErr: true},
+		{env: "week", wantErr: true},
+		{env: ",", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("EXPIRY_REMINDER_DAYS", tt.env)
+		got, err := LoadExpiryReminderDays()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("LoadExpiryReminderDays(%q) = %v, want error", tt.env, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("LoadExpiryReminderDays(%q) returned error: %v", tt.env, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("LoadExpiryReminderDays(%q) = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package scheduler
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"time"
 
@@ -12,22 +11,76 @@ import (
 	"github.com/skoret/wireguard-bot/internal/telegram"
 )
 
+// PaymentTTL describes how long unfinished payments live before they expire.
+// Zero value disables expiration for the status
+type PaymentTTL struct {
+	Created       time.Duration // Payment created but no proof uploaded
+	PendingReview time.Duration // Proof uploaded but payment not reviewed by admin
+}
+
+// DefaultPaymentTTL returns default payment TTLs: 24 hours for created, 72 hours for pending review
+func DefaultPaymentTTL() PaymentTTL {
+	return PaymentTTL{
+		Created:       24 * time.Hour,
+		PendingReview: 72 * time.Hour,
+	}
+}
+
+// DefaultDeviceCleanupDays is the default number of days after grace period end before devices are revoked
+const DefaultDeviceCleanupDays = 30
+
+// DefaultExpiringDays is the default number of days before subscription end it's marked expiring
+const DefaultExpiringDays = 3
+
+const (
+	// notificationRetryInterval is how often queued notifications are checked for retry
+	notificationRetryInterval = time.Minute
+	// maxNotificationAttempts is the number of delivery attempts after which notification is given up
+	maxNotificationAttempts = 10
+	// maxNotificationBackoff caps delay between notification delivery attempts
+	maxNotificationBackoff = 6 * time.Hour
+	// notificationRetryBatch is the max number of notifications retried at once
+	notificationRetryBatch = 100
+)
+
 type Service struct {
-	repo    *storage.Repository
-	bot     *telegram.Bot
-	ctx     context.Context
-	stop    chan struct{}
-	running bool
+	repo       *storage.Repository
+	bot        *telegram.Bot
+	paymentTTL PaymentTTL
+	clock      storage.Clock
+
+	gracePeriodDays   int   // Grace period for subscriptions stored without its end
+	deviceCleanupDays int   // Days after grace period end before devices are revoked
+	expiringDays      int   // Days before subscription end it's marked expiring
+	reminderDays      []int // Days before subscription end to remind at, descending
+	ctx               context.Context
+	stop              chan struct{}
+	running           bool
 }
 
-func NewService(repo *storage.Repository, bot *telegram.Bot) *Service {
+func NewService(repo *storage.Repository, bot *telegram.Bot, clock storage.Clock, paymentTTL PaymentTTL, gracePeriodDays, deviceCleanupDays, expiringDays int, reminderDays []int) *Service {
 	return &Service{
-		repo: repo,
-		bot:  bot,
-		stop: make(chan struct{}),
+		repo:              repo,
+		bot:               bot,
+		paymentTTL:        paymentTTL,
+		clock:             clock,
+		gracePeriodDays:   gracePeriodDays,
+		deviceCleanupDays: deviceCleanupDays,
+		expiringDays:      expiringDays,
+		reminderDays:      reminderDays,
+		stop:              make(chan struct{}),
 	}
 }
 
+// gracePeriodEnd returns end of subscription grace period. It's stored with subscription,
+// configured grace period is used for subscriptions stored without it
+func (s *Service) gracePeriodEnd(sub *storage.Subscription) time.Time {
+	if sub.GracePeriodEndsAt != nil {
+		return *sub.GracePeriodEndsAt
+	}
+	return sub.EndsAt.AddDate(0, 0, s.gracePeriodDays)
+}
+
 // Start starts the scheduler
 func (s *Service) Start(ctx context.Context) {
 	s.ctx = ctx
@@ -40,10 +93,16 @@ func (s *Service) Start(ctx context.Context) {
 	ticker := time.NewTicker(24 * time.Hour)
 	defer ticker.Stop()
 
+	// Undelivered notifications are retried more often
+	retryTicker := time.NewTicker(notificationRetryInterval)
+	defer retryTicker.Stop()
+
 	for {
 		select {
 		case <-ticker.C:
 			go s.run()
+		case <-retryTicker.C:
+			s.runNotificationRetry()
 		case <-s.stop:
 			return
 		case <-ctx.Done():
@@ -64,7 +123,7 @@ func (s *Service) run() {
 	}
 
 	log.Println("Running scheduler tasks...")
-	now := time.Now()
+	now := s.clock.Now()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
@@ -84,6 +143,11 @@ func (s *Service) run() {
 		log.Printf("Error revoking expired devices: %v", err)
 	}
 
+	// Expire stale payments
+	if err := s.expireStalePayments(ctx, now); err != nil {
+		log.Printf("Error expiring stale payments: %v", err)
+	}
+
 	log.Println("Scheduler tasks completed")
 }
 
@@ -96,14 +160,14 @@ func (s *Service) updateSubscriptionStatuses(ctx context.Context, now time.Time)
 	for _, sub := range subscriptions {
 		var newStatus storage.SubscriptionStatus
 
-		// Check if subscription is expiring (3 days before end)
-		expiringThreshold := sub.EndsAt.AddDate(0, 0, -3)
+		// Check if subscription is expiring (expiringDays before end)
+		expiringThreshold := sub.EndsAt.AddDate(0, 0, -s.expiringDays)
 		if now.After(expiringThreshold) && now.Before(sub.EndsAt) && sub.Status == storage.SubscriptionStatusActive {
 			newStatus = storage.SubscriptionStatusExpiring
 		} else if now.After(sub.EndsAt) && sub.Status == storage.SubscriptionStatusExpiring {
 			// Move to paused (grace period)
 			newStatus = storage.SubscriptionStatusPaused
-		} else if sub.GracePeriodEndsAt != nil && now.After(*sub.GracePeriodEndsAt) && sub.Status == storage.SubscriptionStatusPaused {
+		} else if now.After(s.gracePeriodEnd(sub)) && sub.Status == storage.SubscriptionStatusPaused {
 			// Move to expired
 			newStatus = storage.SubscriptionStatusExpired
 		} else {
@@ -128,77 +192,175 @@ func (s *Service) sendNotifications(ctx context.Context, now time.Time) error {
 	}
 
 	for _, sub := range subscriptions {
-		// Send notification 3 days before expiration
-		expiringThreshold := sub.EndsAt.AddDate(0, 0, -3)
-		expiringDayStart := time.Date(expiringThreshold.Year(), expiringThreshold.Month(), expiringThreshold.Day(), 0, 0, 0, 0, expiringThreshold.Location())
-		expiringDayEnd := expiringDayStart.Add(24 * time.Hour)
-
-		if sub.Status == storage.SubscriptionStatusActive && now.After(expiringDayStart) && now.Before(expiringDayEnd) {
-			// Send expiring notification
-			user, err := s.repo.GetUserByID(ctx, sub.UserID)
-			if err != nil || user == nil {
-				log.Printf("Failed to get user %d for notification: %v", sub.UserID, err)
-				continue
-			}
-
+		// Reminders are sent once per subscription period as soon as the tick falls into their range,
+		// so they don't depend on what time of day scheduler runs
+		if days := dueExpiryReminder(s.reminderDays, sub.EndsAt, now); days > 0 {
 			daysLeft := int(sub.EndsAt.Sub(now).Hours() / 24)
-			message := fmt.Sprintf(
-				"⏰ Ваша подписка истекает через %d дн. (%s).\n\n"+
-					"Оформите продление через меню бота, чтобы продолжить использование VPN.",
-				daysLeft, sub.EndsAt.Format("02.01.2006"),
-			)
-
-			if err := s.bot.SendNotification(user.TelegramID, message); err != nil {
-				log.Printf("Failed to send notification to user %d: %v", user.TelegramID, err)
-			}
+			s.sendReminder(ctx, sub, expiryReminderKind(days), func(user *storage.User) error {
+				return s.bot.SendExpiryReminder(user, sub, daysLeft)
+			})
 		}
 
-		// Send notification when subscription ends
-		if sub.Status == storage.SubscriptionStatusPaused && sub.GracePeriodEndsAt != nil {
-			graceDayStart := time.Date(sub.GracePeriodEndsAt.Year(), sub.GracePeriodEndsAt.Month(), sub.GracePeriodEndsAt.Day(), 0, 0, 0, 0, sub.GracePeriodEndsAt.Location())
-			graceDayEnd := graceDayStart.Add(24 * time.Hour)
-
-			if now.After(graceDayStart) && now.Before(graceDayEnd) {
-				user, err := s.repo.GetUserByID(ctx, sub.UserID)
-				if err != nil || user == nil {
-					log.Printf("Failed to get user %d for notification: %v", sub.UserID, err)
-					continue
-				}
-
-				message := fmt.Sprintf(
-					"⚠️ Ваша подписка истекла. У вас есть 3 дня (до %s) для продления, после чего устройства будут отключены.",
-					sub.GracePeriodEndsAt.Format("02.01.2006"),
-				)
-
-				if err := s.bot.SendNotification(user.TelegramID, message); err != nil {
-					log.Printf("Failed to send notification to user %d: %v", user.TelegramID, err)
-				}
-			}
+		// Send notification when subscription ends and grace period starts
+		if graceEnd := s.gracePeriodEnd(sub); !now.Before(sub.EndsAt) && now.Before(graceEnd) {
+			s.sendReminder(ctx, sub, storage.ReminderKindGrace, func(user *storage.User) error {
+				return s.bot.SendGraceReminder(user, sub, graceEnd)
+			})
 		}
 	}
 
 	return nil
 }
 
+// sendReminder sends renewal reminder with send unless reminder of this kind was already sent for current subscription period
+func (s *Service) sendReminder(ctx context.Context, sub *storage.Subscription, kind storage.ReminderKind, send func(user *storage.User) error) {
+	user, err := s.repo.GetUserByID(ctx, sub.UserID)
+	if err != nil || user == nil {
+		log.Printf("Failed to get user %d for notification: %v", sub.UserID, err)
+		return
+	}
+	// Reminder isn't recorded for user who has blocked the bot, so it's sent if they unblock it while it's still due
+	if user.Blocked {
+		return
+	}
+
+	first, err := s.repo.MarkReminderSent(ctx, sub.ID, kind, sub.EndsAt)
+	if err != nil {
+		log.Printf("Failed to record %s reminder for subscription %d: %v", kind, sub.ID, err)
+		return
+	}
+	if !first {
+		return
+	}
+
+	// Undelivered reminder is queued by bot, so it's already recorded as sent
+	if err := send(user); err != nil {
+		log.Printf("Failed to send notification to user %d: %v", user.TelegramID, err)
+	}
+}
+
 func (s *Service) revokeExpiredDevices(ctx context.Context, now time.Time) error {
-	// Get devices that need to be revoked (30 days after grace period ends)
-	cleanupDate := now.AddDate(0, 0, -30)
+	// Get devices that need to be revoked (deviceCleanupDays after grace period ends)
+	cleanupDate := now.AddDate(0, 0, -s.deviceCleanupDays)
 	devices, err := s.repo.GetExpiredDevicesToCleanup(ctx, cleanupDate)
 	if err != nil {
 		return errors.Wrap(err, "failed to get expired devices")
 	}
 
 	for _, device := range devices {
-		if err := s.repo.RevokeDevice(ctx, device.ID); err != nil {
+		// Peer is removed from the interface too, otherwise its addresses are never reused
+		// and expired device can still connect
+		if err := s.bot.RevokeExpiredDevice(ctx, device); err != nil {
 			log.Printf("Failed to revoke device %d: %v", device.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) expireStalePayments(ctx context.Context, now time.Time) error {
+	stale := []struct {
+		status storage.PaymentStatus
+		ttl    time.Duration
+	}{
+		{status: storage.PaymentStatusCreated, ttl: s.paymentTTL.Created},
+		{status: storage.PaymentStatusPendingReview, ttl: s.paymentTTL.PendingReview},
+	}
+
+	for _, st := range stale {
+		if st.ttl <= 0 {
+			continue
+		}
+
+		payments, err := s.repo.GetPaymentsOlderThan(ctx, st.status, now.Add(-st.ttl))
+		if err != nil {
+			return errors.Wrapf(err, "failed to get stale %s payments", st.status)
+		}
+
+		for _, payment := range payments {
+			// Conditional update: payment approved or rejected meanwhile is left untouched
+			expired, err := s.repo.ExpirePayment(ctx, payment.ID, st.status)
+			if err != nil {
+				log.Printf("Failed to expire payment %d: %v", payment.ID, err)
+				continue
+			}
+			if !expired {
+				continue
+			}
+			log.Printf("Expired stale payment %d (status %s, created %s)", payment.ID, st.status, payment.CreatedAt.Format(time.RFC3339))
+
+			user, err := s.repo.GetUserByID(ctx, payment.UserID)
+			if err != nil || user == nil {
+				log.Printf("Failed to get user %d for notification: %v", payment.UserID, err)
+				continue
+			}
+			if user.Blocked {
+				continue
+			}
+			if err := s.bot.SendPaymentExpired(user, payment); err != nil {
+				log.Printf("Failed to send notification to user %d: %v", user.TelegramID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) runNotificationRetry() {
+	if !s.running {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationRetryInterval)
+	defer cancel()
+
+	if err := s.retryNotifications(ctx, s.clock.Now()); err != nil {
+		log.Printf("Error retrying notifications: %v", err)
+	}
+}
+
+// retryNotifications re-sends queued notifications whose retry time has come.
+// Delay between attempts doubles up to maxNotificationBackoff, after maxNotificationAttempts
+// attempts notification is given up
+func (s *Service) retryNotifications(ctx context.Context, now time.Time) error {
+	notifications, err := s.repo.GetDueNotifications(ctx, now, notificationRetryBatch)
+	if err != nil {
+		return errors.Wrap(err, "failed to get due notifications")
+	}
+
+	for _, notification := range notifications {
+		attempts := notification.Attempts + 1
+		sendErr := s.bot.DeliverNotification(notification)
+		if sendErr == nil {
+			if err := s.repo.MarkNotificationDelivered(ctx, notification.ID); err != nil {
+				log.Printf("Failed to mark notification %d delivered: %v", notification.ID, err)
+			}
+			log.Printf("Delivered queued notification %d to chat %d after %d attempts", notification.ID, notification.ChatID, attempts)
 			continue
 		}
 
-		log.Printf("Revoked expired device %d (user %d)", device.ID, device.UserID)
-		// Note: Actual peer revocation from WireGuard interface should be handled separately
-		// This just marks the device as revoked in the database
+		// Notification to user who has blocked the bot is given up right away
+		giveUp := attempts >= maxNotificationAttempts || errors.Is(sendErr, telegram.ErrUserBlocked)
+		if err := s.repo.RecordNotificationFailure(ctx, notification.ID, sendErr.Error(), now.Add(notificationBackoff(attempts)), giveUp); err != nil {
+			log.Printf("Failed to record notification %d failure: %v", notification.ID, err)
+		}
+		if giveUp {
+			s.bot.DropNotification(notification)
+			log.Printf("Giving up notification %d to chat %d after %d attempts: %v", notification.ID, notification.ChatID, attempts, sendErr)
+		}
 	}
 
 	return nil
 }
 
+// notificationBackoff returns delay before the next delivery attempt after given number of attempts
+func notificationBackoff(attempts int) time.Duration {
+	backoff := notificationRetryInterval
+	for i := 1; i < attempts && backoff < maxNotificationBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxNotificationBackoff {
+		backoff = maxNotificationBackoff
+	}
+	return backoff
+}
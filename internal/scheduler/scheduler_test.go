@@ -0,0 +1,296 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/access"
+	"github.com/skoret/wireguard-bot/internal/billing"
+	"github.com/skoret/wireguard-bot/internal/provisioning"
+	"github.com/skoret/wireguard-bot/internal/storage"
+	"github.com/skoret/wireguard-bot/internal/telegram"
+	"github.com/skoret/wireguard-bot/internal/wireguard"
+)
+
+// testClock is a clock moved by the test
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// fakeSender records texts of sent messages instead of calling Telegram API
+type fakeSender struct {
+	mu    sync.Mutex
+	texts map[int64][]string
+}
+
+func (s *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg, ok := c.(tgbotapi.MessageConfig); ok {
+		s.texts[msg.ChatID] = append(s.texts[msg.ChatID], msg.Text)
+	}
+	return tgbotapi.Message{}, nil
+}
+
+func (s *fakeSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (s *fakeSender) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// sentTo returns texts sent to the chat and forgets them
+func (s *fakeSender) sentTo(chatID int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	texts := s.texts[chatID]
+	delete(s.texts, chatID)
+	return texts
+}
+
+// newTestService returns scheduler with default settings backed by in-memory database, clock starts at now
+func newTestService(t *testing.T, now time.Time) (*Service, *storage.Repository, *fakeSender, *testClock) {
+	t.Helper()
+	return newTestServiceWithWireguard(t, now, nil)
+}
+
+// newTestServiceWithWireguard is newTestService with dev WireGuard wrapped by wrap, if it's set
+func newTestServiceWithWireguard(t *testing.T, now time.Time, wrap func(wireguard.Wireguard) wireguard.Wireguard) (*Service, *storage.Repository, *fakeSender, *testClock) {
+	t.Helper()
+	repo, err := storage.NewRepository(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := repo.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	clock := &testClock{now: now}
+	repo.SetClock(clock)
+
+	billingService := billing.NewService(repo, clock, billing.PaymentRequisites{}, billing.DefaultPricingConfig(),
+		billing.DefaultPlansConfig(), billing.DefaultMaxPendingReviewsPerUser, billing.DefaultGracePeriodDays)
+	accessService := access.NewService(repo, clock, access.DefaultMaxDevicesPerUser)
+	provisioner, err := wireguard.NewDevProvisioner(repo, provisioning.Server{})
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	sender := &fakeSender{texts: make(map[int64][]string)}
+	wg := wireguard.NewWireguardFromProvisioner(provisioner)
+	if wrap != nil {
+		wg = wrap(wg)
+	}
+	bot, err := telegram.NewBotWithSender(sender, wg, repo, billingService, accessService, "")
+	if err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+
+	s := NewService(repo, bot, clock, DefaultPaymentTTL(), billing.DefaultGracePeriodDays,
+		DefaultDeviceCleanupDays, DefaultExpiringDays, DefaultExpiryReminderDays())
+	return s, repo, sender, clock
+}
+
+// createTestSubscription creates active subscription of user with given Telegram ID ending at endsAt
+func createTestSubscription(t *testing.T, repo *storage.Repository, telegramID int64, endsAt time.Time) *storage.Subscription {
+	t.Helper()
+	ctx := context.Background()
+	user, err := repo.GetOrCreateUser(ctx, telegramID, "user")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	subscription := &storage.Subscription{
+		UserID:       user.ID,
+		DurationDays: 30,
+		DeviceLimit:  1,
+		Status:       storage.SubscriptionStatusActive,
+		StartsAt:     endsAt.AddDate(0, 0, -30),
+		EndsAt:       endsAt,
+	}
+	if err := repo.CreateSubscription(ctx, subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	return subscription
+}
+
+func TestSendNotificationsByThreshold(t *testing.T) {
+	endsAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	s, repo, sender, clock := newTestService(t, endsAt.AddDate(0, 0, -20))
+	ctx := context.Background()
+	createTestSubscription(t, repo, 100, endsAt)
+
+	// Daily ticks run at a different time of day than the subscription ends
+	ticks := []struct {
+		now  time.Time
+		want int // Number of reminders sent on the tick
+	}{
+		{now: endsAt.AddDate(0, 0, -8).Add(-3 * time.Hour), want: 0},
+		{now: endsAt.AddDate(0, 0, -7).Add(-3 * time.Hour), want: 0},
+		{now: endsAt.AddDate(0, 0, -6).Add(-3 * time.Hour), want: 1}, // 7 days threshold passed
+		{now: endsAt.AddDate(0, 0, -5).Add(-3 * time.Hour), want: 0},
+		{now: endsAt.AddDate(0, 0, -3).Add(-3 * time.Hour), want: 0},
+		{now: endsAt.AddDate(0, 0, -2).Add(-3 * time.Hour), want: 1}, // 3 days threshold passed
+		{now: endsAt.AddDate(0, 0, -1).Add(-3 * time.Hour), want: 0},
+		{now: endsAt.Add(-3 * time.Hour), want: 1}, // 1 day threshold passed
+	}
+	for _, tick := range ticks {
+		clock.now = tick.now
+		if err := s.sendNotifications(ctx, clock.Now()); err != nil {
+			t.Fatalf("sendNotifications() failed: %v", err)
+		}
+		if texts := sender.sentTo(100); len(texts) != tick.want {
+			t.Errorf("%s: sent %q, want %d reminders", tick.now.Format(time.RFC3339), texts, tick.want)
+		}
+	}
+}
+
+func TestUpdateSubscriptionStatuses(t *testing.T) {
+	endsAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	s, repo, _, clock := newTestService(t, endsAt.AddDate(0, 0, -10))
+	ctx := context.Background()
+	subscription := createTestSubscription(t, repo, 100, endsAt)
+
+	// Subscription passes through every status as the clock moves, one transition per tick
+	ticks := []struct {
+		now  time.Time
+		want storage.SubscriptionStatus
+	}{
+		{now: endsAt.AddDate(0, 0, -DefaultExpiringDays).Add(-time.Hour), want: storage.SubscriptionStatusActive},
+		{now: endsAt.AddDate(0, 0, -DefaultExpiringDays).Add(time.Hour), want: storage.SubscriptionStatusExpiring},
+		{now: endsAt.Add(time.Hour), want: storage.SubscriptionStatusPaused},
+		{now: endsAt.AddDate(0, 0, billing.DefaultGracePeriodDays).Add(-time.Hour), want: storage.SubscriptionStatusPaused},
+		{now: endsAt.AddDate(0, 0, billing.DefaultGracePeriodDays).Add(time.Hour), want: storage.SubscriptionStatusExpired},
+	}
+	for _, tick := range ticks {
+		clock.now = tick.now
+		if err := s.updateSubscriptionStatuses(ctx, clock.Now()); err != nil {
+			t.Fatalf("updateSubscriptionStatuses() failed: %v", err)
+		}
+		got, err := repo.GetSubscriptionByID(ctx, subscription.ID)
+		if err != nil {
+			t.Fatalf("failed to get subscription: %v", err)
+		}
+		if got.Status != tick.want {
+			t.Errorf("%s: status %s, want %s", tick.now.Format(time.RFC3339), got.Status, tick.want)
+		}
+	}
+}
+
+func TestSendNotificationsOncePerPeriod(t *testing.T) {
+	endsAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	s, repo, sender, clock := newTestService(t, endsAt.AddDate(0, 0, -2))
+	ctx := context.Background()
+	subscription := createTestSubscription(t, repo, 100, endsAt)
+
+	// Scheduler restarts run the same tick several times
+	for i, want := range []int{1, 0, 0} {
+		if err := s.sendNotifications(ctx, clock.Now()); err != nil {
+			t.Fatalf("sendNotifications() failed: %v", err)
+		}
+		if texts := sender.sentTo(100); len(texts) != want {
+			t.Errorf("run %d: sent %q, want %d reminders", i+1, texts, want)
+		}
+	}
+
+	// Renewed subscription is reminded again before its new end
+	renewedEndsAt := endsAt.AddDate(0, 0, 30)
+	if err := repo.ExtendSubscription(ctx, subscription.ID, 30, 0, billing.DefaultGracePeriodDays); err != nil {
+		t.Fatalf("failed to extend subscription: %v", err)
+	}
+	clock.now = renewedEndsAt.AddDate(0, 0, -2)
+	if err := s.sendNotifications(ctx, clock.Now()); err != nil {
+		t.Fatalf("sendNotifications() failed: %v", err)
+	}
+	if texts := sender.sentTo(100); len(texts) != 1 {
+		t.Errorf("sent %q after renewal, want one reminder", texts)
+	}
+}
+
+// revokeRecorder records keys of peers removed from WireGuard, removal fails with err if it's set
+type revokeRecorder struct {
+	wireguard.Wireguard
+	attempts int
+	revoked  []string
+	err      error
+}
+
+func (r *revokeRecorder) RevokeDevice(ctx context.Context, device *storage.Device) error {
+	r.attempts++
+	if r.err != nil {
+		return r.err
+	}
+	r.revoked = append(r.revoked, device.PeerPublicKey)
+	return nil
+}
+
+func TestRevokeExpiredDevicesRemovesPeers(t *testing.T) {
+	endsAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	recorder := &revokeRecorder{err: errors.New("interface is down")}
+	s, repo, _, clock := newTestServiceWithWireguard(t, endsAt.AddDate(0, 0, -10), func(wg wireguard.Wireguard) wireguard.Wireguard {
+		recorder.Wireguard = wg
+		return recorder
+	})
+	ctx := context.Background()
+	user, err := repo.GetOrCreateUser(ctx, 100, "user")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	graceEnd := endsAt.AddDate(0, 0, billing.DefaultGracePeriodDays)
+	subscription := &storage.Subscription{
+		UserID:            user.ID,
+		DurationDays:      30,
+		DeviceLimit:       1,
+		Status:            storage.SubscriptionStatusActive,
+		StartsAt:          endsAt.AddDate(0, 0, -30),
+		EndsAt:            endsAt,
+		GracePeriodEndsAt: &graceEnd,
+	}
+	if err := repo.CreateSubscription(ctx, subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	device := &storage.Device{
+		UserID:         subscription.UserID,
+		SubscriptionID: subscription.ID,
+		DeviceName:     "phone",
+		PeerPublicKey:  "key",
+		AssignedIP:     "10.0.0.2",
+	}
+	if err := repo.CreateDevice(ctx, device, false); err != nil {
+		t.Fatalf("failed to create device: %v", err)
+	}
+	if err := repo.UpdateSubscriptionStatus(ctx, subscription.ID, storage.SubscriptionStatusExpired); err != nil {
+		t.Fatalf("failed to expire subscription: %v", err)
+	}
+	clock.now = graceEnd.AddDate(0, 0, DefaultDeviceCleanupDays+1)
+
+	// Device whose peer can't be removed stays active and is retried on the next run
+	if err := s.revokeExpiredDevices(ctx, clock.Now()); err != nil {
+		t.Fatalf("revokeExpiredDevices() failed: %v", err)
+	}
+	if recorder.attempts != 1 {
+		t.Fatalf("%d peer removal attempts, want 1", recorder.attempts)
+	}
+	if got, err := repo.GetDeviceByID(ctx, device.ID); err != nil || got.RevokedAt != nil {
+		t.Fatalf("device revoked with peer left on interface: %+v, %v", got, err)
+	}
+
+	recorder.err = nil
+	if err := s.revokeExpiredDevices(ctx, clock.Now()); err != nil {
+		t.Fatalf("revokeExpiredDevices() failed: %v", err)
+	}
+	if len(recorder.revoked) != 1 || recorder.revoked[0] != "key" {
+		t.Errorf("removed peers %q, want peer of expired device", recorder.revoked)
+	}
+	if got, err := repo.GetDeviceByID(ctx, device.ID); err != nil || got.RevokedAt == nil {
+		t.Errorf("device isn't revoked after peer removal: %+v, %v", got, err)
+	}
+}
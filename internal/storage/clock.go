@@ -0,0 +1,18 @@
+package storage
+
+import "time"
+
+// Clock tells current time. Time-based logic (subscription periods, expiry, reminders) takes it
+// as a dependency, so it can be checked with a fixed clock instead of waiting
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RealClock is the system clock
+var RealClock Clock = realClock{}
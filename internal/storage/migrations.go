@@ -2,11 +2,31 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"time"
 )
 
-// Migrate creates all necessary tables
+// schemaMigrationsMigration creates table recording applied migrations, it's applied before the others
+const schemaMigrationsMigration = "create_schema_migrations"
+
+// Migrate creates all necessary tables. Migrations recorded in schema_migrations are skipped,
+// so every migration is applied once
 func (r *Repository) Migrate(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at DATETIME NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("migration %s failed: %w", schemaMigrationsMigration, err)
+	}
+	if err := r.recordMigration(ctx, schemaMigrationsMigration); err != nil {
+		return err
+	}
+	applied, err := r.appliedMigrationNames(ctx)
+	if err != nil {
+		return err
+	}
+
 	migrations := []struct {
 		name string
 		sql  string
@@ -17,7 +37,10 @@ func (r *Repository) Migrate(ctx context.Context) error {
 				id INTEGER PRIMARY KEY AUTOINCREMENT,
 				telegram_id INTEGER NOT NULL UNIQUE,
 				username TEXT NOT NULL,
-				created_at DATETIME NOT NULL
+				created_at DATETIME NOT NULL,
+				blocked_at DATETIME,
+				language TEXT NOT NULL DEFAULT 'ru',
+				banned INTEGER NOT NULL DEFAULT 0
 			)`,
 		},
 		{
@@ -35,6 +58,13 @@ func (r *Repository) Migrate(ctx context.Context) error {
 				created_at DATETIME NOT NULL,
 				reviewed_at DATETIME,
 				reviewed_by TEXT,
+				notified_at DATETIME,
+				submitted_at DATETIME,
+				rejection_reason TEXT,
+				promo_code TEXT,
+				first_approved_by TEXT,
+				proof_is_document INTEGER NOT NULL DEFAULT 0,
+				server_id TEXT NOT NULL DEFAULT '',
 				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 			)`,
 		},
@@ -63,12 +93,92 @@ func (r *Repository) Migrate(ctx context.Context) error {
 				device_name TEXT NOT NULL,
 				peer_public_key TEXT NOT NULL UNIQUE,
 				assigned_ip TEXT NOT NULL,
+				assigned_ipv6 TEXT,
+				private_key_encrypted TEXT,
+				imported_key INTEGER NOT NULL DEFAULT 0,
+				server_id TEXT NOT NULL DEFAULT '',
 				created_at DATETIME NOT NULL,
 				revoked_at DATETIME,
 				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
 				FOREIGN KEY (subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE
 			)`,
 		},
+		{
+			name: "create_admin_notifications",
+			sql: `CREATE TABLE IF NOT EXISTS admin_notifications (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				payment_id INTEGER NOT NULL,
+				chat_id INTEGER NOT NULL,
+				message_id INTEGER NOT NULL,
+				with_proof INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_promo_codes",
+			sql: `CREATE TABLE IF NOT EXISTS promo_codes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				code TEXT NOT NULL UNIQUE,
+				discount_percent INTEGER NOT NULL DEFAULT 0,
+				discount_amount INTEGER NOT NULL DEFAULT 0,
+				expires_at DATETIME,
+				usage_limit INTEGER NOT NULL DEFAULT 0,
+				used_count INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL
+			)`,
+		},
+		{
+			name: "create_notifications",
+			sql: `CREATE TABLE IF NOT EXISTS notifications (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				chat_id INTEGER NOT NULL,
+				text TEXT NOT NULL,
+				config TEXT,
+				device_id INTEGER,
+				device_name TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at DATETIME NOT NULL,
+				last_error TEXT,
+				delivered_at DATETIME,
+				created_at DATETIME NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_notifications_status_next_attempt ON notifications(status, next_attempt_at);`,
+		},
+		{
+			name: "create_audit_log",
+			sql: `CREATE TABLE IF NOT EXISTS audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				actor TEXT NOT NULL,
+				action TEXT NOT NULL,
+				payment_id INTEGER,
+				user_id INTEGER,
+				details TEXT,
+				amount INTEGER,
+				received_amount INTEGER,
+				created_at DATETIME NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);`,
+		},
+		{
+			name: "create_sent_reminders",
+			sql: `CREATE TABLE IF NOT EXISTS sent_reminders (
+				subscription_id INTEGER NOT NULL,
+				kind TEXT NOT NULL,
+				ends_at DATETIME NOT NULL,
+				sent_at DATETIME NOT NULL,
+				PRIMARY KEY (subscription_id, kind, ends_at),
+				FOREIGN KEY (subscription_id) REFERENCES subscriptions(id)
+			);`,
+		},
+		{
+			name: "create_bot_state",
+			sql: `CREATE TABLE IF NOT EXISTS bot_state (
+				key TEXT PRIMARY KEY,
+				value INTEGER NOT NULL
+			);`,
+		},
 		{
 			name: "create_indexes",
 			sql: `CREATE INDEX IF NOT EXISTS idx_payments_user_id ON payments(user_id);
@@ -80,20 +190,50 @@ func (r *Repository) Migrate(ctx context.Context) error {
 				CREATE INDEX IF NOT EXISTS idx_devices_user_id ON devices(user_id);
 				CREATE INDEX IF NOT EXISTS idx_devices_subscription_id ON devices(subscription_id);
 				CREATE INDEX IF NOT EXISTS idx_devices_peer_public_key ON devices(peer_public_key);
+				CREATE INDEX IF NOT EXISTS idx_admin_notifications_payment_id ON admin_notifications(payment_id);
 			`,
 		},
+		{
+			// Active device names are unique per subscription, so repeated device creation can't make duplicates.
+			// Duplicates left by older versions get device ID appended, otherwise the index can't be created
+			name: "unique_device_names",
+			sql: `UPDATE devices SET device_name = device_name || '_' || id
+				WHERE revoked_at IS NULL AND EXISTS (
+					SELECT 1 FROM devices d
+					WHERE d.subscription_id = devices.subscription_id AND d.device_name = devices.device_name
+					  AND d.revoked_at IS NULL AND d.id < devices.id
+				);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_devices_subscription_name ON devices(subscription_id, device_name) WHERE revoked_at IS NULL;`,
+		},
+		{
+			// Expiry reminders are recorded per offset, e.g. expiring_3. The single reminder of older versions
+			// was sent 3 days before subscription end, so it becomes expiring_3 and isn't sent again
+			name: "expiring_reminder_offsets",
+			sql: `UPDATE OR IGNORE sent_reminders SET kind = 'expiring_3' WHERE kind = 'expiring';
+				DELETE FROM sent_reminders WHERE kind = 'expiring';`,
+		},
+	}
+
+	// Add columns introduced after initial release (for existing databases) before migrations
+	// create indexes on them. New databases don't have the tables yet and get the columns from CREATE TABLE.
+	// SQLite doesn't support IF NOT EXISTS for ALTER TABLE ADD COLUMN
+	// We'll try to add them and ignore the error if they already exist
+	for _, c := range addedColumns {
+		_, _ = r.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, c.table, c.column, c.definition))
 	}
 
 	for _, migration := range migrations {
+		if applied[migration.name] {
+			continue
+		}
 		if _, err := r.db.ExecContext(ctx, migration.sql); err != nil {
 			return fmt.Errorf("migration %s failed: %w", migration.name, err)
 		}
+		if err := r.recordMigration(ctx, migration.name); err != nil {
+			return err
+		}
 	}
 
-	// Add payment_comment column if it doesn't exist (for existing databases)
-	// SQLite doesn't support IF NOT EXISTS for ALTER TABLE ADD COLUMN
-	// We'll try to add it and ignore the error if it already exists
-	_, _ = r.db.ExecContext(ctx, `ALTER TABLE payments ADD COLUMN payment_comment TEXT;`)
 	// Create unique index (will be ignored if already exists)
 	_, _ = r.db.ExecContext(ctx, `
 		CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_comment ON payments(payment_comment) WHERE payment_comment IS NOT NULL;
@@ -102,3 +242,149 @@ func (r *Repository) Migrate(ctx context.Context) error {
 	return nil
 }
 
+// addedColumns lists columns added to existing tables after initial release
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"payments", "payment_comment", "TEXT"},
+	{"payments", "notified_at", "DATETIME"},
+	{"payments", "rejection_reason", "TEXT"},
+	{"payments", "promo_code", "TEXT"},
+	{"payments", "first_approved_by", "TEXT"},
+	{"payments", "proof_is_document", "INTEGER NOT NULL DEFAULT 0"},
+	{"payments", "server_id", "TEXT NOT NULL DEFAULT ''"},
+	{"payments", "submitted_at", "DATETIME"},
+	{"admin_notifications", "with_proof", "INTEGER NOT NULL DEFAULT 0"},
+	{"devices", "assigned_ipv6", "TEXT"},
+	{"devices", "private_key_encrypted", "TEXT"},
+	{"devices", "imported_key", "INTEGER NOT NULL DEFAULT 0"},
+	{"devices", "server_id", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "blocked_at", "DATETIME"},
+	{"users", "language", "TEXT NOT NULL DEFAULT 'ru'"},
+	{"users", "banned", "INTEGER NOT NULL DEFAULT 0"},
+	{"notifications", "device_name", "TEXT NOT NULL DEFAULT ''"},
+	{"notifications", "device_id", "INTEGER"},
+	{"audit_log", "amount", "INTEGER"},
+	{"audit_log", "received_amount", "INTEGER"},
+}
+
+// schemaTables lists tables reported by SchemaReport
+var schemaTables = []string{"users", "payments", "subscriptions", "devices", "admin_notifications", "promo_codes", "notifications", "audit_log", "sent_reminders", "bot_state"}
+
+// recordMigration remembers that migration was applied
+func (r *Repository) recordMigration(ctx context.Context, name string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO schema_migrations (name, applied_at) VALUES (?, ?)`,
+		name, r.clock.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", name, err)
+	}
+	return nil
+}
+
+// appliedMigrationNames returns names of migrations recorded in schema_migrations
+func (r *Repository) appliedMigrationNames(ctx context.Context) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT name FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[name] = true
+	}
+	return applied, rows.Err()
+}
+
+// AppliedMigration describes a migration recorded in schema_migrations
+type AppliedMigration struct {
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableSchema describes table columns as reported by PRAGMA table_info
+type TableSchema struct {
+	Name    string
+	Columns []string // "name TYPE", empty if table doesn't exist
+}
+
+// SchemaReport describes current database schema state
+type SchemaReport struct {
+	Migrations     []AppliedMigration
+	Tables         []TableSchema
+	MissingColumns []string // "table.column" of columns that should have been added by migrations
+}
+
+// SchemaReport inspects database schema without modifying it
+func (r *Repository) SchemaReport(ctx context.Context) (*SchemaReport, error) {
+	report := &SchemaReport{}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT name, applied_at FROM schema_migrations ORDER BY applied_at, name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var m AppliedMigration
+		if err := rows.Scan(&m.Name, &m.AppliedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		report.Migrations = append(report.Migrations, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+
+	columns := make(map[string]bool)
+	for _, table := range schemaTables {
+		schema, err := r.tableSchema(ctx, table, columns)
+		if err != nil {
+			return nil, err
+		}
+		report.Tables = append(report.Tables, schema)
+	}
+
+	for _, c := range addedColumns {
+		if !columns[c.table+"."+c.column] {
+			report.MissingColumns = append(report.MissingColumns, c.table+"."+c.column)
+		}
+	}
+	return report, nil
+}
+
+// tableSchema reads table columns, adding "table.column" keys to seen
+func (r *Repository) tableSchema(ctx context.Context, table string, seen map[string]bool) (TableSchema, error) {
+	schema := TableSchema{Name: table}
+	// Table name comes from schemaTables, PRAGMA doesn't accept placeholders
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return schema, fmt.Errorf("failed to get %s schema: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			typ       string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dfltValue, &pk); err != nil {
+			return schema, fmt.Errorf("failed to scan %s schema: %w", table, err)
+		}
+		schema.Columns = append(schema.Columns, name+" "+typ)
+		seen[table+"."+name] = true
+	}
+	return schema, rows.Err()
+}
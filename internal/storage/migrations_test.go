@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Added columns are applied before migrations only to existing tables, new tables must have them in CREATE TABLE
+func TestMigrateCreatesAddedColumns(t *testing.T) {
+	r := newTestRepository(t)
+	for _, c := range addedColumns {
+		var n int
+		err := r.db.QueryRowContext(context.Background(),
+			`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column,
+		).Scan(&n)
+		if err != nil {
+			t.Fatalf("failed to query columns of %s: %v", c.table, err)
+		}
+		if n != 1 {
+			t.Errorf("column %s.%s is missing in new database", c.table, c.column)
+		}
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	r := newTestRepository(t)
+	if err := r.Migrate(context.Background()); err != nil {
+		t.Fatalf("second Migrate() failed: %v", err)
+	}
+}
+
+// Single expiry reminder of older versions was sent 3 days before the end, it isn't sent again after upgrade
+func TestMigrateLegacyExpiringReminder(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	endsAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	var subscriptions []*Subscription
+	for i := int64(1); i <= 2; i++ {
+		user := createTestUser(t, r, i)
+		subscription := &Subscription{
+			UserID:       user.ID,
+			DurationDays: 30,
+			DeviceLimit:  1,
+			Status:       SubscriptionStatusActive,
+			StartsAt:     endsAt.AddDate(0, 0, -30),
+			EndsAt:       endsAt,
+		}
+		if err := r.CreateSubscription(ctx, subscription); err != nil {
+			t.Fatalf("failed to create subscription: %v", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	legacy, both := subscriptions[0], subscriptions[1]
+
+	// Second subscription got the legacy reminder and the new one, they must not collide
+	for _, reminder := range []struct {
+		subscription *Subscription
+		kind         ReminderKind
+	}{
+		{legacy, ReminderKindExpiring},
+		{both, ReminderKindExpiring},
+		{both, "expiring_3"},
+	} {
+		if _, err := r.MarkReminderSent(ctx, reminder.subscription.ID, reminder.kind, endsAt); err != nil {
+			t.Fatalf("failed to record %s reminder: %v", reminder.kind, err)
+		}
+	}
+
+	// Database of older version hasn't had the migration applied
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE name = 'expiring_reminder_offsets'`); err != nil {
+		t.Fatalf("failed to forget migration: %v", err)
+	}
+	if err := r.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+
+	var legacyLeft int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sent_reminders WHERE kind = ?`, ReminderKindExpiring).Scan(&legacyLeft); err != nil {
+		t.Fatalf("failed to count reminders: %v", err)
+	}
+	if legacyLeft != 0 {
+		t.Errorf("%d legacy expiring reminders left", legacyLeft)
+	}
+	for _, subscription := range subscriptions {
+		first, err := r.MarkReminderSent(ctx, subscription.ID, "expiring_3", endsAt)
+		if err != nil {
+			t.Fatalf("failed to record reminder: %v", err)
+		}
+		if first {
+			t.Errorf("expiring_3 reminder of subscription %d would be sent again", subscription.ID)
+		}
+	}
+}
+
+// Migrations recorded in schema_migrations aren't applied again on the next start
+func TestMigrateSkipsAppliedMigrations(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	user := createTestUser(t, r, 1)
+	subscription := &Subscription{
+		UserID:       user.ID,
+		DurationDays: 30,
+		DeviceLimit:  1,
+		Status:       SubscriptionStatusActive,
+		StartsAt:     time.Now(),
+		EndsAt:       time.Now().AddDate(0, 0, 30),
+	}
+	if err := r.CreateSubscription(ctx, subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	// Row expiring_reminder_offsets would rewrite if it ran again
+	if _, err := r.MarkReminderSent(ctx, subscription.ID, ReminderKindExpiring, subscription.EndsAt); err != nil {
+		t.Fatalf("failed to record reminder: %v", err)
+	}
+
+	if err := r.Migrate(ctx); err != nil {
+		t.Fatalf("second Migrate() failed: %v", err)
+	}
+	var legacy int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sent_reminders WHERE kind = ?`, ReminderKindExpiring).Scan(&legacy); err != nil {
+		t.Fatalf("failed to count reminders: %v", err)
+	}
+	if legacy != 1 {
+		t.Errorf("applied migration ran again, %d rows of it are left, want 1", legacy)
+	}
+}
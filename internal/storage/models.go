@@ -4,11 +4,17 @@ import (
 	"time"
 )
 
+// DefaultUserLanguage is the language of users who haven't chosen one
+const DefaultUserLanguage = "ru"
+
 // User represents a Telegram user
 type User struct {
 	ID         int64
 	TelegramID int64
 	Username   string
+	Language   string // Bot interface language code
+	Banned     bool   // Blocked by admins, can't use the bot
+	Blocked    bool   // User has blocked the bot, messages to them can't be delivered
 	CreatedAt  time.Time
 }
 
@@ -22,22 +28,113 @@ const (
 	PaymentStatusRejected      PaymentStatus = "rejected"
 	PaymentStatusExpired       PaymentStatus = "expired"
 	PaymentStatusCancelled     PaymentStatus = "cancelled"
+
+	// PaymentStatusPendingSecondApproval is used for large payments approved by one admin and awaiting another one
+	PaymentStatusPendingSecondApproval PaymentStatus = "pending_second_approval"
 )
 
 // Payment represents a payment attempt
 type Payment struct {
+	ID              int64
+	UserID          int64
+	DurationDays    int
+	DeviceCount     int
+	Amount          int // in kopecks (1 RUB = 100 kopecks)
+	ReferenceCode   string
+	PaymentComment  string // Unique neutral comment for payment (2-3 Russian words + suffix)
+	Status          PaymentStatus
+	ProofFileID     string
+	ProofIsDocument bool // Proof was sent as a document (image or PDF file) rather than a photo
+	CreatedAt       time.Time
+	ReviewedAt      *time.Time
+	ReviewedBy      *string
+	RejectionReason string // Reason provided by admin on rejection (optional)
+	PromoCode       string // Redeemed promo code (optional)
+	FirstApprovedBy string // Admin who gave the first of two required approvals (optional)
+	ServerID        string // WireGuard server chosen for the first device, empty for the default server
+}
+
+// PaymentWithUser is a payment together with the user who made it
+type PaymentWithUser struct {
+	Payment *Payment
+	User    *User
+}
+
+// PromoCode represents a discount code
+type PromoCode struct {
+	ID              int64
+	Code            string
+	DiscountPercent int // Discount in percent, 0 if fixed discount is used
+	DiscountAmount  int // Fixed discount in kopecks, 0 if percent discount is used
+	ExpiresAt       *time.Time
+	UsageLimit      int // 0 means unlimited
+	UsedCount       int
+	CreatedAt       time.Time
+}
+
+// AdminNotification represents a payment notification message sent to an admin chat
+type AdminNotification struct {
+	ID        int64
+	PaymentID int64
+	ChatID    int64
+	MessageID int
+	WithProof bool // Message shows payment proof, its text is a caption
+	CreatedAt time.Time
+}
+
+// NotificationStatus represents delivery status of a queued user notification
+type NotificationStatus string
+
+const (
+	NotificationStatusPending   NotificationStatus = "pending"
+	NotificationStatusDelivered NotificationStatus = "delivered"
+	NotificationStatusFailed    NotificationStatus = "failed" // Delivery given up after too many attempts
+)
+
+// Notification represents a user message that failed to deliver and waits for retry
+type Notification struct {
 	ID            int64
-	UserID        int64
-	DurationDays  int
-	DeviceCount   int
-	Amount        int // in kopecks (1 RUB = 100 kopecks)
-	ReferenceCode string
-	PaymentComment string // Unique neutral comment for payment (2-3 Russian words + suffix)
-	Status        PaymentStatus
-	ProofFileID   string
+	ChatID        int64
+	Text          string
+	Config        string // WireGuard config queued by older versions, new notifications never store it
+	DeviceID      *int64 // Device whose config is sent as QR code and file after text, optional
+	DeviceName    string // Name of device the config belongs to, used in config file name
+	Status        NotificationStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	DeliveredAt   *time.Time
 	CreatedAt     time.Time
-	ReviewedAt    *time.Time
-	ReviewedBy    *string
+}
+
+// AuditAction identifies an admin action recorded in the audit log
+type AuditAction string
+
+const (
+	AuditActionFirstApproval  AuditAction = "payment_first_approval"
+	AuditActionApprovePayment AuditAction = "payment_approve"
+	AuditActionRejectPayment  AuditAction = "payment_reject"
+	AuditActionRevokeDevice   AuditAction = "device_revoke"
+	AuditActionBanUser        AuditAction = "user_ban"
+	AuditActionUnbanUser      AuditAction = "user_unban"
+	AuditActionResendConfig   AuditAction = "device_resend_config"
+	AuditActionCreateDevice   AuditAction = "device_create"
+	AuditActionResyncDevice   AuditAction = "device_resync"
+	AuditActionBroadcast      AuditAction = "broadcast"
+)
+
+// AuditEntry is a single record of the audit log
+type AuditEntry struct {
+	ID        int64
+	Actor     string // Admin username, or "scheduler" for automatic actions
+	Action    AuditAction
+	PaymentID *int64 // Related payment, optional
+	UserID    *int64 // Affected user, optional
+	Details   string
+	// Payment amount and amount actually received when it differs, in kopecks, optional
+	Amount         *int
+	ReceivedAmount *int
+	CreatedAt      time.Time
 }
 
 // SubscriptionStatus represents subscription status
@@ -50,6 +147,14 @@ const (
 	SubscriptionStatusExpired  SubscriptionStatus = "expired"
 )
 
+// ReminderKind identifies a subscription reminder sent to the user
+type ReminderKind string
+
+const (
+	ReminderKindExpiring ReminderKind = "expiring" // Subscription ends soon, suffixed with days before end: expiring_3
+	ReminderKindGrace    ReminderKind = "grace"    // Subscription ended, grace period started
+)
+
 // Subscription represents a user subscription
 type Subscription struct {
 	ID                int64
@@ -66,18 +171,14 @@ type Subscription struct {
 
 // Device represents a user device with WireGuard peer
 type Device struct {
-	ID            int64
-	UserID        int64
+	ID             int64
+	UserID         int64
 	SubscriptionID int64
-	DeviceName    string
-	PeerPublicKey string
-	AssignedIP    string
-	CreatedAt     time.Time
-	RevokedAt     *time.Time
+	DeviceName     string
+	PeerPublicKey  string
+	AssignedIP     string
+	AssignedIPv6   string // Empty if IPv6 is disabled
+	ServerID       string // WireGuard server the device is provisioned on, empty for the default server
+	CreatedAt      time.Time
+	RevokedAt      *time.Time
 }
-
-// GetTime returns current time (helper for testing)
-func GetTime() time.Time {
-	return time.Now()
-}
-
@@ -11,11 +11,48 @@ import (
 	"strings"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
 )
 
+// ErrPromoCodeUnavailable is returned when promo code doesn't exist, expired or reached its usage limit
+var ErrPromoCodeUnavailable = errors.New("promo code is not available")
+
+// ErrDuplicatePaymentCode is returned when new payment's reference code or comment is already taken
+var ErrDuplicatePaymentCode = errors.New("payment reference code or comment already exists")
+
+// ErrDuplicateDeviceName is returned when subscription already has an active device with the same name
+var ErrDuplicateDeviceName = errors.New("active device with this name already exists")
+
+// IsDuplicateDeviceName reports whether insert failed on unique active device name of subscription,
+// for device inserts made outside of Repository
+func IsDuplicateDeviceName(err error) bool {
+	return isUniqueViolation(err, "devices.device_name")
+}
+
+// sqliteConstraintUnique is SQLITE_CONSTRAINT_UNIQUE extended result code
+const sqliteConstraintUnique = 2067
+
+// isUniqueViolation reports whether err is SQLite unique constraint violation on given table column
+func isUniqueViolation(err error, column string) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code() != sqliteConstraintUnique {
+		return false
+	}
+	return strings.Contains(sqliteErr.Error(), column)
+}
+
+// PaymentStatusError is returned when payment can't be processed in its current status
+type PaymentStatusError struct {
+	Status PaymentStatus
+}
+
+func (e *PaymentStatusError) Error() string {
+	return fmt.Sprintf("payment is in %s status", e.Status)
+}
+
 type Repository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock Clock // Source of timestamps written to the database
 }
 
 // NewRepository creates a new repository instance
@@ -82,6 +119,11 @@ func NewRepository(dsn string) (*Repository, error) {
 			return nil, fmt.Errorf("failed to open SQLite database '%s': %w", dsn, err)
 		}
 		log.Printf("SQLite database opened successfully")
+		if dsn == ":memory:" {
+			// Every connection to :memory: opens its own empty database, so a pooled connection
+			// opened after Migrate would see no tables. In-memory database is used by tests only
+			db.SetMaxOpenConns(1)
+		}
 	} else {
 		return nil, fmt.Errorf("unsupported driver '%s', only SQLite is supported (DSN: %s)", driver, dsn)
 	}
@@ -91,23 +133,40 @@ func NewRepository(dsn string) (*Repository, error) {
 	}
 	log.Printf("Database connection established successfully")
 
-	return &Repository{db: db}, nil
+	return &Repository{db: db, clock: RealClock}, nil
 }
 
 func (r *Repository) Close() error {
 	return r.db.Close()
 }
 
+// SetClock replaces clock used for timestamps, system clock is used by default
+func (r *Repository) SetClock(clock Clock) {
+	r.clock = clock
+}
+
+// Clock returns clock used for timestamps
+func (r *Repository) Clock() Clock {
+	return r.clock
+}
+
 // User operations
 
 func (r *Repository) GetOrCreateUser(ctx context.Context, telegramID int64, username string) (*User, error) {
 	user := &User{}
 	err := r.db.QueryRowContext(ctx,
-		"SELECT id, telegram_id, username, created_at FROM users WHERE telegram_id = ?",
+		"SELECT id, telegram_id, username, language, banned, blocked_at IS NOT NULL, created_at FROM users WHERE telegram_id = ?",
 		telegramID,
-	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.CreatedAt)
+	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Language, &user.Banned, &user.Blocked, &user.CreatedAt)
 
 	if err == nil {
+		// User writes to the bot again, so they have unblocked it
+		if user.Blocked {
+			if err := r.MarkUserUnblocked(ctx, telegramID); err != nil {
+				return nil, err
+			}
+			user.Blocked = false
+		}
 		return user, nil
 	}
 	if err != sql.ErrNoRows {
@@ -117,7 +176,7 @@ func (r *Repository) GetOrCreateUser(ctx context.Context, telegramID int64, user
 	// User doesn't exist, create it
 	result, err := r.db.ExecContext(ctx,
 		"INSERT INTO users (telegram_id, username, created_at) VALUES (?, ?, ?)",
-		telegramID, username, time.Now(),
+		telegramID, username, r.clock.Now(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
@@ -132,16 +191,17 @@ func (r *Repository) GetOrCreateUser(ctx context.Context, telegramID int64, user
 		ID:         id,
 		TelegramID: telegramID,
 		Username:   username,
-		CreatedAt:  time.Now(),
+		Language:   DefaultUserLanguage,
+		CreatedAt:  r.clock.Now(),
 	}, nil
 }
 
 func (r *Repository) GetUserByTelegramID(ctx context.Context, telegramID int64) (*User, error) {
 	user := &User{}
 	err := r.db.QueryRowContext(ctx,
-		"SELECT id, telegram_id, username, created_at FROM users WHERE telegram_id = ?",
+		"SELECT id, telegram_id, username, language, banned, blocked_at IS NOT NULL, created_at FROM users WHERE telegram_id = ?",
 		telegramID,
-	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.CreatedAt)
+	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Language, &user.Banned, &user.Blocked, &user.CreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -156,9 +216,9 @@ func (r *Repository) GetUserByTelegramID(ctx context.Context, telegramID int64)
 func (r *Repository) GetUserByID(ctx context.Context, id int64) (*User, error) {
 	user := &User{}
 	err := r.db.QueryRowContext(ctx,
-		"SELECT id, telegram_id, username, created_at FROM users WHERE id = ?",
+		"SELECT id, telegram_id, username, language, banned, blocked_at IS NOT NULL, created_at FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.CreatedAt)
+	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Language, &user.Banned, &user.Blocked, &user.CreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -170,12 +230,13 @@ func (r *Repository) GetUserByID(ctx context.Context, id int64) (*User, error) {
 	return user, nil
 }
 
+// GetUserByUsername finds user by username ignoring case, Telegram usernames are case-insensitive
 func (r *Repository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	user := &User{}
 	err := r.db.QueryRowContext(ctx,
-		"SELECT id, telegram_id, username, created_at FROM users WHERE username = ?",
+		"SELECT id, telegram_id, username, language, banned, blocked_at IS NOT NULL, created_at FROM users WHERE username = ? COLLATE NOCASE",
 		username,
-	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.CreatedAt)
+	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Language, &user.Banned, &user.Blocked, &user.CreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -187,16 +248,138 @@ func (r *Repository) GetUserByUsername(ctx context.Context, username string) (*U
 	return user, nil
 }
 
+// GetAllUserTelegramIDs returns Telegram IDs of all users who haven't blocked the bot and aren't banned
+func (r *Repository) GetAllUserTelegramIDs(ctx context.Context) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT telegram_id FROM users WHERE blocked_at IS NULL AND banned = 0 ORDER BY id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetUserLanguage changes user's bot interface language
+func (r *Repository) SetUserLanguage(ctx context.Context, userID int64, language string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE users SET language = ? WHERE id = ?`,
+		language, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set user language: %w", err)
+	}
+	return nil
+}
+
+// SetUserBanned bans or unbans user, banned users can't use the bot
+func (r *Repository) SetUserBanned(ctx context.Context, userID int64, banned bool) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE users SET banned = ? WHERE id = ?`,
+		banned, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set user banned: %w", err)
+	}
+	return nil
+}
+
+// MarkUserBlocked records that user has blocked the bot, so they are skipped by broadcasts and notifications
+func (r *Repository) MarkUserBlocked(ctx context.Context, telegramID int64) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE users SET blocked_at = ? WHERE telegram_id = ? AND blocked_at IS NULL`,
+		r.clock.Now(), telegramID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark user blocked: %w", err)
+	}
+	return nil
+}
+
+// MarkUserUnblocked clears blocked mark of user who has started using the bot again
+func (r *Repository) MarkUserUnblocked(ctx context.Context, telegramID int64) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE users SET blocked_at = NULL WHERE telegram_id = ?`,
+		telegramID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark user unblocked: %w", err)
+	}
+	return nil
+}
+
 // Payment operations
 
 func (r *Repository) CreatePayment(ctx context.Context, payment *Payment) error {
-	result, err := r.db.ExecContext(ctx,
-		`INSERT INTO payments (user_id, duration_days, device_count, amount, reference_code, payment_comment, status, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+	return r.insertPayment(ctx, r.db, payment)
+}
+
+// CreatePaymentWithPromoCode redeems promo code and creates payment in a single transaction,
+// so concurrent redemptions can't exceed the code's usage limit. The use is returned to the code
+// when payment is cancelled, expires or is rejected
+func (r *Repository) CreatePaymentWithPromoCode(ctx context.Context, payment *Payment) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE promo_codes SET used_count = used_count + 1
+		 WHERE code = ? AND (usage_limit = 0 OR used_count < usage_limit) AND (expires_at IS NULL OR expires_at > ?)`,
+		payment.PromoCode, r.clock.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to redeem promo code: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if affected != 1 {
+		return ErrPromoCodeUnavailable
+	}
+
+	if err := r.insertPayment(ctx, tx, payment); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// dbtx is implemented by both *sql.DB and *sql.Tx
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (r *Repository) insertPayment(ctx context.Context, db dbtx, payment *Payment) error {
+	var promoCode *string
+	if payment.PromoCode != "" {
+		promoCode = &payment.PromoCode
+	}
+	result, err := db.ExecContext(ctx,
+		`INSERT INTO payments (user_id, duration_days, device_count, amount, reference_code, payment_comment, status, promo_code, server_id, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		payment.UserID, payment.DurationDays, payment.DeviceCount, payment.Amount,
-		payment.ReferenceCode, payment.PaymentComment, payment.Status, time.Now(),
+		payment.ReferenceCode, payment.PaymentComment, payment.Status, promoCode, payment.ServerID, r.clock.Now(),
 	)
 	if err != nil {
+		if isUniqueViolation(err, "payments.reference_code") || isUniqueViolation(err, "payments.payment_comment") {
+			return fmt.Errorf("failed to create payment: %w", ErrDuplicatePaymentCode)
+		}
 		return fmt.Errorf("failed to create payment: %w", err)
 	}
 
@@ -209,125 +392,201 @@ func (r *Repository) CreatePayment(ctx context.Context, payment *Payment) error
 	return nil
 }
 
-func (r *Repository) GetPaymentByID(ctx context.Context, id int64) (*Payment, error) {
+// paymentColumns are payments columns in the order scanPayment reads them
+const paymentColumns = `id, user_id, duration_days, device_count, amount, reference_code, payment_comment, status,
+		 proof_file_id, proof_is_document, created_at, reviewed_at, reviewed_by, rejection_reason, promo_code, first_approved_by, server_id`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPayment reads payment selected with paymentColumns, extra destinations are scanned
+// from columns selected after them. Scan error is returned as is, so sql.ErrNoRows can be checked
+func scanPayment(row rowScanner, extra ...interface{}) (*Payment, error) {
 	payment := &Payment{}
-	var proofFileID sql.NullString
-	err := r.db.QueryRowContext(ctx,
-		`SELECT id, user_id, duration_days, device_count, amount, reference_code, payment_comment, status,
-		 proof_file_id, created_at, reviewed_at, reviewed_by
-		 FROM payments WHERE id = ?`,
-		id,
-	).Scan(
+	var paymentComment, proofFileID, rejectionReason, promoCode, firstApprovedBy sql.NullString
+	dest := []interface{}{
 		&payment.ID, &payment.UserID, &payment.DurationDays, &payment.DeviceCount,
-		&payment.Amount, &payment.ReferenceCode, &payment.PaymentComment, &payment.Status,
-		&proofFileID, &payment.CreatedAt, &payment.ReviewedAt, &payment.ReviewedBy,
-	)
+		&payment.Amount, &payment.ReferenceCode, &paymentComment, &payment.Status,
+		&proofFileID, &payment.ProofIsDocument, &payment.CreatedAt, &payment.ReviewedAt, &payment.ReviewedBy, &rejectionReason, &promoCode, &firstApprovedBy, &payment.ServerID,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	payment.PaymentComment = paymentComment.String
+	payment.ProofFileID = proofFileID.String
+	payment.RejectionReason = rejectionReason.String
+	payment.PromoCode = promoCode.String
+	payment.FirstApprovedBy = firstApprovedBy.String
+	return payment, nil
+}
+
+// queryPayments returns payments selected by query with paymentColumns
+func (r *Repository) queryPayments(ctx context.Context, query string, args ...interface{}) ([]*Payment, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []*Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment: %w", err)
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+func (r *Repository) GetPaymentByID(ctx context.Context, id int64) (*Payment, error) {
+	payment, err := scanPayment(r.db.QueryRowContext(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE id = ?`,
+		id,
+	))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to query payment: %w", err)
 	}
-	if proofFileID.Valid {
-		payment.ProofFileID = proofFileID.String
-	}
 	return payment, nil
 }
 
+// GetPaymentWithUser returns payment and its user in a single query, nil if payment doesn't exist
+func (r *Repository) GetPaymentWithUser(ctx context.Context, paymentID int64) (*PaymentWithUser, error) {
+	user := &User{}
+	// Payment columns are listed in paymentColumns order, qualified to tell them from user columns
+	payment, err := scanPayment(r.db.QueryRowContext(ctx,
+		`SELECT p.id, p.user_id, p.duration_days, p.device_count, p.amount, p.reference_code, p.payment_comment, p.status,
+		 p.proof_file_id, p.proof_is_document, p.created_at, p.reviewed_at, p.reviewed_by, p.rejection_reason, p.promo_code, p.first_approved_by, p.server_id,
+		 u.id, u.telegram_id, u.username, u.language, u.banned, u.blocked_at IS NOT NULL, u.created_at
+		 FROM payments p
+		 JOIN users u ON u.id = p.user_id
+		 WHERE p.id = ?`,
+		paymentID,
+	), &user.ID, &user.TelegramID, &user.Username, &user.Language, &user.Banned, &user.Blocked, &user.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query payment with user: %w", err)
+	}
+	return &PaymentWithUser{Payment: payment, User: user}, nil
+}
+
 func (r *Repository) GetPaymentByReferenceCode(ctx context.Context, referenceCode string) (*Payment, error) {
-	payment := &Payment{}
-	var proofFileID sql.NullString
-	err := r.db.QueryRowContext(ctx,
-		`SELECT id, user_id, duration_days, device_count, amount, reference_code, payment_comment, status,
-		 proof_file_id, created_at, reviewed_at, reviewed_by
-		 FROM payments WHERE reference_code = ?`,
+	payment, err := scanPayment(r.db.QueryRowContext(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE reference_code = ?`,
 		referenceCode,
-	).Scan(
-		&payment.ID, &payment.UserID, &payment.DurationDays, &payment.DeviceCount,
-		&payment.Amount, &payment.ReferenceCode, &payment.PaymentComment, &payment.Status,
-		&proofFileID, &payment.CreatedAt, &payment.ReviewedAt, &payment.ReviewedBy,
-	)
+	))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to query payment: %w", err)
 	}
-	if proofFileID.Valid {
-		payment.ProofFileID = proofFileID.String
+	return payment, nil
+}
+
+// GetPaymentByComment returns payment with given payment comment, nil if there is none
+func (r *Repository) GetPaymentByComment(ctx context.Context, comment string) (*Payment, error) {
+	payment, err := scanPayment(r.db.QueryRowContext(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE payment_comment = ?`,
+		comment,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query payment: %w", err)
 	}
 	return payment, nil
 }
 
 func (r *Repository) GetPaymentsByUserIDAndStatus(ctx context.Context, userID int64, status PaymentStatus) ([]*Payment, error) {
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, user_id, duration_days, device_count, amount, reference_code, payment_comment, status,
-		 proof_file_id, created_at, reviewed_at, reviewed_by
-		 FROM payments WHERE user_id = ? AND status = ? ORDER BY created_at ASC`,
+	return r.queryPayments(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE user_id = ? AND status = ? ORDER BY created_at ASC`,
 		userID, status,
 	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query payments: %w", err)
-	}
-	defer rows.Close()
+}
 
-	var payments []*Payment
-	for rows.Next() {
-		payment := &Payment{}
-		var proofFileID sql.NullString
-		err := rows.Scan(
-			&payment.ID, &payment.UserID, &payment.DurationDays, &payment.DeviceCount,
-			&payment.Amount, &payment.ReferenceCode, &payment.PaymentComment, &payment.Status,
-			&proofFileID, &payment.CreatedAt, &payment.ReviewedAt, &payment.ReviewedBy,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan payment: %w", err)
-		}
-		if proofFileID.Valid {
-			payment.ProofFileID = proofFileID.String
-		}
-		payments = append(payments, payment)
-	}
-	return payments, nil
+// GetPaymentsByUserID returns all user's payments in any status, newest first
+func (r *Repository) GetPaymentsByUserID(ctx context.Context, userID int64) ([]*Payment, error) {
+	return r.queryPayments(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE user_id = ? ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
 }
 
 func (r *Repository) GetPendingPayments(ctx context.Context) ([]*Payment, error) {
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, user_id, duration_days, device_count, amount, reference_code, payment_comment, status,
-		 proof_file_id, created_at, reviewed_at, reviewed_by
-		 FROM payments WHERE status = ? ORDER BY created_at ASC`,
-		PaymentStatusPendingReview,
+	return r.queryPayments(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE status IN (?, ?) ORDER BY created_at ASC`,
+		PaymentStatusPendingReview, PaymentStatusPendingSecondApproval,
+	)
+}
+
+// GetPaymentsOlderThan returns payments that entered given status before cutoff: payments in review
+// by the time proof was submitted, others by creation time
+func (r *Repository) GetPaymentsOlderThan(ctx context.Context, status PaymentStatus, cutoff time.Time) ([]*Payment, error) {
+	return r.queryPayments(ctx,
+		`SELECT `+paymentColumns+` FROM payments
+		 WHERE status = ? AND COALESCE(submitted_at, created_at) < ? ORDER BY COALESCE(submitted_at, created_at) ASC`,
+		status, cutoff,
+	)
+}
+
+// GetPaymentsByStatus returns a page of payments in given status, newest first
+func (r *Repository) GetPaymentsByStatus(ctx context.Context, status PaymentStatus, limit, offset int) ([]*Payment, error) {
+	return r.queryPayments(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE status = ? ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+		status, limit, offset,
 	)
+}
+
+// ExpirePayment moves payment to expired status if it is still in fromStatus.
+// Returns false if payment status has changed meanwhile
+func (r *Repository) ExpirePayment(ctx context.Context, id int64, fromStatus PaymentStatus) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query pending payments: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var payments []*Payment
-	for rows.Next() {
-		payment := &Payment{}
-		var proofFileID sql.NullString
-		err := rows.Scan(
-			&payment.ID, &payment.UserID, &payment.DurationDays, &payment.DeviceCount,
-			&payment.Amount, &payment.ReferenceCode, &payment.PaymentComment, &payment.Status,
-			&proofFileID, &payment.CreatedAt, &payment.ReviewedAt, &payment.ReviewedBy,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan payment: %w", err)
-		}
-		if proofFileID.Valid {
-			payment.ProofFileID = proofFileID.String
-		}
-		payments = append(payments, payment)
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payments SET status = ? WHERE id = ? AND status = ?`,
+		PaymentStatusExpired, id, fromStatus,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to expire payment: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
 	}
-	return payments, nil
+	if affected != 1 {
+		return false, nil
+	}
+	if err := releasePromoCode(ctx, tx, id); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
 }
 
+// UpdatePaymentStatus sets payment status and reviewer. Moving payment to pending review records
+// submission time, pending review TTL is counted from it
 func (r *Repository) UpdatePaymentStatus(ctx context.Context, id int64, status PaymentStatus, reviewedBy *string) error {
-	now := time.Now()
+	now := r.clock.Now()
 	_, err := r.db.ExecContext(ctx,
-		`UPDATE payments SET status = ?, reviewed_at = ?, reviewed_by = ? WHERE id = ?`,
-		status, now, reviewedBy, id,
+		`UPDATE payments SET status = ?, reviewed_at = ?, reviewed_by = ?,
+		 submitted_at = CASE WHEN ? = ? AND status != ? THEN ? ELSE submitted_at END
+		 WHERE id = ?`,
+		status, now, reviewedBy, status, PaymentStatusPendingReview, PaymentStatusPendingReview, now, id,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update payment status: %w", err)
@@ -335,98 +594,639 @@ func (r *Repository) UpdatePaymentStatus(ctx context.Context, id int64, status P
 	return nil
 }
 
-func (r *Repository) AttachProofToPayment(ctx context.Context, id int64, proofFileID string) error {
-	_, err := r.db.ExecContext(ctx,
-		`UPDATE payments SET status = ?, proof_file_id = ? WHERE id = ?`,
-		PaymentStatusPendingReview, proofFileID, id,
-	)
+// RejectPayment rejects payment awaiting review. Payment processed concurrently yields *PaymentStatusError
+func (r *Repository) RejectPayment(ctx context.Context, id int64, reviewedBy *string, reason string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to attach proof to payment: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	return nil
-}
-
-// Subscription operations
+	defer tx.Rollback()
 
-func (r *Repository) CreateSubscription(ctx context.Context, subscription *Subscription) error {
-	result, err := r.db.ExecContext(ctx,
-		`INSERT INTO subscriptions (user_id, duration_days, device_limit, amount, status, starts_at, ends_at, grace_period_ends_at, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		subscription.UserID, subscription.DurationDays, subscription.DeviceLimit, subscription.Amount,
-		subscription.Status, subscription.StartsAt, subscription.EndsAt, subscription.GracePeriodEndsAt, time.Now(),
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payments SET status = ?, reviewed_at = ?, reviewed_by = ?, rejection_reason = ?
+		 WHERE id = ? AND status IN (?, ?)`,
+		PaymentStatusRejected, r.clock.Now(), reviewedBy, sql.NullString{String: reason, Valid: reason != ""}, id,
+		PaymentStatusPendingReview, PaymentStatusPendingSecondApproval,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create subscription: %w", err)
+		return fmt.Errorf("failed to reject payment: %w", err)
+	}
+	if err := paymentTransitioned(ctx, tx, result, id); err != nil {
+		return err
+	}
+	if err := releasePromoCode(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return nil
+}
 
-	id, err := result.LastInsertId()
+// releasePromoCode returns promo code use taken by payment that won't be paid,
+// so cancelled, expired and rejected payments don't use up limited codes
+func releasePromoCode(ctx context.Context, db dbtx, paymentID int64) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE promo_codes SET used_count = used_count - 1
+		 WHERE used_count > 0 AND code = (SELECT promo_code FROM payments WHERE id = ?)`,
+		paymentID,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %w", err)
+		return fmt.Errorf("failed to release promo code: %w", err)
 	}
-
-	subscription.ID = id
 	return nil
 }
 
-func (r *Repository) GetActiveSubscriptionByUserID(ctx context.Context, userID int64) (*Subscription, error) {
-	subscription := &Subscription{}
-	err := r.db.QueryRowContext(ctx,
-		`SELECT id, user_id, duration_days, device_limit, amount, status, starts_at, ends_at, grace_period_ends_at, created_at
-		 FROM subscriptions WHERE user_id = ? AND status IN (?, ?, ?) ORDER BY created_at DESC LIMIT 1`,
-		userID, SubscriptionStatusActive, SubscriptionStatusExpiring, SubscriptionStatusPaused,
-	).Scan(
-		&subscription.ID, &subscription.UserID, &subscription.DurationDays, &subscription.DeviceLimit,
-		&subscription.Amount, &subscription.Status, &subscription.StartsAt, &subscription.EndsAt,
-		&subscription.GracePeriodEndsAt, &subscription.CreatedAt,
-	)
+// paymentTransitioned checks that conditional payment status update changed the payment,
+// otherwise returns *PaymentStatusError with status the payment has been moved to meanwhile
+func paymentTransitioned(ctx context.Context, db dbtx, result sql.Result, paymentID int64) error {
+	affected, err := result.RowsAffected()
 	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if affected == 1 {
+		return nil
+	}
+	var status PaymentStatus
+	if err := db.QueryRowContext(ctx, `SELECT status FROM payments WHERE id = ?`, paymentID).Scan(&status); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil
+			return errors.New("payment not found")
 		}
-		return nil, fmt.Errorf("failed to query subscription: %w", err)
+		return fmt.Errorf("failed to query payment status: %w", err)
 	}
-	return subscription, nil
+	return &PaymentStatusError{Status: status}
 }
 
-func (r *Repository) UpdateSubscriptionStatus(ctx context.Context, id int64, status SubscriptionStatus) error {
-	_, err := r.db.ExecContext(ctx,
-		`UPDATE subscriptions SET status = ? WHERE id = ?`,
-		status, id,
-	)
+// ApprovePayment approves payment and creates or extends user's subscription in a single transaction.
+// Payment status is changed only if it's still awaiting review, so a payment processed concurrently
+// (approved, rejected or cancelled by user) yields *PaymentStatusError and no subscription changes.
+// Second approval by the admin who gave the first one yields *PaymentStatusError with pending second approval status
+func (r *Repository) ApprovePayment(ctx context.Context, paymentID int64, reviewedBy string, gracePeriodDays int) error {
+	return r.approvePayment(ctx, paymentID, reviewedBy, gracePeriodDays,
+		PaymentStatusPendingReview, PaymentStatusPendingSecondApproval)
+}
+
+// ApprovePaidPayment approves payment charged by payment provider like ApprovePayment does.
+// Money is already taken, so payment is approved in any status but approved, including
+// expired and cancelled ones. Only already approved payment yields *PaymentStatusError
+func (r *Repository) ApprovePaidPayment(ctx context.Context, paymentID int64, reviewedBy string, gracePeriodDays int) error {
+	return r.approvePayment(ctx, paymentID, reviewedBy, gracePeriodDays,
+		PaymentStatusCreated, PaymentStatusPendingReview, PaymentStatusPendingSecondApproval,
+		PaymentStatusExpired, PaymentStatusCancelled, PaymentStatusRejected)
+}
+
+// approvePayment approves payment in one of fromStatuses and creates or extends user's subscription
+func (r *Repository) approvePayment(ctx context.Context, paymentID int64, reviewedBy string, gracePeriodDays int, fromStatuses ...PaymentStatus) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to update subscription status: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	return nil
-}
+	defer tx.Rollback()
 
-func (r *Repository) GetSubscriptionsNeedingUpdate(ctx context.Context, now time.Time) ([]*Subscription, error) {
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, user_id, duration_days, device_limit, amount, status, starts_at, ends_at, grace_period_ends_at, created_at
-		 FROM subscriptions WHERE status IN (?, ?, ?)`,
-		SubscriptionStatusActive, SubscriptionStatusExpiring, SubscriptionStatusPaused,
+	// Closed payment charged by provider takes back promo code use it has released
+	var previous PaymentStatus
+	if err := tx.QueryRowContext(ctx, `SELECT status FROM payments WHERE id = ?`, paymentID).Scan(&previous); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to query payment status: %w", err)
+	}
+
+	// Status transition goes first and is conditional, so only one of concurrent approvals
+	// gets to change subscription, others see the payment already approved.
+	// Admin who gave the first approval can't give the second one, the payment stays unchanged then
+	now := r.clock.Now()
+	args := []interface{}{PaymentStatusApproved, now, reviewedBy, paymentID, reviewedBy}
+	for _, status := range fromStatuses {
+		args = append(args, status)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(fromStatuses)), ", ")
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payments SET status = ?, reviewed_at = ?, reviewed_by = ?
+		 WHERE id = ? AND (first_approved_by IS NULL OR first_approved_by <> ?) AND status IN (`+placeholders+`)`,
+		args...,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+		return fmt.Errorf("failed to update payment status: %w", err)
 	}
-	defer rows.Close()
-
-	var subscriptions []*Subscription
-	for rows.Next() {
-		sub := &Subscription{}
-		err := rows.Scan(
-			&sub.ID, &sub.UserID, &sub.DurationDays, &sub.DeviceLimit,
-			&sub.Amount, &sub.Status, &sub.StartsAt, &sub.EndsAt,
-			&sub.GracePeriodEndsAt, &sub.CreatedAt,
+	if err := paymentTransitioned(ctx, tx, result, paymentID); err != nil {
+		return err
+	}
+	switch previous {
+	case PaymentStatusCancelled, PaymentStatusExpired, PaymentStatusRejected:
+		_, err := tx.ExecContext(ctx,
+			`UPDATE promo_codes SET used_count = used_count + 1 WHERE code = (SELECT promo_code FROM payments WHERE id = ?)`,
+			paymentID,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+			return fmt.Errorf("failed to redeem promo code: %w", err)
 		}
-		subscriptions = append(subscriptions, sub)
 	}
-	return subscriptions, nil
-}
 
-func (r *Repository) ExtendSubscription(ctx context.Context, subscriptionID int64, durationDays int, amount int) error {
+	payment := &Payment{}
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_id, duration_days, device_count, amount, status FROM payments WHERE id = ?`,
+		paymentID,
+	).Scan(&payment.ID, &payment.UserID, &payment.DurationDays, &payment.DeviceCount, &payment.Amount, &payment.Status)
+	if err != nil {
+		return fmt.Errorf("failed to query payment: %w", err)
+	}
+
+	activeSub, err := queryActiveSubscription(ctx, tx, payment.UserID)
+	if err != nil {
+		return err
+	}
+	if activeSub != nil {
+		if err := extendSubscription(ctx, tx, activeSub, payment.DurationDays, payment.Amount, gracePeriodDays); err != nil {
+			return err
+		}
+	} else {
+		endsAt := now.AddDate(0, 0, payment.DurationDays)
+		gracePeriodEndsAt := endsAt.AddDate(0, 0, gracePeriodDays)
+		subscription := &Subscription{
+			UserID:            payment.UserID,
+			DurationDays:      payment.DurationDays,
+			DeviceLimit:       payment.DeviceCount,
+			Amount:            payment.Amount,
+			Status:            SubscriptionStatusActive,
+			StartsAt:          now,
+			EndsAt:            endsAt,
+			GracePeriodEndsAt: &gracePeriodEndsAt,
+		}
+		if err := r.insertSubscription(ctx, tx, subscription); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RecordFirstApproval stores the first of two required approvals and moves payment to pending second approval.
+// Returns false if payment is not pending review anymore
+func (r *Repository) RecordFirstApproval(ctx context.Context, id int64, approvedBy string) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE payments SET status = ?, first_approved_by = ? WHERE id = ? AND status = ?`,
+		PaymentStatusPendingSecondApproval, approvedBy, id, PaymentStatusPendingReview,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record first approval: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// CancelCreatedPayment cancels payment unless user already confirmed it, returns false if payment is not in created status
+func (r *Repository) CancelCreatedPayment(ctx context.Context, id int64) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payments SET status = ? WHERE id = ? AND status = ?`,
+		PaymentStatusCancelled, id, PaymentStatusCreated,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel payment: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if affected != 1 {
+		return false, nil
+	}
+	if err := releasePromoCode(ctx, tx, id); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// CancelStalePaymentsForUser cancels user's payments in created status except keepID.
+// Returns number of cancelled payments
+func (r *Repository) CancelStalePaymentsForUser(ctx context.Context, userID int64, keepID int64) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Promo code uses of the payments go back first, while they are still in created status
+	_, err = tx.ExecContext(ctx,
+		`UPDATE promo_codes SET used_count = MAX(used_count - (
+			SELECT COUNT(*) FROM payments WHERE promo_code = promo_codes.code AND user_id = ? AND status = ? AND id != ?
+		 ), 0)
+		 WHERE code IN (SELECT promo_code FROM payments WHERE user_id = ? AND status = ? AND id != ?)`,
+		userID, PaymentStatusCreated, keepID, userID, PaymentStatusCreated, keepID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release promo codes: %w", err)
+	}
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payments SET status = ? WHERE user_id = ? AND status = ? AND id != ?`,
+		PaymentStatusCancelled, userID, PaymentStatusCreated, keepID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel stale payments: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return affected, nil
+}
+
+// AttachProofToPayment saves proof file and moves payment to pending review.
+// isDocument tells proof sent as a file from a photo, they are shown differently.
+// Submission time is recorded when payment leaves created status.
+// Returns false if payment is neither created nor pending review anymore
+func (r *Repository) AttachProofToPayment(ctx context.Context, id int64, proofFileID string, isDocument bool) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE payments SET status = ?, proof_file_id = ?, proof_is_document = ?,
+		 submitted_at = CASE WHEN status = ? THEN ? ELSE submitted_at END
+		 WHERE id = ? AND status IN (?, ?)`,
+		PaymentStatusPendingReview, proofFileID, isDocument, PaymentStatusCreated, r.clock.Now(), id,
+		PaymentStatusCreated, PaymentStatusPendingReview,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to attach proof to payment: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// SubmitPaymentWithoutProof moves created payment to pending review without proof, e.g. when
+// payment provider reports the transfer before user has uploaded proof.
+// Returns false if payment isn't in created status anymore
+func (r *Repository) SubmitPaymentWithoutProof(ctx context.Context, id int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE payments SET status = ?, submitted_at = ? WHERE id = ? AND status = ?`,
+		PaymentStatusPendingReview, r.clock.Now(), id, PaymentStatusCreated,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to submit payment: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// MarkPaymentNotified sets notified_at marker for payment
+// Returns false if admins were already notified about this payment
+func (r *Repository) MarkPaymentNotified(ctx context.Context, id int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE payments SET notified_at = ? WHERE id = ? AND notified_at IS NULL`,
+		r.clock.Now(), id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark payment notified: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// Notification outbox operations
+
+// EnqueueNotification stores notification for delivery retry. Config isn't stored, it contains
+// device private key: device ID is kept instead and config is rebuilt on retry
+func (r *Repository) EnqueueNotification(ctx context.Context, notification *Notification) error {
+	now := r.clock.Now()
+	notification.Status = NotificationStatusPending
+	if notification.NextAttemptAt.IsZero() {
+		notification.NextAttemptAt = now
+	}
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO notifications (chat_id, text, device_id, device_name, status, attempts, next_attempt_at, last_error, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		notification.ChatID, notification.Text, notification.DeviceID, notification.DeviceName,
+		notification.Status, notification.Attempts, notification.NextAttemptAt,
+		sql.NullString{String: notification.LastError, Valid: notification.LastError != ""}, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue notification: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	notification.ID = id
+	notification.CreatedAt = now
+	return nil
+}
+
+// GetDueNotifications returns pending notifications whose next attempt time has come, oldest first
+func (r *Repository) GetDueNotifications(ctx context.Context, now time.Time, limit int) ([]*Notification, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, chat_id, text, config, device_id, device_name, status, attempts, next_attempt_at, last_error, delivered_at, created_at
+		 FROM notifications WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at ASC LIMIT ?`,
+		NotificationStatusPending, now, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []*Notification
+	for rows.Next() {
+		notification := &Notification{}
+		var config, lastError sql.NullString
+		err := rows.Scan(
+			&notification.ID, &notification.ChatID, &notification.Text, &config, &notification.DeviceID, &notification.DeviceName, &notification.Status,
+			&notification.Attempts, &notification.NextAttemptAt, &lastError, &notification.DeliveredAt, &notification.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		if config.Valid {
+			notification.Config = config.String
+		}
+		if lastError.Valid {
+			notification.LastError = lastError.String
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationDelivered marks notification delivered and drops config queued by older versions
+func (r *Repository) MarkNotificationDelivered(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE notifications SET status = ?, attempts = attempts + 1, delivered_at = ?, config = NULL WHERE id = ?`,
+		NotificationStatusDelivered, r.clock.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark notification delivered: %w", err)
+	}
+	return nil
+}
+
+// RecordNotificationFailure stores failed delivery attempt and schedules the next one.
+// With giveUp notification is marked failed and config queued by older versions dropped
+func (r *Repository) RecordNotificationFailure(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time, giveUp bool) error {
+	var err error
+	if giveUp {
+		_, err = r.db.ExecContext(ctx,
+			`UPDATE notifications SET status = ?, attempts = attempts + 1, last_error = ?, config = NULL WHERE id = ?`,
+			NotificationStatusFailed, lastError, id,
+		)
+	} else {
+		_, err = r.db.ExecContext(ctx,
+			`UPDATE notifications SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+			lastError, nextAttemptAt, id,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record notification failure: %w", err)
+	}
+	return nil
+}
+
+// Audit log operations
+
+// RecordAudit appends entry to the audit log
+func (r *Repository) RecordAudit(ctx context.Context, entry *AuditEntry) error {
+	entry.CreatedAt = r.clock.Now()
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO audit_log (actor, action, payment_id, user_id, details, amount, received_amount, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Actor, entry.Action, entry.PaymentID, entry.UserID, nullString(entry.Details), entry.Amount, entry.ReceivedAmount, entry.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	entry.ID = id
+	return nil
+}
+
+// CountAuditEntries returns total number of audit log entries
+func (r *Repository) CountAuditEntries(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+	return count, nil
+}
+
+// GetAuditEntries returns a page of audit log entries, newest first
+func (r *Repository) GetAuditEntries(ctx context.Context, limit, offset int) ([]*AuditEntry, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, actor, action, payment_id, user_id, details, amount, received_amount, created_at
+		 FROM audit_log ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		entry := &AuditEntry{}
+		var details sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.PaymentID, &entry.UserID, &details, &entry.Amount, &entry.ReceivedAmount, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.Details = details.String
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Admin notification operations
+
+func (r *Repository) CreateAdminNotification(ctx context.Context, notification *AdminNotification) error {
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO admin_notifications (payment_id, chat_id, message_id, with_proof, created_at) VALUES (?, ?, ?, ?, ?)`,
+		notification.PaymentID, notification.ChatID, notification.MessageID, notification.WithProof, r.clock.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create admin notification: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	notification.ID = id
+	return nil
+}
+
+func (r *Repository) GetAdminNotificationsByPaymentID(ctx context.Context, paymentID int64) ([]*AdminNotification, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, payment_id, chat_id, message_id, with_proof, created_at
+		 FROM admin_notifications WHERE payment_id = ? ORDER BY created_at ASC`,
+		paymentID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []*AdminNotification
+	for rows.Next() {
+		notification := &AdminNotification{}
+		err := rows.Scan(
+			&notification.ID, &notification.PaymentID, &notification.ChatID,
+			&notification.MessageID, &notification.WithProof, &notification.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan admin notification: %w", err)
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// UpdateAdminNotificationMessage points admin notification to a message that replaced the original one
+func (r *Repository) UpdateAdminNotificationMessage(ctx context.Context, id int64, messageID int, withProof bool) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE admin_notifications SET message_id = ?, with_proof = ? WHERE id = ?`,
+		messageID, withProof, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update admin notification: %w", err)
+	}
+	return nil
+}
+
+// Subscription operations
+
+func (r *Repository) CreateSubscription(ctx context.Context, subscription *Subscription) error {
+	return r.insertSubscription(ctx, r.db, subscription)
+}
+
+func (r *Repository) insertSubscription(ctx context.Context, db dbtx, subscription *Subscription) error {
+	result, err := db.ExecContext(ctx,
+		`INSERT INTO subscriptions (user_id, duration_days, device_limit, amount, status, starts_at, ends_at, grace_period_ends_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		subscription.UserID, subscription.DurationDays, subscription.DeviceLimit, subscription.Amount,
+		subscription.Status, subscription.StartsAt, subscription.EndsAt, subscription.GracePeriodEndsAt, r.clock.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	subscription.ID = id
+	return nil
+}
+
+func (r *Repository) GetActiveSubscriptionByUserID(ctx context.Context, userID int64) (*Subscription, error) {
+	return queryActiveSubscription(ctx, r.db, userID)
+}
+
+func queryActiveSubscription(ctx context.Context, db dbtx, userID int64) (*Subscription, error) {
+	subscription := &Subscription{}
+	err := db.QueryRowContext(ctx,
+		`SELECT id, user_id, duration_days, device_limit, amount, status, starts_at, ends_at, grace_period_ends_at, created_at
+		 FROM subscriptions WHERE user_id = ? AND status IN (?, ?, ?) ORDER BY created_at DESC LIMIT 1`,
+		userID, SubscriptionStatusActive, SubscriptionStatusExpiring, SubscriptionStatusPaused,
+	).Scan(
+		&subscription.ID, &subscription.UserID, &subscription.DurationDays, &subscription.DeviceLimit,
+		&subscription.Amount, &subscription.Status, &subscription.StartsAt, &subscription.EndsAt,
+		&subscription.GracePeriodEndsAt, &subscription.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query subscription: %w", err)
+	}
+	return subscription, nil
+}
+
+func (r *Repository) UpdateSubscriptionStatus(ctx context.Context, id int64, status SubscriptionStatus) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE subscriptions SET status = ? WHERE id = ?`,
+		status, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update subscription status: %w", err)
+	}
+	return nil
+}
+
+// MarkReminderSent records that reminder of given kind was sent for subscription period ending at endsAt.
+// Returns false if it was already recorded, so every reminder is sent once per period
+// and renewed subscription gets reminded again
+func (r *Repository) MarkReminderSent(ctx context.Context, subscriptionID int64, kind ReminderKind, endsAt time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO sent_reminders (subscription_id, kind, ends_at, sent_at) VALUES (?, ?, ?, ?)`,
+		subscriptionID, kind, endsAt, r.clock.Now(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark reminder sent: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rows > 0, nil
+}
+
+func (r *Repository) GetSubscriptionsNeedingUpdate(ctx context.Context, now time.Time) ([]*Subscription, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, duration_days, device_limit, amount, status, starts_at, ends_at, grace_period_ends_at, created_at
+		 FROM subscriptions WHERE status IN (?, ?, ?)`,
+		SubscriptionStatusActive, SubscriptionStatusExpiring, SubscriptionStatusPaused,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []*Subscription
+	for rows.Next() {
+		sub := &Subscription{}
+		err := rows.Scan(
+			&sub.ID, &sub.UserID, &sub.DurationDays, &sub.DeviceLimit,
+			&sub.Amount, &sub.Status, &sub.StartsAt, &sub.EndsAt,
+			&sub.GracePeriodEndsAt, &sub.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	return subscriptions, nil
+}
+
+func (r *Repository) ExtendSubscription(ctx context.Context, subscriptionID int64, durationDays int, amount int, gracePeriodDays int) error {
 	// Get current subscription
 	sub, err := r.GetSubscriptionByID(ctx, subscriptionID)
 	if err != nil {
@@ -435,14 +1235,17 @@ func (r *Repository) ExtendSubscription(ctx context.Context, subscriptionID int6
 	if sub == nil {
 		return errors.New("subscription not found")
 	}
+	return extendSubscription(ctx, r.db, sub, durationDays, amount, gracePeriodDays)
+}
 
+func extendSubscription(ctx context.Context, db dbtx, sub *Subscription, durationDays int, amount int, gracePeriodDays int) error {
 	// Calculate new end date from current end date
 	newEndsAt := sub.EndsAt.AddDate(0, 0, durationDays)
-	gracePeriodEndsAt := newEndsAt.AddDate(0, 0, 3)
+	gracePeriodEndsAt := newEndsAt.AddDate(0, 0, gracePeriodDays)
 
-	_, err = r.db.ExecContext(ctx,
+	_, err := db.ExecContext(ctx,
 		`UPDATE subscriptions SET duration_days = duration_days + ?, amount = amount + ?, ends_at = ?, grace_period_ends_at = ?, status = ? WHERE id = ?`,
-		durationDays, amount, newEndsAt, gracePeriodEndsAt, SubscriptionStatusActive, subscriptionID,
+		durationDays, amount, newEndsAt, gracePeriodEndsAt, SubscriptionStatusActive, sub.ID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to extend subscription: %w", err)
@@ -472,14 +1275,18 @@ func (r *Repository) GetSubscriptionByID(ctx context.Context, id int64) (*Subscr
 
 // Device operations
 
-func (r *Repository) CreateDevice(ctx context.Context, device *Device) error {
+// CreateDevice inserts device, imported tells device was created with user supplied public key
+func (r *Repository) CreateDevice(ctx context.Context, device *Device, imported bool) error {
 	result, err := r.db.ExecContext(ctx,
-		`INSERT INTO devices (user_id, subscription_id, device_name, peer_public_key, assigned_ip, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO devices (user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, imported_key, server_id, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		device.UserID, device.SubscriptionID, device.DeviceName, device.PeerPublicKey,
-		device.AssignedIP, time.Now(),
+		device.AssignedIP, nullString(device.AssignedIPv6), imported, device.ServerID, r.clock.Now(),
 	)
 	if err != nil {
+		if IsDuplicateDeviceName(err) {
+			return fmt.Errorf("failed to create device %s: %w", device.DeviceName, ErrDuplicateDeviceName)
+		}
 		return fmt.Errorf("failed to create device: %w", err)
 	}
 
@@ -492,15 +1299,79 @@ func (r *Repository) CreateDevice(ctx context.Context, device *Device) error {
 	return nil
 }
 
+// GetActiveDeviceIPs returns IPv4 addresses assigned to devices of the server that aren't revoked
+func (r *Repository) GetActiveDeviceIPs(ctx context.Context, serverID string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT assigned_ip FROM devices WHERE revoked_at IS NULL AND server_id = ?`, serverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query assigned IPs: %w", err)
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("failed to scan assigned IP: %w", err)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
 func (r *Repository) GetDeviceByPeerPublicKey(ctx context.Context, peerPublicKey string) (*Device, error) {
 	device := &Device{}
+	var assignedIPv6 sql.NullString
 	err := r.db.QueryRowContext(ctx,
-		`SELECT id, user_id, subscription_id, device_name, peer_public_key, assigned_ip, created_at, revoked_at
+		`SELECT id, user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, server_id, created_at, revoked_at
 		 FROM devices WHERE peer_public_key = ?`,
 		peerPublicKey,
 	).Scan(
 		&device.ID, &device.UserID, &device.SubscriptionID, &device.DeviceName,
-		&device.PeerPublicKey, &device.AssignedIP, &device.CreatedAt, &device.RevokedAt,
+		&device.PeerPublicKey, &device.AssignedIP, &assignedIPv6, &device.ServerID, &device.CreatedAt, &device.RevokedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query device: %w", err)
+	}
+	device.AssignedIPv6 = assignedIPv6.String
+	return device, nil
+}
+
+// GetDeviceByID returns device by ID, including revoked ones
+func (r *Repository) GetDeviceByID(ctx context.Context, id int64) (*Device, error) {
+	device := &Device{}
+	var assignedIPv6 sql.NullString
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, server_id, created_at, revoked_at
+		 FROM devices WHERE id = ?`,
+		id,
+	).Scan(
+		&device.ID, &device.UserID, &device.SubscriptionID, &device.DeviceName,
+		&device.PeerPublicKey, &device.AssignedIP, &assignedIPv6, &device.ServerID, &device.CreatedAt, &device.RevokedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query device: %w", err)
+	}
+	device.AssignedIPv6 = assignedIPv6.String
+	return device, nil
+}
+
+// GetActiveDeviceByName returns active device of subscription with the name, nil if there is none
+func (r *Repository) GetActiveDeviceByName(ctx context.Context, subscriptionID int64, deviceName string) (*Device, error) {
+	device := &Device{}
+	var assignedIPv6 sql.NullString
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, server_id, created_at, revoked_at
+		 FROM devices WHERE subscription_id = ? AND device_name = ? AND revoked_at IS NULL`,
+		subscriptionID, deviceName,
+	).Scan(
+		&device.ID, &device.UserID, &device.SubscriptionID, &device.DeviceName,
+		&device.PeerPublicKey, &device.AssignedIP, &assignedIPv6, &device.ServerID, &device.CreatedAt, &device.RevokedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -508,9 +1379,185 @@ func (r *Repository) GetDeviceByPeerPublicKey(ctx context.Context, peerPublicKey
 		}
 		return nil, fmt.Errorf("failed to query device: %w", err)
 	}
+	device.AssignedIPv6 = assignedIPv6.String
 	return device, nil
 }
 
+// GetDeviceKey returns encrypted private key of device, empty if it isn't stored,
+// and whether device was created with user supplied public key, so the user holds its private key.
+// The key is kept out of Device so it isn't loaded with ordinary device queries
+func (r *Repository) GetDeviceKey(ctx context.Context, deviceID int64) (string, bool, error) {
+	var key sql.NullString
+	var imported bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT private_key_encrypted, imported_key FROM devices WHERE id = ?`,
+		deviceID,
+	).Scan(&key, &imported)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to query device key: %w", err)
+	}
+	return key.String, imported, nil
+}
+
+func (r *Repository) GetActiveDevicesByUserID(ctx context.Context, userID int64) ([]*Device, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, server_id, created_at, revoked_at
+		 FROM devices WHERE user_id = ? AND revoked_at IS NULL ORDER BY created_at ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []*Device
+	for rows.Next() {
+		device := &Device{}
+		var assignedIPv6 sql.NullString
+		err := rows.Scan(
+			&device.ID, &device.UserID, &device.SubscriptionID, &device.DeviceName,
+			&device.PeerPublicKey, &device.AssignedIP, &assignedIPv6, &device.ServerID, &device.CreatedAt, &device.RevokedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		device.AssignedIPv6 = assignedIPv6.String
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// GetActiveDevicesByServer returns active devices provisioned on the server, oldest first
+func (r *Repository) GetActiveDevicesByServer(ctx context.Context, serverID string) ([]*Device, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, server_id, created_at, revoked_at
+		 FROM devices WHERE server_id = ? AND revoked_at IS NULL ORDER BY created_at ASC`,
+		serverID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query server devices: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []*Device
+	for rows.Next() {
+		device := &Device{}
+		var assignedIPv6 sql.NullString
+		err := rows.Scan(
+			&device.ID, &device.UserID, &device.SubscriptionID, &device.DeviceName,
+			&device.PeerPublicKey, &device.AssignedIP, &assignedIPv6, &device.ServerID, &device.CreatedAt, &device.RevokedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		device.AssignedIPv6 = assignedIPv6.String
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// GetDevicesByUserID returns all user's devices including revoked ones, oldest first
+func (r *Repository) GetDevicesByUserID(ctx context.Context, userID int64) ([]*Device, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, subscription_id, device_name, peer_public_key, assigned_ip, assigned_ipv6, server_id, created_at, revoked_at
+		 FROM devices WHERE user_id = ? ORDER BY created_at ASC, id ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []*Device
+	for rows.Next() {
+		device := &Device{}
+		var assignedIPv6 sql.NullString
+		err := rows.Scan(
+			&device.ID, &device.UserID, &device.SubscriptionID, &device.DeviceName,
+			&device.PeerPublicKey, &device.AssignedIP, &assignedIPv6, &device.ServerID, &device.CreatedAt, &device.RevokedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		device.AssignedIPv6 = assignedIPv6.String
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// ReassignDeviceSubscription moves an active device to another subscription of the same user and returns
+// device name after the move. Active device names are unique per subscription, so device whose name is
+// taken in the new subscription gets its ID appended, like duplicates renamed by unique_device_names migration
+func (r *Repository) ReassignDeviceSubscription(ctx context.Context, deviceID, newSubscriptionID int64) (string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deviceUserID int64
+	var deviceName string
+	var revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, device_name, revoked_at FROM devices WHERE id = ?`,
+		deviceID,
+	).Scan(&deviceUserID, &deviceName, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("device not found")
+		}
+		return "", fmt.Errorf("failed to query device: %w", err)
+	}
+	if revokedAt.Valid {
+		return "", errors.New("device is revoked")
+	}
+
+	var subscriptionUserID int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id FROM subscriptions WHERE id = ?`,
+		newSubscriptionID,
+	).Scan(&subscriptionUserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("subscription not found")
+		}
+		return "", fmt.Errorf("failed to query subscription: %w", err)
+	}
+	if subscriptionUserID != deviceUserID {
+		return "", errors.New("subscription belongs to another user")
+	}
+
+	var taken bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM devices WHERE subscription_id = ? AND device_name = ? AND revoked_at IS NULL AND id != ?)`,
+		newSubscriptionID, deviceName, deviceID,
+	).Scan(&taken)
+	if err != nil {
+		return "", fmt.Errorf("failed to check device name: %w", err)
+	}
+	if taken {
+		deviceName = fmt.Sprintf("%s_%d", deviceName, deviceID)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE devices SET subscription_id = ?, device_name = ? WHERE id = ?`,
+		newSubscriptionID, deviceName, deviceID,
+	); err != nil {
+		if IsDuplicateDeviceName(err) {
+			return "", fmt.Errorf("failed to reassign device %d: %w", deviceID, ErrDuplicateDeviceName)
+		}
+		return "", fmt.Errorf("failed to reassign device: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deviceName, nil
+}
+
 func (r *Repository) CountActiveDevicesBySubscription(ctx context.Context, subscriptionID int64) (int, error) {
 	var count int
 	err := r.db.QueryRowContext(ctx,
@@ -523,10 +1570,91 @@ func (r *Repository) CountActiveDevicesBySubscription(ctx context.Context, subsc
 	return count, nil
 }
 
+func (r *Repository) CountActiveDevicesByUser(ctx context.Context, userID int64) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM devices WHERE user_id = ? AND revoked_at IS NULL`,
+		userID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count user devices: %w", err)
+	}
+	return count, nil
+}
+
+// CountUsers returns total number of users
+func (r *Repository) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// CountBlockedUsers returns number of users who have blocked the bot
+func (r *Repository) CountBlockedUsers(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE blocked_at IS NOT NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count blocked users: %w", err)
+	}
+	return count, nil
+}
+
+// CountActiveSubscriptions returns number of active and expiring subscriptions
+func (r *Repository) CountActiveSubscriptions(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM subscriptions WHERE status IN (?, ?)`,
+		SubscriptionStatusActive, SubscriptionStatusExpiring,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count active subscriptions: %w", err)
+	}
+	return count, nil
+}
+
+// CountPaymentsByStatus returns number of payments in given status
+func (r *Repository) CountPaymentsByStatus(ctx context.Context, status PaymentStatus) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM payments WHERE status = ?`,
+		status,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count payments: %w", err)
+	}
+	return count, nil
+}
+
+// SumApprovedRevenue returns total amount of approved payments, in kopecks
+func (r *Repository) SumApprovedRevenue(ctx context.Context) (int64, error) {
+	var sum int64
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(amount), 0) FROM payments WHERE status = ?`,
+		PaymentStatusApproved,
+	).Scan(&sum)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum revenue: %w", err)
+	}
+	return sum, nil
+}
+
+// CountActiveDevices returns number of non-revoked devices
+func (r *Repository) CountActiveDevices(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM devices WHERE revoked_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count active devices: %w", err)
+	}
+	return count, nil
+}
+
 func (r *Repository) RevokeDevice(ctx context.Context, deviceID int64) error {
 	_, err := r.db.ExecContext(ctx,
 		`UPDATE devices SET revoked_at = ? WHERE id = ?`,
-		time.Now(), deviceID,
+		r.clock.Now(), deviceID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to revoke device: %w", err)
@@ -534,9 +1662,23 @@ func (r *Repository) RevokeDevice(ctx context.Context, deviceID int64) error {
 	return nil
 }
 
+// AssignDevicesToServer moves all devices of server from to server to and returns how many were moved.
+// Used when a single server setup is switched to multiple servers, so existing devices keep their addresses
+func (r *Repository) AssignDevicesToServer(ctx context.Context, from, to string) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `UPDATE devices SET server_id = ? WHERE server_id = ?`, to, from)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign devices to server: %w", err)
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return moved, nil
+}
+
 func (r *Repository) GetExpiredDevicesToCleanup(ctx context.Context, before time.Time) ([]*Device, error) {
 	rows, err := r.db.QueryContext(ctx,
-		`SELECT d.id, d.user_id, d.subscription_id, d.device_name, d.peer_public_key, d.assigned_ip, d.created_at, d.revoked_at
+		`SELECT d.id, d.user_id, d.subscription_id, d.device_name, d.peer_public_key, d.assigned_ip, d.assigned_ipv6, d.server_id, d.created_at, d.revoked_at
 		 FROM devices d
 		 JOIN subscriptions s ON d.subscription_id = s.id
 		 WHERE s.status = ? AND s.grace_period_ends_at < ? AND d.revoked_at IS NULL`,
@@ -550,18 +1692,95 @@ func (r *Repository) GetExpiredDevicesToCleanup(ctx context.Context, before time
 	var devices []*Device
 	for rows.Next() {
 		device := &Device{}
+		var assignedIPv6 sql.NullString
 		err := rows.Scan(
 			&device.ID, &device.UserID, &device.SubscriptionID, &device.DeviceName,
-			&device.PeerPublicKey, &device.AssignedIP, &device.CreatedAt, &device.RevokedAt,
+			&device.PeerPublicKey, &device.AssignedIP, &assignedIPv6, &device.ServerID, &device.CreatedAt, &device.RevokedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan device: %w", err)
 		}
+		device.AssignedIPv6 = assignedIPv6.String
 		devices = append(devices, device)
 	}
 	return devices, nil
 }
 
+// Promo code operations
+
+func (r *Repository) CreatePromoCode(ctx context.Context, promo *PromoCode) error {
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO promo_codes (code, discount_percent, discount_amount, expires_at, usage_limit, used_count, created_at)
+		 VALUES (?, ?, ?, ?, ?, 0, ?)`,
+		promo.Code, promo.DiscountPercent, promo.DiscountAmount, promo.ExpiresAt, promo.UsageLimit, r.clock.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create promo code: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	promo.ID = id
+	return nil
+}
+
+func (r *Repository) GetPromoCode(ctx context.Context, code string) (*PromoCode, error) {
+	promo := &PromoCode{}
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, code, discount_percent, discount_amount, expires_at, usage_limit, used_count, created_at
+		 FROM promo_codes WHERE code = ?`,
+		code,
+	).Scan(
+		&promo.ID, &promo.Code, &promo.DiscountPercent, &promo.DiscountAmount,
+		&promo.ExpiresAt, &promo.UsageLimit, &promo.UsedCount, &promo.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query promo code: %w", err)
+	}
+	return promo, nil
+}
+
+// nullString converts empty string to NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Bot state operations
+
+// GetLastUpdateID returns ID of the last Telegram update received by the bot, 0 if none was recorded
+func (r *Repository) GetLastUpdateID(ctx context.Context) (int, error) {
+	var id int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT value FROM bot_state WHERE key = 'last_update_id'`,
+	).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to query last update id: %w", err)
+	}
+	return id, nil
+}
+
+// SetLastUpdateID records ID of the last received Telegram update, IDs below the recorded one are ignored
+func (r *Repository) SetLastUpdateID(ctx context.Context, id int) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO bot_state (key, value) VALUES ('last_update_id', ?)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value WHERE excluded.value > bot_state.value`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record last update id: %w", err)
+	}
+	return nil
+}
+
 // Transaction operations
 
 func (r *Repository) BeginTx(ctx context.Context) (*sql.Tx, error) {
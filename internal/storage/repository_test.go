@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestRepository returns repository backed by migrated in-memory database
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	return newTestRepositoryAt(t, ":memory:")
+}
+
+// newTestRepositoryAt returns repository backed by migrated database at dsn
+func newTestRepositoryAt(t *testing.T, dsn string) *Repository {
+	t.Helper()
+	r, err := NewRepository(dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	if err := r.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return r
+}
+
+// createTestUser creates user with given Telegram ID
+func createTestUser(t *testing.T, r *Repository, telegramID int64) *User {
+	t.Helper()
+	user, err := r.GetOrCreateUser(context.Background(), telegramID, fmt.Sprintf("user%d", telegramID))
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+// testPayments numbers payments created by createTestPayment, so their codes are unique
+var testPayments int
+
+// createTestPayment creates 30 days, 1 device payment of user in given status
+func createTestPayment(t *testing.T, r *Repository, userID int64, status PaymentStatus) *Payment {
+	t.Helper()
+	testPayments++
+	payment := &Payment{
+		UserID:         userID,
+		DurationDays:   30,
+		DeviceCount:    1,
+		Amount:         30000,
+		ReferenceCode:  fmt.Sprintf("REF%d", testPayments),
+		PaymentComment: fmt.Sprintf("comment %d", testPayments),
+		Status:         status,
+	}
+	if err := r.CreatePayment(context.Background(), payment); err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	return payment
+}
+
+func TestApprovePaymentCancelledByUser(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	user := createTestUser(t, r, 1)
+	payment := createTestPayment(t, r, user.ID, PaymentStatusCreated)
+
+	// Admin opened the payment before user cancelled it, approval sees the cancellation in its transaction
+	cancelled, err := r.CancelCreatedPayment(ctx, payment.ID)
+	if err != nil || !cancelled {
+		t.Fatalf("CancelCreatedPayment() = %v, %v", cancelled, err)
+	}
+	err = r.ApprovePayment(ctx, payment.ID, "admin", 3)
+	var statusErr *PaymentStatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != PaymentStatusCancelled {
+		t.Fatalf("ApprovePayment() = %v, want cancelled status error", err)
+	}
+
+	got, err := r.GetPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if got.Status != PaymentStatusCancelled || got.ReviewedBy != nil {
+		t.Errorf("payment status %s, reviewed by %v, want cancelled and not reviewed", got.Status, got.ReviewedBy)
+	}
+	subscription, err := r.GetActiveSubscriptionByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	if subscription != nil {
+		t.Errorf("subscription %d created for cancelled payment", subscription.ID)
+	}
+}
+
+func TestLegacyPaymentWithoutComment(t *testing.T) {
+	r, err := NewRepository(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	ctx := context.Background()
+
+	// Payments table of versions before payment comments, payment_comment is added by migration as nullable
+	_, err = r.db.ExecContext(ctx, `
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			telegram_id INTEGER NOT NULL UNIQUE,
+			username TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE payments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			duration_days INTEGER NOT NULL,
+			device_count INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			reference_code TEXT NOT NULL UNIQUE,
+			status TEXT NOT NULL,
+			proof_file_id TEXT,
+			created_at DATETIME NOT NULL,
+			reviewed_at DATETIME,
+			reviewed_by TEXT
+		);
+		INSERT INTO users (id, telegram_id, username, created_at) VALUES (1, 1, 'user', CURRENT_TIMESTAMP);
+		INSERT INTO payments (id, user_id, duration_days, device_count, amount, reference_code, status, created_at)
+		VALUES (1, 1, 30, 1, 30000, 'LEGACY', 'pending_review', CURRENT_TIMESTAMP);`)
+	if err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	if err := r.Migrate(ctx); err != nil {
+		t.Fatalf("failed to migrate legacy database: %v", err)
+	}
+
+	payment, err := r.GetPaymentByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetPaymentByID() failed on NULL payment_comment: %v", err)
+	}
+	if payment == nil || payment.PaymentComment != "" || payment.ReferenceCode != "LEGACY" {
+		t.Fatalf("GetPaymentByID() = %+v, want legacy payment with empty comment", payment)
+	}
+	payments, err := r.GetPaymentsByUserIDAndStatus(ctx, 1, PaymentStatusPendingReview)
+	if err != nil {
+		t.Fatalf("GetPaymentsByUserIDAndStatus() failed on NULL payment_comment: %v", err)
+	}
+	if len(payments) != 1 || payments[0].PaymentComment != "" {
+		t.Fatalf("GetPaymentsByUserIDAndStatus() = %v, want legacy payment with empty comment", payments)
+	}
+	pending, err := r.GetPendingPayments(ctx)
+	if err != nil {
+		t.Fatalf("GetPendingPayments() failed on NULL payment_comment: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("GetPendingPayments() returned %d payments, want 1", len(pending))
+	}
+
+	// New payments still get unique comments next to legacy NULL ones
+	user := &User{ID: 1}
+	createTestPayment(t, r, user.ID, PaymentStatusCreated)
+	createTestPayment(t, r, user.ID, PaymentStatusCreated)
+}
+
+func TestPaymentReviewFieldsNullable(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	user := createTestUser(t, r, 1)
+	payment := createTestPayment(t, r, user.ID, PaymentStatusPendingReview)
+
+	got, err := r.GetPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if got.ReviewedAt != nil || got.ReviewedBy != nil {
+		t.Errorf("unreviewed payment has reviewer %v at %v", got.ReviewedBy, got.ReviewedAt)
+	}
+	pending, err := r.GetPendingPayments(ctx)
+	if err != nil {
+		t.Fatalf("failed to list pending payments: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ReviewedAt != nil || pending[0].ReviewedBy != nil {
+		t.Errorf("GetPendingPayments() = %v, want one unreviewed payment", pending)
+	}
+
+	reviewer := "admin"
+	if err := r.RejectPayment(ctx, payment.ID, &reviewer, ""); err != nil {
+		t.Fatalf("failed to reject payment: %v", err)
+	}
+	rejected, err := r.GetPaymentsByUserIDAndStatus(ctx, user.ID, PaymentStatusRejected)
+	if err != nil {
+		t.Fatalf("failed to list rejected payments: %v", err)
+	}
+	if len(rejected) != 1 || rejected[0].ReviewedAt == nil || rejected[0].ReviewedBy == nil || *rejected[0].ReviewedBy != reviewer {
+		t.Fatalf("GetPaymentsByUserIDAndStatus() = %v, want payment reviewed by %s", rejected, reviewer)
+	}
+	if rejected[0].RejectionReason != "" {
+		t.Errorf("rejection reason %q, want empty", rejected[0].RejectionReason)
+	}
+}
+
+func TestCreatePaymentDuplicateCode(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	user := createTestUser(t, r, 1)
+	existing := createTestPayment(t, r, user.ID, PaymentStatusCreated)
+
+	tests := []struct {
+		name          string
+		referenceCode string
+		comment       string
+	}{
+		{name: "reference code", referenceCode: existing.ReferenceCode, comment: "free comment"},
+		{name: "payment comment", referenceCode: "FREE1", comment: existing.PaymentComment},
+	}
+	for _, tt := range tests {
+		payment := &Payment{
+			UserID:         user.ID,
+			DurationDays:   30,
+			DeviceCount:    1,
+			Amount:         30000,
+			ReferenceCode:  tt.referenceCode,
+			PaymentComment: tt.comment,
+			Status:         PaymentStatusCreated,
+		}
+		if err := r.CreatePayment(ctx, payment); !errors.Is(err, ErrDuplicatePaymentCode) {
+			t.Errorf("%s: CreatePayment() = %v, want ErrDuplicatePaymentCode", tt.name, err)
+		}
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	user := createTestUser(t, r, 1)
+	_, err := r.db.ExecContext(ctx, `INSERT INTO users (telegram_id, username, created_at) VALUES (?, ?, ?)`, user.TelegramID, "dup", time.Now())
+	if err == nil {
+		t.Fatalf("duplicate user inserted")
+	}
+
+	if !isUniqueViolation(err, "users.telegram_id") {
+		t.Errorf("isUniqueViolation(%v, users.telegram_id) = false", err)
+	}
+	if isUniqueViolation(err, "payments.reference_code") {
+		t.Errorf("isUniqueViolation(%v, payments.reference_code) = true", err)
+	}
+	if isUniqueViolation(fmt.Errorf("wrapped: %w", errors.New("UNIQUE constraint failed: users.telegram_id")), "users.telegram_id") {
+		t.Errorf("isUniqueViolation() = true for non-SQLite error")
+	}
+	if isUniqueViolation(nil, "users.telegram_id") {
+		t.Errorf("isUniqueViolation() = true for nil error")
+	}
+}
+
+func TestApprovePaymentConcurrent(t *testing.T) {
+	// File database, so each approval has its own connection like in production
+	r := newTestRepositoryAt(t, filepath.Join(t.TempDir(), "bot.db"))
+	ctx := context.Background()
+	user := createTestUser(t, r, 1)
+	payment := createTestPayment(t, r, user.ID, PaymentStatusPendingReview)
+
+	admins := []string{"admin1", "admin2"}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, len(admins))
+	for i, admin := range admins {
+		wg.Add(1)
+		go func(i int, admin string) {
+			defer wg.Done()
+			<-start
+			errs[i] = r.ApprovePayment(ctx, payment.ID, admin, 3)
+		}(i, admin)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d of %d concurrent approvals succeeded, want exactly one: %v", succeeded, len(admins), errs)
+	}
+
+	count, err := r.CountActiveSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("failed to count subscriptions: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("%d active subscriptions, want 1", count)
+	}
+	subscription, err := r.GetActiveSubscriptionByUserID(ctx, user.ID)
+	if err != nil || subscription == nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	if days := int(subscription.EndsAt.Sub(subscription.StartsAt).Hours() / 24); days != payment.DurationDays {
+		t.Errorf("subscription lasts %d days, want %d", days, payment.DurationDays)
+	}
+
+	// Approved payment can't be approved or rejected again
+	var statusErr *PaymentStatusError
+	if err := r.ApprovePayment(ctx, payment.ID, "admin3", 3); !errors.As(err, &statusErr) || statusErr.Status != PaymentStatusApproved {
+		t.Errorf("second ApprovePayment() = %v, want approved status error", err)
+	}
+	reviewer := "admin3"
+	if err := r.RejectPayment(ctx, payment.ID, &reviewer, "late"); !errors.As(err, &statusErr) || statusErr.Status != PaymentStatusApproved {
+		t.Errorf("RejectPayment() after approval = %v, want approved status error", err)
+	}
+}
+
+// fixedClock always tells the same time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestRepositoryClock(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	now := time.Date(2026, 3, 5, 14, 30, 0, 0, time.UTC)
+	r.SetClock(fixedClock(now))
+
+	user := createTestUser(t, r, 1)
+	payment := createTestPayment(t, r, user.ID, PaymentStatusPendingReview)
+	if err := r.ApprovePayment(ctx, payment.ID, "admin", 3); err != nil {
+		t.Fatalf("failed to approve payment: %v", err)
+	}
+
+	got, err := r.GetPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if !got.CreatedAt.Equal(now) || got.ReviewedAt == nil || !got.ReviewedAt.Equal(now) {
+		t.Errorf("payment created at %s, reviewed at %v, want %s", got.CreatedAt, got.ReviewedAt, now)
+	}
+	subscription, err := r.GetActiveSubscriptionByUserID(ctx, user.ID)
+	if err != nil || subscription == nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	if !subscription.StartsAt.Equal(now) || !subscription.EndsAt.Equal(now.AddDate(0, 0, 30)) {
+		t.Errorf("subscription %s - %s, want 30 days from %s", subscription.StartsAt, subscription.EndsAt, now)
+	}
+}
+
+func TestReassignDeviceSubscriptionRenamesTakenName(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+	user := createTestUser(t, r, 1)
+	now := r.Clock().Now()
+	var subscriptions []*Subscription
+	for i := 0; i < 2; i++ {
+		subscription := &Subscription{
+			UserID:       user.ID,
+			DurationDays: 30,
+			DeviceLimit:  2,
+			Status:       SubscriptionStatusActive,
+			StartsAt:     now,
+			EndsAt:       now.AddDate(0, 0, 30),
+		}
+		if err := r.CreateSubscription(ctx, subscription); err != nil {
+			t.Fatalf("failed to create subscription: %v", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	// Both subscriptions have a device named "phone"
+	var devices []*Device
+	for i, subscription := range subscriptions {
+		device := &Device{
+			UserID:         user.ID,
+			SubscriptionID: subscription.ID,
+			DeviceName:     "phone",
+			PeerPublicKey:  fmt.Sprintf("key%d", i),
+			AssignedIP:     fmt.Sprintf("10.0.0.%d", i+2),
+		}
+		if err := r.CreateDevice(ctx, device, false); err != nil {
+			t.Fatalf("failed to create device: %v", err)
+		}
+		devices = append(devices, device)
+	}
+
+	name, err := r.ReassignDeviceSubscription(ctx, devices[0].ID, subscriptions[1].ID)
+	if err != nil {
+		t.Fatalf("ReassignDeviceSubscription() = %v", err)
+	}
+	want := fmt.Sprintf("phone_%d", devices[0].ID)
+	if name != want {
+		t.Errorf("device renamed to %q, want %q", name, want)
+	}
+	moved, err := r.GetDeviceByID(ctx, devices[0].ID)
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+	if moved.SubscriptionID != subscriptions[1].ID || moved.DeviceName != want {
+		t.Errorf("device in subscription %d named %q, want subscription %d named %q",
+			moved.SubscriptionID, moved.DeviceName, subscriptions[1].ID, want)
+	}
+
+	// Name that isn't taken is kept
+	name, err = r.ReassignDeviceSubscription(ctx, devices[1].ID, subscriptions[0].ID)
+	if err != nil || name != "phone" {
+		t.Errorf("ReassignDeviceSubscription() = %q, %v, want name kept", name, err)
+	}
+}
@@ -0,0 +1,170 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// payAndSendProof walks user through payment and proof submission with admin registered for notifications,
+// returns payment awaiting review
+func payAndSendProof(t *testing.T, bot *Bot, sender *fakeSender, admin, from *tgbotapi.User) *storage.Payment {
+	t.Helper()
+	ctx := context.Background()
+
+	if errs := bot.handle(messageUpdate(1, admin, "/start")); len(errs) != 0 {
+		t.Fatalf("admin /start failed: %v", errs)
+	}
+	if errs := bot.handle(callbackUpdate(2, from, 1, "confirm:1:30")); len(errs) != 0 {
+		t.Fatalf("payment confirmation failed: %v", errs)
+	}
+	user, err := bot.repo.GetUserByTelegramID(ctx, from.ID)
+	if err != nil || user == nil {
+		t.Fatalf("user wasn't created: %v", err)
+	}
+	payments, err := bot.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
+	if err != nil {
+		t.Fatalf("failed to get payments: %v", err)
+	}
+	if len(payments) != 1 {
+		t.Fatalf("%d payments created, want 1", len(payments))
+	}
+	payment := payments[0]
+
+	sender.reset()
+	if errs := bot.handle(callbackUpdate(3, from, 1, fmt.Sprintf("payment_proof:%d", payment.ID))); len(errs) != 0 {
+		t.Fatalf("proof upload failed: %v", errs)
+	}
+	if len(sender.sentTo(admin.ID)) == 0 {
+		t.Fatalf("admin wasn't notified about payment")
+	}
+	assertPaymentStatus(t, bot, payment.ID, storage.PaymentStatusPendingReview)
+	return payment
+}
+
+func TestPaymentApprovalDeliversConfig(t *testing.T) {
+	bot, sender := newTestBot(t)
+	ctx := context.Background()
+	admin := testUser(1, testAdmin)
+	from := testUser(100, "alice")
+	payment := payAndSendProof(t, bot, sender, admin, from)
+
+	sender.reset()
+	if errs := bot.handle(callbackUpdate(4, admin, 1, fmt.Sprintf("admin_approve:%d", payment.ID))); len(errs) != 0 {
+		t.Fatalf("approval failed: %v", errs)
+	}
+	assertPaymentStatus(t, bot, payment.ID, storage.PaymentStatusApproved)
+
+	subscription, err := bot.repo.GetActiveSubscriptionByUserID(ctx, payment.UserID)
+	if err != nil || subscription == nil {
+		t.Fatalf("subscription wasn't created: %v", err)
+	}
+	if subscription.DeviceLimit != payment.DeviceCount || !subscription.EndsAt.After(time.Now().AddDate(0, 0, 29)) {
+		t.Errorf("subscription for %d devices until %s, want %d devices for 30 days", subscription.DeviceLimit, subscription.EndsAt, payment.DeviceCount)
+	}
+	assertActiveDevices(t, bot, payment.UserID, 1)
+	if !sentConfigFile(sender, from.ID) {
+		t.Errorf("config file wasn't sent, sent %q", sender.sentTo(from.ID))
+	}
+}
+
+func TestPaymentApprovalQueuesUndeliveredConfig(t *testing.T) {
+	bot, sender := newTestBot(t)
+	ctx := context.Background()
+	admin := testUser(1, testAdmin)
+	from := testUser(100, "alice")
+	payment := payAndSendProof(t, bot, sender, admin, from)
+
+	// Telegram is unreachable while the payment is approved
+	sender.reset()
+	sender.sendErr = errors.New("connection reset")
+	bot.handle(callbackUpdate(4, admin, 1, fmt.Sprintf("admin_approve:%d", payment.ID)))
+	sender.sendErr = nil
+	assertPaymentStatus(t, bot, payment.ID, storage.PaymentStatusApproved)
+	assertActiveDevices(t, bot, payment.UserID, 1)
+
+	notifications, err := bot.repo.GetDueNotifications(ctx, time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("failed to get notifications: %v", err)
+	}
+	var queued []*storage.Notification
+	for _, n := range notifications {
+		if n.ChatID == from.ID {
+			queued = append(queued, n)
+		}
+	}
+	if len(queued) != 1 || queued[0].DeviceID == nil {
+		t.Fatalf("queued %+v, want one notification with device", queued)
+	}
+	if queued[0].Config != "" {
+		t.Errorf("config with private key stored in notification")
+	}
+
+	// Bot restarted before retry, config is recreated from the stored device
+	bot.stateMutex.Lock()
+	bot.queuedConfigs = make(map[int64][]byte)
+	bot.stateMutex.Unlock()
+	if err := bot.DeliverNotification(queued[0]); err != nil {
+		t.Fatalf("DeliverNotification() failed: %v", err)
+	}
+	if !sentConfigFile(sender, from.ID) {
+		t.Errorf("queued config file wasn't delivered, sent %q", sender.sentTo(from.ID))
+	}
+}
+
+func TestDropNotificationForgetsQueuedConfig(t *testing.T) {
+	bot, sender := newTestBot(t)
+	ctx := context.Background()
+	admin := testUser(1, testAdmin)
+	from := testUser(100, "alice")
+	payment := payAndSendProof(t, bot, sender, admin, from)
+
+	sender.reset()
+	sender.sendErr = errors.New("connection reset")
+	bot.handle(callbackUpdate(4, admin, 1, fmt.Sprintf("admin_approve:%d", payment.ID)))
+	sender.sendErr = nil
+
+	notifications, err := bot.repo.GetDueNotifications(ctx, time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("failed to get notifications: %v", err)
+	}
+	queued := make(map[int64]*storage.Notification)
+	bot.stateMutex.Lock()
+	for _, n := range notifications {
+		if _, ok := bot.queuedConfigs[n.ID]; ok {
+			queued[n.ID] = n
+		}
+	}
+	bot.stateMutex.Unlock()
+	if len(queued) == 0 {
+		t.Fatalf("no config is kept for queued notifications %+v", notifications)
+	}
+
+	// Scheduler has given up the notifications
+	for _, n := range queued {
+		bot.DropNotification(n)
+	}
+	bot.stateMutex.Lock()
+	defer bot.stateMutex.Unlock()
+	if len(bot.queuedConfigs) != 0 {
+		t.Errorf("%d configs with private keys are kept after notifications are given up", len(bot.queuedConfigs))
+	}
+}
+
+// assertPaymentStatus fails test if payment isn't in given status
+func assertPaymentStatus(t *testing.T, bot *Bot, paymentID int64, want storage.PaymentStatus) {
+	t.Helper()
+	payment, err := bot.repo.GetPaymentByID(context.Background(), paymentID)
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if payment.Status != want {
+		t.Fatalf("payment status %s, want %s", payment.Status, want)
+	}
+}
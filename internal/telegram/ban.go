@@ -0,0 +1,178 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// isBanned reports whether user is banned by admins. Admins are never treated as banned
+func (b *Bot) isBanned(user *storage.User) bool {
+	return user != nil && user.Banned && !b.isAdmin(user)
+}
+
+// askBanUsername asks admin for username of user to ban or unban
+func (b *Bot) askBanUsername(chatID int64, msgID int, lang string) (responses, error) {
+	b.setPendingInput(chatID, pendingInput{
+		action: inputBanUsername,
+		msgID:  msgID,
+	})
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgBanPrompt))
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{{menuButton(b.tr, lang)}},
+	}
+	return responses{res}, nil
+}
+
+// handleBanUsernameInput shows user's ban status with ban or unban actions
+func (b *Bot) handleBanUsernameInput(ctx context.Context, chatID int64, user *storage.User, input pendingInput, text string) (responses, error) {
+	if !b.isAdmin(user) {
+		return b.notAdminMsg(chatID), nil
+	}
+
+	lang := userLanguage(user)
+	username := normalizeUsername(text)
+	if username == "" {
+		b.setPendingInput(chatID, input)
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgBanUsernameEmpty))}, nil
+	}
+	targetUser, err := b.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user")
+	}
+	if targetUser == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgAdminUserNotFound, username))}, nil
+	}
+
+	text, keyboard, err := b.banStatus(ctx, lang, targetUser)
+	if err != nil {
+		return nil, err
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	return responses{msg}, nil
+}
+
+// banStatus renders user's ban status with actions available to admin
+func (b *Bot) banStatus(ctx context.Context, lang string, targetUser *storage.User) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	if targetUser.Banned {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonUnban), fmt.Sprintf("admin:unban:%d", targetUser.ID)),
+			),
+			tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)),
+		)
+		return b.tr.Tf(lang, msgBanBanned, targetUser.Username), &keyboard, nil
+	}
+
+	devices, err := b.repo.GetActiveDevicesByUserID(ctx, targetUser.ID)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get devices")
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonBan), fmt.Sprintf("admin:ban:%d", targetUser.ID)),
+		),
+	}
+	if len(devices) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonBanRevoke), fmt.Sprintf("admin:ban:%d:revoke", targetUser.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	text := b.tr.Tf(lang, msgBanNotBanned, targetUser.Username, len(devices))
+	return text, &keyboard, nil
+}
+
+// handleAdminBan bans user, revoking their active devices if requested
+func (b *Bot) handleAdminBan(ctx context.Context, chatID int64, msgID int, user *storage.User, targetUserID int64, revoke bool) (responses, error) {
+	targetUser, err := b.repo.GetUserByID(ctx, targetUserID)
+	if err != nil || targetUser == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("user %d not found", targetUserID)
+	}
+	lang := userLanguage(user)
+	if b.isAdmin(targetUser) {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgBanAdmin))}, nil
+	}
+
+	if err := b.repo.SetUserBanned(ctx, targetUser.ID, true); err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	targetUser.Banned = true
+	log.Printf("User %d banned by %s", targetUser.ID, user.Username)
+	b.recordBanAudit(ctx, user, targetUser, storage.AuditActionBanUser)
+
+	text := b.tr.Tf(lang, msgBanBanned, targetUser.Username)
+	if revoke {
+		revoked, failed, err := b.revokeUserDevices(ctx, targetUser, user.Username)
+		if err != nil {
+			return responses{b.errorMessage(chatID, msgID, true)}, err
+		}
+		text += b.tr.Tf(lang, msgBanRevoked, revoked)
+		if failed > 0 {
+			text += b.tr.Tf(lang, msgBanRevokeFailed, failed, targetUser.Username)
+		}
+	}
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+	return responses{res}, nil
+}
+
+// handleAdminUnban lifts user's ban
+func (b *Bot) handleAdminUnban(ctx context.Context, chatID int64, msgID int, user *storage.User, targetUserID int64) (responses, error) {
+	targetUser, err := b.repo.GetUserByID(ctx, targetUserID)
+	if err != nil || targetUser == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("user %d not found", targetUserID)
+	}
+
+	if err := b.repo.SetUserBanned(ctx, targetUser.ID, false); err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	log.Printf("User %d unbanned by %s", targetUser.ID, user.Username)
+	b.recordBanAudit(ctx, user, targetUser, storage.AuditActionUnbanUser)
+
+	lang := userLanguage(user)
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.Tf(lang, msgBanUnbanned, targetUser.Username))
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+	return responses{res}, nil
+}
+
+// revokeUserDevices revokes all active devices of banned user, returning numbers of revoked and failed ones
+func (b *Bot) revokeUserDevices(ctx context.Context, targetUser *storage.User, actor string) (int, int, error) {
+	devices, err := b.repo.GetActiveDevicesByUserID(ctx, targetUser.ID)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get devices")
+	}
+	revoked, failed := 0, 0
+	for _, device := range devices {
+		if err := b.revokeDevice(ctx, device, actor, "user banned"); err != nil {
+			log.Printf("failed to revoke device %d of banned user %d: %v", device.ID, targetUser.ID, err)
+			failed++
+			continue
+		}
+		revoked++
+	}
+	return revoked, failed, nil
+}
+
+// recordBanAudit records ban or unban of user in the audit log
+func (b *Bot) recordBanAudit(ctx context.Context, admin, targetUser *storage.User, action storage.AuditAction) {
+	entry := &storage.AuditEntry{
+		Actor:   admin.Username,
+		Action:  action,
+		UserID:  &targetUser.ID,
+		Details: "@" + targetUser.Username,
+	}
+	if err := b.repo.RecordAudit(ctx, entry); err != nil {
+		log.Printf("failed to record %s of user %d in audit log: %v", action, targetUser.ID, err)
+	}
+}
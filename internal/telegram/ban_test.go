@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+func TestBanByUsername(t *testing.T) {
+	bot, sender := newTestBot(t)
+	ctx := context.Background()
+	admin := testUser(1, testAdmin)
+	alice := testUser(100, "alice")
+	target, err := bot.repo.GetOrCreateUser(ctx, alice.ID, alice.UserName)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	// Username is looked up the way admins type it
+	steps := []struct {
+		update *tgbotapi.Update
+		want   string
+	}{
+		{update: callbackUpdate(1, admin, 1, "admin:ban"), want: "username"},
+		{update: messageUpdate(2, admin, " @ALICE "), want: "@alice не заблокирован"},
+		{update: callbackUpdate(3, admin, 1, "admin:ban"), want: "username"},
+		{update: messageUpdate(4, admin, "@bob"), want: "@bob не найден"},
+		{update: callbackUpdate(5, admin, 1, fmt.Sprintf("admin:ban:%d", target.ID)), want: "@alice заблокирован"},
+	}
+	for _, step := range steps {
+		sender.reset()
+		if errs := bot.handle(step.update); len(errs) != 0 {
+			t.Fatalf("update %d failed: %v", step.update.UpdateID, errs)
+		}
+		texts := sender.sentTo(admin.ID)
+		if len(texts) == 0 || !strings.Contains(texts[len(texts)-1], step.want) {
+			t.Fatalf("update %d: sent %q, want text with %q", step.update.UpdateID, texts, step.want)
+		}
+	}
+
+	got, err := bot.repo.GetUserByID(ctx, target.ID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if !got.Banned {
+		t.Errorf("user isn't banned")
+	}
+}
+
+func TestBannedUserIsShortCircuited(t *testing.T) {
+	bot, sender := newTestBot(t)
+	ctx := context.Background()
+	from := testUser(100, "alice")
+	payment := createPayment(t, bot, from, storage.PaymentStatusCreated)
+	if err := bot.repo.SetUserBanned(ctx, payment.UserID, true); err != nil {
+		t.Fatalf("failed to ban user: %v", err)
+	}
+
+	proof := messageUpdate(3, from, "")
+	proof.Message.Photo = []tgbotapi.PhotoSize{{FileID: "proof"}}
+	updates := []*tgbotapi.Update{
+		messageUpdate(1, from, "/start"),
+		callbackUpdate(2, from, 1, "duration:30"),
+		proof,
+	}
+	banned := bot.tr.T(defaultLanguage, msgBanned)
+	for _, update := range updates {
+		sender.reset()
+		if errs := bot.handle(update); len(errs) != 0 {
+			t.Fatalf("update %d failed: %v", update.UpdateID, errs)
+		}
+		texts := sender.sentTo(from.ID)
+		if len(texts) != 1 || texts[0] != banned {
+			t.Errorf("update %d: sent %q, want only ban message", update.UpdateID, texts)
+		}
+	}
+
+	got, err := bot.repo.GetPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if got.Status != storage.PaymentStatusCreated || got.ProofFileID != "" {
+		t.Errorf("banned user's proof attached: status %s, file %q", got.Status, got.ProofFileID)
+	}
+}
+
+func TestAdminIsNeverBanned(t *testing.T) {
+	bot, _ := newTestBot(t)
+	if bot.isBanned(&storage.User{Username: "ADMIN", Banned: true}) {
+		t.Errorf("admin treated as banned")
+	}
+	if !bot.isBanned(&storage.User{Username: "alice", Banned: true}) {
+		t.Errorf("banned user isn't treated as banned")
+	}
+	if bot.isBanned(nil) {
+		t.Errorf("unknown user treated as banned")
+	}
+}
@@ -0,0 +1,191 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/access"
+	"github.com/skoret/wireguard-bot/internal/billing"
+	"github.com/skoret/wireguard-bot/internal/provisioning"
+	"github.com/skoret/wireguard-bot/internal/storage"
+	"github.com/skoret/wireguard-bot/internal/wireguard"
+)
+
+const testAdmin = "admin"
+
+// fakeSender records everything the bot sends instead of calling Telegram API
+type fakeSender struct {
+	mu      sync.Mutex
+	sent    []tgbotapi.Chattable
+	sendErr error // Returned by Send when set, nothing is recorded then
+	lastID  int
+}
+
+func (s *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sendErr != nil {
+		return tgbotapi.Message{}, s.sendErr
+	}
+	s.sent = append(s.sent, c)
+	s.lastID++
+	// Telegram returns the sent message with its text
+	sent := tgbotapi.Message{MessageID: s.lastID}
+	if msg, ok := c.(tgbotapi.MessageConfig); ok {
+		sent.Text = msg.Text
+	}
+	return sent, nil
+}
+
+func (s *fakeSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (s *fakeSender) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// messages returns sent messages, edits and files
+func (s *fakeSender) messages() []tgbotapi.Chattable {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]tgbotapi.Chattable(nil), s.sent...)
+}
+
+// reset forgets sent messages
+func (s *fakeSender) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = nil
+}
+
+// sentTo returns texts and captions sent to the chat, as new messages or edits
+func (s *fakeSender) sentTo(chatID int64) []string {
+	var texts []string
+	for _, c := range s.messages() {
+		switch m := c.(type) {
+		case tgbotapi.MessageConfig:
+			if m.ChatID == chatID {
+				texts = append(texts, m.Text)
+			}
+		case tgbotapi.EditMessageTextConfig:
+			if m.ChatID == chatID {
+				texts = append(texts, m.Text)
+			}
+		case tgbotapi.EditMessageCaptionConfig:
+			if m.ChatID == chatID {
+				texts = append(texts, m.Caption)
+			}
+		case tgbotapi.PhotoConfig:
+			if m.ChatID == chatID {
+				texts = append(texts, m.Caption)
+			}
+		case tgbotapi.DocumentConfig:
+			if m.ChatID == chatID {
+				texts = append(texts, m.Caption)
+			}
+		}
+	}
+	return texts
+}
+
+// newTestBot creates bot backed by in-memory database and dev provisioner, sending through fakeSender.
+// testAdmin is the only admin, rate limit is disabled
+func newTestBot(t *testing.T) (*Bot, *fakeSender) {
+	t.Helper()
+	repo, err := storage.NewRepository(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := repo.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	clock := repo.Clock()
+	billingService := billing.NewService(repo, clock, billing.PaymentRequisites{}, billing.DefaultPricingConfig(),
+		billing.DefaultPlansConfig(), billing.DefaultMaxPendingReviewsPerUser, billing.DefaultGracePeriodDays)
+	accessService := access.NewService(repo, clock, access.DefaultMaxDevicesPerUser)
+	provisioner, err := wireguard.NewDevProvisioner(repo, provisioning.Server{})
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	sender := &fakeSender{}
+	bot, err := NewBotWithSender(sender, wireguard.NewWireguardFromProvisioner(provisioner), repo, billingService, accessService, "")
+	if err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+	bot.admins = map[string]struct{}{testAdmin: {}}
+	bot.limiter = nil
+	t.Cleanup(bot.cancelOps)
+	sender.reset()
+	return bot, sender
+}
+
+// testUser returns Telegram user with given ID and username, chat ID is the same as user ID
+func testUser(id int64, username string) *tgbotapi.User {
+	return &tgbotapi.User{ID: id, UserName: username}
+}
+
+// callbackUpdate returns update with button press under message msgID
+func callbackUpdate(updateID int, from *tgbotapi.User, msgID int, data string) *tgbotapi.Update {
+	return &tgbotapi.Update{
+		UpdateID: updateID,
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:   fmt.Sprint(updateID),
+			From: from,
+			Message: &tgbotapi.Message{
+				MessageID: msgID,
+				Chat:      &tgbotapi.Chat{ID: from.ID},
+			},
+			Data: data,
+		},
+	}
+}
+
+// messageUpdate returns update with text message, text starting with "/" is a command
+func messageUpdate(updateID int, from *tgbotapi.User, text string) *tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		MessageID: updateID,
+		From:      from,
+		Chat:      &tgbotapi.Chat{ID: from.ID},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Length: len(command)}}
+	}
+	return &tgbotapi.Update{UpdateID: updateID, Message: msg}
+}
+
+// createPayment stores payment of the user in given status
+func createPayment(t *testing.T, bot *Bot, from *tgbotapi.User, status storage.PaymentStatus) *storage.Payment {
+	t.Helper()
+	ctx := context.Background()
+	user, err := bot.repo.GetOrCreateUser(ctx, from.ID, from.UserName)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	payment := &storage.Payment{
+		UserID:         user.ID,
+		DurationDays:   30,
+		DeviceCount:    1,
+		Amount:         bot.billing.CalculatePrice(30, 1),
+		ReferenceCode:  fmt.Sprintf("REF%d", from.ID),
+		PaymentComment: fmt.Sprintf("comment %d", from.ID),
+		Status:         status,
+	}
+	if status != storage.PaymentStatusCreated {
+		payment.ProofFileID = "proof"
+	}
+	if err := bot.repo.CreatePayment(ctx, payment); err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	return payment
+}
@@ -0,0 +1,330 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+const (
+	defaultBroadcastWorkers = 5
+	// Telegram allows about 30 messages per second to different chats, keep some headroom
+	defaultBroadcastRate = 25
+
+	broadcastProgressInterval = 3 * time.Second
+)
+
+// broadcastConfig describes how fast messages are sent to all users
+type broadcastConfig struct {
+	workers int // Concurrent senders
+	rate    int // Messages per second across all senders
+}
+
+// broadcastConfigFromEnv reads broadcast settings from BROADCAST_WORKERS and BROADCAST_RATE
+// environment variables, falling back to defaults for unset values
+func broadcastConfigFromEnv() (broadcastConfig, error) {
+	cfg := broadcastConfig{workers: defaultBroadcastWorkers, rate: defaultBroadcastRate}
+	if v := os.Getenv("BROADCAST_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, errors.Errorf("invalid BROADCAST_WORKERS value: %s", v)
+		}
+		cfg.workers = n
+	}
+	if v := os.Getenv("BROADCAST_RATE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 30 {
+			return cfg, errors.Errorf("invalid BROADCAST_RATE value: %s, must be in [1, 30]", v)
+		}
+		cfg.rate = n
+	}
+	return cfg, nil
+}
+
+// broadcastResult holds broadcast delivery counters
+type broadcastResult struct {
+	Total   int
+	Sent    int
+	Failed  int
+	Blocked int // Users who blocked the bot, counted in Failed too
+}
+
+// broadcast sends text to all chats with bounded concurrency and global rate limit.
+// Users who blocked the bot are marked unreachable. progress is called periodically
+// with current counters from a single goroutine
+func (b *Bot) broadcast(ctx context.Context, chatIDs []int64, text string, progress func(broadcastResult)) broadcastResult {
+	var mu sync.Mutex
+	result := broadcastResult{Total: len(chatIDs)}
+	snapshot := func() broadcastResult {
+		mu.Lock()
+		defer mu.Unlock()
+		return result
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(b.broadcastCfg.rate))
+	defer ticker.Stop()
+
+	jobs := make(chan int64)
+	var workers sync.WaitGroup
+	for i := 0; i < b.broadcastCfg.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for chatID := range jobs {
+				err := b.sendBroadcastMessage(ctx, chatID, text, ticker.C)
+				blocked := false
+				if err != nil {
+					log.Printf("broadcast to chat %d failed: %v", chatID, err)
+					if isBlockedError(err) {
+						blocked = true
+						b.markBlocked(chatID, err)
+					}
+				}
+
+				mu.Lock()
+				switch {
+				case err == nil:
+					result.Sent++
+				case blocked:
+					result.Failed++
+					result.Blocked++
+				default:
+					result.Failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		progressTicker := time.NewTicker(broadcastProgressInterval)
+		defer progressTicker.Stop()
+		for {
+			select {
+			case <-progressTicker.C:
+				progress(snapshot())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+feed:
+	for _, chatID := range chatIDs {
+		select {
+		case jobs <- chatID:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	close(done)
+	<-reported
+
+	return snapshot()
+}
+
+// sendBroadcastMessage sends a single broadcast message once a rate limiter tick is available,
+// retrying once if Telegram asks to slow down
+func (b *Bot) sendBroadcastMessage(ctx context.Context, chatID int64, text string, tick <-chan time.Time) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		select {
+		case <-tick:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		_, err = b.sender.Send(tgbotapi.NewMessage(chatID, text))
+		apiErr, ok := apiError(err)
+		if !ok || apiErr.Code != 429 {
+			return err
+		}
+
+		retryAfter := time.Duration(apiErr.RetryAfter) * time.Second
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		select {
+		case <-time.After(retryAfter):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// apiError extracts Telegram API error from err
+func apiError(err error) (tgbotapi.Error, bool) {
+	if err == nil {
+		return tgbotapi.Error{}, false
+	}
+	var ptr *tgbotapi.Error
+	if errors.As(err, &ptr) {
+		return *ptr, true
+	}
+	var val tgbotapi.Error
+	if errors.As(err, &val) {
+		return val, true
+	}
+	return tgbotapi.Error{}, false
+}
+
+// isBlockedError reports whether Telegram refused to deliver a message because user can't receive it:
+// "Forbidden: bot was blocked by the user", "Forbidden: user is deactivated" and similar 403 errors,
+// or "Bad Request: chat not found" for users who have deleted their account
+func isBlockedError(err error) bool {
+	apiErr, ok := apiError(err)
+	if !ok {
+		return false
+	}
+	switch apiErr.Code {
+	case 403:
+		return true
+	case 400:
+		return strings.Contains(strings.ToLower(apiErr.Message), "chat not found")
+	}
+	return false
+}
+
+func (b *Bot) broadcastProgressText(lang string, r broadcastResult) string {
+	return b.tr.Tf(lang, msgBroadcastProgress, r.Sent, r.Total, r.Failed)
+}
+
+func (b *Bot) broadcastSummaryText(lang string, r broadcastResult) string {
+	return b.tr.Tf(lang, msgBroadcastSummary, r.Total, r.Sent, r.Failed, r.Blocked)
+}
+
+// handleBroadcast prepares a message to all users and asks for confirmation (admin only)
+// Usage: /broadcast <text>
+func (b *Bot) handleBroadcast(chatID int64, user *storage.User, arg string) (responses, error) {
+	if !b.isAdmin(user) {
+		return b.notAdminMsg(chatID), nil
+	}
+
+	lang := userLanguage(user)
+	text := strings.TrimSpace(arg)
+	if text == "" {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgBroadcastUsage))}, nil
+	}
+
+	return b.broadcastPreview(chatID, lang, text)
+}
+
+// askBroadcastText asks admin to type broadcast message
+func (b *Bot) askBroadcastText(chatID int64, msgID int, lang string) (responses, error) {
+	b.setPendingInput(chatID, pendingInput{
+		action: inputBroadcastText,
+		msgID:  msgID,
+	})
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgBroadcastPrompt))
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonCancel), "admin:broadcast_cancel")},
+		},
+	}
+	return responses{res}, nil
+}
+
+// handleBroadcastTextInput shows broadcast preview and asks admin for confirmation
+func (b *Bot) handleBroadcastTextInput(chatID int64, user *storage.User, input pendingInput, text string) (responses, error) {
+	if !b.isAdmin(user) {
+		return b.notAdminMsg(chatID), nil
+	}
+
+	lang := userLanguage(user)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		b.setPendingInput(chatID, input)
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgBroadcastEmpty))}, nil
+	}
+
+	return b.broadcastPreview(chatID, lang, text)
+}
+
+// broadcastPreview remembers broadcast text and asks admin to confirm sending
+func (b *Bot) broadcastPreview(chatID int64, lang string, text string) (responses, error) {
+	recipients, err := b.repo.GetAllUserTelegramIDs(b.opsCtx)
+	if err != nil {
+		return responses{b.errorMessage(chatID, 0, false)}, err
+	}
+	b.setBroadcastDraft(chatID, text)
+
+	msg := tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgBroadcastPreview, len(recipients), text))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonSend), "admin:broadcast_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonCancel), "admin:broadcast_cancel"),
+		),
+	)
+	return responses{msg}, nil
+}
+
+// auditExcerptLength limits broadcast text kept in the audit log
+const auditExcerptLength = 100
+
+// auditExcerpt shortens broadcast text for the audit log
+func auditExcerpt(text string) string {
+	runes := []rune(text)
+	if len(runes) <= auditExcerptLength {
+		return text
+	}
+	return string(runes[:auditExcerptLength]) + "…"
+}
+
+// handleBroadcastConfirm sends confirmed broadcast, reusing confirmation message for progress
+func (b *Bot) handleBroadcastConfirm(ctx context.Context, chatID int64, msgID int, user *storage.User) (responses, error) {
+	lang := userLanguage(user)
+	text, ok := b.popBroadcastDraft(chatID)
+	if !ok {
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgBroadcastAlreadyDone))
+		res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+	return b.runBroadcast(ctx, chatID, msgID, lang, user.Username, text)
+}
+
+// runBroadcast sends text to all users, reporting progress by editing progressMsgID
+func (b *Bot) runBroadcast(ctx context.Context, chatID int64, progressMsgID int, lang string, username string, text string) (responses, error) {
+	chatIDs, err := b.repo.GetAllUserTelegramIDs(ctx)
+	if err != nil {
+		return responses{b.errorMessage(chatID, progressMsgID, true)}, err
+	}
+
+	progressText := ""
+	progress := func(r broadcastResult) {
+		text := b.broadcastProgressText(lang, r)
+		if text == progressText {
+			// Telegram rejects edits that don't change the message
+			return
+		}
+		progressText = text
+		if _, err := b.sender.Send(tgbotapi.NewEditMessageText(chatID, progressMsgID, text)); err != nil {
+			log.Printf("failed to update broadcast progress: %v", err)
+		}
+	}
+	progress(broadcastResult{Total: len(chatIDs)})
+
+	log.Printf("Broadcast to %d users started by %s", len(chatIDs), username)
+	result := b.broadcast(ctx, chatIDs, text, progress)
+	log.Printf("Broadcast finished: %d sent, %d failed, %d blocked", result.Sent, result.Failed, result.Blocked)
+	b.recordAdminAudit(ctx, username, storage.AuditActionBroadcast, nil,
+		fmt.Sprintf("%d sent, %d failed, %d blocked: %s", result.Sent, result.Failed, result.Blocked, auditExcerpt(text)))
+
+	return responses{tgbotapi.NewEditMessageText(chatID, progressMsgID, b.broadcastSummaryText(lang, result))}, nil
+}
@@ -2,85 +2,240 @@ package telegram
 
 import (
 	"encoding/json"
+	"fmt"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
 )
 
-type handler func(b *Bot, chatID int64, userID int64, username string, arg string) (responses, error)
+type handler func(b *Bot, chatID int64, user *storage.User, arg string) (responses, error)
 
 type command struct {
 	tgbotapi.BotCommand
-	text     string
-	keyboard *tgbotapi.InlineKeyboardMarkup
+	text     string // Static reply text, overridden by textKey
+	textKey  string // Message catalog key of reply text
+	keyboard func(tr *Translator, lang string) *tgbotapi.InlineKeyboardMarkup
 	handler  handler
 }
 
 var (
 	StartCmd = command{
 		BotCommand: tgbotapi.BotCommand{
-			Command:     "start",
-			Description: "Главное меню",
+			Command: "start",
 		},
-		text: "Добро пожаловать! Используйте меню для навигации.",
+		textKey: msgStartText,
 	}
 	MenuCmd = command{
 		BotCommand: tgbotapi.BotCommand{
-			Command:     "menu",
-			Description: "Меню бота",
+			Command: "menu",
 		},
-		text: "Выберите действие:",
+		textKey: msgMenuText,
 	}
 	HelpCmd = command{
 		BotCommand: tgbotapi.BotCommand{
-			Command:     "help",
-			Description: "Помощь",
+			Command: "help",
 		},
-		text: "ℹ️ Доступные команды:\n\n" +
-			"/start - Главное меню\n" +
-			"/menu - Меню бота\n" +
-			"/newkeys - Создать новое устройство (требуется активная подписка)\n" +
-			"/help - Показать эту справку",
+		textKey: msgHelpText,
 	}
 	ConfigForNewKeysCmd = command{
 		BotCommand: tgbotapi.BotCommand{
-			Command:     "newkeys",
-			Description: "Создать новое устройство",
+			Command: "newkeys",
+		},
+		text: "",
+	}
+	ImportKeyCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "importkey",
+		},
+		text: "",
+	}
+	SubscriptionCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "status",
+		},
+		text: "",
+	}
+	ExportCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "export",
+		},
+		text: "",
+	}
+	PaymentsCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "payments",
+		},
+		text: "",
+	}
+	CancelCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "cancel",
+		},
+		text: "",
+	}
+	LanguageCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "language",
 		},
 		text: "",
 	}
 	AdminCmd = command{
 		BotCommand: tgbotapi.BotCommand{
-			Command:     "admin",
-			Description: "Админ-панель",
+			Command: "admin",
+		},
+		text: "",
+	}
+	ReassignDevicesCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "reassign",
+		},
+		text: "",
+	}
+	AddPromoCodeCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "addpromo",
+		},
+		text: "",
+	}
+	StatsJSONCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "statsjson",
+		},
+		text: "",
+	}
+	UserDevicesCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "userdevices",
+		},
+		text: "",
+	}
+	BroadcastCmd = command{
+		BotCommand: tgbotapi.BotCommand{
+			Command: "broadcast",
 		},
 		text: "",
 	}
 )
 
 var commands = map[string]*command{
-	StartCmd.Command:             &StartCmd,
-	MenuCmd.Command:              &MenuCmd,
-	ConfigForNewKeysCmd.Command:  &ConfigForNewKeysCmd,
-	HelpCmd.Command:              &HelpCmd,
-	AdminCmd.Command:             &AdminCmd,
+	StartCmd.Command:            &StartCmd,
+	MenuCmd.Command:             &MenuCmd,
+	ConfigForNewKeysCmd.Command: &ConfigForNewKeysCmd,
+	ImportKeyCmd.Command:        &ImportKeyCmd,
+	HelpCmd.Command:             &HelpCmd,
+	SubscriptionCmd.Command:     &SubscriptionCmd,
+	LanguageCmd.Command:         &LanguageCmd,
+	CancelCmd.Command:           &CancelCmd,
+	PaymentsCmd.Command:         &PaymentsCmd,
+	ExportCmd.Command:           &ExportCmd,
+	AdminCmd.Command:            &AdminCmd,
+	ReassignDevicesCmd.Command:  &ReassignDevicesCmd,
+	AddPromoCodeCmd.Command:     &AddPromoCodeCmd,
+	StatsJSONCmd.Command:        &StatsJSONCmd,
+	BroadcastCmd.Command:        &BroadcastCmd,
+	UserDevicesCmd.Command:      &UserDevicesCmd,
+}
+
+// userCommands lists commands shown in Telegram's command menu
+var userCommands = []*command{
+	&StartCmd,
+	&MenuCmd,
+	&ConfigForNewKeysCmd,
+	&ImportKeyCmd,
+	&SubscriptionCmd,
+	&ExportCmd,
+	&PaymentsCmd,
+	&CancelCmd,
+	&LanguageCmd,
+	&HelpCmd,
 }
 
-// setMyCommands sets bot commands
+// adminCommands are added to the command menu of admin chats only
+var adminCommands = []*command{
+	&AdminCmd,
+	&BroadcastCmd,
+	&StatsJSONCmd,
+	&UserDevicesCmd,
+	&ReassignDevicesCmd,
+	&AddPromoCodeCmd,
+}
+
+// commandDescriptionKeys maps commands to catalog keys of their descriptions
+var commandDescriptionKeys = map[string]string{
+	StartCmd.Command:            msgCmdStart,
+	MenuCmd.Command:             msgCmdMenu,
+	ConfigForNewKeysCmd.Command: msgCmdNewKeys,
+	ImportKeyCmd.Command:        msgCmdImportKey,
+	SubscriptionCmd.Command:     msgCmdStatus,
+	ExportCmd.Command:           msgCmdExport,
+	PaymentsCmd.Command:         msgCmdPayments,
+	CancelCmd.Command:           msgCmdCancel,
+	LanguageCmd.Command:         msgCmdLanguage,
+	HelpCmd.Command:             msgCmdHelp,
+	AdminCmd.Command:            msgCmdAdmin,
+	BroadcastCmd.Command:        msgCmdBroadcast,
+	StatsJSONCmd.Command:        msgCmdStatsJSON,
+	UserDevicesCmd.Command:      msgCmdUserDevices,
+	ReassignDevicesCmd.Command:  msgCmdReassign,
+	AddPromoCodeCmd.Command:     msgCmdAddPromo,
+}
+
+// localizedText returns command reply text in given language
+func (cmd command) localizedText(tr *Translator, lang string) string {
+	if cmd.textKey != "" {
+		return tr.T(lang, cmd.textKey)
+	}
+	return cmd.text
+}
+
+// localizedKeyboard returns command reply keyboard in given language
+func (cmd command) localizedKeyboard(tr *Translator, lang string) *tgbotapi.InlineKeyboardMarkup {
+	if cmd.keyboard == nil {
+		return nil
+	}
+	return cmd.keyboard(tr, lang)
+}
+
+// setMyCommands sets bot commands: default language commands for everyone
+// and translated ones for users with other Telegram interface languages
 func (b *Bot) setMyCommands() error {
-	params := make(tgbotapi.Params)
-	data, err := json.Marshal([]tgbotapi.BotCommand{
-		StartCmd.BotCommand,
-		MenuCmd.BotCommand,
-		ConfigForNewKeysCmd.BotCommand,
-		HelpCmd.BotCommand,
-	})
-	if err != nil {
-		return err
-	}
-	params.AddNonEmpty("commands", string(data))
-	_, err = b.api.MakeRequest("setMyCommands", params)
-	if err != nil {
-		return err
+	return b.setCommands(userCommands, "")
+}
+
+// setAdminCommands shows admin commands in addition to user ones in admin's chat command menu
+func (b *Bot) setAdminCommands(chatID int64) error {
+	cmds := make([]*command, 0, len(userCommands)+len(adminCommands))
+	cmds = append(cmds, userCommands...)
+	cmds = append(cmds, adminCommands...)
+	return b.setCommands(cmds, fmt.Sprintf(`{"type":"chat","chat_id":%d}`, chatID))
+}
+
+// setCommands sets command menu in every supported language for scope,
+// given as JSON encoded BotCommandScope. Empty scope is the default one
+func (b *Bot) setCommands(cmds []*command, scope string) error {
+	for _, lang := range languages {
+		botCommands := make([]tgbotapi.BotCommand, 0, len(cmds))
+		for _, cmd := range cmds {
+			botCommands = append(botCommands, tgbotapi.BotCommand{
+				Command:     cmd.Command,
+				Description: b.tr.T(lang.code, commandDescriptionKeys[cmd.Command]),
+			})
+		}
+		data, err := json.Marshal(botCommands)
+		if err != nil {
+			return err
+		}
+
+		params := make(tgbotapi.Params)
+		params.AddNonEmpty("commands", string(data))
+		params.AddNonEmpty("scope", scope)
+		if lang.code != defaultLanguage {
+			params.AddNonEmpty("language_code", lang.code)
+		}
+		if _, err := b.sender.MakeRequest("setMyCommands", params); err != nil {
+			return err
+		}
 	}
 	return nil
 }
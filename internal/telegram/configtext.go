@@ -0,0 +1,108 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+// configTextTTL is how long sent config is kept for "Показать текстом" button. Config may hold
+// client private key, so it's kept in memory only and only for a short time, and it's never logged
+const configTextTTL = 15 * time.Minute
+
+// configTextCache keeps content of recently sent configs, so it can be shown as text on request
+type configTextCache struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries map[int64]configTextEntry
+}
+
+type configTextEntry struct {
+	chatID  int64
+	content []byte
+	expires time.Time
+}
+
+func newConfigTextCache() *configTextCache {
+	return &configTextCache{entries: make(map[int64]configTextEntry)}
+}
+
+// put remembers config sent to chat and returns its ID for callback data
+func (c *configTextCache) put(chatID int64, content []byte, now time.Time) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.nextID++
+	c.entries[c.nextID] = configTextEntry{chatID: chatID, content: content, expires: now.Add(configTextTTL)}
+	return c.nextID
+}
+
+// get returns config with the ID sent to chat, false if it's unknown or expired
+func (c *configTextCache) get(id int64, chatID int64, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || entry.chatID != chatID || now.After(entry.expires) {
+		return nil, false
+	}
+	return entry.content, true
+}
+
+// configFileName returns config file name with sanitized device name and creation time, e.g. phone-1699999999.conf.
+// Files of configs without device name are named by creation time only
+func configFileName(deviceName string, now time.Time) string {
+	name := strconv.FormatInt(now.Unix(), 10)
+	if deviceName != "" {
+		name = sanitizeFileName(deviceName) + "-" + name
+	}
+	return name + ".conf"
+}
+
+// createFile returns config file message with "Показать текстом" button
+func (b *Bot) createFile(chatID int64, deviceName string, content []byte) tgbotapi.Chattable {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  configFileName(deviceName, time.Now()),
+		Bytes: content,
+	})
+	id := b.configTexts.put(chatID, content, time.Now())
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(b.chatLanguage(chatID), msgButtonShowAsText), fmt.Sprintf("config_text:%d", id)),
+		),
+	)
+	doc.ReplyMarkup = &keyboard
+	return doc
+}
+
+// handleConfigText sends config as preformatted text to copy it instead of downloading the file.
+// HTML parse mode is used with escaped content, so the config isn't mangled by markup.
+// Config is sent directly, not returned in responses, like deliver does: it mustn't get into send log
+func (b *Bot) handleConfigText(chatID int64, id int64) (responses, error) {
+	content, ok := b.configTexts.get(id, chatID, time.Now())
+	if !ok {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(b.chatLanguage(chatID), msgConfigTextExpired))}, nil
+	}
+
+	text := "<pre>" + html.EscapeString(strings.TrimSpace(string(content))) + "</pre>"
+	if len([]rune(text)) > maxMessageLength {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(b.chatLanguage(chatID), msgConfigTextTooLong))}, nil
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+	if _, err := b.sender.Send(msg); err != nil {
+		return nil, errors.Wrap(err, "failed to send config text")
+	}
+	return nil, nil
+}
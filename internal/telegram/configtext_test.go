@@ -0,0 +1,39 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfigPrivateKey = "cHJpdmF0ZS1rZXktb2YtdGVzdC1jbGllbnQtY29uZmlnPQ=="
+
+func TestConfigTextDoesNotLogPrivateKey(t *testing.T) {
+	bot, sender := newTestBot(t)
+	user := testUser(100, "user")
+	config := "[Interface]\nPrivateKey = " + testConfigPrivateKey + "\nAddress = 10.0.0.2/32\n"
+	id := bot.configTexts.put(user.ID, []byte(config), time.Now())
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	if errs := bot.handle(callbackUpdate(1, user, 1, fmt.Sprintf("config_text:%d", id))); len(errs) > 0 {
+		t.Fatalf("handle() failed: %v", errs)
+	}
+
+	texts := sender.sentTo(user.ID)
+	if len(texts) != 1 || !strings.Contains(texts[0], testConfigPrivateKey) {
+		t.Fatalf("config isn't sent as text: %q", texts)
+	}
+	if !strings.Contains(logs.String(), "config_text") {
+		t.Errorf("callback isn't logged, log capture doesn't work:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), testConfigPrivateKey) {
+		t.Errorf("private key is logged:\n%s", logs.String())
+	}
+}
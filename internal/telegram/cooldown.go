@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultProvisionCooldown = 10 * time.Second
+
+// provisionCooldown allows a single device provisioning per user within cooldown period.
+// Unlike rateLimiter, which limits all updates, it protects the WireGuard server
+// from users creating devices in rapid succession
+type provisionCooldown struct {
+	mu     sync.Mutex
+	period time.Duration
+	last   map[int64]time.Time // User ID -> time of last provisioning attempt
+}
+
+// newProvisionCooldown creates cooldown with given period, zero period disables it
+func newProvisionCooldown(period time.Duration) *provisionCooldown {
+	if period <= 0 {
+		return nil
+	}
+	return &provisionCooldown{
+		period: period,
+		last:   make(map[int64]time.Time),
+	}
+}
+
+// provisionCooldownFromEnv creates cooldown from PROVISION_COOLDOWN_SECONDS environment variable,
+// falling back to default when unset
+func provisionCooldownFromEnv() (*provisionCooldown, error) {
+	period := defaultProvisionCooldown
+	if v := os.Getenv("PROVISION_COOLDOWN_SECONDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid PROVISION_COOLDOWN_SECONDS value: %s", v)
+		}
+		period = time.Duration(n) * time.Second
+	}
+	return newProvisionCooldown(period), nil
+}
+
+// take reports whether user may provision a device now, otherwise how long to wait.
+// Allowed attempt starts a new cooldown period, even if provisioning fails afterwards
+func (c *provisionCooldown) take(userID int64, now time.Time) (bool, time.Duration) {
+	if c == nil {
+		return true, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if last, ok := c.last[userID]; ok {
+		if wait := last.Add(c.period).Sub(now); wait > 0 {
+			return false, wait
+		}
+	}
+	if len(c.last) >= rateLimitPruneSize {
+		c.prune(now)
+	}
+	c.last[userID] = now
+	return true, 0
+}
+
+// prune drops users whose cooldown has passed
+func (c *provisionCooldown) prune(now time.Time) {
+	for id, last := range c.last {
+		if !now.Before(last.Add(c.period)) {
+			delete(c.last, id)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"sync"
+)
+
+// updateDedupWindow is how many recent update IDs are remembered to detect redelivered updates
+const updateDedupWindow = 1000
+
+// updateDedup remembers IDs of recently handled updates, so an update delivered twice
+// (e.g. after network error while confirming it) isn't handled twice
+type updateDedup struct {
+	mu   sync.Mutex
+	ids  map[int]struct{}
+	ring []int // Remembered IDs in arrival order, the oldest one is forgotten first
+	next int   // Position in ring for the next ID
+}
+
+func newUpdateDedup(window int) *updateDedup {
+	return &updateDedup{
+		ids:  make(map[int]struct{}, window),
+		ring: make([]int, 0, window),
+	}
+}
+
+// first reports whether update with the ID is seen for the first time and remembers it
+func (d *updateDedup) first(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.ids[id]; ok {
+		return false
+	}
+	if len(d.ring) < cap(d.ring) {
+		d.ring = append(d.ring, id)
+	} else {
+		delete(d.ids, d.ring[d.next])
+		d.ring[d.next] = id
+		d.next = (d.next + 1) % len(d.ring)
+	}
+	d.ids[id] = struct{}{}
+	return true
+}
@@ -0,0 +1,57 @@
+package telegram
+
+import "testing"
+
+func TestUpdateDedupFirst(t *testing.T) {
+	d := newUpdateDedup(3)
+	steps := []struct {
+		id   int
+		want bool
+	}{
+		{id: 1, want: true},
+		{id: 2, want: true},
+		{id: 1, want: false},
+		{id: 3, want: true},
+		{id: 3, want: false},
+		{id: 4, want: true}, // Window is full, 1 is forgotten
+		{id: 2, want: false},
+		{id: 1, want: true}, // Forgotten ID is seen as new, 2 is forgotten now
+		{id: 2, want: true},
+		{id: 4, want: false},
+	}
+	for i, step := range steps {
+		if got := d.first(step.id); got != step.want {
+			t.Errorf("step %d: first(%d) = %v, want %v", i+1, step.id, got, step.want)
+		}
+	}
+}
+
+func TestRedeliveredCallbackHandledOnce(t *testing.T) {
+	bot, sender := newTestBot(t)
+	from := testUser(100, "alice")
+
+	update := callbackUpdate(1, from, 1, "confirm:1:30")
+	if errs := bot.handle(update); len(errs) != 0 {
+		t.Fatalf("callback failed: %v", errs)
+	}
+	if len(sender.sentTo(from.ID)) == 0 {
+		t.Fatalf("nothing sent for callback")
+	}
+
+	// Telegram redelivers the update after a timeout or restart
+	sender.reset()
+	if errs := bot.handle(update); len(errs) != 0 {
+		t.Fatalf("redelivered callback failed: %v", errs)
+	}
+	if texts := sender.sentTo(from.ID); len(texts) != 0 {
+		t.Errorf("redelivered callback handled again, sent %q", texts)
+	}
+
+	// The same button pressed again is a new update and is handled
+	if errs := bot.handle(callbackUpdate(2, from, 1, "confirm:1:30")); len(errs) != 0 {
+		t.Fatalf("repeated callback failed: %v", errs)
+	}
+	if len(sender.sentTo(from.ID)) == 0 {
+		t.Errorf("nothing sent for repeated button press")
+	}
+}
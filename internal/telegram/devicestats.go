@@ -0,0 +1,72 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/provisioning"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// deviceOnlineWindow is how recent last handshake of connected device is. WireGuard repeats handshake
+// every 2 minutes while there is traffic, so older handshake means the device is disconnected
+const deviceOnlineWindow = 3 * time.Minute
+
+// devicesStatusText lists devices with their connection status for /status
+func (b *Bot) devicesStatusText(ctx context.Context, lang string, devices []*storage.Device) string {
+	text := b.tr.T(lang, msgDevicesTitle)
+	now := time.Now()
+	for _, device := range devices {
+		text += "\n• " + device.DeviceName
+		if status := b.deviceStatus(ctx, lang, device, now); status != "" {
+			text += " — " + status
+		}
+	}
+	return text
+}
+
+// deviceStatus describes device connection and traffic, empty if its stats are unavailable
+func (b *Bot) deviceStatus(ctx context.Context, lang string, device *storage.Device, now time.Time) string {
+	stats, err := b.wireguard.DeviceStats(ctx, device)
+	if errors.Is(err, provisioning.ErrStatsUnsupported) {
+		return ""
+	}
+	// Device is active in DB but its peer is gone from the server, admins can re-add it from /userdevices
+	if errors.Is(err, provisioning.ErrPeerNotFound) {
+		log.Printf("device %d of user %d is out of sync: peer %s is missing on server %q", device.ID, device.UserID, device.PeerPublicKey, device.ServerID)
+		return b.tr.T(lang, msgDeviceMissing)
+	}
+	if err != nil {
+		log.Printf("failed to get stats of device %d: %v", device.ID, err)
+		return ""
+	}
+
+	if stats.LastHandshake.IsZero() {
+		return b.tr.T(lang, msgDeviceNeverConnected)
+	}
+	units := strings.Fields(b.tr.T(lang, msgByteUnits))
+	traffic := fmt.Sprintf("↓ %s ↑ %s", formatBytes(stats.TransmitBytes, units), formatBytes(stats.ReceiveBytes, units))
+	if now.Sub(stats.LastHandshake) <= deviceOnlineWindow {
+		return b.tr.Tf(lang, msgDeviceOnline, traffic)
+	}
+	return b.tr.Tf(lang, msgDeviceOffline, stats.LastHandshake.Format("02.01.2006 15:04"), traffic)
+}
+
+// formatBytes formats byte count with binary units, given from bytes up
+func formatBytes(n int64, units []string) string {
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", n, units[0])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -0,0 +1,122 @@
+package telegram
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/provisioning"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// maxFileNameLength limits sanitized device name used in file names
+const maxFileNameLength = 32
+
+// sanitizeFileName turns device name into a file system safe token: letters and digits are kept,
+// other characters become dashes. Returns "device" for names without letters and digits
+func sanitizeFileName(name string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && sb.Len() > 0 {
+			sb.WriteRune('-')
+			dash = true
+		}
+	}
+	token := []rune(strings.TrimRight(sb.String(), "-"))
+	if len(token) > maxFileNameLength {
+		token = []rune(strings.TrimRight(string(token[:maxFileNameLength]), "-"))
+	}
+	if len(token) == 0 {
+		return "device"
+	}
+	return string(token)
+}
+
+// handleExport sends configs of all user's active devices as a single zip archive.
+// Devices whose private key isn't stored are listed but can't be exported
+func (b *Bot) handleExport(chatID int64, user *storage.User, _ string) (responses, error) {
+	ctx := b.opsCtx
+
+	devices, err := b.repo.GetActiveDevicesByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get devices")
+	}
+	lang := userLanguage(user)
+	if len(devices) == 0 {
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgExportNoDevices))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	names := make(map[string]bool)
+	var exported int
+	var skipped, imported []string
+	for _, device := range devices {
+		cfg, importedKey, err := b.wireguard.RecreateConfig(ctx, device)
+		if errors.Is(err, provisioning.ErrPrivateKeyNotStored) {
+			skipped = append(skipped, device.DeviceName)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to recreate config of device %d", device.ID)
+		}
+
+		// Device names are unique within subscription only
+		name := sanitizeFileName(device.DeviceName)
+		if names[name] {
+			name = fmt.Sprintf("%s-%d", name, device.ID)
+		}
+		names[name] = true
+		file, err := archive.Create(name + ".conf")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to add config to archive")
+		}
+		if _, err := io.Copy(file, cfg); err != nil {
+			return nil, errors.Wrap(err, "failed to write config to archive")
+		}
+		exported++
+		if importedKey {
+			imported = append(imported, device.DeviceName)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to create archive")
+	}
+
+	var notes string
+	if len(imported) > 0 {
+		notes += b.tr.Tf(lang, msgExportImportedKeys, strings.Join(imported, ", "))
+	}
+	if len(skipped) > 0 {
+		notes += b.tr.Tf(lang, msgExportSkipped, strings.Join(skipped, ", "))
+	}
+	if exported == 0 {
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgExportNothing)+notes)
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	log.Printf("Configs of %d devices exported by user %s", exported, user.Username)
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("wireguard-%s.zip", time.Now().Format("20060102")),
+		Bytes: buf.Bytes(),
+	})
+	doc.Caption = b.tr.Tf(lang, msgExportCaption, exported)
+	return responses{doc, tgbotapi.NewMessage(chatID, b.tr.T(lang, msgExportDone)+notes)}, nil
+}
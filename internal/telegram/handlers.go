@@ -1,28 +1,47 @@
 package telegram
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
-	"github.com/yeqown/go-qrcode"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
+	"github.com/skoret/wireguard-bot/internal/access"
+	"github.com/skoret/wireguard-bot/internal/billing"
+	"github.com/skoret/wireguard-bot/internal/provisioning"
 	"github.com/skoret/wireguard-bot/internal/storage"
 )
 
 type responses []tgbotapi.Chattable
 
 func (b *Bot) handleMessage(msg *tgbotapi.Message) (responses, error) {
-	log.Printf("new message: %+v", msg)
+	log.Printf("new message %d from user %d in chat %d", msg.MessageID, msg.From.ID, msg.Chat.ID)
+
+	// Charged payment is processed even for banned users
+	if msg.SuccessfulPayment != nil {
+		return b.handleSuccessfulPayment(msg)
+	}
+
+	// Banned users get nothing but a notice
+	known, err := b.repo.GetUserByTelegramID(b.opsCtx, int64(msg.From.ID))
+	if err != nil {
+		return responses{b.errorMessage(msg.Chat.ID, msg.MessageID, false)}, errors.Wrap(err, "failed to get user")
+	}
+	if b.isBanned(known) {
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(userLanguage(known), msgBanned))}, nil
+	}
 
 	// Handle photo/document uploads (for payment proof)
 	if msg.Photo != nil && len(msg.Photo) > 0 {
@@ -33,38 +52,50 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) (responses, error) {
 	}
 
 	if !msg.IsCommand() {
-		// Check if user is in payment proof mode (could be implemented with state machine)
-		// For now, just show menu
-		return responses{tgbotapi.NewMessage(msg.Chat.ID, "Используйте команды из меню или нажмите /menu")}, nil
+		// Check if bot is waiting for text input in this chat
+		if input, ok := b.popPendingInput(msg.Chat.ID); ok {
+			return b.handlePendingInput(msg, input)
+		}
+		user, err := b.repo.GetUserByTelegramID(b.opsCtx, int64(msg.From.ID))
+		if err != nil {
+			log.Printf("failed to get user %d language: %v", msg.From.ID, err)
+		}
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(userLanguage(user), msgUseMenu))}, nil
 	}
 
-	cmd, ok := commands[msg.Command()]
-	if !ok {
-		return responses{tgbotapi.NewMessage(msg.Chat.ID, "Неизвестная команда. Используйте /menu")}, nil
-	}
+	// Any command cancels pending text input
+	b.clearPendingInput(msg.Chat.ID)
 
 	// Get or create user
-	ctx := context.Background()
+	ctx := b.opsCtx
 	user, err := b.repo.GetOrCreateUser(ctx, int64(msg.From.ID), msg.From.UserName)
 	if err != nil {
-		return responses{errorMessage(msg.Chat.ID, msg.MessageID, false)}, errors.Wrap(err, "failed to get/create user")
+		return responses{b.errorMessage(msg.Chat.ID, msg.MessageID, false)}, errors.Wrap(err, "failed to get/create user")
+	}
+	lang := userLanguage(user)
+
+	cmd, ok := commands[msg.Command()]
+	if !ok {
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgUnknownCommand))}, nil
 	}
 
 	// Register admin if this is /start command and user is admin
-	if msg.Command() == "start" && msg.From.UserName != "" {
-		b.registerAdmin(msg.From.UserName, msg.Chat.ID)
+	if msg.Command() == "start" {
+		b.registerAdmin(user, msg.Chat.ID)
 	}
 
-	res0 := tgbotapi.NewMessage(msg.Chat.ID, cmd.text)
-	res0.ReplyMarkup = cmd.keyboard
+	res0 := tgbotapi.NewMessage(msg.Chat.ID, cmd.localizedText(b.tr, lang))
+	if keyboard := cmd.localizedKeyboard(b.tr, lang); keyboard != nil {
+		res0.ReplyMarkup = keyboard
+	}
 
 	if cmd.handler == nil {
 		return responses{res0}, nil
 	}
 
-	res1, err := cmd.handler(b, msg.Chat.ID, user.ID, user.Username, msg.CommandArguments())
+	res1, err := cmd.handler(b, msg.Chat.ID, user, msg.CommandArguments())
 	if err != nil {
-		return responses{errorMessage(msg.Chat.ID, msg.MessageID, false)}, err
+		return responses{b.errorMessage(msg.Chat.ID, msg.MessageID, false)}, err
 	}
 	if res1 == nil {
 		return responses{res0}, nil
@@ -72,103 +103,282 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) (responses, error) {
 	return append(responses{res0}, res1...), nil
 }
 
+// handlePhoto handles payment proof sent as a photo
 func (b *Bot) handlePhoto(msg *tgbotapi.Message) (responses, error) {
-	// Handle payment proof photo
-	ctx := context.Background()
-	user, err := b.repo.GetUserByTelegramID(ctx, int64(msg.From.ID))
-	if err != nil || user == nil {
-		return responses{tgbotapi.NewMessage(msg.Chat.ID, "Ошибка: пользователь не найден")}, err
+	if len(msg.Photo) == 0 {
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(b.chatLanguage(msg.Chat.ID), msgProofUnreadable))}, nil
 	}
-
 	// Get the largest photo
 	photo := msg.Photo[len(msg.Photo)-1]
-	fileID := photo.FileID
+	return b.attachPaymentProof(msg, photo.FileID, false)
+}
 
-	var pendingPayment *storage.Payment
+// handleDocument handles payment proof sent as a file, only images and PDF are accepted
+func (b *Bot) handleDocument(msg *tgbotapi.Message) (responses, error) {
+	if msg.Document == nil || msg.Document.FileID == "" {
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(b.chatLanguage(msg.Chat.ID), msgProofUnreadable))}, nil
+	}
+	if !isProofDocument(msg.Document) {
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(b.chatLanguage(msg.Chat.ID), msgProofDocumentsOnly))}, nil
+	}
+	return b.attachPaymentProof(msg, msg.Document.FileID, true)
+}
 
-	// First, try to find payment by reference code in caption (if provided)
-	if msg.Caption != "" {
-		referenceCode := strings.TrimSpace(msg.Caption)
-		payment, err := b.repo.GetPaymentByReferenceCode(ctx, referenceCode)
-		if err == nil && payment != nil {
-			// Verify it belongs to this user and is in correct status
-			if payment.UserID == user.ID && payment.Status == storage.PaymentStatusCreated {
-				pendingPayment = payment
-			}
-		}
+// isProofDocument reports whether document can be a payment proof: an image or a PDF
+func isProofDocument(doc *tgbotapi.Document) bool {
+	mime := strings.ToLower(doc.MimeType)
+	return strings.HasPrefix(mime, "image/") || mime == "application/pdf"
+}
+
+// proofMessage returns message showing payment proof, as a photo or as a document depending on how it was sent
+func proofMessage(chatID int64, payment *storage.Payment, caption string) tgbotapi.Chattable {
+	if payment.ProofIsDocument {
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileID(payment.ProofFileID))
+		doc.Caption = caption
+		return doc
+	}
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(payment.ProofFileID))
+	photo.Caption = caption
+	return photo
+}
+
+// attachPaymentProof attaches proof file to one of user's unpaid payments and sends it to review
+func (b *Bot) attachPaymentProof(msg *tgbotapi.Message, fileID string, isDocument bool) (responses, error) {
+	ctx := b.opsCtx
+	user, err := b.repo.GetUserByTelegramID(ctx, int64(msg.From.ID))
+	if err != nil || user == nil {
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(defaultLanguage, msgUserNotFound))}, err
 	}
+	lang := userLanguage(user)
 
-	// If not found by reference code, find latest payment with status "created" for this user
-	if pendingPayment == nil {
-		payments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
-		if err == nil && len(payments) > 0 {
-			// Get the most recent payment with status "created"
-			pendingPayment = payments[len(payments)-1]
+	// Find which of user's unpaid payments the proof is for, caption may contain reference code or amount
+	payments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
+	if err != nil {
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgProofSaveFailed))}, err
+	}
+	if len(payments) == 0 {
+		// Payment may have been sent to review with "Я оплатил" before proof was uploaded
+		payments, err = b.getPaymentsAwaitingProof(ctx, user.ID)
+		if err != nil {
+			return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgProofSaveFailed))}, err
+		}
+	}
+	pendingPayment, ambiguous := selectPaymentForProof(payments, msg.Caption)
+	if ambiguous {
+		var sb strings.Builder
+		sb.WriteString(b.tr.T(lang, msgProofAmbiguous))
+		for _, p := range payments {
+			sb.WriteString("\n• " + b.tr.Tf(lang, msgPaymentListItem, p.ReferenceCode, float64(p.Amount)/100.0, p.DurationDays))
 		}
+		sb.WriteString(b.tr.T(lang, msgProofAmbiguousHint))
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, sb.String())}, nil
 	}
 
 	if pendingPayment == nil {
-		return responses{tgbotapi.NewMessage(msg.Chat.ID, 
-			"❌ Не найдена ожидающая оплата со статусом 'создана'.\n\n"+
-			"Создайте заявку через меню 'Оплата/Продление', затем отправьте скриншот подтверждения оплаты.\n\n"+
-			"Вы также можете указать код заявки в подписи к фото.")}, nil
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgProofNoPayment))}, nil
 	}
 
-	// Verify payment status is still "created" (hasn't been processed yet)
-	if pendingPayment.Status != storage.PaymentStatusCreated {
+	// Verify payment hasn't been processed yet
+	inReview := pendingPayment.Status == storage.PaymentStatusPendingReview
+	if pendingPayment.Status != storage.PaymentStatusCreated && !inReview {
 		return responses{tgbotapi.NewMessage(msg.Chat.ID, 
-			fmt.Sprintf("❌ Платеж с кодом `%s` уже обработан (статус: %s).", 
-				pendingPayment.ReferenceCode, pendingPayment.Status))}, nil
+			b.tr.Tf(lang, msgProofAlreadyProcessedStatus, pendingPayment.ReferenceCode, pendingPayment.Status))}, nil
+	}
+
+	// Don't let user flood admin queue, payment already in review doesn't add to it
+	if !inReview {
+		if err := b.billing.CheckPendingReviewLimit(ctx, user.ID); err != nil {
+			if errors.Is(err, billing.ErrPendingReviewLimit) {
+				return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgPendingReviewLimit))}, nil
+			}
+			return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgProofSaveFailed))}, err
+		}
 	}
 
 	// Attach proof to payment and move to pending_review
-	if err := b.billing.AttachProofAndMoveToPendingReview(ctx, pendingPayment.ID, fileID); err != nil {
-		return responses{tgbotapi.NewMessage(msg.Chat.ID, "Ошибка при сохранении подтверждения оплаты")}, err
+	if err := b.billing.AttachProofAndMoveToPendingReview(ctx, pendingPayment.ID, fileID, isDocument); err != nil {
+		if errors.Is(err, billing.ErrPaymentAlreadyProcessed) {
+			// Admin reviewed payment meanwhile
+			return responses{tgbotapi.NewMessage(msg.Chat.ID,
+				b.tr.Tf(lang, msgProofAlreadyProcessed, pendingPayment.ReferenceCode))}, nil
+		}
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgProofSaveFailed))}, err
 	}
+	b.notifyAdminAboutPayment(ctx, pendingPayment, user.Username)
 
-	text := fmt.Sprintf("✅ Подтверждение оплаты получено!\n\n"+
-		"Ваша заявка отправлена на проверку администратору.\n"+
-		"Код заявки: `%s`\n\n"+
-		"После одобрения администратором вы получите уведомление и сможете создать устройства.",
-		pendingPayment.ReferenceCode)
+	text := b.tr.Tf(lang, msgProofReceived, pendingPayment.ReferenceCode)
 
 	return responses{tgbotapi.NewMessage(msg.Chat.ID, text)}, nil
 }
 
-func (b *Bot) handleDocument(msg *tgbotapi.Message) (responses, error) {
-	// Similar to handlePhoto but for documents
-	return b.handlePhoto(msg)
+// getPaymentsAwaitingProof returns user's payments sent to review without proof
+func (b *Bot) getPaymentsAwaitingProof(ctx context.Context, userID int64) ([]*storage.Payment, error) {
+	payments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, userID, storage.PaymentStatusPendingReview)
+	if err != nil {
+		return nil, err
+	}
+	var awaiting []*storage.Payment
+	for _, p := range payments {
+		if p.ProofFileID == "" {
+			awaiting = append(awaiting, p)
+		}
+	}
+	return awaiting, nil
+}
+
+// selectPaymentForProof chooses which of user's unpaid payments a payment proof belongs to.
+// Payment is matched by reference code in caption first, then by amount in caption.
+// Without a match the only payment is used, with several payments ambiguous is reported
+// instead of guessing, as attaching proof to a wrong payment leads to a wrong approval
+func selectPaymentForProof(payments []*storage.Payment, caption string) (payment *storage.Payment, ambiguous bool) {
+	if len(payments) == 0 {
+		return nil, false
+	}
+
+	words := strings.FieldsFunc(caption, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '#' || r == ':' || r == ';'
+	})
+	for _, word := range words {
+		word = strings.TrimFunc(word, unicode.IsPunct)
+		for _, p := range payments {
+			if strings.EqualFold(word, p.ReferenceCode) {
+				return p, false
+			}
+		}
+	}
+
+	var byAmount []*storage.Payment
+	for _, word := range words {
+		amount, err := billing.ParseRubles(word)
+		if err != nil {
+			continue
+		}
+		for _, p := range payments {
+			if p.Amount == amount {
+				byAmount = append(byAmount, p)
+			}
+		}
+		if len(byAmount) > 0 {
+			break
+		}
+	}
+	if len(byAmount) == 1 {
+		return byAmount[0], false
+	}
+
+	if len(payments) == 1 {
+		return payments[0], false
+	}
+	return nil, true
+}
+
+// handlePendingInput handles text message the bot was waiting for
+func (b *Bot) handlePendingInput(msg *tgbotapi.Message, input pendingInput) (responses, error) {
+	ctx := b.opsCtx
+	user, err := b.repo.GetOrCreateUser(ctx, int64(msg.From.ID), msg.From.UserName)
+	if err != nil {
+		return responses{b.errorMessage(msg.Chat.ID, msg.MessageID, false)}, errors.Wrap(err, "failed to get/create user")
+	}
+
+	lang := userLanguage(user)
+
+	switch input.action {
+	case inputRejectionReason:
+		reason := strings.TrimSpace(msg.Text)
+		if reason == "" {
+			b.setPendingInput(msg.Chat.ID, input)
+			return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgRejectReasonPrompt))}, nil
+		}
+		resps, err := b.rejectPayment(ctx, msg.Chat.ID, input.msgID, user, input.id, reason)
+		if input.caption {
+			resps = editCaptions(resps, msg.Chat.ID, input.msgID)
+		}
+		return resps, err
+	case inputApproveAmount:
+		amount, err := billing.ParseRubles(msg.Text)
+		if err != nil {
+			b.setPendingInput(msg.Chat.ID, input)
+			return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgApproveAmountPrompt))}, nil
+		}
+		resps, err := b.handleApprovePayment(ctx, msg.Chat.ID, input.msgID, user, input.id, "", amount, false)
+		if input.caption {
+			resps = editCaptions(resps, msg.Chat.ID, input.msgID)
+		}
+		return resps, err
+	case inputPromoCode:
+		return b.handlePromoCodeInput(ctx, msg.Chat.ID, lang, input, msg.Text)
+	case inputBroadcastText:
+		return b.handleBroadcastTextInput(msg.Chat.ID, user, input, msg.Text)
+	case inputBanUsername:
+		return b.handleBanUsernameInput(ctx, msg.Chat.ID, user, input, msg.Text)
+	case inputPublicKey:
+		return b.importPublicKey(ctx, msg.Chat.ID, lang, user.ID, msg.Text)
+	}
+
+	return responses{tgbotapi.NewMessage(msg.Chat.ID, b.tr.T(lang, msgUseMenu))}, nil
 }
 
 func (b *Bot) handleQuery(query *tgbotapi.CallbackQuery) (responses, error) {
-	log.Printf("new callback query: %+v", query)
+	log.Printf("new callback query %s from user %d: %q", query.ID, query.From.ID, query.Data)
 
 	if query.Message == nil {
 		return nil, errors.New("callback query received without message")
 	}
 
 	chatID, msgID := query.Message.Chat.ID, query.Message.MessageID
-	ctx := context.Background()
+	ctx := b.opsCtx
 
 	// Get or create user
 	user, err := b.repo.GetOrCreateUser(ctx, int64(query.From.ID), query.From.UserName)
 	if err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to get/create user")
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to get/create user")
 	}
 
 	callback := tgbotapi.NewCallback(query.ID, "")
-	if _, err := b.api.Request(callback); err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to process callback query")
+	if _, err := b.sender.Request(callback); err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to process callback query")
 	}
 
+	if b.isBanned(user) {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(userLanguage(user), msgBanned))}, nil
+	}
+
+	// Any button press cancels pending text input
+	b.clearPendingInput(chatID)
+
 	// Handle callback data
 	data := query.Data
 	resps, err := b.handleCallbackData(ctx, chatID, msgID, user, data)
 	if err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, err
+		resps = responses{b.errorMessage(chatID, msgID, true)}
+	}
+	if isMediaMessage(query.Message) {
+		// Buttons under media, e.g. admin notification with payment proof: message text is its caption
+		resps = editCaptions(resps, chatID, msgID)
+		b.markPendingInputCaption(chatID, msgID)
 	}
 
-	return resps, nil
+	return resps, err
+}
+
+// isMediaMessage reports whether message is a photo or a document, which have caption instead of text
+func isMediaMessage(msg *tgbotapi.Message) bool {
+	return len(msg.Photo) > 0 || msg.Document != nil
+}
+
+// editCaptions replaces text edits of media message with caption edits, text of media message can't be edited
+func editCaptions(resps responses, chatID int64, msgID int) responses {
+	for i, r := range resps {
+		edit, ok := r.(tgbotapi.EditMessageTextConfig)
+		if !ok || edit.ChatID != chatID || edit.MessageID != msgID {
+			continue
+		}
+		caption := tgbotapi.NewEditMessageCaption(chatID, msgID, edit.Text)
+		caption.ParseMode = edit.ParseMode
+		caption.ReplyMarkup = edit.ReplyMarkup
+		resps[i] = caption
+	}
+	return resps
 }
 
 func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, user *storage.User, data string) (responses, error) {
@@ -176,22 +386,37 @@ func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, u
 	
 	// Handle menu commands
 	if cmd, ok := commands[data]; ok {
-		res0 := tgbotapi.NewEditMessageText(chatID, msgID, cmd.text)
-		res0.ReplyMarkup = cmd.keyboard
+		lang := userLanguage(user)
+		res0 := tgbotapi.NewEditMessageText(chatID, msgID, cmd.localizedText(b.tr, lang))
+		res0.ReplyMarkup = cmd.localizedKeyboard(b.tr, lang)
 		if cmd.handler == nil {
 			return responses{res0}, nil
 		}
-		res1, err := cmd.handler(b, chatID, user.ID, user.Username, "")
+		res1, err := cmd.handler(b, chatID, user, "")
 		if err != nil {
 			return responses{res0}, err
 		}
 		return append(responses{res0}, res1...), nil
 	}
 
+	// Reject admin-only callbacks from non-admins before dispatching
+	if isAdminCallback(data) && !b.isAdmin(user) {
+		log.Printf("non-admin %s tried admin callback '%s'", user.Username, data)
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+
+	// Show sent config as text: config_text:<id>
+	if strings.HasPrefix(data, "config_text:") {
+		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "config_text:"), 10, 64)
+		return b.handleConfigText(chatID, id)
+	}
+
 	// Handle payment proof FIRST (before payment prefix check)
-	if data == "payment_proof" {
+	if data == "payment_proof" || strings.HasPrefix(data, "payment_proof:") {
 		log.Printf("Handling payment_proof callback for user %s (chat_id: %d, msg_id: %d)", user.Username, chatID, msgID)
-		resps, err := b.handlePaymentProof(ctx, chatID, msgID, user)
+		// Payment ID is set for button under a specific payment, 0 for menu button
+		paymentID, _ := strconv.ParseInt(strings.TrimPrefix(data, "payment_proof:"), 10, 64)
+		resps, err := b.handlePaymentProof(ctx, chatID, msgID, user, paymentID)
 		if err != nil {
 			log.Printf("ERROR in handlePaymentProof: %v", err)
 		} else {
@@ -200,11 +425,41 @@ func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, u
 		return resps, err
 	}
 
-	// Handle payment flow (but not payment_proof, which is handled above)
+	// Handle payment detail view (before payment prefix check)
+	if strings.HasPrefix(data, "payment_detail:") {
+		paymentIDStr := strings.TrimPrefix(data, "payment_detail:")
+		paymentID, _ := strconv.ParseInt(paymentIDStr, 10, 64)
+		return b.handlePaymentDetail(ctx, chatID, msgID, user, paymentID)
+	}
+
+	// Handle payment history pages
+	if strings.HasPrefix(data, "my_payments:") {
+		page, _ := strconv.Atoi(strings.TrimPrefix(data, "my_payments:"))
+		return b.handleUserPaymentsPage(ctx, chatID, msgID, user, page)
+	}
+
+	// Handle payment cancellation (before payment prefix check)
+	if strings.HasPrefix(data, "payment_cancel:") {
+		paymentID, _ := strconv.ParseInt(strings.TrimPrefix(data, "payment_cancel:"), 10, 64)
+		return b.handlePaymentCancel(ctx, chatID, msgID, user, paymentID)
+	}
+
+	// Handle payment flow (but not payment_proof, payment_detail and payment_cancel, which are handled above)
 	if strings.HasPrefix(data, "payment") {
 		return b.handlePaymentFlow(ctx, chatID, msgID, user, data)
 	}
 
+	// Handle config resend of user's own device
+	if strings.HasPrefix(data, "resend_config:") {
+		deviceID, _ := strconv.ParseInt(strings.TrimPrefix(data, "resend_config:"), 10, 64)
+		return b.handleResendConfig(ctx, chatID, msgID, user, deviceID)
+	}
+
+	// Handle language selection
+	if strings.HasPrefix(data, "lang:") {
+		return b.handleLanguageSelection(ctx, chatID, msgID, user, strings.TrimPrefix(data, "lang:"))
+	}
+
 	// Handle duration selection
 	if strings.HasPrefix(data, "duration:") {
 		durationStr := strings.TrimPrefix(data, "duration:")
@@ -223,6 +478,70 @@ func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, u
 		return b.handleDeviceCountSelection(ctx, chatID, msgID, user, deviceCount, duration)
 	}
 
+	// Handle region selection: region:<devices>:<duration>:<server>
+	if strings.HasPrefix(data, "region:") {
+		parts := strings.SplitN(strings.TrimPrefix(data, "region:"), ":", 3)
+		if len(parts) < 3 {
+			return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("invalid region callback data: %s", data)
+		}
+		deviceCount, _ := strconv.Atoi(parts[0])
+		duration, _ := strconv.Atoi(parts[1])
+		return b.handleRegionSelection(ctx, chatID, msgID, user, deviceCount, duration, parts[2])
+	}
+
+	// Handle renewal from reminder: renew:<duration>:<devices>
+	if strings.HasPrefix(data, "renew:") {
+		parts := strings.Split(strings.TrimPrefix(data, "renew:"), ":")
+		if len(parts) < 2 {
+			return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("invalid renew callback data: %s", data)
+		}
+		duration, _ := strconv.Atoi(parts[0])
+		deviceCount, _ := strconv.Atoi(parts[1])
+		// Suggested plan may be gone from current tariffs, handleDeviceCountSelection falls back to duration choice then
+		return b.handleDeviceCountSelection(ctx, chatID, msgID, user, deviceCount, duration)
+	}
+
+	// Handle promo code request: promo:<devices>:<duration>[:<server>]
+	if strings.HasPrefix(data, "promo:") {
+		parts := strings.Split(strings.TrimPrefix(data, "promo:"), ":")
+		if len(parts) < 2 {
+			return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("invalid promo callback data: %s", data)
+		}
+		deviceCount, _ := strconv.Atoi(parts[0])
+		duration, _ := strconv.Atoi(parts[1])
+		serverID := ""
+		if len(parts) > 2 {
+			serverID = parts[2]
+		}
+		return b.handlePromoCodeRequest(chatID, msgID, userLanguage(user), deviceCount, duration, serverID)
+	}
+
+	// Handle order confirmation: confirm:<devices>:<duration>[:<promo>[:<server>]]
+	if strings.HasPrefix(data, "confirm:") {
+		parts := strings.SplitN(strings.TrimPrefix(data, "confirm:"), ":", 4)
+		if len(parts) < 2 {
+			return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("invalid confirm callback data: %s", data)
+		}
+		deviceCount, _ := strconv.Atoi(parts[0])
+		duration, _ := strconv.Atoi(parts[1])
+		promoCode, serverID := "", ""
+		if len(parts) > 2 {
+			promoCode = parts[2]
+		}
+		if len(parts) > 3 {
+			serverID = parts[3]
+		}
+		if promoCode == appliedPromoMarker {
+			code, ok := b.appliedPromo(chatID)
+			if !ok {
+				// Chat state is lost on restart, user has to enter promo code again
+				return b.promoExpiredResponses(chatID, msgID, userLanguage(user), deviceCount, duration, serverID), nil
+			}
+			promoCode = code
+		}
+		return b.handlePaymentConfirm(ctx, chatID, msgID, user, deviceCount, duration, promoCode, serverID)
+	}
+
 	// Handle admin callbacks
 	if strings.HasPrefix(data, "admin:") {
 		return b.handleAdminCallback(ctx, chatID, msgID, user, data)
@@ -235,6 +554,42 @@ func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, u
 		return b.handleAdminApprovePayment(ctx, chatID, msgID, user, paymentID)
 	}
 
+	if strings.HasPrefix(data, "admin_resync:") {
+		deviceID, _ := strconv.ParseInt(strings.TrimPrefix(data, "admin_resync:"), 10, 64)
+		return b.handleAdminResyncDevice(ctx, chatID, msgID, user, deviceID)
+	}
+
+	if strings.HasPrefix(data, "admin_devices:") {
+		// admin_devices:<userID>:<page>
+		parts := strings.Split(strings.TrimPrefix(data, "admin_devices:"), ":")
+		targetUserID, _ := strconv.ParseInt(parts[0], 10, 64)
+		page := 0
+		if len(parts) > 1 {
+			page, _ = strconv.Atoi(parts[1])
+		}
+		return b.handleAdminUserDevicesPage(ctx, chatID, msgID, userLanguage(user), targetUserID, page)
+	}
+
+	if strings.HasPrefix(data, "admin_revoke:") {
+		deviceID, _ := strconv.ParseInt(strings.TrimPrefix(data, "admin_revoke:"), 10, 64)
+		return b.handleAdminRevokeDeviceConfirm(ctx, chatID, msgID, userLanguage(user), deviceID)
+	}
+
+	if strings.HasPrefix(data, "admin_revoke_confirm:") {
+		deviceID, _ := strconv.ParseInt(strings.TrimPrefix(data, "admin_revoke_confirm:"), 10, 64)
+		return b.handleAdminRevokeDevice(ctx, chatID, msgID, user, deviceID)
+	}
+
+	if strings.HasPrefix(data, "admin_resend_config:") {
+		targetUserID, _ := strconv.ParseInt(strings.TrimPrefix(data, "admin_resend_config:"), 10, 64)
+		return b.handleAdminResendConfig(ctx, chatID, msgID, user, targetUserID)
+	}
+
+	if strings.HasPrefix(data, "admin_new_device:") {
+		targetUserID, _ := strconv.ParseInt(strings.TrimPrefix(data, "admin_new_device:"), 10, 64)
+		return b.handleAdminNewDevice(ctx, chatID, msgID, user, targetUserID)
+	}
+
 	if strings.HasPrefix(data, "admin_reject:") {
 		paymentIDStr := strings.TrimPrefix(data, "admin_reject:")
 		paymentID, _ := strconv.ParseInt(paymentIDStr, 10, 64)
@@ -251,7 +606,18 @@ func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, u
 			// Join remaining parts in case comment contains ":"
 			verifiedComment = strings.Join(parts[1:], ":")
 		}
-		return b.handleApprovePayment(ctx, chatID, msgID, user, paymentID, verifiedComment)
+		return b.handleApprovePayment(ctx, chatID, msgID, user, paymentID, verifiedComment, 0, false)
+	}
+
+	if strings.HasPrefix(data, "approve_amount:") {
+		// approve_amount:<paymentID>:<received kopecks>, approves despite amount mismatch
+		parts := strings.Split(strings.TrimPrefix(data, "approve_amount:"), ":")
+		paymentID, _ := strconv.ParseInt(parts[0], 10, 64)
+		amount := 0
+		if len(parts) > 1 {
+			amount, _ = strconv.Atoi(parts[1])
+		}
+		return b.handleApprovePayment(ctx, chatID, msgID, user, paymentID, "", amount, true)
 	}
 
 	if strings.HasPrefix(data, "reject:") {
@@ -260,10 +626,10 @@ func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, u
 		return b.handleRejectPayment(ctx, chatID, msgID, user, paymentID)
 	}
 
-	if strings.HasPrefix(data, "payment_detail:") {
-		paymentIDStr := strings.TrimPrefix(data, "payment_detail:")
+	if strings.HasPrefix(data, "reject_noreason:") {
+		paymentIDStr := strings.TrimPrefix(data, "reject_noreason:")
 		paymentID, _ := strconv.ParseInt(paymentIDStr, 10, 64)
-		return b.handlePaymentDetail(ctx, chatID, msgID, user, paymentID)
+		return b.rejectPayment(ctx, chatID, msgID, user, paymentID, "")
 	}
 
 	if strings.HasPrefix(data, "approve_verify:") {
@@ -272,646 +638,2269 @@ func (b *Bot) handleCallbackData(ctx context.Context, chatID int64, msgID int, u
 		return b.handleApprovePaymentVerify(ctx, chatID, msgID, user, paymentID)
 	}
 
-	return responses{errorMessage(chatID, msgID, true)}, errors.Errorf("unknown callback data: %s", data)
+	return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("unknown callback data: %s", data)
+}
+
+// adminCallbackPrefixes lists callback data prefixes available to admins only
+var adminCallbackPrefixes = []string{
+	"admin:",
+	"admin_approve:",
+	"admin_reject:",
+	"admin_resync:",
+	"admin_revoke:",
+	"admin_revoke_confirm:",
+	"admin_resend_config:",
+	"admin_devices:",
+	"admin_new_device:",
+	"approve:",
+	"approve_verify:",
+	"approve_amount:",
+	"reject:",
+	"reject_noreason:",
+	"payment_detail:",
+}
+
+// isAdminCallback reports whether callback data belongs to admin-only actions
+func isAdminCallback(data string) bool {
+	for _, prefix := range adminCallbackPrefixes {
+		if strings.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 func (b *Bot) handlePaymentFlow(ctx context.Context, chatID int64, msgID int, user *storage.User, data string) (responses, error) {
 	if data == "payment" {
 		// Show duration selection
-		text := "Выберите срок подписки:"
-		res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-		res.ReplyMarkup = &durationKeyboard
+		lang := userLanguage(user)
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgChooseDuration))
+		res.ReplyMarkup = durationKeyboard(b.tr, lang, b.billing.Plans().Durations)
 		return responses{res}, nil
 	}
 	return nil, nil
 }
 
 func (b *Bot) handleDurationSelection(ctx context.Context, chatID int64, msgID int, user *storage.User, duration int) (responses, error) {
-	text := fmt.Sprintf("Выбран срок: %d дней\n\nВыберите количество устройств:", duration)
+	lang := userLanguage(user)
+	plans := b.billing.Plans()
+	if !plans.AllowsDuration(duration) {
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgChooseDuration))
+		res.ReplyMarkup = durationKeyboard(b.tr, lang, plans.Durations)
+		return responses{res}, nil
+	}
+
+	text := b.tr.Tf(lang, msgChooseDeviceCount, duration)
 	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ReplyMarkup = deviceCountKeyboardForDuration(duration)
+	res.ReplyMarkup = deviceCountKeyboardForDuration(b.tr, lang, duration, plans.MaxDevices)
 
 	return responses{res}, nil
 }
 
 func (b *Bot) handleDeviceCountSelection(ctx context.Context, chatID int64, msgID int, user *storage.User, deviceCount int, duration int) (responses, error) {
-	amount := b.billing.CalculatePrice(duration, deviceCount)
-	
-	// Create payment attempt
-	payment, err := b.billing.CreatePaymentAttempt(ctx, user.ID, duration, deviceCount)
-	if err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to create payment")
+	lang := userLanguage(user)
+	plans := b.billing.Plans()
+	if !plans.AllowsDuration(duration) || !plans.AllowsDeviceCount(deviceCount) {
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgChooseDuration))
+		res.ReplyMarkup = durationKeyboard(b.tr, lang, plans.Durations)
+		return responses{res}, nil
 	}
 
-	// Simplified payment flow message
-	text := fmt.Sprintf("💳 Оплата подписки\n\n"+
-		"📋 Детали заявки:\n"+
-		"• Срок: %d дней\n"+
-		"• Устройств: %d\n"+
-		"• Сумма: %.2f руб.\n\n"+
-		"🔑 КОД ЗАЯВКИ:\n"+
-		"`%s`\n\n"+
-		"━━━━━━━━━━━━━━━━━━━━\n\n"+
-		"📝 Инструкция:\n"+
-		"1. Отсканируйте QR-код ниже\n"+
-		"2. Оплатите нужную сумму\n"+
-		"3. В комментарии к переводу укажите КОД ЗАЯВКИ\n"+
-		"4. После оплаты нажмите «Я оплатил»\n\n"+
-		"⚠️ БЕЗ КОДА ЗАЯВКИ ПЛАТЕЖ НЕ БУДЕТ ПРИНЯТ!",
-		duration, deviceCount, float64(amount)/100.0, payment.ReferenceCode)
-
+	text, keyboard := b.planStep(lang, duration, deviceCount)
 	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ParseMode = "Markdown"
-	
-	// Keyboard with buttons
+	res.ReplyMarkup = keyboard
+	return responses{res}, nil
+}
+
+// orderSummary returns order summary text and keyboard to confirm order or enter promo code,
+// serverID is the chosen server, empty for the default one
+func (b *Bot) orderSummary(lang string, duration int, deviceCount int, serverID string) (string, tgbotapi.InlineKeyboardMarkup) {
+	amount := b.billing.CalculatePrice(duration, deviceCount)
+	text := b.tr.Tf(lang, msgOrderSummary, duration, deviceCount, b.regionLine(lang, serverID), float64(amount)/100.0)
+
+	promoData := fmt.Sprintf("promo:%d:%d", deviceCount, duration)
+	if serverID != "" {
+		promoData += ":" + serverID
+	}
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✅ Я оплатил", "payment_proof"),
-			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", MenuCmd.Command),
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonGoToPayment), confirmCallbackData(deviceCount, duration, false, serverID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonEnterPromo), promoData),
 		),
+		tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)),
 	)
-	res.ReplyMarkup = &keyboard
-	
-	// Send static QR code from file
-	qrPhoto := b.sendPaymentQR(chatID)
-	if qrPhoto == nil {
-		// If QR failed to load, show error message
-		errorMsg := tgbotapi.NewEditMessageText(chatID, msgID, 
-			"❌ Ошибка: QR-код не найден. Обратитесь к администратору.")
-		return responses{errorMsg}, nil
-	}
-
-	return responses{res, qrPhoto}, nil
+	return text, keyboard
 }
 
-func (b *Bot) handlePaymentProof(ctx context.Context, chatID int64, msgID int, user *storage.User) (responses, error) {
-	log.Printf("handlePaymentProof called for user %s (ID: %d, chat_id: %d)", user.Username, user.ID, chatID)
-	
-	// First, check if there's a payment already in pending_review
-	pendingPayments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusPendingReview)
-	if err == nil && len(pendingPayments) > 0 {
-		// Payment already in review
-		pendingPayment := pendingPayments[len(pendingPayments)-1]
-		text := fmt.Sprintf("⏳ Ваша заявка уже на проверке!\n\n"+
-			"Код заявки: `%s`\n"+
-			"Сумма: %.2f руб.\n"+
-			"Срок: %d дней\n"+
-			"Устройств: %d\n\n"+
-			"Администратор проверит ваш платеж и одобрит его.\n"+
-			"После одобрения вы получите уведомление.",
-			pendingPayment.ReferenceCode,
-			float64(pendingPayment.Amount)/100.0,
-			pendingPayment.DurationDays,
-			pendingPayment.DeviceCount)
-		res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-		res.ParseMode = "Markdown"
-		res.ReplyMarkup = &mainMenuKeyboard
-		return responses{res}, nil
+// handleStart handles /start command with optional deep-link argument:
+// "pay", "pay_<days>" or "pay_<days>_<devices>" open payment flow with preselected plan
+func (b *Bot) handleStart(chatID int64, user *storage.User, arg string) (responses, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "pay" || strings.HasPrefix(arg, "pay_") {
+		return b.paymentDeepLink(chatID, userLanguage(user), strings.TrimPrefix(strings.TrimPrefix(arg, "pay"), "_")), nil
 	}
+	return nil, nil
+}
 
-	// Find latest payment with status "created" for this user
-	payments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
-	if err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, err
-	}
+// paymentDeepLink opens payment flow at the step matching preselected plan,
+// falling back to the duration selection for missing or invalid values
+func (b *Bot) paymentDeepLink(chatID int64, lang string, plan string) responses {
+	plans := b.billing.Plans()
+	durationMsg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgChooseDuration))
+	durationMsg.ReplyMarkup = durationKeyboard(b.tr, lang, plans.Durations)
 
-	var pendingPayment *storage.Payment
-	if len(payments) > 0 {
-		// Get the most recent payment with status "created"
-		pendingPayment = payments[len(payments)-1]
+	if plan == "" {
+		return responses{durationMsg}
 	}
-
-	if pendingPayment == nil {
-		text := "❌ Не найдена ожидающая оплата.\n\n" +
-			"Создайте заявку через 'Оплата/Продление' в меню."
-		res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-		res.ReplyMarkup = &mainMenuKeyboard
-		return responses{res}, nil
+	parts := strings.Split(plan, "_")
+	if len(parts) > 2 {
+		return responses{durationMsg}
+	}
+	duration, err := strconv.Atoi(parts[0])
+	if err != nil || !plans.AllowsDuration(duration) {
+		return responses{durationMsg}
 	}
 
-	// Move payment to pending_review status (simplified - no proof required at this step)
-	// Proof will be checked by admin
-	if err := b.repo.UpdatePaymentStatus(ctx, pendingPayment.ID, storage.PaymentStatusPendingReview, nil); err != nil {
-		log.Printf("ERROR: failed to update payment status to pending_review: %v", err)
-		return responses{errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to update payment status")
+	if len(parts) == 1 {
+		msg := tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgChooseDeviceCount, duration))
+		msg.ReplyMarkup = deviceCountKeyboardForDuration(b.tr, lang, duration, plans.MaxDevices)
+		return responses{msg}
 	}
-	log.Printf("Payment %d moved to pending_review status", pendingPayment.ID)
 
-	// Notify admin about new payment
-	log.Printf("Calling notifyAdminAboutPayment for payment %d, user %s", pendingPayment.ID, user.Username)
-	b.notifyAdminAboutPayment(ctx, pendingPayment, user.Username)
+	deviceCount, err := strconv.Atoi(parts[1])
+	if err != nil || !plans.AllowsDeviceCount(deviceCount) {
+		msg := tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgChooseDeviceCount, duration))
+		msg.ReplyMarkup = deviceCountKeyboardForDuration(b.tr, lang, duration, plans.MaxDevices)
+		return responses{msg}
+	}
 
-	text := fmt.Sprintf("✅ Заявка отправлена на проверку!\n\n"+
-		"📋 Ваша заявка:\n"+
-		"• Код заявки: `%s`\n"+
-		"• Сумма: %.2f руб.\n"+
-		"• Срок: %d дней\n"+
-		"• Устройств: %d\n\n"+
-		"⏳ ОЖИДАЕТ ПРОВЕРКИ АДМИНИСТРАТОРОМ\n\n"+
-		"После одобрения вы получите уведомление и VPN конфигурацию.",
-		pendingPayment.ReferenceCode,
-		float64(pendingPayment.Amount)/100.0,
-		pendingPayment.DurationDays,
-		pendingPayment.DeviceCount)
+	text, keyboard := b.planStep(lang, duration, deviceCount)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	return responses{msg}
+}
 
-	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ParseMode = "Markdown"
-	res.ReplyMarkup = &mainMenuKeyboard
+// handlePromoCodeRequest asks user to send promo code for selected plan and server
+func (b *Bot) handlePromoCodeRequest(chatID int64, msgID int, lang string, deviceCount int, duration int, serverID string) (responses, error) {
+	b.setPendingInput(chatID, pendingInput{
+		action:      inputPromoCode,
+		msgID:       msgID,
+		deviceCount: deviceCount,
+		duration:    duration,
+		serverID:    serverID,
+	})
 
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgPromoPrompt))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonWithoutPromo), confirmCallbackData(deviceCount, duration, false, serverID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)),
+	)
+	res.ReplyMarkup = &keyboard
 	return responses{res}, nil
 }
 
-// notifyAdminAboutPayment sends notification to all admins about new payment
-func (b *Bot) notifyAdminAboutPayment(ctx context.Context, payment *storage.Payment, username string) {
-	log.Printf("notifyAdminAboutPayment called for payment %d, username %s", payment.ID, username)
-	adminChatIDs := b.getAdminChatIDs()
-	log.Printf("Found %d admin chat IDs: %v", len(adminChatIDs), adminChatIDs)
-	if len(adminChatIDs) == 0 {
-		log.Printf("WARNING: No admin chat IDs registered, cannot send notification. Admin must send /start first.")
-		return
+// handlePromoCodeInput validates promo code sent by user and shows discounted price
+func (b *Bot) handlePromoCodeInput(ctx context.Context, chatID int64, lang string, input pendingInput, text string) (responses, error) {
+	withoutPromoRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonWithoutPromo), confirmCallbackData(input.deviceCount, input.duration, false, input.serverID)),
+	)
+
+	promo, err := b.billing.ValidatePromoCode(ctx, text)
+	if err != nil {
+		if errors.Is(err, billing.ErrInvalidPromoCode) {
+			b.setPendingInput(chatID, input)
+			msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgPromoInvalid))
+			keyboard := tgbotapi.NewInlineKeyboardMarkup(withoutPromoRow, tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)))
+			msg.ReplyMarkup = &keyboard
+			return responses{msg}, nil
+		}
+		return responses{b.errorMessage(chatID, 0, false)}, errors.Wrap(err, "failed to validate promo code")
 	}
 
-	paymentUser, err := b.repo.GetUserByID(ctx, payment.UserID)
-	if err == nil && paymentUser != nil {
-		username = paymentUser.Username
+	amount := b.billing.CalculatePrice(input.duration, input.deviceCount)
+	discounted := billing.ApplyPromoCode(amount, promo)
+	msgText := b.tr.Tf(lang, msgPromoApplied,
+		promo.Code, input.duration, input.deviceCount, b.regionLine(lang, input.serverID), float64(discounted)/100.0, float64(amount)/100.0)
+
+	b.setAppliedPromo(chatID, promo.Code)
+	msg := tgbotapi.NewMessage(chatID, msgText)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonGoToPayment), confirmCallbackData(input.deviceCount, input.duration, true, input.serverID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)),
+	)
+	msg.ReplyMarkup = &keyboard
+	return responses{msg}, nil
+}
+
+// handlePaymentConfirm creates payment attempt for selected plan and server and shows payment instructions
+func (b *Bot) handlePaymentConfirm(ctx context.Context, chatID int64, msgID int, user *storage.User, deviceCount int, duration int, promoCode string, serverID string) (responses, error) {
+	// Chosen server may have been removed from configuration since the order summary was shown
+	if _, ok := b.findServer(serverID); !ok && serverID != "" {
+		return b.handleDeviceCountSelection(ctx, chatID, msgID, user, deviceCount, duration)
 	}
 
-	text := fmt.Sprintf("💳 НОВАЯ ОПЛАТА\n\n"+
-		"👤 Пользователь: @%s\n"+
-		"📆 Срок: %d дней\n"+
-		"📱 Устройств: %d\n"+
-		"💰 Сумма: %.2f ₽\n\n"+
-		"🔑 Код заявки:\n`%s`",
-		username,
-		payment.DurationDays,
-		payment.DeviceCount,
-		float64(payment.Amount)/100.0,
-		payment.ReferenceCode)
+	lang := userLanguage(user)
+
+	// Create payment attempt
+	payment, err := b.billing.CreatePaymentAttempt(ctx, user.ID, duration, deviceCount, promoCode, serverID)
+	if err != nil {
+		if errors.Is(err, billing.ErrInvalidPromoCode) {
+			return b.promoExpiredResponses(chatID, msgID, lang, deviceCount, duration, serverID), nil
+		}
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to create payment")
+	}
+	if b.invoicesEnabled() {
+		return b.invoiceResponses(chatID, msgID, lang, payment), nil
+	}
+	amount := payment.Amount
+
+	promoLine := ""
+	if payment.PromoCode != "" {
+		promoLine = b.tr.Tf(lang, msgPaymentPromoLine, payment.PromoCode)
+	}
+
+	// Simplified payment flow message
+	text := b.tr.Tf(lang, msgPaymentInstructions,
+		duration, deviceCount, escapeMarkdown(b.regionLine(lang, payment.ServerID)), escapeMarkdown(promoLine), float64(amount)/100.0, payment.ReferenceCode,
+		escapeMarkdown(b.paymentTexts.render(b.paymentTexts.instructionsText(b.tr, lang), payment)))
+
+	// Send payment QR (dynamic with embedded amount and comment, or static from file).
+	// Payment stays valid without QR, user gets a note to ask for requisites
+	qrPhoto := b.sendPaymentQR(chatID, payment)
+	if qrPhoto == nil {
+		log.Printf("payment QR is not available for payment %d", payment.ID)
+		text += b.tr.T(lang, msgPaymentQRUnavailable)
+	}
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ParseMode = "Markdown"
+	
+	// Keyboard with buttons
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonPaid), fmt.Sprintf("payment_proof:%d", payment.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonCancel), fmt.Sprintf("payment_cancel:%d", payment.ID)),
+		),
+	)
+	res.ReplyMarkup = &keyboard
+
+	if qrPhoto == nil {
+		return responses{res}, nil
+	}
+	return responses{res, qrPhoto}, nil
+}
 
-	// Create keyboard with approve/reject buttons
+// promoExpiredResponses tells user that promo code can't be applied anymore and offers to pay without it
+func (b *Bot) promoExpiredResponses(chatID int64, msgID int, lang string, deviceCount int, duration int, serverID string) responses {
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgPromoExpired))
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✅ Подтвердить", fmt.Sprintf("admin_approve:%d", payment.ID)),
-			tgbotapi.NewInlineKeyboardButtonData("❌ Отклонить", fmt.Sprintf("admin_reject:%d", payment.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonWithoutPromo), confirmCallbackData(deviceCount, duration, false, serverID)),
 		),
+		tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)),
 	)
+	res.ReplyMarkup = &keyboard
+	return responses{res}
+}
+
+// handlePaymentCancel cancels user's payment from the payment message
+func (b *Bot) handlePaymentCancel(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	payment, err := b.repo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	if payment == nil || payment.UserID != user.ID {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("payment %d not found for user %d", paymentID, user.ID)
+	}
+
+	lang := userLanguage(user)
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.cancelPayment(ctx, lang, payment))
+	res.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+	return responses{res}, nil
+}
+
+// handleHelp adds admin commands section to help for admins, others get common help only
+func (b *Bot) handleHelp(chatID int64, user *storage.User, _ string) (responses, error) {
+	if !b.isAdmin(user) {
+		return nil, nil
+	}
+	return responses{tgbotapi.NewMessage(chatID, b.tr.T(userLanguage(user), msgHelpAdminText))}, nil
+}
+
+// handleCancel cancels user's unpaid payment and any pending conversation
+func (b *Bot) handleCancel(chatID int64, user *storage.User, _ string) (responses, error) {
+	ctx := b.opsCtx
+	lang := userLanguage(user)
+	// Pending text input is already cleared by command dispatch
+	b.popBroadcastDraft(chatID)
+
+	payments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payments")
+	}
+	if len(payments) > 0 {
+		var text string
+		for _, payment := range payments {
+			text = b.cancelPayment(ctx, lang, payment)
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+
+	pending, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusPendingReview)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payments")
+	}
+	if len(pending) > 0 {
+		return responses{tgbotapi.NewMessage(chatID, b.cancelPayment(ctx, lang, pending[len(pending)-1]))}, nil
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgCancelNothing))
+	msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+	return responses{msg}, nil
+}
+
+// cancelPayment cancels payment if it is still unpaid and returns text for the user
+func (b *Bot) cancelPayment(ctx context.Context, lang string, payment *storage.Payment) string {
+	switch payment.Status {
+	case storage.PaymentStatusCreated:
+	case storage.PaymentStatusPendingReview, storage.PaymentStatusPendingSecondApproval:
+		return b.tr.Tf(lang, msgCancelInReview, payment.ReferenceCode)
+	case storage.PaymentStatusApproved:
+		return b.tr.Tf(lang, msgCancelApproved, payment.ReferenceCode)
+	default:
+		return b.tr.Tf(lang, msgCancelClosed, payment.ReferenceCode, payment.Status)
+	}
+
+	if err := b.billing.CancelPaymentAttempt(ctx, payment.ID); err != nil {
+		// Payment status changed meanwhile, e.g. proof was just uploaded
+		log.Printf("failed to cancel payment %d: %v", payment.ID, err)
+		return b.tr.Tf(lang, msgCancelFailed, payment.ReferenceCode)
+	}
+	log.Printf("Payment %d cancelled by user %d", payment.ID, payment.UserID)
+	return b.tr.Tf(lang, msgCancelDone, payment.ReferenceCode)
+}
+
+func (b *Bot) handlePaymentProof(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	log.Printf("handlePaymentProof called for user %s (ID: %d, chat_id: %d)", user.Username, user.ID, chatID)
+	lang := userLanguage(user)
+	
+	// Find latest payment with status "created" for this user
+	payments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+
+	// Check if there's a payment already in pending_review: either nothing new to submit,
+	// or user can't submit more payments until admins review the pending ones
+	limitErr := b.billing.CheckPendingReviewLimit(ctx, user.ID)
+	if limitErr != nil && !errors.Is(limitErr, billing.ErrPendingReviewLimit) {
+		return responses{b.errorMessage(chatID, msgID, true)}, limitErr
+	}
+	pendingPayments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusPendingReview)
+	if err == nil && len(pendingPayments) > 0 && (len(payments) == 0 || limitErr != nil) {
+		// Payment already in review
+		pendingPayment := pendingPayments[len(pendingPayments)-1]
+
+		// Resubmission: update existing admin notification instead of sending a new one
+		b.notifyAdminAboutPayment(ctx, pendingPayment, user.Username)
+
+		text := b.tr.Tf(lang, msgProofAlreadyInReview,
+			pendingPayment.ReferenceCode,
+			float64(pendingPayment.Amount)/100.0,
+			pendingPayment.DurationDays,
+			pendingPayment.DeviceCount)
+		res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+		res.ParseMode = "Markdown"
+		res.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+
+	if limitErr != nil {
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgPendingReviewLimit))
+		res.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+
+	var pendingPayment *storage.Payment
+	for _, p := range payments {
+		if p.ID == paymentID {
+			pendingPayment = p
+		}
+	}
+	if pendingPayment == nil && len(payments) > 0 {
+		var ambiguous bool
+		pendingPayment, ambiguous = selectPaymentForProof(payments, "")
+		if ambiguous {
+			// Several unpaid payments, user must choose which one was paid
+			var buttons [][]tgbotapi.InlineKeyboardButton
+			for _, p := range payments {
+				label := b.tr.Tf(lang, msgPaymentListItem, p.ReferenceCode, float64(p.Amount)/100.0, p.DurationDays)
+				buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("payment_proof:%d", p.ID)),
+				))
+			}
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)))
+			res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgProofChoosePayment))
+			res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+			return responses{res}, nil
+		}
+	}
+
+	if pendingPayment == nil {
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgProofNoPendingPayment))
+		res.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+
+	if b.requireProof {
+		// Payment goes to review only with proof attached, see attachPaymentProof
+		text := b.tr.Tf(lang, msgProofPrompt,
+			pendingPayment.ReferenceCode,
+			float64(pendingPayment.Amount)/100.0)
+		res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+		res.ParseMode = "Markdown"
+		res.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+
+	// Move payment to pending_review status (no proof required at this step)
+	// Proof will be checked by admin
+	if err := b.repo.UpdatePaymentStatus(ctx, pendingPayment.ID, storage.PaymentStatusPendingReview, nil); err != nil {
+		log.Printf("ERROR: failed to update payment status to pending_review: %v", err)
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to update payment status")
+	}
+	log.Printf("Payment %d moved to pending_review status", pendingPayment.ID)
+
+	// Notify admin about new payment
+	log.Printf("Calling notifyAdminAboutPayment for payment %d, user %s", pendingPayment.ID, user.Username)
+	b.notifyAdminAboutPayment(ctx, pendingPayment, user.Username)
+
+	text := b.tr.Tf(lang, msgProofSentToReview,
+		pendingPayment.ReferenceCode,
+		float64(pendingPayment.Amount)/100.0,
+		pendingPayment.DurationDays,
+		pendingPayment.DeviceCount)
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ParseMode = "Markdown"
+	res.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+
+	return responses{res}, nil
+}
+
+// notifyAdminAboutPayment sends notification to all admins about new payment.
+// Admins are notified only once per payment: on resubmission the existing
+// admin messages are updated instead of sending new ones
+func (b *Bot) notifyAdminAboutPayment(ctx context.Context, payment *storage.Payment, username string) {
+	log.Printf("notifyAdminAboutPayment called for payment %d, username %s", payment.ID, username)
+	adminChatIDs := b.getAdminChatIDs()
+	log.Printf("Found %d admin chat IDs: %v", len(adminChatIDs), adminChatIDs)
+	if len(adminChatIDs) == 0 {
+		log.Printf("WARNING: No admin chat IDs registered, cannot send notification. Admin must send /start first.")
+		return
+	}
+
+	// Payment passed by caller may be stale, e.g. proof was attached meanwhile
+	if p, err := b.repo.GetPaymentByID(ctx, payment.ID); err == nil && p != nil {
+		payment = p
+	}
+	paymentUser, err := b.repo.GetUserByID(ctx, payment.UserID)
+	if err == nil && paymentUser != nil {
+		username = paymentUser.Username
+	}
+
+	// Notification is built in each admin's language
+	notification := func(lang string) (string, tgbotapi.InlineKeyboardMarkup) {
+		return adminPaymentNotificationText(b.tr, lang, payment, username), adminPaymentKeyboard(b.tr, lang, payment.ID)
+	}
+
+	firstNotification, err := b.repo.MarkPaymentNotified(ctx, payment.ID)
+	if err != nil {
+		log.Printf("failed to mark payment %d notified: %v", payment.ID, err)
+		return
+	}
+
+	if !firstNotification {
+		notifications, err := b.repo.GetAdminNotificationsByPaymentID(ctx, payment.ID)
+		if err != nil {
+			log.Printf("failed to get admin notifications for payment %d: %v", payment.ID, err)
+			return
+		}
+		if len(notifications) > 0 {
+			now := time.Now().Format("02.01.2006 15:04")
+			for _, n := range notifications {
+				lang := b.chatLanguage(n.ChatID)
+				text, keyboard := notification(lang)
+				if !n.WithProof && payment.ProofFileID != "" {
+					// Proof attached after notification was sent, text message can't get media added
+					b.replaceAdminNotification(ctx, n, payment,
+						text+b.tr.Tf(lang, msgAdminProofAttached, now), keyboard)
+					continue
+				}
+				edit := editAdminNotification(n,
+					text+b.tr.Tf(lang, msgAdminPaymentResubmitted, now), keyboard)
+				if err := b.send(edit); err != nil {
+					log.Printf("failed to update admin notification (chat_id: %d): %v", n.ChatID, err)
+				}
+			}
+			log.Printf("Admins already notified about payment %d, updated %d messages", payment.ID, len(notifications))
+			return
+		}
+		log.Printf("Payment %d marked notified but no admin messages stored, sending new notification", payment.ID)
+	}
 
 	// Send to all registered admin chat IDs
 	for _, chatID := range adminChatIDs {
+		text, keyboard := notification(b.chatLanguage(chatID))
+		sent, err := b.sender.Send(adminNotificationMessage(chatID, payment, text, keyboard))
+		if err != nil {
+			log.Printf("failed to notify admin (chat_id: %d): %v", chatID, err)
+			continue
+		}
+		log.Printf("Notification sent to admin (chat_id: %d)", chatID)
+
+		notification := &storage.AdminNotification{
+			PaymentID: payment.ID,
+			ChatID:    chatID,
+			MessageID: sent.MessageID,
+			WithProof: payment.ProofFileID != "",
+		}
+		if err := b.repo.CreateAdminNotification(ctx, notification); err != nil {
+			log.Printf("failed to save admin notification (chat_id: %d): %v", chatID, err)
+		}
+	}
+}
+
+// replaceAdminNotification sends new admin notification showing payment proof instead of text-only one
+func (b *Bot) replaceAdminNotification(ctx context.Context, n *storage.AdminNotification, payment *storage.Payment, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	sent, err := b.sender.Send(adminNotificationMessage(n.ChatID, payment, text, keyboard))
+	if err != nil {
+		log.Printf("failed to send admin notification with proof (chat_id: %d): %v", n.ChatID, err)
+		return
+	}
+	if err := b.repo.UpdateAdminNotificationMessage(ctx, n.ID, sent.MessageID, true); err != nil {
+		log.Printf("failed to save admin notification (chat_id: %d): %v", n.ChatID, err)
+	}
+	// Old message can't be deleted after 48 hours, then at least its buttons are removed
+	if _, err := b.sender.Request(tgbotapi.NewDeleteMessage(n.ChatID, n.MessageID)); err != nil {
+		edit := tgbotapi.NewEditMessageReplyMarkup(n.ChatID, n.MessageID, tgbotapi.InlineKeyboardMarkup{
+			InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+		})
+		if err := b.send(edit); err != nil {
+			log.Printf("failed to remove old admin notification (chat_id: %d): %v", n.ChatID, err)
+		}
+	}
+}
+
+// adminNotificationMessage creates admin notification about payment: proof photo or document
+// with notification text as caption if proof is attached, text message otherwise
+func adminNotificationMessage(chatID int64, payment *storage.Payment, text string, keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.Chattable {
+	switch {
+	case payment.ProofFileID == "":
 		msg := tgbotapi.NewMessage(chatID, text)
 		msg.ParseMode = "Markdown"
 		msg.ReplyMarkup = &keyboard
-		if err := b.send(msg); err != nil {
-			log.Printf("failed to notify admin (chat_id: %d): %v", chatID, err)
-		} else {
-			log.Printf("Notification sent to admin (chat_id: %d)", chatID)
+		return msg
+	case payment.ProofIsDocument:
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileID(payment.ProofFileID))
+		doc.Caption = text
+		doc.ParseMode = "Markdown"
+		doc.ReplyMarkup = &keyboard
+		return doc
+	default:
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(payment.ProofFileID))
+		photo.Caption = text
+		photo.ParseMode = "Markdown"
+		photo.ReplyMarkup = &keyboard
+		return photo
+	}
+}
+
+// editAdminNotification creates edit of admin notification text, caption for notifications showing proof
+func editAdminNotification(n *storage.AdminNotification, text string, keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.Chattable {
+	if n.WithProof {
+		edit := tgbotapi.NewEditMessageCaption(n.ChatID, n.MessageID, text)
+		edit.ParseMode = "Markdown"
+		edit.ReplyMarkup = &keyboard
+		return edit
+	}
+	edit := tgbotapi.NewEditMessageText(n.ChatID, n.MessageID, text)
+	edit.ParseMode = "Markdown"
+	edit.ReplyMarkup = &keyboard
+	return edit
+}
+
+// adminPaymentNotificationText builds admin notification text about payment
+func adminPaymentNotificationText(tr *Translator, lang string, payment *storage.Payment, username string) string {
+	promoLine := ""
+	if payment.PromoCode != "" {
+		promoLine = tr.Tf(lang, msgAdminPromoLine, payment.PromoCode)
+	}
+	return tr.Tf(lang, msgAdminNewPayment,
+		escapeMarkdown(username),
+		payment.DurationDays,
+		payment.DeviceCount,
+		escapeMarkdown(promoLine),
+		float64(payment.Amount)/100.0,
+		payment.ReferenceCode)
+}
+
+// adminPaymentKeyboard creates keyboard with approve/reject buttons
+func adminPaymentKeyboard(tr *Translator, lang string, paymentID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonApprove), fmt.Sprintf("admin_approve:%d", paymentID)),
+			tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonReject), fmt.Sprintf("admin_reject:%d", paymentID)),
+		),
+	)
+}
+
+func (b *Bot) handleAdminCallback(ctx context.Context, chatID int64, msgID int, user *storage.User, data string) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+	lang := userLanguage(user)
+
+	if data == "admin:pending" || strings.HasPrefix(data, "admin:pending:") {
+		page, _ := strconv.Atoi(strings.TrimPrefix(data, "admin:pending:"))
+		return b.handleAdminPendingPayments(ctx, chatID, msgID, user, page)
+	}
+	switch data {
+	case "admin:broadcast":
+		return b.askBroadcastText(chatID, msgID, lang)
+	case "admin:broadcast_confirm":
+		return b.handleBroadcastConfirm(ctx, chatID, msgID, user)
+	case "admin:broadcast_cancel":
+		b.popBroadcastDraft(chatID)
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgBroadcastCancelled))
+		res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+	if data == "admin:stats" {
+		return b.handleAdminStats(ctx, chatID, msgID, lang)
+	}
+	if data == "admin:ban" {
+		return b.askBanUsername(chatID, msgID, lang)
+	}
+	if strings.HasPrefix(data, "admin:ban:") {
+		// admin:ban:<userID>[:revoke]
+		parts := strings.Split(strings.TrimPrefix(data, "admin:ban:"), ":")
+		targetUserID, _ := strconv.ParseInt(parts[0], 10, 64)
+		revoke := len(parts) > 1 && parts[1] == "revoke"
+		return b.handleAdminBan(ctx, chatID, msgID, user, targetUserID, revoke)
+	}
+	if strings.HasPrefix(data, "admin:unban:") {
+		targetUserID, _ := strconv.ParseInt(strings.TrimPrefix(data, "admin:unban:"), 10, 64)
+		return b.handleAdminUnban(ctx, chatID, msgID, user, targetUserID)
+	}
+	if data == "admin:schema" {
+		return b.handleAdminSchema(ctx, chatID, msgID, lang)
+	}
+	if data == "admin:health" {
+		return b.handleAdminHealth(ctx, chatID, msgID, lang)
+	}
+	if data == "admin:reconcile" {
+		return b.handleAdminReconcile(ctx, chatID, msgID, user)
+	}
+	if data == "admin:audit" || strings.HasPrefix(data, "admin:audit:") {
+		page, _ := strconv.Atoi(strings.TrimPrefix(data, "admin:audit:"))
+		return b.handleAdminAudit(ctx, chatID, msgID, lang, page)
+	}
+	if strings.HasPrefix(data, "admin:payments:") {
+		// admin:payments:<status>[:<page>]
+		parts := strings.Split(strings.TrimPrefix(data, "admin:payments:"), ":")
+		page := 0
+		if len(parts) > 1 {
+			page, _ = strconv.Atoi(parts[1])
+		}
+		return b.handleAdminPaymentsByStatus(ctx, chatID, msgID, lang, storage.PaymentStatus(parts[0]), page)
+	}
+
+	return nil, nil
+}
+
+func (b *Bot) handleAdminPendingPayments(ctx context.Context, chatID int64, msgID int, user *storage.User, page int) (responses, error) {
+	lang := userLanguage(user)
+	payments, err := b.billing.GetPendingPayments(ctx)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+
+	if len(payments) == 0 {
+		res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgAdminNoPending))
+		res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+
+	// Show list of payments
+	listPage := paginate(len(payments), page, listPageSize)
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, p := range payments[listPage.start:listPage.end] {
+		paymentUser, err := b.repo.GetUserByID(ctx, p.UserID)
+		username := "Unknown"
+		if err == nil && paymentUser != nil {
+			username = paymentUser.Username
+		}
+
+		label := b.tr.Tf(lang, msgAdminPendingItem, username, p.DurationDays, p.DeviceCount, float64(p.Amount)/100.0)
+		button := tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("payment_detail:%d", p.ID))
+		buttons = append(buttons, []tgbotapi.InlineKeyboardButton{button})
+	}
+
+	if nav := listPage.navRow("admin:pending:"); nav != nil {
+		buttons = append(buttons, nav)
+	}
+	buttons = append(buttons, []tgbotapi.InlineKeyboardButton{menuButton(b.tr, lang)})
+
+	text := b.tr.Tf(lang, msgAdminPendingTitle, len(payments), listPage.label(b.tr, lang))
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+
+	return responses{res}, nil
+}
+
+// adminPaymentStatusFilters lists statuses admins can browse payments by, with catalog keys of button labels
+var adminPaymentStatusFilters = []struct {
+	status   storage.PaymentStatus
+	labelKey string
+}{
+	{storage.PaymentStatusPendingReview, msgFilterPendingReview},
+	{storage.PaymentStatusApproved, msgFilterApproved},
+	{storage.PaymentStatusRejected, msgFilterRejected},
+}
+
+// handleAdminPaymentsByStatus shows a page of payments in given status with reviewer info
+func (b *Bot) handleAdminPaymentsByStatus(ctx context.Context, chatID int64, msgID int, lang string, status storage.PaymentStatus, page int) (responses, error) {
+	known := false
+	for _, f := range adminPaymentStatusFilters {
+		if f.status == status {
+			known = true
+			break
 		}
 	}
+	if !known {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("unknown payment status filter: %s", status)
+	}
+
+	total, err := b.repo.CountPaymentsByStatus(ctx, status)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	listPage := paginate(total, page, listPageSize)
+
+	payments, err := b.repo.GetPaymentsByStatus(ctx, status, listPageSize, listPage.start)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(b.tr.Tf(lang, msgAdminPaymentsTitle, status, total, listPage.label(b.tr, lang)))
+
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, p := range payments {
+		username := "Unknown"
+		if paymentUser, err := b.repo.GetUserByID(ctx, p.UserID); err == nil && paymentUser != nil {
+			username = paymentUser.Username
+		}
+		sb.WriteString(b.tr.Tf(lang, msgAdminPaymentsItem,
+			p.ID, username, p.DurationDays, p.DeviceCount, float64(p.Amount)/100.0, p.CreatedAt.Format("02.01.2006")))
+		if review := paymentReviewText(b.tr, lang, p); review != "" {
+			sb.WriteString("\n   " + review)
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("#%d @%s", p.ID, username), fmt.Sprintf("payment_detail:%d", p.ID)),
+		))
+	}
+
+	if nav := listPage.navRow(fmt.Sprintf("admin:payments:%s:", status)); nav != nil {
+		buttons = append(buttons, nav)
+	}
+
+	var filters []tgbotapi.InlineKeyboardButton
+	for _, f := range adminPaymentStatusFilters {
+		if f.status != status {
+			filters = append(filters, tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, f.labelKey), fmt.Sprintf("admin:payments:%s", f.status)))
+		}
+	}
+	buttons = append(buttons, filters, []tgbotapi.InlineKeyboardButton{menuButton(b.tr, lang)})
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, sb.String())
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+	return responses{res}, nil
+}
+
+// auditActionLabels maps audit log actions to catalog keys of their descriptions for admins
+var auditActionLabels = map[storage.AuditAction]string{
+	storage.AuditActionFirstApproval:  msgAuditFirstApproval,
+	storage.AuditActionApprovePayment: msgAuditApprovePayment,
+	storage.AuditActionRejectPayment:  msgAuditRejectPayment,
+	storage.AuditActionRevokeDevice:   msgAuditRevokeDevice,
+	storage.AuditActionBanUser:        msgAuditBanUser,
+	storage.AuditActionUnbanUser:      msgAuditUnbanUser,
+	storage.AuditActionResendConfig:   msgAuditResendConfig,
+	storage.AuditActionCreateDevice:   msgAuditCreateDevice,
+	storage.AuditActionResyncDevice:   msgAuditResyncDevice,
+	storage.AuditActionBroadcast:      msgAuditBroadcast,
+}
+
+// handleAdminAudit shows a page of the audit log, newest entries first
+func (b *Bot) handleAdminAudit(ctx context.Context, chatID int64, msgID int, lang string, page int) (responses, error) {
+	total, err := b.repo.CountAuditEntries(ctx)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	listPage := paginate(total, page, listPageSize)
+
+	entries, err := b.repo.GetAuditEntries(ctx, listPageSize, listPage.start)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(b.tr.Tf(lang, msgAuditTitle, total, listPage.label(b.tr, lang)))
+	for _, entry := range entries {
+		action := string(entry.Action)
+		if key, ok := auditActionLabels[entry.Action]; ok {
+			action = b.tr.T(lang, key)
+		}
+		sb.WriteString(fmt.Sprintf("\n%s @%s: %s", entry.CreatedAt.Format("02.01.2006 15:04"), entry.Actor, action))
+		if entry.PaymentID != nil {
+			sb.WriteString(b.tr.Tf(lang, msgAuditPayment, *entry.PaymentID))
+		}
+		if entry.UserID != nil {
+			sb.WriteString(b.tr.Tf(lang, msgAuditUser, *entry.UserID))
+		}
+		if entry.Amount != nil {
+			sb.WriteString(b.tr.Tf(lang, msgAuditAmount, float64(*entry.Amount)/100.0))
+			if entry.ReceivedAmount != nil {
+				sb.WriteString(b.tr.Tf(lang, msgAuditReceivedAmount, float64(*entry.ReceivedAmount)/100.0))
+			}
+		}
+		if entry.Details != "" {
+			sb.WriteString("\n   " + entry.Details)
+		}
+	}
+
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	if nav := listPage.navRow("admin:audit:"); nav != nil {
+		buttons = append(buttons, nav)
+	}
+	buttons = append(buttons, []tgbotapi.InlineKeyboardButton{menuButton(b.tr, lang)})
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, sb.String())
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+	return responses{res}, nil
+}
+
+// handleAdminStats shows aggregate bot numbers
+func (b *Bot) handleAdminStats(ctx context.Context, chatID int64, msgID int, lang string) (responses, error) {
+	stats, err := b.collectStats(ctx)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+
+	text := b.tr.Tf(lang, msgAdminStats,
+		stats.Users, stats.ActiveSubscriptions, stats.PendingPayments,
+		float64(stats.Revenue)/100.0, stats.ActiveDevices, stats.BlockedUsers)
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+	return responses{res}, nil
+}
+
+// handleAdminSchema shows applied migrations and current schema of key tables
+func (b *Bot) handleAdminSchema(ctx context.Context, chatID int64, msgID int, lang string) (responses, error) {
+	report, err := b.repo.SchemaReport(ctx)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(b.tr.Tf(lang, msgSchemaTitle, len(report.Migrations)))
+	for _, m := range report.Migrations {
+		sb.WriteString(fmt.Sprintf("• %s (%s)\n", m.Name, m.AppliedAt.Format("02.01.2006 15:04")))
+	}
+	for _, table := range report.Tables {
+		if len(table.Columns) == 0 {
+			sb.WriteString(b.tr.Tf(lang, msgSchemaTableMissing, table.Name))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("\n%s: %s\n", table.Name, strings.Join(table.Columns, ", ")))
+	}
+	if len(report.MissingColumns) > 0 {
+		sb.WriteString(b.tr.T(lang, msgSchemaMissingColumns) + strings.Join(report.MissingColumns, ", "))
+	} else {
+		sb.WriteString(b.tr.T(lang, msgSchemaColumnsOK))
+	}
+
+	text := sb.String()
+	if runes := []rune(text); len(runes) > maxMessageLength {
+		text = string(runes[:maxMessageLength])
+	}
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+	return responses{res}, nil
+}
+
+// handleAdminHealth runs health checks of WireGuard servers and shows their results
+func (b *Bot) handleAdminHealth(ctx context.Context, chatID int64, msgID int, lang string) (responses, error) {
+	var sb strings.Builder
+	sb.WriteString(b.tr.T(lang, msgHealthTitle))
+	for _, health := range b.wireguard.HealthCheck(ctx) {
+		name := health.Server.Name
+		if name == "" {
+			name = b.tr.T(lang, msgHealthDefaultServer)
+		}
+		if health.Err != nil {
+			log.Printf("health check of server %q failed: %v", health.Server.ID, health.Err)
+			sb.WriteString(fmt.Sprintf("\n❌ %s: %s", name, health.Err.Error()))
+			continue
+		}
+		sb.WriteString(b.tr.Tf(lang, msgHealthOK, name))
+	}
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, sb.String())
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+	return responses{res}, nil
+}
+
+func (b *Bot) handlePaymentDetail(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+
+	pu, err := b.repo.GetPaymentWithUser(ctx, paymentID)
+	if err != nil || pu == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	}
+	payment, username := pu.Payment, pu.User.Username
+	lang := userLanguage(user)
+
+	text := b.tr.Tf(lang, msgPaymentDetail,
+		payment.ID, escapeMarkdown(username), payment.DurationDays, payment.DeviceCount,
+		float64(payment.Amount)/100.0, payment.ReferenceCode,
+		payment.PaymentComment,
+		payment.Status, payment.CreatedAt.Format("02.01.2006 15:04"))
+	if payment.FirstApprovedBy != "" {
+		text += b.tr.T(lang, msgPaymentFirstApproval) + escapeMarkdown(payment.FirstApprovedBy)
+		if payment.Status == storage.PaymentStatusPendingSecondApproval {
+			text += b.tr.T(lang, msgPaymentSecondApprovalNeeded)
+		}
+	}
+	if review := paymentReviewText(b.tr, lang, payment); review != "" {
+		text += "\n" + escapeMarkdown(review)
+	}
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ParseMode = "Markdown"
+
+	buttons := [][]tgbotapi.InlineKeyboardButton{
+		{
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonVerifyApprove), fmt.Sprintf("approve_verify:%d", payment.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonReject), fmt.Sprintf("reject:%d", payment.ID)),
+		},
+		{menuButton(b.tr, lang)},
+	}
+
+	if payment.ProofFileID != "" {
+		// Send proof photo or document
+		res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+		return responses{proofMessage(chatID, payment, b.tr.T(lang, msgPaymentProofCaption)), res}, nil
+	}
+
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+	return responses{res}, nil
+}
+
+func (b *Bot) handleApprovePaymentVerify(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+
+	payment, err := b.repo.GetPaymentByID(ctx, paymentID)
+	if err != nil || payment == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	}
+
+	b.setPendingInput(chatID, pendingInput{
+		action: inputApproveAmount,
+		id:     paymentID,
+		msgID:  msgID,
+	})
+	lang := userLanguage(user)
+
+	text := b.tr.Tf(lang, msgApproveVerifyAmount, payment.PaymentComment, float64(payment.Amount)/100.0)
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ParseMode = "Markdown"
+
+	buttons := [][]tgbotapi.InlineKeyboardButton{
+		{
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonApproveWithComment), fmt.Sprintf("approve:%d:%s", paymentID, payment.PaymentComment)),
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonReject), fmt.Sprintf("reject:%d", paymentID)),
+		},
+		{menuButton(b.tr, lang)},
+	}
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+
+	return responses{res}, nil
+}
+
+// handleApprovePayment approves payment after admin verified comment and, optionally, received amount.
+// Amount mismatch is shown to admin, who may approve the payment anyway
+func (b *Bot) handleApprovePayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64, verifiedComment string, verifiedAmount int, allowAmountMismatch bool) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+
+	// Get payment before approval to get user info
+	pu, err := b.repo.GetPaymentWithUser(ctx, paymentID)
+	if err != nil || pu == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	}
+
+	// If comment is not provided, use payment's comment (simplified flow)
+	if verifiedComment == "" {
+		verifiedComment = pu.Payment.PaymentComment
+	}
+	lang := userLanguage(user)
+
+	// Verify and approve payment
+	if err := b.billing.AdminApprovePayment(ctx, paymentID, user.Username, verifiedComment, verifiedAmount, allowAmountMismatch); err != nil {
+		if text, ok := paymentReviewErrorText(b.tr, lang, err); ok {
+			res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+			res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+			return responses{res}, nil
+		}
+		if errors.Is(err, billing.ErrPaymentMismatch) {
+			text := b.tr.Tf(lang, msgApproveAmountMismatch, float64(pu.Payment.Amount)/100.0, float64(verifiedAmount)/100.0)
+			res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+			res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
+				InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+					{tgbotapi.NewInlineKeyboardButtonData(b.tr.Tf(lang, msgButtonApproveAmount, float64(verifiedAmount)/100.0),
+						fmt.Sprintf("approve_amount:%d:%d", paymentID, verifiedAmount))},
+					{
+						tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonRetry), fmt.Sprintf("approve_verify:%d", paymentID)),
+						tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonReject), fmt.Sprintf("reject:%d", paymentID)),
+					},
+					{menuButton(b.tr, lang)},
+				},
+			}
+			return responses{res}, nil
+		}
+		// If verification fails, show error
+		errMsg := b.tr.Tf(lang, msgApproveFailed, err.Error())
+		res := tgbotapi.NewEditMessageText(chatID, msgID, errMsg)
+		res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
+			InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+				{tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonRetry), fmt.Sprintf("approve_verify:%d", paymentID))},
+				{menuButton(b.tr, lang)},
+			},
+		}
+		return responses{res}, nil
+	}
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgApproveDone))
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+
+	// Automatically create device and send config to user
+	b.provisionApprovedPayment(ctx, pu.Payment, pu.User)
+
+	return responses{res}, nil
+}
+
+// provisionApprovedPayment creates the first device for just approved payment and sends its config to the user.
+// Subscription is re-read after approval has been committed, so a paused subscription extended by this
+// payment is already active here. Device is only created if access check passes for a genuinely active
+// subscription, otherwise user is told to create devices with /newkeys
+func (b *Bot) provisionApprovedPayment(ctx context.Context, payment *storage.Payment, paymentUser *storage.User) {
+	lang := userLanguage(paymentUser)
+	fallbackText := b.tr.Tf(lang, msgApprovedNoDevice, payment.DurationDays)
+
+	subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, payment.UserID)
+	if err != nil || subscription == nil {
+		log.Printf("no subscription found after approval of payment %d: %v", payment.ID, err)
+		b.SendNotification(paymentUser.TelegramID, fallbackText)
+		return
+	}
+	if subscription.Status != storage.SubscriptionStatusActive && subscription.Status != storage.SubscriptionStatusExpiring {
+		log.Printf("subscription %d is %s after approval of payment %d, skipping device creation",
+			subscription.ID, subscription.Status, payment.ID)
+		b.SendNotification(paymentUser.TelegramID, fallbackText)
+		return
+	}
+	check, err := b.access.CanProvisionDevice(ctx, payment.UserID)
+	if err != nil {
+		log.Printf("failed to check access after approval of payment %d: %v", payment.ID, err)
+		b.SendNotification(paymentUser.TelegramID, fallbackText)
+		return
+	}
+	if !check.CanProvision {
+		log.Printf("device can't be created after approval of payment %d: %s", payment.ID, check.Reason)
+		b.SendNotification(paymentUser.TelegramID, fallbackText)
+		return
+	}
+
+	deviceName := b.nextDeviceName(ctx, subscription)
+
+	cfg, publicKey, assignedIP, err := b.wireguard.CreateConfigForNewKeys(ctx, payment.ServerID, payment.UserID, subscription.ID, deviceName)
+	if err != nil {
+		log.Printf("failed to create device: %v", err)
+		b.SendNotification(paymentUser.TelegramID, fallbackText)
+		return
+	}
+	content, err := io.ReadAll(cfg)
+	if err != nil {
+		log.Printf("failed to read config: %v", err)
+		b.SendNotification(paymentUser.TelegramID, fallbackText)
+		return
+	}
+	device, err := b.createdDevice(ctx, publicKey)
+	if err != nil {
+		log.Printf("failed to get created device: %v", err)
+		b.SendNotification(paymentUser.TelegramID, fallbackText)
+		return
+	}
+
+	notifyText := b.tr.Tf(lang, msgApprovedWithConfig,
+		payment.DurationDays, payment.DeviceCount, assignedIP)
+
+	b.sendConfig(paymentUser.TelegramID, notifyText, device, content)
+	log.Printf("VPN config sent to user %d", paymentUser.TelegramID)
+}
+
+// paymentReviewText returns who reviewed payment and when, empty if payment wasn't reviewed
+func paymentReviewText(tr *Translator, lang string, payment *storage.Payment) string {
+	if payment.ReviewedAt == nil && payment.ReviewedBy == nil {
+		return ""
+	}
+	reviewer := tr.T(lang, msgUnknown)
+	if payment.ReviewedBy != nil && *payment.ReviewedBy != "" {
+		reviewer = "@" + *payment.ReviewedBy
+	}
+	reviewedAt := tr.T(lang, msgUnknown)
+	if payment.ReviewedAt != nil {
+		reviewedAt = payment.ReviewedAt.Format("02.01.2006 15:04")
+	}
+	return tr.Tf(lang, msgPaymentReviewed, reviewer, reviewedAt)
+}
+
+// paymentReviewErrorText returns admin-facing text for payments that can't be reviewed anymore
+func paymentReviewErrorText(tr *Translator, lang string, err error) (string, bool) {
+	switch {
+	case errors.Is(err, billing.ErrPaymentCancelled):
+		return tr.T(lang, msgReviewCancelled), true
+	case errors.Is(err, billing.ErrPaymentAlreadyProcessed):
+		return tr.T(lang, msgReviewAlreadyProcessed), true
+	case errors.Is(err, billing.ErrSecondApprovalRequired):
+		return tr.T(lang, msgReviewSecondApproval), true
+	case errors.Is(err, billing.ErrSameApprover):
+		return tr.T(lang, msgReviewSameApprover), true
+	}
+	return "", false
+}
+
+// billingErrorText describes payment processing error for admins, amount mismatch is formatted
+// in admin's language, other errors are shown as is
+func billingErrorText(tr *Translator, lang string, err error) string {
+	var mismatch *billing.AmountMismatchError
+	if errors.As(err, &mismatch) {
+		return tr.Tf(lang, msgAmountMismatchError, float64(mismatch.Received)/100.0, float64(mismatch.Expected)/100.0)
+	}
+	return err.Error()
+}
+
+// handleAdminApprovePayment - simplified admin approval (from notification)
+func (b *Bot) handleAdminApprovePayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+
+	// Get payment
+	pu, err := b.repo.GetPaymentWithUser(ctx, paymentID)
+	if err != nil || pu == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	}
+
+	lang := userLanguage(user)
+
+	// Approve payment (use payment's comment as verified)
+	if err := b.billing.AdminApprovePayment(ctx, paymentID, user.Username, pu.Payment.PaymentComment, 0, false); err != nil {
+		if text, ok := paymentReviewErrorText(b.tr, lang, err); ok {
+			return responses{tgbotapi.NewEditMessageText(chatID, msgID, text)}, nil
+		}
+		errMsg := b.tr.Tf(lang, msgApproveFailedShort, err.Error())
+		res := tgbotapi.NewEditMessageText(chatID, msgID, errMsg)
+		return responses{res}, nil
+	}
+
+	// Update message
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgApproveDone))
+
+	// Send VPN config to user
+	b.provisionApprovedPayment(ctx, pu.Payment, pu.User)
+
+	return responses{res}, nil
+}
+
+// handleAdminRejectPayment - simplified admin rejection (from notification)
+func (b *Bot) handleAdminRejectPayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	return b.askRejectionReason(ctx, chatID, msgID, user, paymentID)
+}
+
+func (b *Bot) handleRejectPayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	return b.askRejectionReason(ctx, chatID, msgID, user, paymentID)
+}
+
+// askRejectionReason asks admin to type rejection reason for payment
+func (b *Bot) askRejectionReason(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+
+	payment, err := b.repo.GetPaymentByID(ctx, paymentID)
+	if err != nil || payment == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	}
+	lang := userLanguage(user)
+	if payment.Status != storage.PaymentStatusPendingReview && payment.Status != storage.PaymentStatusPendingSecondApproval {
+		text := b.tr.Tf(lang, msgRejectAlreadyProcessed, payment.Status)
+		res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+		res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+		return responses{res}, nil
+	}
+
+	b.setPendingInput(chatID, pendingInput{
+		action: inputRejectionReason,
+		id:     paymentID,
+		msgID:  msgID,
+	})
+
+	text := b.tr.Tf(lang, msgRejectReasonRequest, payment.ReferenceCode)
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ParseMode = "Markdown"
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonRejectNoReason), fmt.Sprintf("reject_noreason:%d", paymentID))},
+			{menuButton(b.tr, lang)},
+		},
+	}
+	return responses{res}, nil
+}
+
+// rejectPayment rejects payment with optional reason and notifies user
+func (b *Bot) rejectPayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64, reason string) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+	}
+
+	lang := userLanguage(user)
+	if err := b.billing.AdminRejectPayment(ctx, paymentID, user.Username, reason); err != nil {
+		if text, ok := paymentReviewErrorText(b.tr, lang, err); ok {
+			res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+			res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+			return responses{res}, nil
+		}
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to reject payment")
+	}
+
+	pu, err := b.repo.GetPaymentWithUser(ctx, paymentID)
+	if err != nil {
+		log.Printf("failed to get payment %d to notify user about rejection: %v", paymentID, err)
+	}
+
+	text := b.tr.T(lang, msgRejectDone)
+	if reason != "" {
+		text += b.tr.T(lang, msgRejectReason) + reason
+	}
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+
+	// Notify user
+	if pu != nil {
+		userLang := userLanguage(pu.User)
+		notifyText := b.tr.T(userLang, msgRejectUserNotice)
+		if reason != "" {
+			notifyText += b.tr.T(userLang, msgRejectReason) + reason
+		}
+		notifyText += b.tr.T(userLang, msgRejectUserSupport)
+		b.SendNotification(pu.User.TelegramID, notifyText)
+	}
+
+	return responses{res}, nil
+}
+
+func (b *Bot) handleConfigForNewKeys(chatID int64, user *storage.User, _ string) (responses, error) {
+	ctx := b.opsCtx
+	lang := userLanguage(user)
+
+	// Check access
+	result, err := b.access.CanProvisionDevice(ctx, user.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check access")
+	}
+
+	if !result.CanProvision {
+		msg := tgbotapi.NewMessage(chatID, b.accessDeniedText(lang, result))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	if res := b.checkProvisionCooldown(chatID, lang, user.ID); res != nil {
+		return res, nil
+	}
+
+	// Get active subscription
+	subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, user.ID)
+	if err != nil || subscription == nil {
+		return nil, errors.New("subscription not found")
+	}
+
+	// Generate device name
+	deviceName := b.nextDeviceName(ctx, subscription)
+
+	// Create config
+	cfg, _, _, err := b.wireguard.CreateConfigForNewKeys(ctx, b.userServerID(ctx, user.ID), user.ID, subscription.ID, deviceName)
+	if errors.Is(err, provisioning.ErrDeviceLimitReached) {
+		// Concurrent request took the last slot after access check
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgDeviceLimitReached))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	if errors.Is(err, provisioning.ErrDeviceExists) {
+		// Repeated request, the device was created by the previous one
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgDeviceExists))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	if err != nil {
+		return responses{b.errorMessage(chatID, 0, false)}, errors.Wrap(err, "failed to create new config")
+	}
+
+	content, err := io.ReadAll(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read new config")
+	}
+
+	text := emoji()
+	if remaining, err := b.access.RemainingDeviceSlots(ctx, subscription); err != nil {
+		log.Printf("failed to get remaining device slots for subscription %d: %v", subscription.ID, err)
+	} else {
+		text += b.tr.Tf(lang, msgDeviceSlotsLeft, remaining)
+	}
+
+	file := b.createFile(chatID, deviceName, content)
+	qr := b.createQR(chatID, content)
+	if qr == nil {
+		return responses{tgbotapi.NewMessage(chatID, text+b.tr.T(lang, msgQRUnavailable)), file}, nil
+	}
+	return responses{tgbotapi.NewMessage(chatID, text), qr, file}, nil
+}
+
+// userServerID returns server chosen with the user's latest approved payment, additional devices are
+// provisioned in the region user paid for. Empty ID selects the default server
+func (b *Bot) userServerID(ctx context.Context, userID int64) string {
+	payments, err := b.repo.GetPaymentsByUserIDAndStatus(ctx, userID, storage.PaymentStatusApproved)
+	if err != nil {
+		log.Printf("failed to get approved payments of user %d: %v", userID, err)
+		return ""
+	}
+	if len(payments) == 0 {
+		return ""
+	}
+	return payments[len(payments)-1].ServerID
+}
+
+// nextDeviceName returns the lowest device_N name not taken by active devices of subscription.
+// Names are unique among active devices, so requests racing for the same name get the same device
+func (b *Bot) nextDeviceName(ctx context.Context, subscription *storage.Subscription) string {
+	taken := make(map[string]bool)
+	devices, err := b.repo.GetActiveDevicesByUserID(ctx, subscription.UserID)
+	if err != nil {
+		log.Printf("failed to get active devices of user %d: %v", subscription.UserID, err)
+	}
+	for _, device := range devices {
+		if device.SubscriptionID == subscription.ID {
+			taken[device.DeviceName] = true
+		}
+	}
+	for n := 1; ; n++ {
+		if name := fmt.Sprintf("device_%d", n); !taken[name] {
+			return name
+		}
+	}
+}
+
+// createdDevice returns device just created with the public key, its config is sent by sendConfig
+func (b *Bot) createdDevice(ctx context.Context, publicKey string) (*storage.Device, error) {
+	device, err := b.repo.GetDeviceByPeerPublicKey(ctx, publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get created device")
+	}
+	if device == nil {
+		return nil, errors.Errorf("device with public key %s not found", publicKey)
+	}
+	return device, nil
+}
+
+// handleImportKey creates device for user's own WireGuard public key, so the private key
+// never leaves user's device. Key is taken from command argument or asked for
+func (b *Bot) handleImportKey(chatID int64, user *storage.User, arg string) (responses, error) {
+	ctx := b.opsCtx
+	lang := userLanguage(user)
+	if strings.TrimSpace(arg) != "" {
+		return b.importPublicKey(ctx, chatID, lang, user.ID, arg)
+	}
+
+	// Check access before asking for the key
+	result, err := b.access.CanProvisionDevice(ctx, user.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check access")
+	}
+	if !result.CanProvision {
+		msg := tgbotapi.NewMessage(chatID, b.accessDeniedText(lang, result))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+
+	b.setPendingInput(chatID, pendingInput{action: inputPublicKey})
+	return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgImportKeyPrompt))}, nil
+}
+
+// checkProvisionCooldown returns "please wait" message if user provisioned a device too recently, nil otherwise
+func (b *Bot) checkProvisionCooldown(chatID int64, lang string, userID int64) responses {
+	allowed, wait := b.cooldown.take(userID, time.Now())
+	if allowed {
+		return nil
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	msg := tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgProvisionCooldown, seconds))
+	msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+	return responses{msg}
+}
+
+// importPublicKey validates user supplied public key and creates device for it
+func (b *Bot) importPublicKey(ctx context.Context, chatID int64, lang string, userID int64, text string) (responses, error) {
+	key, err := wgtypes.ParseKey(strings.TrimSpace(text))
+	if err != nil {
+		b.setPendingInput(chatID, pendingInput{action: inputPublicKey})
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgImportKeyInvalid))}, nil
+	}
+
+	result, err := b.access.CanProvisionDevice(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check access")
+	}
+	if !result.CanProvision {
+		msg := tgbotapi.NewMessage(chatID, b.accessDeniedText(lang, result))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+
+	existing, err := b.repo.GetDeviceByPeerPublicKey(ctx, key.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check existing device")
+	}
+	if existing != nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgImportKeyExists))}, nil
+	}
+	if res := b.checkProvisionCooldown(chatID, lang, userID); res != nil {
+		return res, nil
+	}
+
+	subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, userID)
+	if err != nil || subscription == nil {
+		return nil, errors.New("subscription not found")
+	}
+
+	deviceName := b.nextDeviceName(ctx, subscription)
+
+	cfg, _, err := b.wireguard.CreateConfigForPublicKey(ctx, b.userServerID(ctx, userID), key.String(), userID, subscription.ID, deviceName)
+	if errors.Is(err, provisioning.ErrDeviceLimitReached) {
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgDeviceLimitReached))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	if errors.Is(err, provisioning.ErrDeviceExists) {
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgDeviceExists))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	if err != nil {
+		return responses{b.errorMessage(chatID, 0, false)}, errors.Wrap(err, "failed to create config for public key")
+	}
+	content, err := io.ReadAll(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read new config")
+	}
+	log.Printf("Device %s with imported public key created for user %d", deviceName, userID)
+
+	// No QR code: config without private key can't be imported as is
+	msg := tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgImportKeyDone, deviceName))
+	return responses{msg, b.createFile(chatID, deviceName, content)}, nil
+}
+
+func (b *Bot) handleSubscriptionStatus(chatID int64, user *storage.User, _ string) (responses, error) {
+	ctx := b.opsCtx
+
+	subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get subscription")
+	}
+
+	lang := userLanguage(user)
+	if subscription == nil {
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgSubNone))
+		msg.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
+			InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+				{tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonSubscribe), "payment")},
+				{menuButton(b.tr, lang)},
+			},
+		}
+		return responses{msg}, nil
+	}
+
+	deviceCount, err := b.repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count devices")
+	}
+
+	text := b.tr.Tf(lang, msgSubStatus,
+		b.subscriptionStatusText(lang, subscription.Status), subscription.EndsAt.Format("02.01.2006"))
+	if subscription.GracePeriodEndsAt != nil {
+		text += b.tr.Tf(lang, msgSubGrace, subscription.GracePeriodEndsAt.Format("02.01.2006"))
+	}
+	remaining := subscription.DeviceLimit - deviceCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	text += b.tr.Tf(lang, msgSubDevices, deviceCount, subscription.DeviceLimit, remaining)
+
+	devices, err := b.repo.GetActiveDevicesByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get devices")
+	}
+	if len(devices) > 0 {
+		text += b.devicesStatusText(ctx, lang, devices)
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	if len(devices) == 0 {
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	// Buttons to get config of an existing device again
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, device := range devices {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.Tf(lang, msgButtonDeviceConfig, device.DeviceName), fmt.Sprintf("resend_config:%d", device.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return responses{msg}, nil
+}
+
+// handleResendConfig sends config of user's active device again. Config is rebuilt from
+// stored encrypted private key, or without private key for devices with user supplied public key
+func (b *Bot) handleResendConfig(ctx context.Context, chatID int64, msgID int, user *storage.User, deviceID int64) (responses, error) {
+	device, err := b.repo.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	if device == nil || device.UserID != user.ID || device.RevokedAt != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("active device %d not found for user %d", deviceID, user.ID)
+	}
+
+	lang := userLanguage(user)
+	cfg, importedKey, err := b.wireguard.RecreateConfig(ctx, device)
+	if errors.Is(err, provisioning.ErrPrivateKeyNotStored) {
+		msg := tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgResendNoKey, device.DeviceName))
+		msg.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+		return responses{msg}, nil
+	}
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrapf(err, "failed to recreate config of device %d", device.ID)
+	}
+	content, err := io.ReadAll(cfg)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to read config")
+	}
+	log.Printf("Config of device %d resent to user %s", device.ID, user.Username)
+
+	text := b.tr.Tf(lang, msgResendConfig, device.DeviceName)
+	file := b.createFile(chatID, device.DeviceName, content)
+	if importedKey {
+		// QR code of config without private key can't be imported as is, send file only
+		text += b.tr.T(lang, msgResendImportedKey)
+		return responses{tgbotapi.NewMessage(chatID, text), file}, nil
+	}
+	qr := b.createQR(chatID, content)
+	if qr == nil {
+		return responses{tgbotapi.NewMessage(chatID, text+b.tr.T(lang, msgQRUnavailable)), file}, nil
+	}
+	return responses{tgbotapi.NewMessage(chatID, text), qr, file}, nil
+}
+
+// handleLanguage asks user to choose bot language
+func (b *Bot) handleLanguage(chatID int64, user *storage.User, _ string) (responses, error) {
+	msg := tgbotapi.NewMessage(chatID, b.tr.T(userLanguage(user), msgLanguagePrompt))
+	msg.ReplyMarkup = languageKeyboard
+	return responses{msg}, nil
+}
+
+// handleLanguageSelection saves chosen bot language and shows main menu in it
+func (b *Bot) handleLanguageSelection(ctx context.Context, chatID int64, msgID int, user *storage.User, lang string) (responses, error) {
+	if !isSupportedLanguage(lang) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("unsupported language: %s", lang)
+	}
+	if err := b.repo.SetUserLanguage(ctx, user.ID, lang); err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	log.Printf("User %s switched language to %s", user.Username, lang)
+
+	res := tgbotapi.NewEditMessageText(chatID, msgID, b.tr.T(lang, msgLanguageChanged))
+	res.ReplyMarkup = localizedMainMenuKeyboard(b.tr, lang)
+	return responses{res}, nil
 }
 
-func (b *Bot) handleAdminCallback(ctx context.Context, chatID int64, msgID int, user *storage.User, data string) (responses, error) {
-	if !b.isAdmin(user.Username) {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+// handleReassignDevices moves user's active devices onto the current subscription (admin only)
+// Usage: /reassign <username>
+func (b *Bot) handleReassignDevices(chatID int64, user *storage.User, arg string) (responses, error) {
+	if !b.isAdmin(user) {
+		return b.notAdminMsg(chatID), nil
 	}
 
-	if data == "admin:pending" {
-		return b.handleAdminPendingPayments(ctx, chatID, msgID, user)
+	lang := userLanguage(user)
+	targetUsername := strings.TrimPrefix(strings.TrimSpace(arg), "@")
+	if targetUsername == "" {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgReassignUsage))}, nil
 	}
 
-	return nil, nil
-}
+	ctx := b.opsCtx
+	targetUser, err := b.repo.GetUserByUsername(ctx, targetUsername)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user")
+	}
+	if targetUser == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgAdminUserNotFound, targetUsername))}, nil
+	}
 
-func (b *Bot) handleAdminPendingPayments(ctx context.Context, chatID int64, msgID int, user *storage.User) (responses, error) {
-	payments, err := b.billing.GetPendingPayments(ctx)
+	subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, targetUser.ID)
 	if err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, err
+		return nil, errors.Wrap(err, "failed to get subscription")
+	}
+	if subscription == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgAdminNoSubscription, targetUsername))}, nil
 	}
 
-	if len(payments) == 0 {
-		text := "✅ Нет ожидающих оплат."
-		res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-		res.ReplyMarkup = &adminKeyboard
-		return responses{res}, nil
+	devices, err := b.repo.GetActiveDevicesByUserID(ctx, targetUser.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get devices")
 	}
 
-	// Show list of payments
-	var buttons [][]tgbotapi.InlineKeyboardButton
-	for _, p := range payments {
-		paymentUser, err := b.repo.GetUserByID(ctx, p.UserID)
-		username := "Unknown"
-		if err == nil && paymentUser != nil {
-			username = paymentUser.Username
+	moved, failed := 0, 0
+	var renamed []string
+	for _, device := range devices {
+		if device.SubscriptionID == subscription.ID {
+			continue
+		}
+		name, err := b.repo.ReassignDeviceSubscription(ctx, device.ID, subscription.ID)
+		if err != nil {
+			log.Printf("failed to reassign device %d to subscription %d: %v", device.ID, subscription.ID, err)
+			failed++
+			continue
+		}
+		log.Printf("Device %d reassigned from subscription %d to %d by %s", device.ID, device.SubscriptionID, subscription.ID, user.Username)
+		moved++
+		if name != device.DeviceName {
+			renamed = append(renamed, b.tr.Tf(lang, msgReassignRenamedItem, device.DeviceName, name))
 		}
-
-		label := fmt.Sprintf("💰 %s - %d дней, %d устр. - %.2f руб.", username, p.DurationDays, p.DeviceCount, float64(p.Amount)/100.0)
-		button := tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("payment_detail:%d", p.ID))
-		buttons = append(buttons, []tgbotapi.InlineKeyboardButton{button})
 	}
 
-	buttons = append(buttons, []tgbotapi.InlineKeyboardButton{goToMenuButton})
-
-	text := fmt.Sprintf("📋 Ожидающие оплаты (%d):", len(payments))
-	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
+	deviceCount, err := b.repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count devices")
+	}
 
-	return responses{res}, nil
+	text := b.tr.Tf(lang, msgReassignDone,
+		moved, subscription.ID, subscription.EndsAt.Format("02.01.2006"), deviceCount, subscription.DeviceLimit)
+	if len(renamed) > 0 {
+		text += b.tr.Tf(lang, msgReassignRenamed, strings.Join(renamed, ""))
+	}
+	if failed > 0 {
+		text += b.tr.Tf(lang, msgReassignFailed, failed)
+	}
+	if deviceCount > subscription.DeviceLimit {
+		text += b.tr.T(lang, msgReassignOverLimit)
+	}
+	return responses{tgbotapi.NewMessage(chatID, text)}, nil
 }
 
-func (b *Bot) handlePaymentDetail(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
-	if !b.isAdmin(user.Username) {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+// handleUserDevices lists user's active devices with peer re-sync buttons (admin only)
+// Usage: /userdevices <username>
+func (b *Bot) handleUserDevices(chatID int64, user *storage.User, arg string) (responses, error) {
+	if !b.isAdmin(user) {
+		return b.notAdminMsg(chatID), nil
 	}
 
-	payment, err := b.repo.GetPaymentByID(ctx, paymentID)
-	if err != nil || payment == nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	lang := userLanguage(user)
+	targetUsername := strings.TrimPrefix(strings.TrimSpace(arg), "@")
+	if targetUsername == "" {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.T(lang, msgUserDevicesUsage))}, nil
 	}
 
-	paymentUser, _ := b.repo.GetUserByID(ctx, payment.UserID)
-	username := "Unknown"
-	if paymentUser != nil {
-		username = paymentUser.Username
+	ctx := b.opsCtx
+	targetUser, err := b.repo.GetUserByUsername(ctx, targetUsername)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user")
+	}
+	if targetUser == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgAdminUserNotFound, targetUsername))}, nil
 	}
 
-	text := fmt.Sprintf("📋 Детали оплаты:\n\n"+
-		"ID: %d\n"+
-		"Пользователь: @%s\n"+
-		"Срок: %d дней\n"+
-		"Устройств: %d\n"+
-		"Сумма: %.2f руб.\n"+
-		"Код заявки: `%s`\n\n"+
-		"⚠️ КОММЕНТАРИЙ К ПЕРЕВОДУ:\n"+
-		"`%s`\n\n"+
-		"При одобрении проверьте:\n"+
-		"✅ Сумма платежа\n"+
-		"✅ Комментарий к переводу\n"+
-		"✅ Скриншот подтверждения\n\n"+
-		"Статус: %s\n"+
-		"Создано: %s",
-		payment.ID, username, payment.DurationDays, payment.DeviceCount,
-		float64(payment.Amount)/100.0, payment.ReferenceCode,
-		payment.PaymentComment,
-		payment.Status, payment.CreatedAt.Format("02.01.2006 15:04"))
+	text, keyboard, err := b.userDevicesPage(ctx, lang, targetUser, 0)
+	if err != nil {
+		return nil, err
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = keyboard
+	}
+	return responses{msg}, nil
+}
 
+// handleAdminUserDevicesPage switches page of user's device list
+func (b *Bot) handleAdminUserDevicesPage(ctx context.Context, chatID int64, msgID int, lang string, targetUserID int64, page int) (responses, error) {
+	targetUser, err := b.repo.GetUserByID(ctx, targetUserID)
+	if err != nil || targetUser == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Errorf("user %d not found", targetUserID)
+	}
+
+	text, keyboard, err := b.userDevicesPage(ctx, lang, targetUser, page)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
 	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ParseMode = "Markdown"
+	res.ReplyMarkup = keyboard
+	return responses{res}, nil
+}
 
-	buttons := [][]tgbotapi.InlineKeyboardButton{
-		{
-			tgbotapi.NewInlineKeyboardButtonData("✅ Проверить и одобрить", fmt.Sprintf("approve_verify:%d", payment.ID)),
-			tgbotapi.NewInlineKeyboardButtonData("❌ Отклонить", fmt.Sprintf("reject:%d", payment.ID)),
-		},
-		{goToMenuButton},
+// userDevicesPage renders a page of user's devices, including revoked ones, with admin actions
+func (b *Bot) userDevicesPage(ctx context.Context, lang string, targetUser *storage.User, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	devices, err := b.repo.GetDevicesByUserID(ctx, targetUser.ID)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get devices")
+	}
+	if len(devices) == 0 {
+		return b.tr.Tf(lang, msgUserDevicesNone, targetUser.Username), nil, nil
 	}
 
-	if payment.ProofFileID != "" {
-		// Send proof photo
-		photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(payment.ProofFileID))
-		photoMsg.Caption = "Подтверждение оплаты"
-		res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
-		return responses{photoMsg, res}, nil
+	listPage := paginate(len(devices), page, listPageSize)
+	var sb strings.Builder
+	sb.WriteString(b.tr.Tf(lang, msgUserDevicesTitle, targetUser.Username, len(devices), listPage.label(b.tr, lang)))
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, device := range devices[listPage.start:listPage.end] {
+		sb.WriteString(b.tr.Tf(lang, msgUserDevicesItem, device.ID, device.DeviceName, device.AssignedIP, device.CreatedAt.Format("02.01.2006")))
+		if device.RevokedAt != nil {
+			sb.WriteString(b.tr.Tf(lang, msgUserDevicesRevoked, device.RevokedAt.Format("02.01.2006")))
+			continue
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🔄 #%d", device.ID), fmt.Sprintf("admin_resync:%d", device.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.Tf(lang, msgButtonRevokeDevice, device.ID), fmt.Sprintf("admin_revoke:%d", device.ID)),
+		))
 	}
+	if nav := listPage.navRow(fmt.Sprintf("admin_devices:%d:", targetUser.ID)); nav != nil {
+		buttons = append(buttons, nav)
+	}
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonAdminResendConfig), fmt.Sprintf("admin_resend_config:%d", targetUser.ID)),
+	))
 
-	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
-	return responses{res}, nil
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	return sb.String(), &keyboard, nil
 }
 
-func (b *Bot) handleApprovePaymentVerify(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
-	if !b.isAdmin(user.Username) {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+// handleAdminResyncDevice re-adds device peer to the WireGuard interface, keeping its key and IP
+func (b *Bot) handleAdminResyncDevice(ctx context.Context, chatID int64, msgID int, user *storage.User, deviceID int64) (responses, error) {
+	device, err := b.repo.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	lang := userLanguage(user)
+	if device == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgDeviceNotFound, deviceID))}, nil
+	}
+	if device.RevokedAt != nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgResyncRevoked, deviceID))}, nil
 	}
 
-	payment, err := b.repo.GetPaymentByID(ctx, paymentID)
-	if err != nil || payment == nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	if err := b.wireguard.ResyncDevice(ctx, device); err != nil {
+		log.Printf("failed to resync device %d: %v", device.ID, err)
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgResyncFailed, device.ID, err.Error()))}, nil
 	}
+	log.Printf("Device %d peer re-synced by %s", device.ID, user.Username)
+	b.recordAdminAudit(ctx, user.Username, storage.AuditActionResyncDevice, &device.UserID,
+		fmt.Sprintf("device #%d %s (%s)", device.ID, device.DeviceName, device.AssignedIP))
 
-	text := fmt.Sprintf("✅ Проверьте платеж и введите комментарий к переводу:\n\n"+
-		"Ожидаемый комментарий: `%s`\n\n"+
-		"Введите комментарий, который указан в скриншоте платежа.\n"+
-		"Если комментарий совпадает, платеж будет одобрен.\n\n"+
-		"Отправьте комментарий в следующем сообщении.",
-		payment.PaymentComment)
+	return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgResyncDone, device.ID, device.DeviceName, device.AssignedIP))}, nil
+}
 
-	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ParseMode = "Markdown"
+// handleAdminRevokeDeviceConfirm asks admin to confirm device revocation
+func (b *Bot) handleAdminRevokeDeviceConfirm(ctx context.Context, chatID int64, msgID int, lang string, deviceID int64) (responses, error) {
+	device, err := b.repo.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	if device == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgDeviceNotFound, deviceID))}, nil
+	}
+	if device.RevokedAt != nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgDeviceAlreadyRevoked, deviceID))}, nil
+	}
 
-	buttons := [][]tgbotapi.InlineKeyboardButton{
-		{
-			tgbotapi.NewInlineKeyboardButtonData("✅ Одобрить с этим комментарием", fmt.Sprintf("approve:%d:%s", paymentID, payment.PaymentComment)),
-			tgbotapi.NewInlineKeyboardButtonData("❌ Отклонить", fmt.Sprintf("reject:%d", paymentID)),
+	text := b.tr.Tf(lang, msgRevokeConfirm, device.ID, device.DeviceName, device.AssignedIP)
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+			{
+				tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonRevoke), fmt.Sprintf("admin_revoke_confirm:%d", device.ID)),
+				tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonBack), fmt.Sprintf("admin_devices:%d:0", device.UserID)),
+			},
 		},
-		{goToMenuButton},
 	}
-	res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: buttons}
-
 	return responses{res}, nil
 }
 
-func (b *Bot) handleApprovePayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64, verifiedComment string) (responses, error) {
-	if !b.isAdmin(user.Username) {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+// handleAdminRevokeDevice removes device peer from WireGuard and marks device revoked
+func (b *Bot) handleAdminRevokeDevice(ctx context.Context, chatID int64, msgID int, user *storage.User, deviceID int64) (responses, error) {
+	if !b.isAdmin(user) {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("not an admin")
 	}
 
-	// If comment is not provided, use payment's comment (simplified flow)
-	if verifiedComment == "" {
-		payment, err := b.repo.GetPaymentByID(ctx, paymentID)
-		if err != nil || payment == nil {
-			return responses{errorMessage(chatID, msgID, true)}, errors.New("payment not found")
-		}
-		verifiedComment = payment.PaymentComment
+	device, err := b.repo.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
 	}
-
-	// Get payment before approval to get user info
-	payment, err := b.repo.GetPaymentByID(ctx, paymentID)
-	if err != nil || payment == nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	lang := userLanguage(user)
+	if device == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgDeviceNotFound, deviceID))}, nil
+	}
+	if device.RevokedAt != nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgDeviceAlreadyRevoked, deviceID))}, nil
 	}
 
-	// Verify and approve payment
-	if err := b.billing.AdminApprovePayment(ctx, paymentID, user.Username, verifiedComment); err != nil {
-		// If verification fails, show error
-		errMsg := fmt.Sprintf("❌ Ошибка при одобрении:\n\n%s\n\nПроверьте комментарий к переводу.", err.Error())
-		res := tgbotapi.NewEditMessageText(chatID, msgID, errMsg)
-		res.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{
-			InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
-				{tgbotapi.NewInlineKeyboardButtonData("🔄 Попробовать снова", fmt.Sprintf("approve_verify:%d", paymentID))},
-				{goToMenuButton},
-			},
-		}
-		return responses{res}, nil
+	if err := b.revokeDevice(ctx, device, user.Username, ""); err != nil {
+		log.Printf("failed to revoke device %d: %v", device.ID, err)
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgRevokeFailed, device.ID, err.Error()))}, nil
 	}
 
-	// Get updated payment and user after approval
-	paymentUser, _ := b.repo.GetUserByID(ctx, payment.UserID)
-	
-	text := fmt.Sprintf("✅ Платеж одобрен!\n\nПодписка активирована.")
-	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ReplyMarkup = &adminKeyboard
+	resps, err := b.handleAdminUserDevicesPage(ctx, chatID, msgID, lang, device.UserID, 0)
+	return append(resps, tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgRevokeDone, device.ID, device.DeviceName, device.AssignedIP))), err
+}
 
-	// Automatically create device and send config to user
-	if paymentUser != nil {
-		// Get active subscription (created by AdminApprovePayment)
-		subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, payment.UserID)
-		if err == nil && subscription != nil {
-			// Create device automatically
-			deviceCount, _ := b.repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
-			deviceName := fmt.Sprintf("device_%d", deviceCount+1)
-			
-			// Create WireGuard config
-			cfg, _, assignedIP, err := b.wireguard.CreateConfigForNewKeys(ctx, payment.UserID, subscription.ID, deviceName)
-			if err == nil {
-				content, err := io.ReadAll(cfg)
-				if err == nil {
-					// Send notification with config
-					notifyText := fmt.Sprintf("✅ Ваш платеж одобрен!\n\n"+
-						"Подписка активирована на %d дней.\n"+
-						"Устройств: %d\n\n"+
-						"📱 Ваш WireGuard конфиг готов!\n"+
-						"IP адрес: %s\n\n"+
-						"Используйте QR-код для подключения на телефоне или скачайте .conf файл для ПК.",
-						payment.DurationDays, payment.DeviceCount, assignedIP)
-					
-					msg := tgbotapi.NewMessage(paymentUser.TelegramID, notifyText)
-					file := createFile(paymentUser.TelegramID, content)
-					qr := createQR(paymentUser.TelegramID, content)
-					
-					// Send messages
-					b.send(msg)
-					if qr != nil {
-						b.send(qr)
-					}
-					b.send(file)
-				} else {
-					log.Printf("failed to read config: %v", err)
-					// Fallback notification
-					notifyText := fmt.Sprintf("✅ Ваш платеж одобрен!\n\n"+
-						"Подписка активирована на %d дней.\n"+
-						"Вы можете создать устройства через /newkeys",
-						payment.DurationDays)
-					b.SendNotification(paymentUser.TelegramID, notifyText)
-				}
-			} else {
-				log.Printf("failed to create device: %v", err)
-				// Fallback notification
-				notifyText := fmt.Sprintf("✅ Ваш платеж одобрен!\n\n"+
-					"Подписка активирована на %d дней.\n"+
-					"Вы можете создать устройства через /newkeys",
-					payment.DurationDays)
-				b.SendNotification(paymentUser.TelegramID, notifyText)
-			}
-		} else {
-			// Fallback notification if subscription not found
-			notifyText := fmt.Sprintf("✅ Ваш платеж одобрен!\n\n"+
-				"Подписка активирована на %d дней.\n"+
-				"Вы можете создать устройства через /newkeys",
-				payment.DurationDays)
-			b.SendNotification(paymentUser.TelegramID, notifyText)
-		}
+// revokeDevice removes device peer from WireGuard, marks device revoked and records it in the audit log.
+// Peer is removed first: if it fails, device stays active and revocation can be retried
+func (b *Bot) revokeDevice(ctx context.Context, device *storage.Device, actor, reason string) error {
+	if err := b.wireguard.RevokeDevice(ctx, device); err != nil {
+		return errors.Wrap(err, "failed to remove peer from WireGuard")
+	}
+	if err := b.repo.RevokeDevice(ctx, device.ID); err != nil {
+		return errors.Wrap(err, "failed to revoke device")
 	}
+	log.Printf("Device %d of user %d revoked by %s", device.ID, device.UserID, actor)
 
-	return responses{res}, nil
+	details := fmt.Sprintf("device #%d %s (%s)", device.ID, device.DeviceName, device.AssignedIP)
+	if reason != "" {
+		details += ": " + reason
+	}
+	entry := &storage.AuditEntry{
+		Actor:   actor,
+		Action:  storage.AuditActionRevokeDevice,
+		UserID:  &device.UserID,
+		Details: details,
+	}
+	if err := b.repo.RecordAudit(ctx, entry); err != nil {
+		log.Printf("failed to record revocation of device %d in audit log: %v", device.ID, err)
+	}
+	return nil
 }
 
-// handleAdminApprovePayment - simplified admin approval (from notification)
-func (b *Bot) handleAdminApprovePayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
-	if !b.isAdmin(user.Username) {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+// recordAdminAudit records admin action in the audit log. The action has already been done,
+// so failure to record it is only logged
+func (b *Bot) recordAdminAudit(ctx context.Context, actor string, action storage.AuditAction, userID *int64, details string) {
+	entry := &storage.AuditEntry{
+		Actor:   actor,
+		Action:  action,
+		UserID:  userID,
+		Details: details,
 	}
-
-	// Get payment
-	payment, err := b.repo.GetPaymentByID(ctx, paymentID)
-	if err != nil || payment == nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("payment not found")
+	if err := b.repo.RecordAudit(ctx, entry); err != nil {
+		log.Printf("failed to record %s in audit log: %v", action, err)
 	}
+}
 
-	// Approve payment (use payment's comment as verified)
-	if err := b.billing.AdminApprovePayment(ctx, paymentID, user.Username, payment.PaymentComment); err != nil {
-		errMsg := fmt.Sprintf("❌ Ошибка при одобрении:\n\n%s", err.Error())
-		res := tgbotapi.NewEditMessageText(chatID, msgID, errMsg)
-		return responses{res}, nil
+// handleAdminResendConfig handles support request to re-send user's config.
+// Config of user's latest device is rebuilt and sent to the user. If the device has no stored
+// private key (key encryption isn't configured or device was created before it), admin is offered
+// to create a fresh device for the user instead
+func (b *Bot) handleAdminResendConfig(ctx context.Context, chatID int64, msgID int, user *storage.User, targetUserID int64) (responses, error) {
+	targetUser, err := b.repo.GetUserByID(ctx, targetUserID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	lang := userLanguage(user)
+	if targetUser == nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgAdminUserIDNotFound, targetUserID))}, nil
 	}
 
-	// Update message
-	text := "✅ Платеж одобрен!\n\nПодписка активирована."
-	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	devices, err := b.repo.GetActiveDevicesByUserID(ctx, targetUser.ID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	var latest *storage.Device
+	for _, device := range devices {
+		if latest == nil || device.CreatedAt.After(latest.CreatedAt) {
+			latest = device
+		}
+	}
+	log.Printf("Config resend for user %d requested by %s", targetUser.ID, user.Username)
 
-	// Get user and send VPN config
-	paymentUser, _ := b.repo.GetUserByID(ctx, payment.UserID)
-	if paymentUser != nil {
-		// Get active subscription
-		subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, payment.UserID)
-		if err == nil && subscription != nil {
-			// Create device automatically
-			deviceCount, _ := b.repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
-			deviceName := fmt.Sprintf("device_%d", deviceCount+1)
-			
-			// Create WireGuard config
-			cfg, _, assignedIP, err := b.wireguard.CreateConfigForNewKeys(ctx, payment.UserID, subscription.ID, deviceName)
-			if err == nil {
-				content, err := io.ReadAll(cfg)
-				if err == nil {
-					// Send notification with config
-					notifyText := fmt.Sprintf("✅ Ваш платеж одобрен!\n\n"+
-						"Подписка активирована на %d дней.\n"+
-						"Устройств: %d\n\n"+
-						"📱 Ваш WireGuard конфиг готов!\n"+
-						"IP адрес: %s\n\n"+
-						"Используйте QR-код для подключения на телефоне или скачайте .conf файл для ПК.",
-						payment.DurationDays, payment.DeviceCount, assignedIP)
-					
-					msg := tgbotapi.NewMessage(paymentUser.TelegramID, notifyText)
-					file := createFile(paymentUser.TelegramID, content)
-					qr := createQR(paymentUser.TelegramID, content)
-					
-					// Send messages
-					b.send(msg)
-					if qr != nil {
-						b.send(qr)
-					}
-					b.send(file)
-					log.Printf("VPN config sent to user %d", paymentUser.TelegramID)
-				} else {
-					log.Printf("failed to read config: %v", err)
-				}
-			} else {
-				log.Printf("failed to create device: %v", err)
+	if latest != nil {
+		cfg, _, err := b.wireguard.RecreateConfig(ctx, latest)
+		if err == nil {
+			content, err := io.ReadAll(cfg)
+			if err != nil {
+				return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to read config")
 			}
+			b.sendConfig(targetUser.TelegramID, b.tr.Tf(userLanguage(targetUser), msgSupportConfigResent, latest.DeviceName), latest, content)
+			log.Printf("Config of device %d resent to user %d by %s", latest.ID, targetUser.ID, user.Username)
+			b.recordAdminAudit(ctx, user.Username, storage.AuditActionResendConfig, &targetUser.ID,
+				fmt.Sprintf("device #%d %s (%s)", latest.ID, latest.DeviceName, latest.AssignedIP))
+			return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgSupportResendDone,
+				latest.ID, latest.DeviceName, targetUser.Username))}, nil
+		}
+		if !errors.Is(err, provisioning.ErrPrivateKeyNotStored) {
+			return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrapf(err, "failed to recreate config of device %d", latest.ID)
 		}
 	}
 
-	return responses{res}, nil
+	text := b.tr.Tf(lang, msgSupportNoDevices, targetUser.Username)
+	if latest != nil {
+		text = b.tr.Tf(lang, msgSupportLatestDevice,
+			targetUser.Username, latest.ID, latest.DeviceName, latest.AssignedIP)
+	}
+	text += b.tr.T(lang, msgSupportOfferNewDevice)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonCreateDevice), fmt.Sprintf("admin_new_device:%d", targetUser.ID)),
+		),
+	)
+	return responses{msg}, nil
 }
 
-// handleAdminRejectPayment - simplified admin rejection (from notification)
-func (b *Bot) handleAdminRejectPayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
-	if !b.isAdmin(user.Username) {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+// handleAdminNewDevice creates a fresh device for user and sends its config to the user
+func (b *Bot) handleAdminNewDevice(ctx context.Context, chatID int64, msgID int, user *storage.User, targetUserID int64) (responses, error) {
+	targetUser, err := b.repo.GetUserByID(ctx, targetUserID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	lang := userLanguage(user)
+	if targetUser == nil {
+		return responses{tgbotapi.NewEditMessageText(chatID, msgID, b.tr.Tf(lang, msgAdminUserIDNotFound, targetUserID))}, nil
 	}
 
-	if err := b.billing.AdminRejectPayment(ctx, paymentID, user.Username); err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to reject payment")
+	result, err := b.access.CanProvisionDevice(ctx, targetUser.ID)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	if !result.CanProvision {
+		return responses{tgbotapi.NewEditMessageText(chatID, msgID,
+			b.tr.Tf(lang, msgAdminNewDeviceDenied, targetUser.Username, b.accessDeniedText(lang, result)))}, nil
 	}
 
-	payment, _ := b.repo.GetPaymentByID(ctx, paymentID)
-	paymentUser, _ := b.repo.GetUserByID(ctx, payment.UserID)
+	subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, targetUser.ID)
+	if err != nil || subscription == nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.New("subscription not found")
+	}
 
-	text := "❌ Платеж отклонен."
-	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	deviceName := b.nextDeviceName(ctx, subscription)
 
-	// Notify user
-	if paymentUser != nil {
-		notifyText := "❌ Ваш платеж отклонен администратором.\n\nОбратитесь в поддержку для уточнения деталей."
-		b.SendNotification(paymentUser.TelegramID, notifyText)
+	cfg, publicKey, assignedIP, err := b.wireguard.CreateConfigForNewKeys(ctx, b.userServerID(ctx, targetUser.ID), targetUser.ID, subscription.ID, deviceName)
+	if errors.Is(err, provisioning.ErrDeviceLimitReached) {
+		return responses{tgbotapi.NewEditMessageText(chatID, msgID,
+			b.tr.Tf(lang, msgAdminNewDeviceLimit, targetUser.Username))}, nil
+	}
+	if errors.Is(err, provisioning.ErrDeviceExists) {
+		// Repeated tap, the device was created by the previous one
+		return responses{tgbotapi.NewEditMessageText(chatID, msgID,
+			b.tr.Tf(lang, msgAdminNewDeviceExists, deviceName, targetUser.Username))}, nil
+	}
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to create new config")
+	}
+	content, err := io.ReadAll(cfg)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to read new config")
+	}
+	device, err := b.createdDevice(ctx, publicKey)
+	if err != nil {
+		return responses{b.errorMessage(chatID, msgID, true)}, err
 	}
 
-	return responses{res}, nil
+	b.sendConfig(targetUser.TelegramID, b.tr.T(userLanguage(targetUser), msgSupportNewDevice), device, content)
+	log.Printf("Device %s (%s) created for user %d by %s, config sent", deviceName, assignedIP, targetUser.ID, user.Username)
+	b.recordAdminAudit(ctx, user.Username, storage.AuditActionCreateDevice, &targetUser.ID,
+		fmt.Sprintf("device %s (%s)", deviceName, assignedIP))
+
+	return responses{tgbotapi.NewEditMessageText(chatID, msgID,
+		b.tr.Tf(lang, msgAdminNewDeviceDone, deviceName, assignedIP, targetUser.Username))}, nil
 }
 
-func (b *Bot) handleRejectPayment(ctx context.Context, chatID int64, msgID int, user *storage.User, paymentID int64) (responses, error) {
-	if !b.isAdmin(user.Username) {
-		return responses{errorMessage(chatID, msgID, true)}, errors.New("not an admin")
+// handleAddPromoCode creates a promo code (admin only)
+// Usage: /addpromo <code> <percent%|rubles> [usage limit] [valid days]
+func (b *Bot) handleAddPromoCode(chatID int64, user *storage.User, arg string) (responses, error) {
+	if !b.isAdmin(user) {
+		return b.notAdminMsg(chatID), nil
 	}
 
-	if err := b.billing.AdminRejectPayment(ctx, paymentID, user.Username); err != nil {
-		return responses{errorMessage(chatID, msgID, true)}, errors.Wrap(err, "failed to reject payment")
+	lang := userLanguage(user)
+	usage := b.tr.T(lang, msgAddPromoUsage)
+	fields := strings.Fields(arg)
+	if len(fields) < 2 || len(fields) > 4 {
+		return responses{tgbotapi.NewMessage(chatID, usage)}, nil
 	}
 
-	payment, _ := b.repo.GetPaymentByID(ctx, paymentID)
-	paymentUser, _ := b.repo.GetUserByID(ctx, payment.UserID)
+	var discountPercent, discountAmount int
+	if strings.HasSuffix(fields[1], "%") {
+		percent, err := strconv.Atoi(strings.TrimSuffix(fields[1], "%"))
+		if err != nil {
+			return responses{tgbotapi.NewMessage(chatID, usage)}, nil
+		}
+		discountPercent = percent
+	} else {
+		rubles, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return responses{tgbotapi.NewMessage(chatID, usage)}, nil
+		}
+		discountAmount = rubles * 100
+	}
 
-	text := fmt.Sprintf("❌ Платеж отклонен.")
-	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
-	res.ReplyMarkup = &adminKeyboard
+	limits := make([]int, 2)
+	for i, field := range fields[2:] {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return responses{tgbotapi.NewMessage(chatID, usage)}, nil
+		}
+		limits[i] = value
+	}
 
-	// Notify user
-	if paymentUser != nil {
-		notifyText := "❌ Ваш платеж отклонен администратором.\n\nОбратитесь в поддержку для уточнения деталей."
-		b.SendNotification(paymentUser.TelegramID, notifyText)
+	promo, err := b.billing.CreatePromoCode(b.opsCtx, fields[0], discountPercent, discountAmount, limits[0], limits[1])
+	if err != nil {
+		return responses{tgbotapi.NewMessage(chatID, b.tr.Tf(lang, msgAddPromoFailed, err.Error()))}, nil
 	}
+	log.Printf("Promo code %s created by %s", promo.Code, user.Username)
 
-	return responses{res}, nil
+	discount := fmt.Sprintf("%d%%", promo.DiscountPercent)
+	if promo.DiscountAmount > 0 {
+		discount = b.tr.Tf(lang, msgAddPromoAmount, float64(promo.DiscountAmount)/100.0)
+	}
+	limit := b.tr.T(lang, msgAddPromoUnlimited)
+	if promo.UsageLimit > 0 {
+		limit = strconv.Itoa(promo.UsageLimit)
+	}
+	expires := b.tr.T(lang, msgAddPromoNoExpiry)
+	if promo.ExpiresAt != nil {
+		expires = b.tr.Tf(lang, msgAddPromoExpiresAt, promo.ExpiresAt.Format("02.01.2006 15:04"))
+	}
+
+	text := b.tr.Tf(lang, msgAddPromoDone, promo.Code, discount, limit, expires)
+	return responses{tgbotapi.NewMessage(chatID, text)}, nil
 }
 
-func (b *Bot) handleConfigForNewKeys(chatID int64, userID int64, username string, _ string) (responses, error) {
-	ctx := context.Background()
+// botStats holds aggregate bot numbers
+type botStats struct {
+	Users               int   `json:"users"`
+	ActiveSubscriptions int   `json:"active_subscriptions"`
+	PendingPayments     int   `json:"pending_payments"`
+	Revenue             int64 `json:"revenue_kopecks"`
+	ActiveDevices       int   `json:"active_devices"`
+	BlockedUsers        int   `json:"blocked_users"`
+}
 
-	// Check access
-	result, err := b.access.CanProvisionDevice(ctx, userID)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to check access")
+// collectStats computes aggregate bot numbers
+func (b *Bot) collectStats(ctx context.Context) (*botStats, error) {
+	var stats botStats
+	var err error
+	if stats.Users, err = b.repo.CountUsers(ctx); err != nil {
+		return nil, err
+	}
+	if stats.ActiveSubscriptions, err = b.repo.CountActiveSubscriptions(ctx); err != nil {
+		return nil, err
+	}
+	if stats.PendingPayments, err = b.repo.CountPaymentsByStatus(ctx, storage.PaymentStatusPendingReview); err != nil {
+		return nil, err
 	}
+	if stats.Revenue, err = b.repo.SumApprovedRevenue(ctx); err != nil {
+		return nil, err
+	}
+	if stats.ActiveDevices, err = b.repo.CountActiveDevices(ctx); err != nil {
+		return nil, err
+	}
+	if stats.BlockedUsers, err = b.repo.CountBlockedUsers(ctx); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
 
-	if !result.CanProvision {
-		msg := tgbotapi.NewMessage(chatID, result.Reason)
-		msg.ReplyMarkup = &mainMenuKeyboard
-		return responses{msg}, nil
+// handleStatsJSON replies with aggregate bot numbers as JSON (admin only),
+// so they can be copied into dashboards or scripts
+func (b *Bot) handleStatsJSON(chatID int64, user *storage.User, _ string) (responses, error) {
+	if !b.isAdmin(user) {
+		return b.notAdminMsg(chatID), nil
 	}
 
-	// Get active subscription
-	subscription, err := b.repo.GetActiveSubscriptionByUserID(ctx, userID)
-	if err != nil || subscription == nil {
-		return nil, errors.New("subscription not found")
+	stats, err := b.collectStats(b.opsCtx)
+	if err != nil {
+		return responses{b.errorMessage(chatID, 0, false)}, err
+	}
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return responses{b.errorMessage(chatID, 0, false)}, err
 	}
 
-	// Generate device name
-	deviceCount, _ := b.repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
-	deviceName := fmt.Sprintf("device_%d", deviceCount+1)
+	msg := tgbotapi.NewMessage(chatID, "```\n"+string(data)+"\n```")
+	msg.ParseMode = "Markdown"
+	return responses{msg}, nil
+}
 
-	// Create config
-	cfg, _, _, err := b.wireguard.CreateConfigForNewKeys(ctx, userID, subscription.ID, deviceName)
+// handlePayments shows user's payment history
+func (b *Bot) handlePayments(chatID int64, user *storage.User, _ string) (responses, error) {
+	text, keyboard, err := b.userPaymentsPage(b.opsCtx, user, 0)
 	if err != nil {
-		return responses{errorMessage(chatID, 0, false)}, errors.Wrap(err, "failed to create new config")
+		return nil, err
 	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	return responses{msg}, nil
+}
 
-	content, err := io.ReadAll(cfg)
+// handleUserPaymentsPage switches page of user's payment history
+func (b *Bot) handleUserPaymentsPage(ctx context.Context, chatID int64, msgID int, user *storage.User, page int) (responses, error) {
+	text, keyboard, err := b.userPaymentsPage(ctx, user, page)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read new config")
+		return responses{b.errorMessage(chatID, msgID, true)}, err
+	}
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = keyboard
+	return responses{res}, nil
+}
+
+// userPaymentsPage renders a page of user's payments, newest first
+func (b *Bot) userPaymentsPage(ctx context.Context, user *storage.User, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	payments, err := b.repo.GetPaymentsByUserID(ctx, user.ID)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get payments")
+	}
+	lang := userLanguage(user)
+	if len(payments) == 0 {
+		return b.tr.T(lang, msgPaymentsNone), localizedMainMenuKeyboard(b.tr, lang), nil
 	}
 
-	msg := tgbotapi.NewMessage(chatID, emoji())
-	file := createFile(chatID, content)
-	qr := createQR(chatID, content)
+	listPage := paginate(len(payments), page, listPageSize)
+	var sb strings.Builder
+	sb.WriteString(b.tr.Tf(lang, msgPaymentsTitle, len(payments), listPage.label(b.tr, lang)))
+	for _, p := range payments[listPage.start:listPage.end] {
+		sb.WriteString(b.tr.Tf(lang, msgPaymentsItem,
+			p.CreatedAt.Format("02.01.2006"), p.ReferenceCode,
+			b.paymentStatusText(lang, p.Status), float64(p.Amount)/100.0, p.DurationDays, p.DeviceCount))
+	}
 
-	if qr == nil {
-		return responses{msg, file}, nil
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	if nav := listPage.navRow("my_payments:"); nav != nil {
+		buttons = append(buttons, nav)
 	}
-	return responses{msg, qr, file}, nil
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	return sb.String(), &keyboard, nil
 }
 
-func createFile(chatID int64, content []byte) tgbotapi.Chattable {
-	name := strconv.FormatInt(time.Now().Unix(), 10)
-	return tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
-		Name:  name + ".conf",
-		Bytes: content,
-	})
+// paymentStatusKeys maps payment statuses to their catalog keys
+var paymentStatusKeys = map[storage.PaymentStatus]string{
+	storage.PaymentStatusCreated:               msgPaymentStatusCreated,
+	storage.PaymentStatusPendingReview:         msgPaymentStatusInReview,
+	storage.PaymentStatusPendingSecondApproval: msgPaymentStatusInReview,
+	storage.PaymentStatusApproved:              msgPaymentStatusApproved,
+	storage.PaymentStatusRejected:              msgPaymentStatusRejected,
+	storage.PaymentStatusExpired:               msgPaymentStatusExpired,
+	storage.PaymentStatusCancelled:             msgPaymentStatusCancelled,
 }
 
-func createQR(chatID int64, content []byte) tgbotapi.Chattable {
-	options := []qrcode.ImageOption{
-		qrcode.WithLogoImageFilePNG("assets/logo-min.png"),
-		qrcode.WithQRWidth(7),
-		qrcode.WithBuiltinImageEncoder(qrcode.PNG_FORMAT),
+// paymentStatusText returns human-readable payment status
+func (b *Bot) paymentStatusText(lang string, status storage.PaymentStatus) string {
+	if key, ok := paymentStatusKeys[status]; ok {
+		return b.tr.T(lang, key)
 	}
-	qrc, err := qrcode.New(string(content), options...)
-	if err != nil {
-		log.Printf("failed to create qr code: %v", err)
-		return nil
+	return string(status)
+}
+
+// subscriptionStatusKeys maps subscription statuses to their catalog keys
+var subscriptionStatusKeys = map[storage.SubscriptionStatus]string{
+	storage.SubscriptionStatusActive:   msgSubStatusActive,
+	storage.SubscriptionStatusExpiring: msgSubStatusExpiring,
+	storage.SubscriptionStatusPaused:   msgSubStatusPaused,
+	storage.SubscriptionStatusExpired:  msgSubStatusExpired,
+}
+
+// subscriptionStatusText returns human-readable subscription status
+func (b *Bot) subscriptionStatusText(lang string, status storage.SubscriptionStatus) string {
+	if key, ok := subscriptionStatusKeys[status]; ok {
+		return b.tr.T(lang, key)
 	}
-	buf := bytes.Buffer{}
-	if err := qrc.SaveTo(&buf); err != nil {
-		log.Printf("failed to read new qr code: %v", err)
-		return nil
+	return string(status)
+}
+
+// accessDeniedKeys maps access check reasons to their catalog keys
+var accessDeniedKeys = map[access.Reason]string{
+	access.ReasonNoSubscription:  msgAccessNoSubscription,
+	access.ReasonExpired:         msgAccessExpired,
+	access.ReasonPaused:          msgAccessPaused,
+	access.ReasonDeviceLimit:     msgAccessDeviceLimit,
+	access.ReasonUserDeviceLimit: msgAccessUserDeviceLimit,
+}
+
+// accessDeniedText explains why a device can't be provisioned
+func (b *Bot) accessDeniedText(lang string, result *access.CheckResult) string {
+	key, ok := accessDeniedKeys[result.Reason]
+	if !ok {
+		return string(result.Reason)
 	}
-	name := strconv.FormatInt(time.Now().Unix(), 10)
-	return tgbotapi.NewPhoto(chatID, tgbotapi.FileReader{
-		Name:   name + ".png",
-		Reader: &buf,
-	})
+	if result.Reason == access.ReasonDeviceLimit || result.Reason == access.ReasonUserDeviceLimit {
+		return b.tr.Tf(lang, key, result.Devices, result.Limit)
+	}
+	return b.tr.T(lang, key)
 }
 
-// sendPaymentQR sends the static payment QR code from file
-func (b *Bot) sendPaymentQR(chatID int64) tgbotapi.Chattable {
+// sendPaymentQR sends the payment QR code. If bank requisites are configured,
+// a dynamic QR with embedded amount and payment comment is generated,
+// otherwise the static payment QR code from file is sent
+func (b *Bot) sendPaymentQR(chatID int64, payment *storage.Payment) tgbotapi.Chattable {
+	if b.billing.DynamicQREnabled() {
+		if photo := b.createDynamicPaymentQR(chatID, payment); photo != nil {
+			return photo
+		}
+		log.Printf("falling back to static payment QR for payment %d", payment.ID)
+	}
+
 	if b.paymentQRPath == "" {
 		log.Printf("PAYMENT_QR_PATH is not set, cannot send QR code")
 		return nil
@@ -940,37 +2929,72 @@ func (b *Bot) sendPaymentQR(chatID int64) tgbotapi.Chattable {
 		Name:  fileName,
 		Bytes: fileBytes,
 	})
-	photo.Caption = "QR-код для оплаты"
+	photo.Caption = b.paymentTexts.render(b.paymentTexts.qrCaptionText(b.tr, b.chatLanguage(chatID)), payment)
+	return photo
+}
+
+// createDynamicPaymentQR renders a payment QR with embedded amount and payment comment
+func (b *Bot) createDynamicPaymentQR(chatID int64, payment *storage.Payment) tgbotapi.Chattable {
+	payload, err := b.billing.GeneratePaymentQRPayload(payment)
+	if err != nil {
+		log.Printf("failed to generate payment QR payload for payment %d: %v", payment.ID, err)
+		return nil
+	}
+
+	buf, err := b.generateQR([]byte(payload))
+	if err != nil {
+		log.Printf("failed to render payment QR for payment %d: %v", payment.ID, err)
+		return nil
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("payment_%s.png", payment.ReferenceCode),
+		Bytes: buf.Bytes(),
+	})
+	photo.Caption = b.tr.T(b.chatLanguage(chatID), msgPaymentDynamicQRCaption)
 	return photo
 }
 
 func init() {
 	ConfigForNewKeysCmd.handler = (*Bot).handleConfigForNewKeys
-	StartCmd.handler = func(b *Bot, chatID int64, userID int64, username string, arg string) (responses, error) {
-		return nil, nil
-	}
-	MenuCmd.handler = func(b *Bot, chatID int64, userID int64, username string, arg string) (responses, error) {
+	ImportKeyCmd.handler = (*Bot).handleImportKey
+	SubscriptionCmd.handler = (*Bot).handleSubscriptionStatus
+	ReassignDevicesCmd.handler = (*Bot).handleReassignDevices
+	AddPromoCodeCmd.handler = (*Bot).handleAddPromoCode
+	StatsJSONCmd.handler = (*Bot).handleStatsJSON
+	LanguageCmd.handler = (*Bot).handleLanguage
+	CancelCmd.handler = (*Bot).handleCancel
+	PaymentsCmd.handler = (*Bot).handlePayments
+	ExportCmd.handler = (*Bot).handleExport
+	BroadcastCmd.handler = (*Bot).handleBroadcast
+	UserDevicesCmd.handler = (*Bot).handleUserDevices
+	StartCmd.handler = (*Bot).handleStart
+	HelpCmd.handler = (*Bot).handleHelp
+	MenuCmd.handler = func(b *Bot, chatID int64, user *storage.User, arg string) (responses, error) {
 		return nil, nil
 	}
-	AdminCmd.handler = func(b *Bot, chatID int64, userID int64, username string, arg string) (responses, error) {
-		if !b.isAdmin(username) {
-			return responses{tgbotapi.NewMessage(chatID, "❌ У вас нет прав администратора.")}, nil
+	AdminCmd.handler = func(b *Bot, chatID int64, user *storage.User, arg string) (responses, error) {
+		if !b.isAdmin(user) {
+			return b.notAdminMsg(chatID), nil
 		}
-		text := "👑 Админ-панель"
-		msg := tgbotapi.NewMessage(chatID, text)
-		msg.ReplyMarkup = &adminKeyboard
+		lang := userLanguage(user)
+		msg := tgbotapi.NewMessage(chatID, b.tr.T(lang, msgAdminPanel))
+		msg.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
 		return responses{msg}, nil
 	}
 }
 
-const sorry = "Что-то пошло не так, извините 👉🏻👈🏻"
+// maxMessageLength is Telegram's message text length limit
+const maxMessageLength = 4096
 
-func errorMessage(chatID int64, msgID int, edit bool) (res tgbotapi.Chattable) {
+func (b *Bot) errorMessage(chatID int64, msgID int, edit bool) (res tgbotapi.Chattable) {
+	lang := b.chatLanguage(chatID)
+	sorry := b.tr.T(lang, msgSorry)
 	if edit {
 		res = tgbotapi.NewEditMessageTextAndMarkup(
 			chatID, msgID, sorry,
 			tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(goToMenuButton),
+				tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)),
 			),
 		)
 	} else {
@@ -0,0 +1,398 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/billing"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+func TestSelectPaymentForProof(t *testing.T) {
+	first := &storage.Payment{ID: 1, Amount: 30000, ReferenceCode: "AB12CD"}
+	second := &storage.Payment{ID: 2, Amount: 85500, ReferenceCode: "EF34GH"}
+	sameAmount := &storage.Payment{ID: 3, Amount: 30000, ReferenceCode: "IJ56KL"}
+
+	tests := []struct {
+		name          string
+		payments      []*storage.Payment
+		caption       string
+		wantID        int64
+		wantAmbiguous bool
+	}{
+		{name: "no payments", caption: "AB12CD"},
+		{name: "only payment without caption", payments: []*storage.Payment{first}, wantID: 1},
+		{name: "only payment with unrelated caption", payments: []*storage.Payment{first}, caption: "оплатил", wantID: 1},
+		{name: "reference code", payments: []*storage.Payment{first, second}, caption: "EF34GH", wantID: 2},
+		{name: "reference code in sentence", payments: []*storage.Payment{first, second}, caption: "Оплата #ef34gh, спасибо", wantID: 2},
+		{name: "reference code after colon", payments: []*storage.Payment{first, second}, caption: "код:AB12CD", wantID: 1},
+		{name: "reference code wins over amount", payments: []*storage.Payment{first, second}, caption: "300 EF34GH", wantID: 2},
+		{name: "amount", payments: []*storage.Payment{first, second}, caption: "перевел 855,00", wantID: 2},
+		{name: "amount with dot at sentence end", payments: []*storage.Payment{first, second}, caption: "Перевел 300.", wantID: 1},
+		{name: "amount matching several payments", payments: []*storage.Payment{first, second, sameAmount}, caption: "300", wantAmbiguous: true},
+		{name: "amount matching no payment", payments: []*storage.Payment{first, second}, caption: "1000", wantAmbiguous: true},
+		{name: "several payments without caption", payments: []*storage.Payment{first, second}, wantAmbiguous: true},
+		{name: "unknown reference code", payments: []*storage.Payment{first, second}, caption: "ZZ99ZZ", wantAmbiguous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment, ambiguous := selectPaymentForProof(tt.payments, tt.caption)
+			if ambiguous != tt.wantAmbiguous {
+				t.Fatalf("ambiguous = %v, want %v", ambiguous, tt.wantAmbiguous)
+			}
+			var gotID int64
+			if payment != nil {
+				gotID = payment.ID
+			}
+			if gotID != tt.wantID {
+				t.Fatalf("payment = %d, want %d", gotID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAdminCallbacksRejectNonAdmins(t *testing.T) {
+	bot, sender := newTestBot(t)
+	ctx := context.Background()
+	from := testUser(100, "mallory")
+	payment := createPayment(t, bot, from, storage.PaymentStatusPendingReview)
+
+	// Listed explicitly, so callback missing in adminCallbackPrefixes fails the test
+	id := payment.ID
+	callbacks := []string{
+		"admin:pending",
+		"admin:stats",
+		"admin:broadcast",
+		"admin:broadcast_confirm",
+		"admin:ban",
+		fmt.Sprintf("admin:unban:%d", id),
+		"admin:schema",
+		fmt.Sprintf("admin_approve:%d", id),
+		fmt.Sprintf("admin_reject:%d", id),
+		fmt.Sprintf("admin_resync:%d", id),
+		fmt.Sprintf("admin_revoke:%d", id),
+		fmt.Sprintf("admin_revoke_confirm:%d", id),
+		fmt.Sprintf("admin_resend_config:%d", id),
+		fmt.Sprintf("admin_devices:%d:0", id),
+		fmt.Sprintf("admin_new_device:%d", id),
+		fmt.Sprintf("approve:%d", id),
+		fmt.Sprintf("approve:%d:%s", id, payment.PaymentComment),
+		fmt.Sprintf("approve_verify:%d", id),
+		fmt.Sprintf("approve_amount:%d:%d", id, payment.Amount),
+		fmt.Sprintf("reject:%d", id),
+		fmt.Sprintf("reject_noreason:%d", id),
+		fmt.Sprintf("payment_detail:%d", id),
+	}
+	for i, data := range callbacks {
+		t.Run(data, func(t *testing.T) {
+			sender.reset()
+			if errs := bot.handle(callbackUpdate(i+1, from, 1, data)); len(errs) == 0 {
+				t.Errorf("no error for admin callback from non-admin")
+			}
+			texts := sender.sentTo(from.ID)
+			if len(texts) != 1 || texts[0] != bot.tr.T(defaultLanguage, msgSorry) {
+				t.Errorf("sent %q, want only error message", texts)
+			}
+
+			got, err := bot.repo.GetPaymentByID(ctx, payment.ID)
+			if err != nil {
+				t.Fatalf("failed to get payment: %v", err)
+			}
+			if got.Status != storage.PaymentStatusPendingReview || got.ReviewedBy != nil {
+				t.Errorf("payment changed: status %s, reviewed by %v", got.Status, got.ReviewedBy)
+			}
+			subscription, err := bot.repo.GetActiveSubscriptionByUserID(ctx, payment.UserID)
+			if err != nil {
+				t.Fatalf("failed to get subscription: %v", err)
+			}
+			if subscription != nil {
+				t.Errorf("subscription %d created", subscription.ID)
+			}
+		})
+	}
+}
+
+func TestSendPaymentQRFromFile(t *testing.T) {
+	bot, _ := newTestBot(t)
+	payment := &storage.Payment{ID: 1, Amount: 30000, ReferenceCode: "AB12CD", PaymentComment: "тихий лес 42"}
+
+	if qr := bot.sendPaymentQR(1, payment); qr != nil {
+		t.Fatalf("QR sent without PAYMENT_QR_PATH and requisites: %T", qr)
+	}
+
+	image := []byte("png image")
+	bot.paymentQRPath = filepath.Join(t.TempDir(), "qr.png")
+	if err := os.WriteFile(bot.paymentQRPath, image, 0600); err != nil {
+		t.Fatalf("failed to write QR file: %v", err)
+	}
+	photo, ok := bot.sendPaymentQR(1, payment).(tgbotapi.PhotoConfig)
+	if !ok {
+		t.Fatalf("payment QR is not a photo")
+	}
+	file, ok := photo.File.(tgbotapi.FileBytes)
+	if !ok || file.Name != "qr.png" || !bytes.Equal(file.Bytes, image) {
+		t.Errorf("sent file %+v, want qr.png from PAYMENT_QR_PATH", photo.File)
+	}
+	if photo.Caption == "" {
+		t.Errorf("payment QR has no caption")
+	}
+
+	bot.paymentQRPath = filepath.Join(t.TempDir(), "missing.png")
+	if qr := bot.sendPaymentQR(1, payment); qr != nil {
+		t.Errorf("QR sent for missing file: %T", qr)
+	}
+}
+
+func TestPaymentReviewText(t *testing.T) {
+	reviewedAt := time.Date(2026, 3, 5, 14, 30, 0, 0, time.UTC)
+	reviewer := "admin"
+	empty := ""
+
+	tests := []struct {
+		name    string
+		payment *storage.Payment
+		want    string
+	}{
+		{name: "not reviewed", payment: &storage.Payment{}, want: ""},
+		{name: "reviewed", payment: &storage.Payment{ReviewedAt: &reviewedAt, ReviewedBy: &reviewer}, want: "Проверил: @admin, 05.03.2026 14:30"},
+		{name: "reviewer unknown", payment: &storage.Payment{ReviewedAt: &reviewedAt}, want: "Проверил: неизвестно, 05.03.2026 14:30"},
+		{name: "reviewer empty", payment: &storage.Payment{ReviewedAt: &reviewedAt, ReviewedBy: &empty}, want: "Проверил: неизвестно, 05.03.2026 14:30"},
+		{name: "time unknown", payment: &storage.Payment{ReviewedBy: &reviewer}, want: "Проверил: @admin, неизвестно"},
+	}
+	for _, tt := range tests {
+		if got := paymentReviewText(NewTranslator(), defaultLanguage, tt.payment); got != tt.want {
+			t.Errorf("%s: paymentReviewText() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProvisionApprovedPaymentRequiresActiveSubscription(t *testing.T) {
+	bot, sender := newTestBot(t)
+	ctx := context.Background()
+	from := testUser(100, "alice")
+	payment := createPayment(t, bot, from, storage.PaymentStatusPendingReview)
+	user, err := bot.repo.GetOrCreateUser(ctx, from.ID, from.UserName)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+
+	// Subscription ended and is in grace period, so it's returned as the current one but paused
+	now := bot.repo.Clock().Now()
+	graceEnds := now.AddDate(0, 0, 1)
+	paused := &storage.Subscription{
+		UserID:            user.ID,
+		DurationDays:      30,
+		DeviceLimit:       1,
+		Amount:            payment.Amount,
+		Status:            storage.SubscriptionStatusPaused,
+		StartsAt:          now.AddDate(0, 0, -32),
+		EndsAt:            now.AddDate(0, 0, -2),
+		GracePeriodEndsAt: &graceEnds,
+	}
+	if err := bot.repo.CreateSubscription(ctx, paused); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+
+	bot.provisionApprovedPayment(ctx, payment, user)
+	assertActiveDevices(t, bot, user.ID, 0)
+	texts := sender.sentTo(from.ID)
+	if len(texts) != 1 || !strings.Contains(texts[0], "/newkeys") {
+		t.Fatalf("sent %q, want text offering /newkeys", texts)
+	}
+
+	// Approval extends the paused subscription, it is active when re-read and gets the device
+	sender.reset()
+	if err := bot.repo.ApprovePayment(ctx, payment.ID, testAdmin, 3); err != nil {
+		t.Fatalf("failed to approve payment: %v", err)
+	}
+	bot.provisionApprovedPayment(ctx, payment, user)
+	assertActiveDevices(t, bot, user.ID, 1)
+	if !sentConfigFile(sender, from.ID) {
+		t.Errorf("config file wasn't sent after approval, sent %q", sender.sentTo(from.ID))
+	}
+}
+
+func TestIsProofDocument(t *testing.T) {
+	tests := []struct {
+		mime string
+		want bool
+	}{
+		{mime: "image/png", want: true},
+		{mime: "image/jpeg", want: true},
+		{mime: "IMAGE/HEIC", want: true},
+		{mime: "application/pdf", want: true},
+		{mime: "application/zip", want: false},
+		{mime: "text/plain", want: false},
+		{mime: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := isProofDocument(&tgbotapi.Document{MimeType: tt.mime}); got != tt.want {
+			t.Errorf("isProofDocument(%q) = %v, want %v", tt.mime, got, tt.want)
+		}
+	}
+}
+
+func TestPaymentProofAttachment(t *testing.T) {
+	tests := []struct {
+		name         string
+		message      func(msg *tgbotapi.Message)
+		wantAttached bool
+		wantDocument bool
+		wantFileID   string
+	}{
+		{
+			name: "photo",
+			message: func(msg *tgbotapi.Message) {
+				msg.Photo = []tgbotapi.PhotoSize{{FileID: "small", Width: 90}, {FileID: "large", Width: 1280}}
+			},
+			wantAttached: true,
+			wantFileID:   "large",
+		},
+		{
+			name: "image document",
+			message: func(msg *tgbotapi.Message) {
+				msg.Document = &tgbotapi.Document{FileID: "screenshot", MimeType: "image/png"}
+			},
+			wantAttached: true,
+			wantDocument: true,
+			wantFileID:   "screenshot",
+		},
+		{
+			name: "pdf document",
+			message: func(msg *tgbotapi.Message) {
+				msg.Document = &tgbotapi.Document{FileID: "receipt", MimeType: "application/pdf"}
+			},
+			wantAttached: true,
+			wantDocument: true,
+			wantFileID:   "receipt",
+		},
+		{
+			name: "other document",
+			message: func(msg *tgbotapi.Message) {
+				msg.Document = &tgbotapi.Document{FileID: "archive", MimeType: "application/zip"}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, sender := newTestBot(t)
+			ctx := context.Background()
+			from := testUser(100, "alice")
+			payment := createPayment(t, bot, from, storage.PaymentStatusCreated)
+
+			update := messageUpdate(1, from, "")
+			tt.message(update.Message)
+			if errs := bot.handle(update); len(errs) != 0 {
+				t.Fatalf("proof handling failed: %v", errs)
+			}
+			if texts := sender.sentTo(from.ID); len(texts) == 0 {
+				t.Errorf("user got no reply")
+			}
+
+			got, err := bot.repo.GetPaymentByID(ctx, payment.ID)
+			if err != nil {
+				t.Fatalf("failed to get payment: %v", err)
+			}
+			if !tt.wantAttached {
+				if got.Status != storage.PaymentStatusCreated || got.ProofFileID != "" {
+					t.Fatalf("proof attached: status %s, file %q", got.Status, got.ProofFileID)
+				}
+				return
+			}
+			if got.Status != storage.PaymentStatusPendingReview || got.ProofFileID != tt.wantFileID || got.ProofIsDocument != tt.wantDocument {
+				t.Fatalf("payment status %s, proof %q, document %v, want pending review with %q, document %v",
+					got.Status, got.ProofFileID, got.ProofIsDocument, tt.wantFileID, tt.wantDocument)
+			}
+
+			// Admins get proof back the way it was sent, sendPhoto fails for document file IDs
+			switch proof := proofMessage(1, got, "").(type) {
+			case tgbotapi.DocumentConfig:
+				if !tt.wantDocument || proof.File != tgbotapi.FileID(tt.wantFileID) {
+					t.Errorf("proof shown as document %v", proof.File)
+				}
+			case tgbotapi.PhotoConfig:
+				if tt.wantDocument || proof.File != tgbotapi.FileID(tt.wantFileID) {
+					t.Errorf("proof shown as photo %v", proof.File)
+				}
+			default:
+				t.Errorf("proof shown as %T", proof)
+			}
+		})
+	}
+}
+
+// assertActiveDevices fails test if user doesn't have given number of active devices
+func assertActiveDevices(t *testing.T, bot *Bot, userID int64, want int) {
+	t.Helper()
+	devices, err := bot.repo.GetActiveDevicesByUserID(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+	if len(devices) != want {
+		t.Fatalf("user has %d active devices, want %d", len(devices), want)
+	}
+}
+
+// sentConfigFile reports whether .conf file was sent to the chat
+func sentConfigFile(sender *fakeSender, chatID int64) bool {
+	for _, c := range sender.messages() {
+		if doc, ok := c.(tgbotapi.DocumentConfig); ok && doc.ChatID == chatID {
+			if file, ok := doc.File.(tgbotapi.FileBytes); ok && strings.HasSuffix(file.Name, ".conf") && len(file.Bytes) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestConfirmCallbackDataFitsTelegramLimit(t *testing.T) {
+	// Largest plan with promo code and the longest server ID
+	data := confirmCallbackData(billing.MaxPlanDevices, billing.MaxPlanDurationDays, true, strings.Repeat("z", 16))
+	if len(data) > callbackDataMaxLen {
+		t.Errorf("callback data %q is %d bytes, limit is %d", data, len(data), callbackDataMaxLen)
+	}
+}
+
+func TestConfirmWithAppliedPromoCode(t *testing.T) {
+	bot, _ := newTestBot(t)
+	ctx := context.Background()
+	from := testUser(100, "alice")
+	code := strings.Repeat("P", 32)
+	if _, err := bot.billing.CreatePromoCode(ctx, code, 10, 0, 0, 30); err != nil {
+		t.Fatalf("failed to create promo code: %v", err)
+	}
+
+	if errs := bot.handle(callbackUpdate(1, from, 1, "promo:1:30")); len(errs) != 0 {
+		t.Fatalf("promo request failed: %v", errs)
+	}
+	if errs := bot.handle(messageUpdate(2, from, code)); len(errs) != 0 {
+		t.Fatalf("promo input failed: %v", errs)
+	}
+	data := confirmCallbackData(1, 30, true, "")
+	if strings.Contains(data, code) {
+		t.Fatalf("promo code is carried in callback data %q", data)
+	}
+	if errs := bot.handle(callbackUpdate(3, from, 1, data)); len(errs) != 0 {
+		t.Fatalf("payment confirmation failed: %v", errs)
+	}
+
+	user, err := bot.repo.GetUserByTelegramID(ctx, from.ID)
+	if err != nil || user == nil {
+		t.Fatalf("user wasn't created: %v", err)
+	}
+	payments, err := bot.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
+	if err != nil {
+		t.Fatalf("failed to get payments: %v", err)
+	}
+	if len(payments) != 1 || payments[0].PromoCode != code {
+		t.Errorf("payments %+v, want one with promo code %s", payments, code)
+	}
+}
@@ -0,0 +1,1311 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// defaultLanguage is used for new users and for messages missing in user's language
+const defaultLanguage = storage.DefaultUserLanguage
+
+// language describes a supported bot language
+type language struct {
+	code string // IETF language code, as used by Telegram
+	name string // Button label
+}
+
+var languages = []language{
+	{code: "ru", name: "🇷🇺 Русский"},
+	{code: "en", name: "🇬🇧 English"},
+}
+
+// isSupportedLanguage reports whether there is a message catalog for language code
+func isSupportedLanguage(code string) bool {
+	for _, lang := range languages {
+		if lang.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Message catalog keys
+const (
+	msgStartText                   = "start.text"
+	msgMenuText                    = "menu.text"
+	msgHelpText                    = "help.text"
+	msgHelpAdminText               = "help.admin_text"
+	msgUseMenu                     = "use_menu"
+	msgUnknownCommand              = "unknown_command"
+	msgBanned                      = "banned"
+	msgLanguagePrompt              = "language.prompt"
+	msgLanguageChanged             = "language.changed"
+	msgButtonPayment               = "button.payment"
+	msgButtonPaid                  = "button.paid"
+	msgButtonNewDevice             = "button.new_device"
+	msgButtonMySub                 = "button.my_subscription"
+	msgButtonHelp                  = "button.help"
+	msgButtonMenu                  = "button.menu"
+	msgCmdStart                    = "cmd.start"
+	msgCmdMenu                     = "cmd.menu"
+	msgCmdNewKeys                  = "cmd.newkeys"
+	msgCmdImportKey                = "cmd.importkey"
+	msgCmdStatus                   = "cmd.status"
+	msgCmdExport                   = "cmd.export"
+	msgCmdPayments                 = "cmd.payments"
+	msgCmdCancel                   = "cmd.cancel"
+	msgCmdHelp                     = "cmd.help"
+	msgCmdLanguage                 = "cmd.language"
+	msgCmdAdmin                    = "cmd.admin"
+	msgCmdBroadcast                = "cmd.broadcast"
+	msgCmdStatsJSON                = "cmd.statsjson"
+	msgCmdUserDevices              = "cmd.userdevices"
+	msgCmdReassign                 = "cmd.reassign"
+	msgCmdAddPromo                 = "cmd.addpromo"
+	msgSorry                       = "sorry"
+	msgNotAdmin                    = "not_admin"
+	msgRateLimited                 = "rate_limited"
+	msgAdminPanel                  = "admin.panel"
+	msgButtonDuration              = "button.duration"
+	msgButtonAdminPending          = "button.admin_pending"
+	msgButtonAdminPayments         = "button.admin_payments"
+	msgButtonAdminAudit            = "button.admin_audit"
+	msgButtonAdminStats            = "button.admin_stats"
+	msgButtonAdminBroadcast        = "button.admin_broadcast"
+	msgButtonAdminBan              = "button.admin_ban"
+	msgButtonAdminSchema           = "button.admin_schema"
+	msgButtonAdminHealth           = "button.admin_health"
+	msgButtonAdminReconcile        = "button.admin_reconcile"
+	msgProofDocumentsOnly          = "proof.documents_only"
+	msgProofUnreadable             = "proof.unreadable"
+	msgUserNotFound                = "user_not_found"
+	msgProofSaveFailed             = "proof.save_failed"
+	msgProofAmbiguous              = "proof.ambiguous"
+	msgPaymentListItem             = "payment.list_item"
+	msgProofAmbiguousHint          = "proof.ambiguous_hint"
+	msgProofNoPayment              = "proof.no_payment"
+	msgProofAlreadyProcessedStatus = "proof.already_processed_status"
+	msgProofAlreadyProcessed       = "proof.already_processed"
+	msgProofReceived               = "proof.received"
+	msgRejectReasonPrompt          = "reject.reason_prompt"
+	msgPendingReviewLimit          = "payment.pending_review_limit"
+	msgChooseDuration              = "payment.choose_duration"
+	msgChooseDeviceCount           = "payment.choose_device_count"
+	msgOrderSummary                = "payment.order_summary"
+	msgButtonGoToPayment           = "button.go_to_payment"
+	msgButtonEnterPromo            = "button.enter_promo"
+	msgButtonWithoutPromo          = "button.without_promo"
+	msgPromoPrompt                 = "promo.prompt"
+	msgPromoInvalid                = "promo.invalid"
+	msgPromoApplied                = "promo.applied"
+	msgPromoExpired                = "promo.expired"
+	msgPaymentPromoLine            = "payment.promo_line"
+	msgPaymentInstructions         = "payment.instructions"
+	msgPaymentQRUnavailable        = "payment.qr_unavailable"
+	msgButtonCancel                = "button.cancel"
+	msgCancelNothing               = "cancel.nothing"
+	msgCancelInReview              = "cancel.in_review"
+	msgCancelApproved              = "cancel.approved"
+	msgCancelClosed                = "cancel.closed"
+	msgCancelFailed                = "cancel.failed"
+	msgCancelDone                  = "cancel.done"
+	msgProofAlreadyInReview        = "proof.already_in_review"
+	msgProofChoosePayment          = "proof.choose_payment"
+	msgProofNoPendingPayment       = "proof.no_pending_payment"
+	msgProofPrompt                 = "proof.prompt"
+	msgProofSentToReview           = "proof.sent_to_review"
+	msgAdminProofAttached          = "admin.proof_attached"
+	msgAdminPaymentResubmitted     = "admin.payment_resubmitted"
+	msgAdminPromoLine              = "admin.promo_line"
+	msgAdminNewPayment             = "admin.new_payment"
+	msgButtonApprove               = "button.approve"
+	msgButtonReject                = "button.reject"
+	msgBroadcastCancelled          = "broadcast.cancelled"
+	msgAdminNoPending              = "admin.no_pending"
+	msgAdminPendingItem            = "admin.pending_item"
+	msgAdminPendingTitle           = "admin.pending_title"
+	msgFilterPendingReview         = "admin.filter_pending_review"
+	msgFilterApproved              = "admin.filter_approved"
+	msgFilterRejected              = "admin.filter_rejected"
+	msgAdminPaymentsTitle          = "admin.payments_title"
+	msgAdminPaymentsItem           = "admin.payments_item"
+	msgAuditFirstApproval          = "audit.first_approval"
+	msgAuditApprovePayment         = "audit.approve_payment"
+	msgAuditRejectPayment          = "audit.reject_payment"
+	msgAuditRevokeDevice           = "audit.revoke_device"
+	msgAuditBanUser                = "audit.ban_user"
+	msgAuditUnbanUser              = "audit.unban_user"
+	msgAuditResendConfig           = "audit.resend_config"
+	msgAuditCreateDevice           = "audit.create_device"
+	msgAuditResyncDevice           = "audit.resync_device"
+	msgAuditBroadcast              = "audit.broadcast"
+	msgAuditTitle                  = "audit.title"
+	msgAuditPayment                = "audit.payment"
+	msgAuditUser                   = "audit.user"
+	msgAuditAmount                 = "audit.amount"
+	msgAuditReceivedAmount         = "audit.received_amount"
+	msgAdminStats                  = "admin.stats"
+	msgSchemaTitle                 = "schema.title"
+	msgSchemaTableMissing          = "schema.table_missing"
+	msgSchemaMissingColumns        = "schema.missing_columns"
+	msgSchemaColumnsOK             = "schema.columns_ok"
+	msgHealthTitle                 = "health.title"
+	msgHealthDefaultServer         = "health.default_server"
+	msgHealthOK                    = "health.ok"
+	msgPaymentDetail               = "admin.payment_detail"
+	msgPaymentFirstApproval        = "admin.first_approval"
+	msgPaymentSecondApprovalNeeded = "admin.second_approval_needed"
+	msgButtonVerifyApprove         = "button.verify_approve"
+	msgPaymentProofCaption         = "admin.proof_caption"
+	msgPageLabel                   = "page.label"
+	msgUnknown                     = "unknown"
+	msgPaymentReviewed             = "payment.reviewed"
+	msgButtonApproveWithComment    = "button.approve_with_comment"
+	msgButtonRetry                 = "button.retry"
+	msgApproveFailed               = "approve.failed"
+	msgApproveDone                 = "approve.done"
+	msgApprovedNoDevice            = "approve.user_no_device"
+	msgApprovedWithConfig          = "approve.user_config"
+	msgReviewCancelled             = "review.cancelled"
+	msgReviewAlreadyProcessed      = "review.already_processed"
+	msgReviewSecondApproval        = "review.second_approval"
+	msgReviewSameApprover          = "review.same_approver"
+	msgApproveFailedShort          = "approve.failed_short"
+	msgRejectAlreadyProcessed      = "reject.already_processed"
+	msgRejectReasonRequest         = "reject.reason_request"
+	msgButtonRejectNoReason        = "button.reject_no_reason"
+	msgRejectDone                  = "reject.done"
+	msgRejectReason                = "reject.reason"
+	msgRejectUserNotice            = "reject.user_notice"
+	msgRejectUserSupport           = "reject.user_support"
+	msgDeviceSlotsLeft             = "device.slots_left"
+	msgDeviceLimitReached          = "device.limit_reached"
+	msgDeviceExists                = "device.exists"
+	msgImportKeyPrompt             = "importkey.prompt"
+	msgImportKeyInvalid            = "importkey.invalid"
+	msgImportKeyExists             = "importkey.exists"
+	msgImportKeyDone               = "importkey.done"
+	msgQRUnavailable               = "qr.unavailable"
+	msgConfigLost                  = "config.lost"
+	msgSubNone                     = "sub.none"
+	msgButtonSubscribe             = "button.subscribe"
+	msgSubStatus                   = "sub.status"
+	msgSubGrace                    = "sub.grace"
+	msgSubDevices                  = "sub.devices"
+	msgSubStatusActive             = "sub.status_active"
+	msgSubStatusExpiring           = "sub.status_expiring"
+	msgSubStatusPaused             = "sub.status_paused"
+	msgSubStatusExpired            = "sub.status_expired"
+	msgDevicesTitle                = "devices.title"
+	msgDeviceMissing               = "devices.missing"
+	msgDeviceNeverConnected        = "devices.never_connected"
+	msgDeviceOnline                = "devices.online"
+	msgDeviceOffline               = "devices.offline"
+	msgByteUnits                   = "devices.byte_units"
+	msgButtonDeviceConfig          = "button.device_config"
+	msgResendNoKey                 = "resend.no_key"
+	msgResendConfig                = "resend.config"
+	msgResendImportedKey           = "resend.imported_key"
+	msgReassignUsage               = "reassign.usage"
+	msgAdminUserNotFound           = "admin.user_not_found"
+	msgAdminUserIDNotFound         = "admin.user_id_not_found"
+	msgAdminNoSubscription         = "admin.no_subscription"
+	msgReassignDone                = "reassign.done"
+	msgReassignOverLimit           = "reassign.over_limit"
+	msgReassignRenamed             = "reassign.renamed"
+	msgReassignRenamedItem         = "reassign.renamed_item"
+	msgReassignFailed              = "reassign.failed"
+	msgUserDevicesUsage            = "userdevices.usage"
+	msgUserDevicesNone             = "userdevices.none"
+	msgUserDevicesTitle            = "userdevices.title"
+	msgUserDevicesItem             = "userdevices.item"
+	msgUserDevicesRevoked          = "userdevices.revoked"
+	msgButtonRevokeDevice          = "button.revoke_device"
+	msgButtonAdminResendConfig     = "button.admin_resend_config"
+	msgDeviceNotFound              = "device.not_found"
+	msgResyncRevoked               = "resync.revoked"
+	msgResyncFailed                = "resync.failed"
+	msgResyncDone                  = "resync.done"
+	msgDeviceAlreadyRevoked        = "device.already_revoked"
+	msgRevokeConfirm               = "revoke.confirm"
+	msgButtonRevoke                = "button.revoke"
+	msgButtonBack                  = "button.back"
+	msgRevokeFailed                = "revoke.failed"
+	msgRevokeDone                  = "revoke.done"
+	msgSupportConfigResent         = "support.config_resent"
+	msgSupportResendDone           = "support.resend_done"
+	msgSupportNoDevices            = "support.no_devices"
+	msgSupportLatestDevice         = "support.latest_device"
+	msgSupportOfferNewDevice       = "support.offer_new_device"
+	msgButtonCreateDevice          = "button.create_device"
+	msgAdminNewDeviceDenied        = "admin.new_device_denied"
+	msgAdminNewDeviceLimit         = "admin.new_device_limit"
+	msgAdminNewDeviceExists        = "admin.new_device_exists"
+	msgSupportNewDevice            = "support.new_device"
+	msgAdminNewDeviceDone          = "admin.new_device_done"
+	msgAddPromoUsage               = "addpromo.usage"
+	msgAddPromoFailed              = "addpromo.failed"
+	msgAddPromoAmount              = "addpromo.amount"
+	msgAddPromoUnlimited           = "addpromo.unlimited"
+	msgAddPromoNoExpiry            = "addpromo.no_expiry"
+	msgAddPromoExpiresAt           = "addpromo.expires_at"
+	msgAddPromoDone                = "addpromo.done"
+	msgPaymentsNone                = "payments.none"
+	msgPaymentsTitle               = "payments.title"
+	msgPaymentsItem                = "payments.item"
+	msgPaymentDynamicQRCaption     = "payment.dynamic_qr_caption"
+	msgAccessNoSubscription        = "access.no_subscription"
+	msgAccessExpired               = "access.expired"
+	msgAccessPaused                = "access.paused"
+	msgAccessDeviceLimit           = "access.device_limit"
+	msgAccessUserDeviceLimit       = "access.user_device_limit"
+	msgBroadcastProgress           = "broadcast.progress"
+	msgBroadcastSummary            = "broadcast.summary"
+	msgBroadcastUsage              = "broadcast.usage"
+	msgBroadcastPrompt             = "broadcast.prompt"
+	msgBroadcastEmpty              = "broadcast.empty"
+	msgBroadcastPreview            = "broadcast.preview"
+	msgButtonSend                  = "button.send"
+	msgBroadcastAlreadyDone        = "broadcast.already_done"
+	msgPaymentDefaultInstructions  = "payment.default_instructions"
+	msgPaymentDefaultQRCaption     = "payment.default_qr_caption"
+	msgButtonShowAsText            = "button.show_as_text"
+	msgConfigTextExpired           = "config.text_expired"
+	msgConfigTextTooLong           = "config.text_too_long"
+	msgExportNoDevices             = "export.no_devices"
+	msgExportImportedKeys          = "export.imported_keys"
+	msgExportSkipped               = "export.skipped"
+	msgExportNothing               = "export.nothing"
+	msgExportCaption               = "export.caption"
+	msgExportDone                  = "export.done"
+	msgReconcileTitle              = "reconcile.title"
+	msgReconcileOK                 = "reconcile.ok"
+	msgReconcileServer             = "reconcile.server"
+	msgReconcileMore               = "reconcile.more"
+	msgReconcileRestored           = "reconcile.restored"
+	msgReconcileFailed             = "reconcile.failed"
+	msgReconcileOrphan             = "reconcile.orphan"
+	msgReconcileOrphansNote        = "reconcile.orphans_note"
+	msgInvoicePromoLine            = "invoice.promo_line"
+	msgInvoiceDetails              = "invoice.details"
+	msgInvoiceDescription          = "invoice.description"
+	msgInvoiceTitle                = "invoice.title"
+	msgInvoiceExpired              = "invoice.expired"
+	msgAdminInvoiceNotConfirmed    = "admin.invoice_not_confirmed"
+	msgInvoiceNotActivated         = "invoice.not_activated"
+	msgAdminInvoicePaid            = "admin.invoice_paid"
+	msgAdminWebhookParseFailed     = "admin.webhook_parse_failed"
+	msgAdminWebhookSecondApproval  = "admin.webhook_second_approval"
+	msgAdminWebhookNoPayment       = "admin.webhook_no_payment"
+	msgAdminWebhookNotConfirmed    = "admin.webhook_not_confirmed"
+	msgAdminWebhookPaid            = "admin.webhook_paid"
+	msgPaymentExpired              = "payment.expired"
+	msgPaymentExpiredUnreviewed    = "payment.expired_unreviewed"
+	msgExpiryReminder              = "reminder.expiry"
+	msgGraceReminder               = "reminder.grace"
+	msgButtonRenew                 = "button.renew"
+	msgPaymentStatusCreated        = "payment.status_created"
+	msgPaymentStatusInReview       = "payment.status_in_review"
+	msgPaymentStatusApproved       = "payment.status_approved"
+	msgPaymentStatusRejected       = "payment.status_rejected"
+	msgPaymentStatusExpired        = "payment.status_expired"
+	msgPaymentStatusCancelled      = "payment.status_cancelled"
+	msgBanPrompt                   = "ban.prompt"
+	msgBanUsernameEmpty            = "ban.username_empty"
+	msgButtonUnban                 = "button.unban"
+	msgBanBanned                   = "ban.banned"
+	msgButtonBan                   = "button.ban"
+	msgButtonBanRevoke             = "button.ban_revoke"
+	msgBanNotBanned                = "ban.not_banned"
+	msgBanAdmin                    = "ban.admin"
+	msgBanRevoked                  = "ban.revoked"
+	msgBanRevokeFailed             = "ban.revoke_failed"
+	msgBanUnbanned                 = "ban.unbanned"
+	msgProvisionCooldown           = "device.cooldown"
+	msgRegionLine                  = "region.line"
+	msgRegionSelection             = "region.selection"
+	msgApproveAmountPrompt         = "approve.amount_prompt"
+	msgApproveVerifyAmount         = "approve.verify_amount"
+	msgApproveAmountMismatch       = "approve.amount_mismatch"
+	msgButtonApproveAmount         = "button.approve_amount"
+	msgAmountMismatchError         = "error.amount_mismatch"
+)
+
+// catalog maps language code to message key to message
+var catalog = map[string]map[string]string{
+	"ru": {
+		msgStartText: "Добро пожаловать! Используйте меню для навигации.",
+		msgMenuText:  "Выберите действие:",
+		msgHelpText: "ℹ️ Доступные команды:\n\n" +
+			"/start - Главное меню\n" +
+			"/menu - Меню бота\n" +
+			"/newkeys - Создать новое устройство (требуется активная подписка)\n" +
+			"/importkey - Подключить устройство со своим публичным ключом\n" +
+			"/status - Статус подписки\n" +
+			"/export - Выгрузить конфиги всех устройств архивом\n" +
+			"/payments - История платежей\n" +
+			"/cancel - Отменить неоплаченную заявку\n" +
+			"/language - Сменить язык\n" +
+			"/help - Показать эту справку",
+		msgHelpAdminText: "👑 Команды администратора:\n\n" +
+			"/admin - Админ-панель: ожидающие оплаты, история оплат, журнал действий, статистика, рассылка\n" +
+			"/broadcast <текст> - Рассылка сообщения всем пользователям\n" +
+			"/statsjson - Статистика в формате JSON\n" +
+			"/userdevices <username> - Устройства пользователя\n" +
+			"/reassign <username> - Перенести устройства пользователя на текущую подписку\n" +
+			"/addpromo <код> <процент%|рубли> [лимит] [дней] - Создать промокод",
+		msgUseMenu:              "Используйте команды из меню или нажмите /menu",
+		msgUnknownCommand:       "Неизвестная команда. Используйте /menu",
+		msgBanned:               "🚫 Ваш аккаунт заблокирован администратором.",
+		msgLanguagePrompt:       "Выберите язык:",
+		msgLanguageChanged:      "✅ Язык изменен на русский.",
+		msgButtonPayment:        "💳 Оплата/Продление",
+		msgButtonPaid:           "✅ Я оплатил",
+		msgButtonNewDevice:      "📱 Создать устройство",
+		msgButtonMySub:          "📊 Моя подписка",
+		msgButtonHelp:           "ℹ️ Помощь",
+		msgButtonMenu:           "◀️ Меню",
+		msgCmdStart:             "Главное меню",
+		msgCmdMenu:              "Меню бота",
+		msgCmdNewKeys:           "Создать новое устройство",
+		msgCmdImportKey:         "Подключить устройство со своим ключом",
+		msgCmdStatus:            "Статус подписки",
+		msgCmdExport:            "Выгрузить конфиги всех устройств",
+		msgCmdPayments:          "История платежей",
+		msgCmdCancel:            "Отменить неоплаченную заявку",
+		msgCmdHelp:              "Помощь",
+		msgCmdLanguage:          "Сменить язык",
+		msgCmdAdmin:             "Админ-панель",
+		msgCmdBroadcast:         "Рассылка сообщения всем пользователям",
+		msgCmdStatsJSON:         "Статистика в формате JSON",
+		msgCmdUserDevices:       "Устройства пользователя",
+		msgCmdReassign:          "Перенести устройства пользователя на текущую подписку",
+		msgCmdAddPromo:          "Создать промокод",
+		msgSorry:                "Что-то пошло не так, извините 👉🏻👈🏻",
+		msgNotAdmin:             "❌ У вас нет прав администратора.",
+		msgRateLimited:          "⏳ Слишком много запросов, подождите немного.",
+		msgAdminPanel:           "👑 Админ-панель",
+		msgButtonDuration:       "%d дней",
+		msgButtonAdminPending:   "📋 Ожидающие оплаты",
+		msgButtonAdminPayments:  "🗂 История оплат",
+		msgButtonAdminAudit:     "📜 Журнал действий",
+		msgButtonAdminStats:     "📊 Статистика",
+		msgButtonAdminBroadcast: "📣 Рассылка",
+		msgButtonAdminBan:       "🚫 Блокировка пользователей",
+		msgButtonAdminSchema:    "🗄 Схема БД",
+		msgButtonAdminHealth:    "🩺 Состояние серверов",
+		msgButtonAdminReconcile: "🔧 Сверка устройств",
+		msgProofDocumentsOnly:   "❌ В качестве подтверждения оплаты принимаются фото, изображения и PDF файлы.",
+		msgProofUnreadable:      "❌ Не удалось получить файл. Отправьте скриншот оплаты ещё раз.",
+		msgUserNotFound:         "Ошибка: пользователь не найден",
+		msgProofSaveFailed:      "Ошибка при сохранении подтверждения оплаты",
+		msgProofAmbiguous:       "❓ У вас несколько неоплаченных заявок, и по подписи к фото непонятно, какую из них вы оплатили:\n",
+		msgPaymentListItem:      "%s - %.2f руб., %d дней",
+		msgProofAmbiguousHint:   "\n\nОтправьте скриншот еще раз, указав в подписи код заявки.",
+		msgProofNoPayment: "❌ Не найдена ожидающая оплата со статусом 'создана'.\n\n" +
+			"Создайте заявку через меню 'Оплата/Продление', затем отправьте скриншот подтверждения оплаты.\n\n" +
+			"Вы также можете указать код заявки в подписи к фото.",
+		msgProofAlreadyProcessedStatus: "❌ Платеж с кодом `%s` уже обработан (статус: %s).",
+		msgProofAlreadyProcessed:       "❌ Платеж с кодом `%s` уже обработан.",
+		msgProofReceived: "✅ Подтверждение оплаты получено!\n\n" +
+			"Ваша заявка отправлена на проверку администратору.\n" +
+			"Код заявки: `%s`\n\n" +
+			"После одобрения администратором вы получите уведомление и сможете создать устройства.",
+		msgRejectReasonPrompt: "Отправьте причину отклонения текстом.",
+		msgPendingReviewLimit: "⏳ У вас уже есть заявки на проверке.\n\n" +
+			"Дождитесь, пока администратор проверит их, прежде чем отправлять новое подтверждение оплаты.",
+		msgChooseDuration:    "Выберите срок подписки:",
+		msgChooseDeviceCount: "Выбран срок: %d дней\n\nВыберите количество устройств:",
+		msgOrderSummary: "📋 Ваш заказ:\n" +
+			"• Срок: %d дней\n" +
+			"• Устройств: %d\n" +
+			"%s" +
+			"• Сумма: %.2f руб.\n\n" +
+			"Есть промокод? Введите его перед оплатой.",
+		msgButtonGoToPayment:  "✅ Перейти к оплате",
+		msgButtonEnterPromo:   "🎟 Ввести промокод",
+		msgButtonWithoutPromo: "Продолжить без промокода",
+		msgPromoPrompt:        "🎟 Отправьте промокод сообщением.",
+		msgPromoInvalid:       "❌ Промокод не найден или больше не действует.\n\nОтправьте другой промокод или продолжите без него.",
+		msgPromoApplied: "🎟 Промокод %s применён!\n\n" +
+			"📋 Ваш заказ:\n" +
+			"• Срок: %d дней\n" +
+			"• Устройств: %d\n" +
+			"%s" +
+			"• Сумма: %.2f руб. (вместо %.2f руб.)",
+		msgPromoExpired:     "❌ Промокод больше не действует.",
+		msgPaymentPromoLine: "• Промокод: %s\n",
+		msgPaymentInstructions: "💳 Оплата подписки\n\n" +
+			"📋 Детали заявки:\n" +
+			"• Срок: %d дней\n" +
+			"• Устройств: %d\n" +
+			"%s%s" +
+			"• Сумма: %.2f руб.\n\n" +
+			"🔑 КОД ЗАЯВКИ:\n" +
+			"`%s`\n\n" +
+			"━━━━━━━━━━━━━━━━━━━━\n\n" +
+			"%s",
+		msgPaymentQRUnavailable: "\n\n⚠️ Не удалось сформировать QR-код для оплаты. Обратитесь к администратору за реквизитами.",
+		msgButtonCancel:         "❌ Отмена",
+		msgCancelNothing:        "Нет заявок на оплату, которые можно отменить.",
+		msgCancelInReview: "⏳ Заявка %s уже отправлена на проверку и не может быть отменена.\n" +
+			"Если вы передумали, напишите администратору.",
+		msgCancelApproved: "✅ Заявка %s уже одобрена, подписка активирована. Отменить ее нельзя.",
+		msgCancelClosed:   "Заявка %s уже закрыта (статус: %s).",
+		msgCancelFailed:   "Не удалось отменить заявку %s: она уже обрабатывается.",
+		msgCancelDone:     "❌ Заявка %s отменена.",
+		msgProofAlreadyInReview: "⏳ Ваша заявка уже на проверке!\n\n" +
+			"Код заявки: `%s`\n" +
+			"Сумма: %.2f руб.\n" +
+			"Срок: %d дней\n" +
+			"Устройств: %d\n\n" +
+			"Администратор проверит ваш платеж и одобрит его.\n" +
+			"После одобрения вы получите уведомление.",
+		msgProofChoosePayment: "У вас несколько неоплаченных заявок. Выберите ту, которую вы оплатили:",
+		msgProofNoPendingPayment: "❌ Не найдена ожидающая оплата.\n\n" +
+			"Создайте заявку через 'Оплата/Продление' в меню.",
+		msgProofPrompt: "📸 Отправьте скриншот или PDF с подтверждением оплаты ответным сообщением.\n\n" +
+			"Код заявки: `%s`\n" +
+			"Сумма: %.2f руб.\n\n" +
+			"Если у вас несколько неоплаченных заявок, укажите код заявки в подписи.\n" +
+			"Заявка будет отправлена на проверку после получения подтверждения.",
+		msgProofSentToReview: "✅ Заявка отправлена на проверку!\n\n" +
+			"📋 Ваша заявка:\n" +
+			"• Код заявки: `%s`\n" +
+			"• Сумма: %.2f руб.\n" +
+			"• Срок: %d дней\n" +
+			"• Устройств: %d\n\n" +
+			"⏳ ОЖИДАЕТ ПРОВЕРКИ АДМИНИСТРАТОРОМ\n\n" +
+			"После одобрения вы получите уведомление и VPN конфигурацию.",
+		msgAdminProofAttached:      "\n\n📎 Пользователь прикрепил подтверждение оплаты (%s)",
+		msgAdminPaymentResubmitted: "\n\n🔁 Пользователь повторно подтвердил оплату (%s)",
+		msgAdminPromoLine:          "🎟 Промокод: %s\n",
+		msgAdminNewPayment: "💳 НОВАЯ ОПЛАТА\n\n" +
+			"👤 Пользователь: @%s\n" +
+			"📆 Срок: %d дней\n" +
+			"📱 Устройств: %d\n" +
+			"%s" +
+			"💰 Сумма: %.2f ₽\n\n" +
+			"🔑 Код заявки:\n`%s`",
+		msgButtonApprove:       "✅ Подтвердить",
+		msgButtonReject:        "❌ Отклонить",
+		msgBroadcastCancelled:  "Рассылка отменена.",
+		msgAdminNoPending:      "✅ Нет ожидающих оплат.",
+		msgAdminPendingItem:    "💰 %s - %d дней, %d устр. - %.2f руб.",
+		msgAdminPendingTitle:   "📋 Ожидающие оплаты (%d)%s:",
+		msgFilterPendingReview: "⏳ На проверке",
+		msgFilterApproved:      "✅ Одобренные",
+		msgFilterRejected:      "❌ Отклоненные",
+		msgAdminPaymentsTitle:  "🗂 Оплаты со статусом %s: %d%s\n",
+		msgAdminPaymentsItem:   "\n#%d @%s - %d дней, %d устр. - %.2f руб., %s",
+		msgAuditFirstApproval:  "первое одобрение",
+		msgAuditApprovePayment: "одобрение",
+		msgAuditRejectPayment:  "отклонение",
+		msgAuditRevokeDevice:   "отзыв устройства",
+		msgAuditBanUser:        "блокировка пользователя",
+		msgAuditUnbanUser:      "разблокировка пользователя",
+		msgAuditResendConfig:   "повторная отправка конфига",
+		msgAuditCreateDevice:   "создание устройства",
+		msgAuditResyncDevice:   "синхронизация устройства",
+		msgAuditBroadcast:      "рассылка",
+		msgAuditTitle:          "📜 Журнал действий: %d%s\n",
+		msgAuditPayment:        ", платеж #%d",
+		msgAuditUser:           ", пользователь #%d",
+		msgAuditAmount:         "\n   %.2f руб.",
+		msgAuditReceivedAmount: ", получено %.2f руб.",
+		msgAdminStats: "📊 Статистика\n\n" +
+			"👥 Пользователей: %d\n" +
+			"✅ Активных подписок: %d\n" +
+			"📋 Оплат на проверке: %d\n" +
+			"💰 Выручка (одобренные оплаты): %.2f руб.\n" +
+			"📱 Активных устройств: %d\n" +
+			"🚫 Заблокировали бота: %d",
+		msgSchemaTitle:          "🗄 Схема БД\n\nПримененные миграции (%d):\n",
+		msgSchemaTableMissing:   "\n❌ %s: таблица отсутствует\n",
+		msgSchemaMissingColumns: "\n⚠️ Отсутствуют колонки: ",
+		msgSchemaColumnsOK:      "\n✅ Все колонки на месте",
+		msgHealthTitle:          "🩺 Состояние серверов WireGuard\n",
+		msgHealthDefaultServer:  "основной",
+		msgHealthOK:             "\n✅ %s: работает",
+		msgPaymentDetail: "📋 Детали оплаты:\n\n" +
+			"ID: %d\n" +
+			"Пользователь: @%s\n" +
+			"Срок: %d дней\n" +
+			"Устройств: %d\n" +
+			"Сумма: %.2f руб.\n" +
+			"Код заявки: `%s`\n\n" +
+			"⚠️ КОММЕНТАРИЙ К ПЕРЕВОДУ:\n" +
+			"`%s`\n\n" +
+			"При одобрении проверьте:\n" +
+			"✅ Сумма платежа\n" +
+			"✅ Комментарий к переводу\n" +
+			"✅ Скриншот подтверждения\n\n" +
+			"Статус: %s\n" +
+			"Создано: %s",
+		msgPaymentFirstApproval:        "\nПервое одобрение: @",
+		msgPaymentSecondApprovalNeeded: " (нужно подтверждение другого администратора)",
+		msgButtonVerifyApprove:         "✅ Проверить и одобрить",
+		msgPaymentProofCaption:         "Подтверждение оплаты",
+		msgPageLabel:                   " (стр. %d/%d)",
+		msgUnknown:                     "неизвестно",
+		msgPaymentReviewed:             "Проверил: %s, %s",
+		msgButtonApproveWithComment:    "✅ Одобрить с этим комментарием",
+		msgButtonRetry:                 "🔄 Попробовать снова",
+		msgApproveFailed:               "❌ Ошибка при одобрении:\n\n%s\n\nПроверьте комментарий к переводу.",
+		msgApproveDone:                 "✅ Платеж одобрен!\n\nПодписка активирована.",
+		msgApprovedNoDevice: "✅ Ваш платеж одобрен!\n\n" +
+			"Подписка активирована на %d дней.\n" +
+			"Вы можете создать устройства через /newkeys",
+		msgApprovedWithConfig: "✅ Ваш платеж одобрен!\n\n" +
+			"Подписка активирована на %d дней.\n" +
+			"Устройств: %d\n\n" +
+			"📱 Ваш WireGuard конфиг готов!\n" +
+			"IP адрес: %s\n\n" +
+			"Используйте QR-код для подключения на телефоне или скачайте .conf файл для ПК.",
+		msgReviewCancelled:        "⚠️ Платеж отменён пользователем.\n\nПодписка не активирована.",
+		msgReviewAlreadyProcessed: "ℹ️ Платеж уже обработан.",
+		msgReviewSecondApproval:   "🕐 Первое одобрение записано.\n\nСумма превышает порог, подписка будет активирована после одобрения другим администратором.",
+		msgReviewSameApprover:     "⚠️ Вы уже одобрили этот платеж.\n\nТребуется одобрение другого администратора.",
+		msgApproveFailedShort:     "❌ Ошибка при одобрении:\n\n%s",
+		msgRejectAlreadyProcessed: "ℹ️ Платеж уже обработан (статус: %s).",
+		msgRejectReasonRequest: "✏️ Отклонение платежа `%s`\n\n" +
+			"Отправьте причину отклонения следующим сообщением — она будет передана пользователю.",
+		msgButtonRejectNoReason: "❌ Отклонить без причины",
+		msgRejectDone:           "❌ Платеж отклонен.",
+		msgRejectReason:         "\n\nПричина: ",
+		msgRejectUserNotice:     "❌ Ваш платеж отклонен администратором.",
+		msgRejectUserSupport:    "\n\nОбратитесь в поддержку для уточнения деталей.",
+		msgDeviceSlotsLeft:      "\n\nОсталось слотов: %d",
+		msgDeviceLimitReached:   "Достигнут лимит устройств подписки. Отзовите одно из устройств или оформите продление с большим количеством устройств.",
+		msgDeviceExists:         "Это устройство уже создано, конфиг был отправлен в ответ на предыдущий запрос. Чтобы подключить еще одно устройство, повторите команду.",
+		msgImportKeyPrompt: "🔑 Отправьте публичный ключ WireGuard следующим сообщением.\n\n" +
+			"Ключ можно получить командой «wg pubkey» или в приложении WireGuard при создании туннеля. " +
+			"Приватный ключ не отправляйте.\n\nДля отмены нажмите /cancel",
+		msgImportKeyInvalid: "❌ Это не похоже на публичный ключ WireGuard: " +
+			"ожидается строка из 44 символов base64.\n\nОтправьте ключ еще раз или нажмите /cancel",
+		msgImportKeyExists: "❌ Устройство с этим ключом уже подключено. " +
+			"Сгенерируйте новую пару ключей для нового устройства.",
+		msgImportKeyDone: "✅ Устройство %s подключено.\n\n" +
+			"В конфиге нет приватного ключа: замените строку PrivateKey своим приватным ключом, " +
+			"парным к отправленному публичному, и импортируйте файл в WireGuard.",
+		msgQRUnavailable: "\n\n⚠️ Не удалось сгенерировать QR-код, импортируйте конфигурацию из файла.",
+		msgConfigLost:    "\n\n⚠️ Конфиг не удалось доставить вовремя, а повторно его создать нельзя. Обратитесь в поддержку, чтобы получить новое устройство.",
+		msgSubNone: "У вас нет активной подписки.\n\n" +
+			"Оформите подписку через «Оплата/Продление», чтобы подключить VPN.",
+		msgButtonSubscribe: "💳 Оформить подписку",
+		msgSubStatus: "📊 Ваша подписка\n\n" +
+			"Статус: %s\n" +
+			"Действует до: %s\n",
+		msgSubGrace:             "Льготный период до: %s\n",
+		msgSubDevices:           "Устройства: %d/%d (осталось слотов: %d)",
+		msgSubStatusActive:      "✅ активна",
+		msgSubStatusExpiring:    "⏰ скоро истекает",
+		msgSubStatusPaused:      "⏸ приостановлена (льготный период)",
+		msgSubStatusExpired:     "❌ истекла",
+		msgDevicesTitle:         "\n\nВаши устройства:",
+		msgDeviceMissing:        "⚠️ не найдено на сервере, обратитесь в поддержку",
+		msgDeviceNeverConnected: "⚪️ еще не подключалось",
+		msgDeviceOnline:         "🟢 в сети, %s",
+		msgDeviceOffline:        "⚪️ не в сети, последнее подключение %s, %s",
+		msgByteUnits:            "Б КБ МБ ГБ ТБ",
+		msgButtonDeviceConfig:   "📤 Конфиг %s",
+		msgResendNoKey: "❌ Конфиг устройства %s нельзя отправить повторно: " +
+			"приватный ключ хранится только в выданном ранее файле.\n\n" +
+			"Если файл утерян, создайте новое устройство через /newkeys.",
+		msgResendConfig:        "📤 Конфиг устройства %s.",
+		msgResendImportedKey:   "\n\nУстройство создано с вашим публичным ключом, поэтому в поле PrivateKey нужно вставить ваш приватный ключ.",
+		msgReassignUsage:       "Использование: /reassign <username>",
+		msgAdminUserNotFound:   "Пользователь @%s не найден.",
+		msgAdminUserIDNotFound: "Пользователь #%d не найден.",
+		msgAdminNoSubscription: "У пользователя @%s нет активной подписки.",
+		msgReassignDone: "✅ Перенесено устройств: %d\n\n" +
+			"Подписка #%d (до %s)\n" +
+			"Устройства: %d/%d",
+		msgReassignOverLimit:       "\n\n⚠️ Количество устройств превышает лимит подписки.",
+		msgReassignRenamed:         "\n\nИмя уже занято в подписке, устройства переименованы:%s",
+		msgReassignRenamedItem:     "\n%s → %s",
+		msgReassignFailed:          "\n\n❌ Не удалось перенести устройств: %d, подробности в логах.",
+		msgUserDevicesUsage:        "Использование: /userdevices <username>",
+		msgUserDevicesNone:         "У пользователя @%s нет устройств.",
+		msgUserDevicesTitle:        "📱 Устройства @%s (%d)%s:\n",
+		msgUserDevicesItem:         "\n#%d %s - %s, создано %s",
+		msgUserDevicesRevoked:      " (отозвано %s)",
+		msgButtonRevokeDevice:      "🗑 Отозвать #%d",
+		msgButtonAdminResendConfig: "📤 Переотправить конфиг",
+		msgDeviceNotFound:          "Устройство #%d не найдено.",
+		msgResyncRevoked:           "Устройство #%d отозвано, синхронизация не требуется.",
+		msgResyncFailed:            "❌ Не удалось синхронизировать устройство #%d: %s",
+		msgResyncDone:              "✅ Устройство #%d (%s, %s) добавлено на интерфейс WireGuard.",
+		msgDeviceAlreadyRevoked:    "Устройство #%d уже отозвано.",
+		msgRevokeConfirm: "🗑 Отозвать устройство #%d %s (%s)?\n\n" +
+			"Устройство будет удалено с интерфейса WireGuard, пользователь потеряет доступ к VPN с него.",
+		msgButtonRevoke: "✅ Отозвать",
+		msgButtonBack:   "◀️ Назад",
+		msgRevokeFailed: "❌ Не удалось отозвать устройство #%d: %s",
+		msgRevokeDone:   "✅ Устройство #%d (%s, %s) отозвано.",
+		msgSupportConfigResent: "📤 По вашему запросу в поддержку повторно отправлен конфиг устройства %s.\n\n" +
+			"Используйте QR-код для подключения на телефоне или скачайте .conf файл для ПК.",
+		msgSupportResendDone: "✅ Конфиг устройства #%d %s отправлен @%s.",
+		msgSupportNoDevices:  "У пользователя @%s нет активных устройств.",
+		msgSupportLatestDevice: "📤 Последнее устройство @%s: #%d %s (%s)\n\n" +
+			"Конфиг нельзя отправить повторно: приватный ключ устройства не хранится на сервере " +
+			"(хранение включается через STORE_PRIVATE_KEYS).",
+		msgSupportOfferNewDevice: "\n\nСоздать новое устройство и отправить пользователю конфиг?",
+		msgButtonCreateDevice:    "📱 Создать устройство",
+		msgAdminNewDeviceDenied:  "❌ Нельзя создать устройство для @%s:\n\n%s",
+		msgAdminNewDeviceLimit:   "❌ Нельзя создать устройство для @%s: достигнут лимит устройств подписки.",
+		msgAdminNewDeviceExists:  "Устройство %s для @%s уже создано.",
+		msgSupportNewDevice: "📱 По вашему запросу в поддержку создано новое устройство.\n\n" +
+			"Используйте QR-код для подключения на телефоне или скачайте .conf файл для ПК.",
+		msgAdminNewDeviceDone: "✅ Устройство %s (%s) создано, конфиг отправлен @%s.",
+		msgAddPromoUsage: "Использование: /addpromo <код> <скидка> [лимит использований] [срок действия в днях]\n\n" +
+			"Скидка указывается в процентах (10%) или в рублях (50).\n" +
+			"Лимит и срок 0 или не указаны - без ограничений.",
+		msgAddPromoFailed:    "❌ Не удалось создать промокод: %s",
+		msgAddPromoAmount:    "%.2f руб.",
+		msgAddPromoUnlimited: "без ограничений",
+		msgAddPromoNoExpiry:  "бессрочно",
+		msgAddPromoExpiresAt: "до %s",
+		msgAddPromoDone: "✅ Промокод %s создан\n\n" +
+			"Скидка: %s\n" +
+			"Лимит использований: %s\n" +
+			"Действует: %s",
+		msgPaymentsNone:            "У вас пока нет платежей.",
+		msgPaymentsTitle:           "🧾 История платежей (%d)%s:\n",
+		msgPaymentsItem:            "\n%s - %s\n%s, %.2f руб., %d дней, %d устр.\n",
+		msgPaymentDynamicQRCaption: "QR-код для оплаты (сумма и комментарий уже заполнены)",
+		msgAccessNoSubscription:    "У вас нет активной подписки. Оформите оплату через меню бота.",
+		msgAccessExpired:           "Ваша подписка истекла. Оформите продление через меню бота.",
+		msgAccessPaused:            "Ваша подписка приостановлена. Оформите продление через меню бота.",
+		msgAccessDeviceLimit:       "Достигнут лимит устройств (%d/%d). Отзовите одно из устройств или оформите продление с большим количеством устройств.",
+		msgAccessUserDeviceLimit:   "Достигнут общий лимит устройств на аккаунт (%d/%d). Отзовите одно из устройств.",
+		msgBroadcastProgress:       "📣 Рассылка: отправлено %d/%d, ошибок %d",
+		msgBroadcastSummary: "✅ Рассылка завершена\n\n" +
+			"Всего получателей: %d\n" +
+			"Отправлено: %d\n" +
+			"Ошибок: %d (из них заблокировали бота: %d)",
+		msgBroadcastUsage:       "Использование: /broadcast <текст сообщения>",
+		msgBroadcastPrompt:      "📣 Рассылка\n\nОтправьте текст сообщения для всех пользователей следующим сообщением.",
+		msgBroadcastEmpty:       "Отправьте текст рассылки сообщением.",
+		msgBroadcastPreview:     "📣 Сообщение будет отправлено %d пользователям:\n\n%s",
+		msgButtonSend:           "✅ Отправить",
+		msgBroadcastAlreadyDone: "Рассылка уже отправлена или отменена.",
+		msgPaymentDefaultInstructions: "📝 Инструкция:\n" +
+			"1. Отсканируйте QR-код ниже\n" +
+			"2. Оплатите нужную сумму\n" +
+			"3. В комментарии к переводу укажите КОД ЗАЯВКИ\n" +
+			"4. После оплаты нажмите «Я оплатил»\n\n" +
+			"⚠️ БЕЗ КОДА ЗАЯВКИ ПЛАТЕЖ НЕ БУДЕТ ПРИНЯТ!",
+		msgPaymentDefaultQRCaption: "QR-код для оплаты",
+		msgButtonShowAsText:        "📝 Показать текстом",
+		msgConfigTextExpired:       "Конфиг больше недоступен для показа текстом. Используйте отправленный .conf файл.",
+		msgConfigTextTooLong:       "Конфиг слишком длинный для одного сообщения. Используйте отправленный .conf файл.",
+		msgExportNoDevices:         "У вас нет активных устройств.\n\nСоздайте устройство через /newkeys.",
+		msgExportImportedKeys:      "\n\nУстройства %s созданы с вашим публичным ключом, в поле PrivateKey их конфигов нужно вставить ваш приватный ключ.",
+		msgExportSkipped:           "\n\nКонфиги устройств %s нельзя выгрузить: приватный ключ хранится только в выданном ранее файле.",
+		msgExportNothing:           "❌ Нет устройств, конфиги которых можно выгрузить.",
+		msgExportCaption:           "📦 Конфиги устройств: %d",
+		msgExportDone:              "Распакуйте архив и импортируйте нужные конфиги в WireGuard.",
+		msgReconcileTitle:          "🔧 Сверка устройств с WireGuard\n",
+		msgReconcileOK:             "\n✅ %s: расхождений нет (устройств: %d)\n",
+		msgReconcileServer:         "\n⚠️ %s (устройств: %d)\n",
+		msgReconcileMore:           "  ... и еще %d\n",
+		msgReconcileRestored:       "• Восстановлен peer #%d %s (%s)\n",
+		msgReconcileFailed:         "• ❌ Не удалось восстановить peer #%d %s (%s)\n",
+		msgReconcileOrphan:         "• Peer без устройства в БД: %s\n",
+		msgReconcileOrphansNote:    "\nPeers без устройства не удаляются автоматически: проверьте их и удалите вручную через wg.",
+		msgInvoicePromoLine:        "• Промокод: %s\n",
+		msgInvoiceDetails: "💳 Оплата подписки\n\n" +
+			"📋 Детали заявки:\n" +
+			"• Срок: %d дней\n" +
+			"• Устройств: %d\n" +
+			"%s%s" +
+			"• Сумма: %.2f руб.\n\n" +
+			"Оплатите счет ниже. Подписка активируется автоматически сразу после оплаты.",
+		msgInvoiceDescription: "Подписка на %d дней, устройств: %d",
+		msgInvoiceTitle:       "Подписка на VPN",
+		msgInvoiceExpired:     "Заявка на оплату больше не действует. Оформите новую заявку через меню бота.",
+		msgAdminInvoiceNotConfirmed: "⚠️ Оплата через Telegram не зачислена автоматически\n\n" +
+			"Пользователь: %d\n" +
+			"Сумма: %.2f %s\n" +
+			"Платеж Telegram: %s\n" +
+			"Платеж провайдера: %s\n" +
+			"Ошибка: %s\n\n" +
+			"Проверьте платеж и активируйте подписку или верните деньги.",
+		msgInvoiceNotActivated: "⚠️ Оплата получена, но подписку не удалось активировать автоматически. " +
+			"Администратор проверит платеж и свяжется с вами.",
+		msgAdminInvoicePaid: "💳 Оплата через Telegram\n\n" +
+			"Пользователь: @%s\n" +
+			"Код заявки: %s\n" +
+			"Сумма: %.2f руб., %d дней, %d устр.\n" +
+			"Платеж Telegram: %s",
+		msgAdminWebhookParseFailed: "⚠️ Не удалось обработать уведомление об оплате от %s\n\nОшибка: %s",
+		msgAdminWebhookSecondApproval: "💳 Получена оплата по заявке %s (%.2f руб.)\n\n" +
+			"Сумма превышает порог, подписка будет активирована после одобрения администратором.",
+		msgAdminWebhookNoPayment: "не найдена",
+		msgAdminWebhookNotConfirmed: "⚠️ Оплата не зачислена автоматически\n\n" +
+			"Комментарий: %s\n" +
+			"Заявка: %s\n" +
+			"Сумма: %.2f руб.\n" +
+			"Операция %s: %s\n" +
+			"Ошибка: %s\n\n" +
+			"Проверьте платеж и одобрите заявку вручную или верните деньги.",
+		msgAdminWebhookPaid: "💳 Оплата подтверждена %s\n\n" +
+			"Пользователь: @%s\n" +
+			"Код заявки: %s\n" +
+			"Сумма: %.2f руб., %d дней, %d устр.\n" +
+			"Операция: %s",
+		msgPaymentExpired: "⌛ Заявка на оплату %s истекла.\n\n" +
+			"Если вы еще не оплатили подписку, оформите новую заявку через меню бота.",
+		msgPaymentExpiredUnreviewed: "⌛ Заявка на оплату %s не была проверена вовремя и закрыта.\n\n" +
+			"Если вы оплатили ее, пожалуйста, обратитесь в поддержку.",
+		msgExpiryReminder: "⏰ Ваша подписка истекает через %d дн. (%s).\n\n" +
+			"Нажмите «Продлить», чтобы оформить продление на тех же условиях.",
+		msgGraceReminder:          "⚠️ Ваша подписка истекла. У вас есть время до %s для продления, после чего устройства будут отключены.",
+		msgButtonRenew:            "🔄 Продлить",
+		msgPaymentStatusCreated:   "🕐 ожидает оплаты",
+		msgPaymentStatusInReview:  "⏳ на проверке",
+		msgPaymentStatusApproved:  "✅ одобрен",
+		msgPaymentStatusRejected:  "❌ отклонен",
+		msgPaymentStatusExpired:   "⌛ истек",
+		msgPaymentStatusCancelled: "🚫 отменен",
+		msgBanPrompt:              "🚫 Блокировка пользователей\n\nОтправьте username пользователя следующим сообщением.",
+		msgBanUsernameEmpty:       "Отправьте username пользователя сообщением.",
+		msgButtonUnban:            "✅ Разблокировать",
+		msgBanBanned:              "🚫 Пользователь @%s заблокирован.",
+		msgButtonBan:              "🚫 Заблокировать",
+		msgButtonBanRevoke:        "🚫 Заблокировать и отозвать устройства",
+		msgBanNotBanned: "👤 Пользователь @%s не заблокирован, активных устройств: %d.\n\n" +
+			"Заблокированный пользователь не может пользоваться ботом: оплачивать подписку и создавать устройства. " +
+			"Уже созданные устройства продолжают работать, если их не отозвать.",
+		msgBanAdmin:            "❌ Администратора заблокировать нельзя.",
+		msgBanRevoked:          "\n\nОтозвано устройств: %d",
+		msgBanRevokeFailed:     ", не удалось отозвать: %d (см. /userdevices %s)",
+		msgBanUnbanned:         "✅ Пользователь @%s разблокирован.",
+		msgProvisionCooldown:   "⏳ Подождите %d сек. перед созданием следующего устройства.",
+		msgRegionLine:          "• Регион: %s\n",
+		msgRegionSelection:     "Выбран срок: %d дней\nУстройств: %d\n\nВыберите регион сервера:",
+		msgApproveAmountPrompt: "Отправьте полученную сумму числом, например 300 или 300.50",
+		msgApproveVerifyAmount: "✅ Проверьте платеж:\n\n" +
+			"Ожидаемый комментарий: `%s`\n" +
+			"Ожидаемая сумма: %.2f руб.\n\n" +
+			"Отправьте сумму, фактически полученную по переводу, следующим сообщением.\n" +
+			"Если сумма совпадает, платеж будет одобрен.",
+		msgApproveAmountMismatch: "⚠️ Сумма не совпадает!\n\n" +
+			"Ожидалось: %.2f руб.\n" +
+			"Получено: %.2f руб.\n\n" +
+			"Платеж не одобрен. Одобряйте с другой суммой, только если уверены, что оплата получена полностью.",
+		msgButtonApproveAmount: "⚠️ Одобрить с суммой %.2f руб.",
+		msgAmountMismatchError: "сумма не совпадает: получено %.2f руб., ожидалось %.2f руб.",
+	},
+	"en": {
+		msgStartText: "Welcome! Use the menu to navigate.",
+		msgMenuText:  "Choose an action:",
+		msgHelpText: "ℹ️ Available commands:\n\n" +
+			"/start - Main menu\n" +
+			"/menu - Bot menu\n" +
+			"/newkeys - Create a new device (active subscription required)\n" +
+			"/importkey - Add a device with your own public key\n" +
+			"/status - Subscription status\n" +
+			"/export - Export configs of all devices as an archive\n" +
+			"/payments - Payment history\n" +
+			"/cancel - Cancel unpaid payment request\n" +
+			"/language - Change language\n" +
+			"/help - Show this help",
+		msgHelpAdminText: "👑 Admin commands:\n\n" +
+			"/admin - Admin panel: pending payments, payment history, audit log, stats, broadcast\n" +
+			"/broadcast <text> - Send a message to all users\n" +
+			"/statsjson - Stats as JSON\n" +
+			"/userdevices <username> - User's devices\n" +
+			"/reassign <username> - Move user's devices to the current subscription\n" +
+			"/addpromo <code> <percent%|rubles> [limit] [days] - Create a promo code",
+		msgUseMenu:              "Use the menu commands or press /menu",
+		msgUnknownCommand:       "Unknown command. Use /menu",
+		msgBanned:               "🚫 Your account has been blocked by an administrator.",
+		msgLanguagePrompt:       "Choose a language:",
+		msgLanguageChanged:      "✅ Language changed to English.",
+		msgButtonPayment:        "💳 Pay/Renew",
+		msgButtonPaid:           "✅ I have paid",
+		msgButtonNewDevice:      "📱 Create device",
+		msgButtonMySub:          "📊 My subscription",
+		msgButtonHelp:           "ℹ️ Help",
+		msgButtonMenu:           "◀️ Menu",
+		msgCmdStart:             "Main menu",
+		msgCmdMenu:              "Bot menu",
+		msgCmdNewKeys:           "Create a new device",
+		msgCmdImportKey:         "Add a device with your own key",
+		msgCmdStatus:            "Subscription status",
+		msgCmdExport:            "Export configs of all devices",
+		msgCmdPayments:          "Payment history",
+		msgCmdCancel:            "Cancel unpaid payment request",
+		msgCmdHelp:              "Help",
+		msgCmdLanguage:          "Change language",
+		msgCmdAdmin:             "Admin panel",
+		msgCmdBroadcast:         "Send a message to all users",
+		msgCmdStatsJSON:         "Stats as JSON",
+		msgCmdUserDevices:       "User's devices",
+		msgCmdReassign:          "Move user's devices to the current subscription",
+		msgCmdAddPromo:          "Create a promo code",
+		msgSorry:                "Something went wrong, sorry 👉🏻👈🏻",
+		msgNotAdmin:             "❌ You don't have administrator rights.",
+		msgRateLimited:          "⏳ Too many requests, please wait a bit.",
+		msgAdminPanel:           "👑 Admin panel",
+		msgButtonDuration:       "%d days",
+		msgButtonAdminPending:   "📋 Pending payments",
+		msgButtonAdminPayments:  "🗂 Payment history",
+		msgButtonAdminAudit:     "📜 Audit log",
+		msgButtonAdminStats:     "📊 Stats",
+		msgButtonAdminBroadcast: "📣 Broadcast",
+		msgButtonAdminBan:       "🚫 Ban users",
+		msgButtonAdminSchema:    "🗄 DB schema",
+		msgButtonAdminHealth:    "🩺 Server health",
+		msgButtonAdminReconcile: "🔧 Reconcile devices",
+		msgProofDocumentsOnly:   "❌ Only photos, images and PDF files are accepted as payment proof.",
+		msgProofUnreadable:      "❌ Failed to get the file. Please send the payment screenshot again.",
+		msgUserNotFound:         "Error: user not found",
+		msgProofSaveFailed:      "Failed to save the payment proof",
+		msgProofAmbiguous:       "❓ You have several unpaid requests, and the caption doesn't tell which one you have paid:\n",
+		msgPaymentListItem:      "%s - %.2f RUB, %d days",
+		msgProofAmbiguousHint:   "\n\nSend the screenshot again with the request code in the caption.",
+		msgProofNoPayment: "❌ No payment request awaiting payment was found.\n\n" +
+			"Create a request via the 'Pay/Renew' menu, then send the payment confirmation screenshot.\n\n" +
+			"You can also put the request code in the photo caption.",
+		msgProofAlreadyProcessedStatus: "❌ Payment with code `%s` has already been processed (status: %s).",
+		msgProofAlreadyProcessed:       "❌ Payment with code `%s` has already been processed.",
+		msgProofReceived: "✅ Payment confirmation received!\n\n" +
+			"Your request has been sent to an administrator for review.\n" +
+			"Request code: `%s`\n\n" +
+			"Once it is approved, you will be notified and will be able to create devices.",
+		msgRejectReasonPrompt: "Send the rejection reason as text.",
+		msgPendingReviewLimit: "⏳ You already have requests under review.\n\n" +
+			"Please wait until an administrator reviews them before sending a new payment confirmation.",
+		msgChooseDuration:    "Choose subscription period:",
+		msgChooseDeviceCount: "Selected period: %d days\n\nChoose number of devices:",
+		msgOrderSummary: "📋 Your order:\n" +
+			"• Period: %d days\n" +
+			"• Devices: %d\n" +
+			"%s" +
+			"• Amount: %.2f RUB\n\n" +
+			"Have a promo code? Enter it before paying.",
+		msgButtonGoToPayment:  "✅ Proceed to payment",
+		msgButtonEnterPromo:   "🎟 Enter promo code",
+		msgButtonWithoutPromo: "Continue without promo code",
+		msgPromoPrompt:        "🎟 Send the promo code as a message.",
+		msgPromoInvalid:       "❌ Promo code not found or no longer valid.\n\nSend another promo code or continue without it.",
+		msgPromoApplied: "🎟 Promo code %s applied!\n\n" +
+			"📋 Your order:\n" +
+			"• Period: %d days\n" +
+			"• Devices: %d\n" +
+			"%s" +
+			"• Amount: %.2f RUB (instead of %.2f RUB)",
+		msgPromoExpired:     "❌ Promo code is no longer valid.",
+		msgPaymentPromoLine: "• Promo code: %s\n",
+		msgPaymentInstructions: "💳 Subscription payment\n\n" +
+			"📋 Request details:\n" +
+			"• Period: %d days\n" +
+			"• Devices: %d\n" +
+			"%s%s" +
+			"• Amount: %.2f RUB\n\n" +
+			"🔑 REQUEST CODE:\n" +
+			"`%s`\n\n" +
+			"━━━━━━━━━━━━━━━━━━━━\n\n" +
+			"%s",
+		msgPaymentQRUnavailable: "\n\n⚠️ Failed to generate the payment QR code. Ask an administrator for payment details.",
+		msgButtonCancel:         "❌ Cancel",
+		msgCancelNothing:        "There are no payment requests to cancel.",
+		msgCancelInReview: "⏳ Request %s has already been sent for review and can't be cancelled.\n" +
+			"If you changed your mind, contact an administrator.",
+		msgCancelApproved: "✅ Request %s has already been approved and the subscription is active. It can't be cancelled.",
+		msgCancelClosed:   "Request %s is already closed (status: %s).",
+		msgCancelFailed:   "Failed to cancel request %s: it is already being processed.",
+		msgCancelDone:     "❌ Request %s cancelled.",
+		msgProofAlreadyInReview: "⏳ Your request is already under review!\n\n" +
+			"Request code: `%s`\n" +
+			"Amount: %.2f RUB\n" +
+			"Period: %d days\n" +
+			"Devices: %d\n\n" +
+			"An administrator will check your payment and approve it.\n" +
+			"You will be notified once it is approved.",
+		msgProofChoosePayment: "You have several unpaid requests. Choose the one you have paid:",
+		msgProofNoPendingPayment: "❌ No payment request awaiting payment was found.\n\n" +
+			"Create a request via 'Pay/Renew' in the menu.",
+		msgProofPrompt: "📸 Send a screenshot or PDF confirming the payment in reply.\n\n" +
+			"Request code: `%s`\n" +
+			"Amount: %.2f RUB\n\n" +
+			"If you have several unpaid requests, put the request code in the caption.\n" +
+			"The request will be sent for review once the confirmation is received.",
+		msgProofSentToReview: "✅ Request sent for review!\n\n" +
+			"📋 Your request:\n" +
+			"• Request code: `%s`\n" +
+			"• Amount: %.2f RUB\n" +
+			"• Period: %d days\n" +
+			"• Devices: %d\n\n" +
+			"⏳ AWAITING ADMINISTRATOR REVIEW\n\n" +
+			"Once it is approved, you will get a notification and the VPN configuration.",
+		msgAdminProofAttached:      "\n\n📎 User attached a payment confirmation (%s)",
+		msgAdminPaymentResubmitted: "\n\n🔁 User confirmed the payment again (%s)",
+		msgAdminPromoLine:          "🎟 Promo code: %s\n",
+		msgAdminNewPayment: "💳 NEW PAYMENT\n\n" +
+			"👤 User: @%s\n" +
+			"📆 Period: %d days\n" +
+			"📱 Devices: %d\n" +
+			"%s" +
+			"💰 Amount: %.2f ₽\n\n" +
+			"🔑 Request code:\n`%s`",
+		msgButtonApprove:       "✅ Approve",
+		msgButtonReject:        "❌ Reject",
+		msgBroadcastCancelled:  "Broadcast cancelled.",
+		msgAdminNoPending:      "✅ No pending payments.",
+		msgAdminPendingItem:    "💰 %s - %d days, %d dev. - %.2f RUB",
+		msgAdminPendingTitle:   "📋 Pending payments (%d)%s:",
+		msgFilterPendingReview: "⏳ Under review",
+		msgFilterApproved:      "✅ Approved",
+		msgFilterRejected:      "❌ Rejected",
+		msgAdminPaymentsTitle:  "🗂 Payments with status %s: %d%s\n",
+		msgAdminPaymentsItem:   "\n#%d @%s - %d days, %d dev. - %.2f RUB, %s",
+		msgAuditFirstApproval:  "first approval",
+		msgAuditApprovePayment: "approval",
+		msgAuditRejectPayment:  "rejection",
+		msgAuditRevokeDevice:   "device revocation",
+		msgAuditBanUser:        "user ban",
+		msgAuditUnbanUser:      "user unban",
+		msgAuditResendConfig:   "config resend",
+		msgAuditCreateDevice:   "device creation",
+		msgAuditResyncDevice:   "device resync",
+		msgAuditBroadcast:      "broadcast",
+		msgAuditTitle:          "📜 Audit log: %d%s\n",
+		msgAuditPayment:        ", payment #%d",
+		msgAuditUser:           ", user #%d",
+		msgAuditAmount:         "\n   %.2f RUB",
+		msgAuditReceivedAmount: ", received %.2f RUB",
+		msgAdminStats: "📊 Stats\n\n" +
+			"👥 Users: %d\n" +
+			"✅ Active subscriptions: %d\n" +
+			"📋 Payments under review: %d\n" +
+			"💰 Revenue (approved payments): %.2f RUB\n" +
+			"📱 Active devices: %d\n" +
+			"🚫 Blocked the bot: %d",
+		msgSchemaTitle:          "🗄 DB schema\n\nApplied migrations (%d):\n",
+		msgSchemaTableMissing:   "\n❌ %s: table is missing\n",
+		msgSchemaMissingColumns: "\n⚠️ Missing columns: ",
+		msgSchemaColumnsOK:      "\n✅ All columns are in place",
+		msgHealthTitle:          "🩺 WireGuard server health\n",
+		msgHealthDefaultServer:  "default",
+		msgHealthOK:             "\n✅ %s: up",
+		msgPaymentDetail: "📋 Payment details:\n\n" +
+			"ID: %d\n" +
+			"User: @%s\n" +
+			"Period: %d days\n" +
+			"Devices: %d\n" +
+			"Amount: %.2f RUB\n" +
+			"Request code: `%s`\n\n" +
+			"⚠️ TRANSFER COMMENT:\n" +
+			"`%s`\n\n" +
+			"Before approving check:\n" +
+			"✅ Payment amount\n" +
+			"✅ Transfer comment\n" +
+			"✅ Confirmation screenshot\n\n" +
+			"Status: %s\n" +
+			"Created: %s",
+		msgPaymentFirstApproval:        "\nFirst approval: @",
+		msgPaymentSecondApprovalNeeded: " (another administrator's approval is required)",
+		msgButtonVerifyApprove:         "✅ Verify and approve",
+		msgPaymentProofCaption:         "Payment confirmation",
+		msgPageLabel:                   " (page %d/%d)",
+		msgUnknown:                     "unknown",
+		msgPaymentReviewed:             "Reviewed by: %s, %s",
+		msgButtonApproveWithComment:    "✅ Approve with this comment",
+		msgButtonRetry:                 "🔄 Try again",
+		msgApproveFailed:               "❌ Approval failed:\n\n%s\n\nCheck the transfer comment.",
+		msgApproveDone:                 "✅ Payment approved!\n\nSubscription activated.",
+		msgApprovedNoDevice: "✅ Your payment has been approved!\n\n" +
+			"Subscription activated for %d days.\n" +
+			"You can create devices with /newkeys",
+		msgApprovedWithConfig: "✅ Your payment has been approved!\n\n" +
+			"Subscription activated for %d days.\n" +
+			"Devices: %d\n\n" +
+			"📱 Your WireGuard config is ready!\n" +
+			"IP address: %s\n\n" +
+			"Scan the QR code to connect on a phone or download the .conf file for a PC.",
+		msgReviewCancelled:        "⚠️ Payment was cancelled by the user.\n\nSubscription is not activated.",
+		msgReviewAlreadyProcessed: "ℹ️ Payment has already been processed.",
+		msgReviewSecondApproval:   "🕐 First approval recorded.\n\nThe amount exceeds the threshold, the subscription will be activated after another administrator approves it.",
+		msgReviewSameApprover:     "⚠️ You have already approved this payment.\n\nAnother administrator's approval is required.",
+		msgApproveFailedShort:     "❌ Approval failed:\n\n%s",
+		msgRejectAlreadyProcessed: "ℹ️ Payment has already been processed (status: %s).",
+		msgRejectReasonRequest: "✏️ Rejecting payment `%s`\n\n" +
+			"Send the rejection reason in the next message, it will be passed to the user.",
+		msgButtonRejectNoReason: "❌ Reject without reason",
+		msgRejectDone:           "❌ Payment rejected.",
+		msgRejectReason:         "\n\nReason: ",
+		msgRejectUserNotice:     "❌ Your payment has been rejected by an administrator.",
+		msgRejectUserSupport:    "\n\nContact support for details.",
+		msgDeviceSlotsLeft:      "\n\nSlots left: %d",
+		msgDeviceLimitReached:   "Subscription device limit reached. Revoke one of the devices or renew with more devices.",
+		msgDeviceExists:         "This device has already been created, its config was sent in reply to the previous request. To add one more device, repeat the command.",
+		msgImportKeyPrompt: "🔑 Send your WireGuard public key in the next message.\n\n" +
+			"You can get the key with the «wg pubkey» command or in the WireGuard app when creating a tunnel. " +
+			"Don't send the private key.\n\nPress /cancel to cancel",
+		msgImportKeyInvalid: "❌ This doesn't look like a WireGuard public key: " +
+			"a 44 character base64 string is expected.\n\nSend the key again or press /cancel",
+		msgImportKeyExists: "❌ A device with this key is already connected. " +
+			"Generate a new key pair for a new device.",
+		msgImportKeyDone: "✅ Device %s connected.\n\n" +
+			"The config has no private key: replace the PrivateKey line with your private key " +
+			"matching the public one you sent, and import the file into WireGuard.",
+		msgQRUnavailable: "\n\n⚠️ Failed to generate the QR code, import the configuration from the file.",
+		msgConfigLost:    "\n\n⚠️ The config couldn't be delivered in time and can't be created again. Contact support to get a new device.",
+		msgSubNone: "You have no active subscription.\n\n" +
+			"Subscribe via «Payment/Renewal» to connect to the VPN.",
+		msgButtonSubscribe: "💳 Subscribe",
+		msgSubStatus: "📊 Your subscription\n\n" +
+			"Status: %s\n" +
+			"Valid until: %s\n",
+		msgSubGrace:             "Grace period until: %s\n",
+		msgSubDevices:           "Devices: %d/%d (slots left: %d)",
+		msgSubStatusActive:      "✅ active",
+		msgSubStatusExpiring:    "⏰ expiring soon",
+		msgSubStatusPaused:      "⏸ paused (grace period)",
+		msgSubStatusExpired:     "❌ expired",
+		msgDevicesTitle:         "\n\nYour devices:",
+		msgDeviceMissing:        "⚠️ not found on the server, contact support",
+		msgDeviceNeverConnected: "⚪️ never connected",
+		msgDeviceOnline:         "🟢 online, %s",
+		msgDeviceOffline:        "⚪️ offline, last connected %s, %s",
+		msgByteUnits:            "B KB MB GB TB",
+		msgButtonDeviceConfig:   "📤 Config %s",
+		msgResendNoKey: "❌ Config of device %s can't be sent again: " +
+			"the private key is kept only in the file issued earlier.\n\n" +
+			"If the file is lost, create a new device with /newkeys.",
+		msgResendConfig:        "📤 Config of device %s.",
+		msgResendImportedKey:   "\n\nThe device was created with your public key, so put your private key into the PrivateKey field.",
+		msgReassignUsage:       "Usage: /reassign <username>",
+		msgAdminUserNotFound:   "User @%s not found.",
+		msgAdminUserIDNotFound: "User #%d not found.",
+		msgAdminNoSubscription: "User @%s has no active subscription.",
+		msgReassignDone: "✅ Devices moved: %d\n\n" +
+			"Subscription #%d (until %s)\n" +
+			"Devices: %d/%d",
+		msgReassignOverLimit:       "\n\n⚠️ Device count exceeds the subscription limit.",
+		msgReassignRenamed:         "\n\nName is already taken in the subscription, devices renamed:%s",
+		msgReassignRenamedItem:     "\n%s → %s",
+		msgReassignFailed:          "\n\n❌ Failed to move devices: %d, see logs for details.",
+		msgUserDevicesUsage:        "Usage: /userdevices <username>",
+		msgUserDevicesNone:         "User @%s has no devices.",
+		msgUserDevicesTitle:        "📱 Devices of @%s (%d)%s:\n",
+		msgUserDevicesItem:         "\n#%d %s - %s, created %s",
+		msgUserDevicesRevoked:      " (revoked %s)",
+		msgButtonRevokeDevice:      "🗑 Revoke #%d",
+		msgButtonAdminResendConfig: "📤 Resend config",
+		msgDeviceNotFound:          "Device #%d not found.",
+		msgResyncRevoked:           "Device #%d is revoked, no sync needed.",
+		msgResyncFailed:            "❌ Failed to sync device #%d: %s",
+		msgResyncDone:              "✅ Device #%d (%s, %s) added to the WireGuard interface.",
+		msgDeviceAlreadyRevoked:    "Device #%d is already revoked.",
+		msgRevokeConfirm: "🗑 Revoke device #%d %s (%s)?\n\n" +
+			"The device will be removed from the WireGuard interface, the user will lose VPN access from it.",
+		msgButtonRevoke: "✅ Revoke",
+		msgButtonBack:   "◀️ Back",
+		msgRevokeFailed: "❌ Failed to revoke device #%d: %s",
+		msgRevokeDone:   "✅ Device #%d (%s, %s) revoked.",
+		msgSupportConfigResent: "📤 As requested via support, the config of device %s is sent again.\n\n" +
+			"Use the QR code to connect on a phone or download the .conf file for a PC.",
+		msgSupportResendDone: "✅ Config of device #%d %s sent to @%s.",
+		msgSupportNoDevices:  "User @%s has no active devices.",
+		msgSupportLatestDevice: "📤 Latest device of @%s: #%d %s (%s)\n\n" +
+			"The config can't be sent again: the device private key isn't stored on the server " +
+			"(storing is enabled with STORE_PRIVATE_KEYS).",
+		msgSupportOfferNewDevice: "\n\nCreate a new device and send its config to the user?",
+		msgButtonCreateDevice:    "📱 Create device",
+		msgAdminNewDeviceDenied:  "❌ Can't create a device for @%s:\n\n%s",
+		msgAdminNewDeviceLimit:   "❌ Can't create a device for @%s: the subscription device limit is reached.",
+		msgAdminNewDeviceExists:  "Device %s for @%s is already created.",
+		msgSupportNewDevice: "📱 As requested via support, a new device is created.\n\n" +
+			"Use the QR code to connect on a phone or download the .conf file for a PC.",
+		msgAdminNewDeviceDone: "✅ Device %s (%s) created, config sent to @%s.",
+		msgAddPromoUsage: "Usage: /addpromo <code> <discount> [usage limit] [valid days]\n\n" +
+			"Discount is set in percent (10%) or in rubles (50).\n" +
+			"Limit and validity of 0 or omitted mean no restriction.",
+		msgAddPromoFailed:    "❌ Failed to create the promo code: %s",
+		msgAddPromoAmount:    "%.2f RUB",
+		msgAddPromoUnlimited: "unlimited",
+		msgAddPromoNoExpiry:  "no expiry",
+		msgAddPromoExpiresAt: "until %s",
+		msgAddPromoDone: "✅ Promo code %s created\n\n" +
+			"Discount: %s\n" +
+			"Usage limit: %s\n" +
+			"Valid: %s",
+		msgPaymentsNone:            "You have no payments yet.",
+		msgPaymentsTitle:           "🧾 Payment history (%d)%s:\n",
+		msgPaymentsItem:            "\n%s - %s\n%s, %.2f RUB, %d days, %d dev.\n",
+		msgPaymentDynamicQRCaption: "Payment QR code (amount and comment are already filled in)",
+		msgAccessNoSubscription:    "You have no active subscription. Pay for one via the bot menu.",
+		msgAccessExpired:           "Your subscription has expired. Renew it via the bot menu.",
+		msgAccessPaused:            "Your subscription is paused. Renew it via the bot menu.",
+		msgAccessDeviceLimit:       "Device limit reached (%d/%d). Revoke one of the devices or renew with more devices.",
+		msgAccessUserDeviceLimit:   "Account-wide device limit reached (%d/%d). Revoke one of the devices.",
+		msgBroadcastProgress:       "📣 Broadcast: sent %d/%d, errors %d",
+		msgBroadcastSummary: "✅ Broadcast finished\n\n" +
+			"Total recipients: %d\n" +
+			"Sent: %d\n" +
+			"Errors: %d (blocked the bot: %d)",
+		msgBroadcastUsage:       "Usage: /broadcast <message text>",
+		msgBroadcastPrompt:      "📣 Broadcast\n\nSend the message text for all users in the next message.",
+		msgBroadcastEmpty:       "Send the broadcast text as a message.",
+		msgBroadcastPreview:     "📣 The message will be sent to %d users:\n\n%s",
+		msgButtonSend:           "✅ Send",
+		msgBroadcastAlreadyDone: "The broadcast has already been sent or cancelled.",
+		msgPaymentDefaultInstructions: "📝 Instructions:\n" +
+			"1. Scan the QR code below\n" +
+			"2. Pay the required amount\n" +
+			"3. Put the REQUEST CODE into the transfer comment\n" +
+			"4. After paying, tap «I have paid»\n\n" +
+			"⚠️ PAYMENTS WITHOUT THE REQUEST CODE WON'T BE ACCEPTED!",
+		msgPaymentDefaultQRCaption: "Payment QR code",
+		msgButtonShowAsText:        "📝 Show as text",
+		msgConfigTextExpired:       "The config is no longer available as text. Use the .conf file sent earlier.",
+		msgConfigTextTooLong:       "The config is too long for a single message. Use the .conf file sent earlier.",
+		msgExportNoDevices:         "You have no active devices.\n\nCreate a device with /newkeys.",
+		msgExportImportedKeys:      "\n\nDevices %s were created with your public key, put your private key into the PrivateKey field of their configs.",
+		msgExportSkipped:           "\n\nConfigs of devices %s can't be exported: the private key is kept only in the file issued earlier.",
+		msgExportNothing:           "❌ No devices whose configs can be exported.",
+		msgExportCaption:           "📦 Device configs: %d",
+		msgExportDone:              "Unpack the archive and import the configs you need into WireGuard.",
+		msgReconcileTitle:          "🔧 Device reconciliation with WireGuard\n",
+		msgReconcileOK:             "\n✅ %s: no mismatches (devices: %d)\n",
+		msgReconcileServer:         "\n⚠️ %s (devices: %d)\n",
+		msgReconcileMore:           "  ... and %d more\n",
+		msgReconcileRestored:       "• Restored peer #%d %s (%s)\n",
+		msgReconcileFailed:         "• ❌ Failed to restore peer #%d %s (%s)\n",
+		msgReconcileOrphan:         "• Peer without a device in the DB: %s\n",
+		msgReconcileOrphansNote:    "\nPeers without a device aren't removed automatically: check them and remove them manually with wg.",
+		msgInvoicePromoLine:        "• Promo code: %s\n",
+		msgInvoiceDetails: "💳 Subscription payment\n\n" +
+			"📋 Request details:\n" +
+			"• Period: %d days\n" +
+			"• Devices: %d\n" +
+			"%s%s" +
+			"• Amount: %.2f RUB\n\n" +
+			"Pay the invoice below. The subscription is activated automatically right after payment.",
+		msgInvoiceDescription: "Subscription for %d days, devices: %d",
+		msgInvoiceTitle:       "VPN subscription",
+		msgInvoiceExpired:     "The payment request is no longer valid. Create a new one via the bot menu.",
+		msgAdminInvoiceNotConfirmed: "⚠️ Telegram payment wasn't credited automatically\n\n" +
+			"User: %d\n" +
+			"Amount: %.2f %s\n" +
+			"Telegram charge: %s\n" +
+			"Provider charge: %s\n" +
+			"Error: %s\n\n" +
+			"Check the payment and activate the subscription or refund the money.",
+		msgInvoiceNotActivated: "⚠️ Payment received, but the subscription couldn't be activated automatically. " +
+			"An administrator will check the payment and contact you.",
+		msgAdminInvoicePaid: "💳 Telegram payment\n\n" +
+			"User: @%s\n" +
+			"Request code: %s\n" +
+			"Amount: %.2f RUB, %d days, %d dev.\n" +
+			"Telegram charge: %s",
+		msgAdminWebhookParseFailed: "⚠️ Failed to process payment notification from %s\n\nError: %s",
+		msgAdminWebhookSecondApproval: "💳 Payment received for request %s (%.2f RUB)\n\n" +
+			"The amount exceeds the threshold, the subscription will be activated after admin approval.",
+		msgAdminWebhookNoPayment: "not found",
+		msgAdminWebhookNotConfirmed: "⚠️ Payment wasn't credited automatically\n\n" +
+			"Comment: %s\n" +
+			"Request: %s\n" +
+			"Amount: %.2f RUB\n" +
+			"Operation %s: %s\n" +
+			"Error: %s\n\n" +
+			"Check the payment and approve the request manually or refund the money.",
+		msgAdminWebhookPaid: "💳 Payment confirmed by %s\n\n" +
+			"User: @%s\n" +
+			"Request code: %s\n" +
+			"Amount: %.2f RUB, %d days, %d dev.\n" +
+			"Operation: %s",
+		msgPaymentExpired: "⌛ Payment request %s has expired.\n\n" +
+			"If you haven't paid for the subscription yet, create a new request via the bot menu.",
+		msgPaymentExpiredUnreviewed: "⌛ Payment request %s wasn't reviewed in time and is closed.\n\n" +
+			"If you have paid it, please contact support.",
+		msgExpiryReminder: "⏰ Your subscription expires in %d days (%s).\n\n" +
+			"Tap «Renew» to renew it on the same terms.",
+		msgGraceReminder:          "⚠️ Your subscription has expired. You have until %s to renew it, after that your devices will be disconnected.",
+		msgButtonRenew:            "🔄 Renew",
+		msgPaymentStatusCreated:   "🕐 awaiting payment",
+		msgPaymentStatusInReview:  "⏳ in review",
+		msgPaymentStatusApproved:  "✅ approved",
+		msgPaymentStatusRejected:  "❌ rejected",
+		msgPaymentStatusExpired:   "⌛ expired",
+		msgPaymentStatusCancelled: "🚫 cancelled",
+		msgBanPrompt:              "🚫 Blocking users\n\nSend the user's username in the next message.",
+		msgBanUsernameEmpty:       "Send the user's username as a message.",
+		msgButtonUnban:            "✅ Unblock",
+		msgBanBanned:              "🚫 User @%s is blocked.",
+		msgButtonBan:              "🚫 Block",
+		msgButtonBanRevoke:        "🚫 Block and revoke devices",
+		msgBanNotBanned: "👤 User @%s isn't blocked, active devices: %d.\n\n" +
+			"A blocked user can't use the bot: pay for a subscription or create devices. " +
+			"Devices created earlier keep working unless revoked.",
+		msgBanAdmin:            "❌ An administrator can't be blocked.",
+		msgBanRevoked:          "\n\nDevices revoked: %d",
+		msgBanRevokeFailed:     ", failed to revoke: %d (see /userdevices %s)",
+		msgBanUnbanned:         "✅ User @%s is unblocked.",
+		msgProvisionCooldown:   "⏳ Wait %d s before creating the next device.",
+		msgRegionLine:          "• Region: %s\n",
+		msgRegionSelection:     "Chosen period: %d days\nDevices: %d\n\nChoose the server region:",
+		msgApproveAmountPrompt: "Send the received amount as a number, e.g. 300 or 300.50",
+		msgApproveVerifyAmount: "✅ Check the payment:\n\n" +
+			"Expected comment: `%s`\n" +
+			"Expected amount: %.2f RUB\n\n" +
+			"Send the amount actually received by the transfer in the next message.\n" +
+			"If the amount matches, the payment will be approved.",
+		msgApproveAmountMismatch: "⚠️ Amount doesn't match!\n\n" +
+			"Expected: %.2f RUB\n" +
+			"Received: %.2f RUB\n\n" +
+			"The payment is not approved. Approve with a different amount only if you are sure the payment was received in full.",
+		msgButtonApproveAmount: "⚠️ Approve with amount %.2f RUB",
+		msgAmountMismatchError: "amount doesn't match: received %.2f RUB, expected %.2f RUB",
+	},
+}
+
+// userLanguage returns user's language, falling back to default one
+func userLanguage(user *storage.User) string {
+	if user == nil || !isSupportedLanguage(user.Language) {
+		return defaultLanguage
+	}
+	return user.Language
+}
+
+// chatLanguage returns language of the user owning private chat, for replies built without user at hand
+func (b *Bot) chatLanguage(chatID int64) string {
+	user, err := b.repo.GetUserByTelegramID(b.opsCtx, chatID)
+	if err != nil {
+		log.Printf("failed to get user %d language: %v", chatID, err)
+	}
+	return userLanguage(user)
+}
+
+// Translator looks up messages in the catalog
+type Translator struct {
+	messages map[string]map[string]string
+}
+
+// NewTranslator creates translator over the built-in message catalog
+func NewTranslator() *Translator {
+	return &Translator{messages: catalog}
+}
+
+// T returns message in given language, falling back to default language and then to key itself
+func (t *Translator) T(lang string, key string) string {
+	if msg, ok := t.messages[lang][key]; ok {
+		return msg
+	}
+	if msg, ok := t.messages[defaultLanguage][key]; ok {
+		return msg
+	}
+	return key
+}
+
+// Tf formats message in given language with args, message is looked up as in T
+func (t *Translator) Tf(lang string, key string, args ...interface{}) string {
+	return fmt.Sprintf(t.T(lang, key), args...)
+}
@@ -0,0 +1,135 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/billing"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// invoicePayloadPrefix prefixes payment ID in invoice payload
+const invoicePayloadPrefix = "payment:"
+
+// telegramPaymentsReviewer is recorded as reviewer of payments approved by Telegram Payments
+const telegramPaymentsReviewer = "telegram_payments"
+
+// invoicesEnabled reports whether payments are made with Telegram Payments invoices instead of manual transfer
+func (b *Bot) invoicesEnabled() bool {
+	return b.paymentToken != ""
+}
+
+// parseInvoicePayload returns payment ID from invoice payload
+func parseInvoicePayload(payload string) (int64, bool) {
+	if !strings.HasPrefix(payload, invoicePayloadPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(payload, invoicePayloadPrefix), 10, 64)
+	return id, err == nil
+}
+
+// invoiceResponses shows payment details and sends invoice for the payment
+func (b *Bot) invoiceResponses(chatID int64, msgID int, lang string, payment *storage.Payment) responses {
+	promoLine := ""
+	if payment.PromoCode != "" {
+		promoLine = b.tr.Tf(lang, msgInvoicePromoLine, payment.PromoCode)
+	}
+	text := b.tr.Tf(lang, msgInvoiceDetails,
+		payment.DurationDays, payment.DeviceCount, b.regionLine(lang, payment.ServerID), promoLine, float64(payment.Amount)/100.0)
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(b.tr.T(lang, msgButtonCancel), fmt.Sprintf("payment_cancel:%d", payment.ID)),
+		),
+	)
+	res.ReplyMarkup = &keyboard
+
+	description := b.tr.Tf(lang, msgInvoiceDescription, payment.DurationDays, payment.DeviceCount)
+	invoice := tgbotapi.NewInvoice(chatID, b.tr.T(lang, msgInvoiceTitle), description,
+		invoicePayloadPrefix+strconv.FormatInt(payment.ID, 10), b.paymentToken, payment.ReferenceCode,
+		billing.ProviderCurrency, []tgbotapi.LabeledPrice{{Label: description, Amount: payment.Amount}})
+	return responses{res, invoice}
+}
+
+// handlePreCheckoutQuery confirms checkout only for user's payment that still awaits payment with the same amount.
+// Telegram requires the answer within 10 seconds, otherwise the checkout fails
+func (b *Bot) handlePreCheckoutQuery(query *tgbotapi.PreCheckoutQuery) error {
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+
+	var checkErr error
+	paymentID, ok := parseInvoicePayload(query.InvoicePayload)
+	if !ok {
+		checkErr = errors.Errorf("unknown invoice payload %q", query.InvoicePayload)
+	} else if user, err := b.repo.GetUserByTelegramID(b.opsCtx, int64(query.From.ID)); err != nil || user == nil {
+		checkErr = errors.Errorf("user %d not found: %v", query.From.ID, err)
+	} else {
+		_, checkErr = b.billing.CheckProviderPayment(b.opsCtx, paymentID, user.ID, query.TotalAmount, query.Currency)
+	}
+	if checkErr != nil {
+		log.Printf("pre-checkout query %s declined: %v", query.ID, checkErr)
+		answer.OK = false
+		answer.ErrorMessage = b.tr.T(b.chatLanguage(int64(query.From.ID)), msgInvoiceExpired)
+	}
+
+	if _, err := b.sender.Request(answer); err != nil {
+		return errors.Wrap(err, "failed to answer pre-checkout query")
+	}
+	return nil
+}
+
+// handleSuccessfulPayment approves payment charged by Telegram Payments and provisions the first device
+// the same way admin approval does. Admins are notified about the payment, and asked to check it if it
+// can't be approved, because user has been charged anyway
+func (b *Bot) handleSuccessfulPayment(msg *tgbotapi.Message) (responses, error) {
+	ctx := b.opsCtx
+	paid := msg.SuccessfulPayment
+	log.Printf("successful payment %s from user %d: %d %s, payload %q",
+		paid.TelegramPaymentChargeID, msg.From.ID, paid.TotalAmount, paid.Currency, paid.InvoicePayload)
+
+	paymentID, ok := parseInvoicePayload(paid.InvoicePayload)
+	var payment *storage.Payment
+	err := errors.Errorf("unknown invoice payload %q", paid.InvoicePayload)
+	if ok {
+		payment, err = b.billing.ConfirmProviderPayment(ctx, paymentID, telegramPaymentsReviewer, paid.TelegramPaymentChargeID, paid.TotalAmount, paid.Currency)
+	}
+	if errors.Is(err, billing.ErrPaymentAlreadyProcessed) {
+		log.Printf("payment %d of charge %s is already approved", paymentID, paid.TelegramPaymentChargeID)
+		return nil, nil
+	}
+	if err != nil {
+		b.notifyAdmins(func(lang string) string {
+			return b.tr.Tf(lang, msgAdminInvoiceNotConfirmed,
+				msg.From.ID, float64(paid.TotalAmount)/100.0, paid.Currency,
+				paid.TelegramPaymentChargeID, paid.ProviderPaymentChargeID, err.Error())
+		})
+		text := b.tr.T(b.chatLanguage(msg.Chat.ID), msgInvoiceNotActivated)
+		return responses{tgbotapi.NewMessage(msg.Chat.ID, text)}, errors.Wrapf(err, "failed to confirm charge %s", paid.TelegramPaymentChargeID)
+	}
+
+	user, err := b.repo.GetUserByID(ctx, payment.UserID)
+	if err != nil || user == nil {
+		return responses{b.errorMessage(msg.Chat.ID, 0, false)}, errors.Errorf("user %d of payment %d not found: %v", payment.UserID, payment.ID, err)
+	}
+	b.notifyAdmins(func(lang string) string {
+		return b.tr.Tf(lang, msgAdminInvoicePaid,
+			user.Username, payment.ReferenceCode, float64(payment.Amount)/100.0, payment.DurationDays, payment.DeviceCount,
+			paid.TelegramPaymentChargeID)
+	})
+
+	b.provisionApprovedPayment(ctx, payment, user)
+	return nil, nil
+}
+
+// notifyAdmins sends plain text message to all admin chats known to the bot, rendered by text in admin's language
+func (b *Bot) notifyAdmins(text func(lang string) string) {
+	for _, chatID := range b.getAdminChatIDs() {
+		if err := b.send(tgbotapi.NewMessage(chatID, text(b.chatLanguage(chatID)))); err != nil {
+			log.Printf("failed to notify admin chat %d: %v", chatID, err)
+		}
+	}
+}
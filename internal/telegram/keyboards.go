@@ -2,75 +2,153 @@ package telegram
 
 import (
 	"fmt"
+	"strconv"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/billing"
 )
 
-func (cmd command) button() tgbotapi.InlineKeyboardButton {
-	return tgbotapi.NewInlineKeyboardButtonData(cmd.Description, cmd.Command)
-}
+// maxDeviceCountButtons keeps device count keyboard within Telegram's inline keyboard size limit
+const maxDeviceCountButtons = billing.MaxPlanDevices
 
 var (
-	mainMenuKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("💳 Оплата/Продление", "payment"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✅ Я оплатил", "payment_proof"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("📱 Создать устройство", ConfigForNewKeysCmd.Command),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("ℹ️ Помощь", HelpCmd.Command),
-		),
-	)
+	// Main menu keyboard factory
+	localizedMainMenuKeyboard = func(tr *Translator, lang string) *tgbotapi.InlineKeyboardMarkup {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonPayment), "payment"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonPaid), "payment_proof"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonNewDevice), ConfigForNewKeysCmd.Command),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonMySub), SubscriptionCmd.Command),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonHelp), HelpCmd.Command),
+			),
+		)
+		return &keyboard
+	}
 
-	goToMenuButton = tgbotapi.NewInlineKeyboardButtonData("◀️ Меню", MenuCmd.Command)
+	// Help keyboard factory
+	localizedHelpKeyboard = func(tr *Translator, lang string) *tgbotapi.InlineKeyboardMarkup {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonMenu), MenuCmd.Command),
+			),
+		)
+		return &keyboard
+	}
 
-	helpKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(goToMenuButton),
-	)
+	// Language selection keyboard
+	languageKeyboard = func() tgbotapi.InlineKeyboardMarkup {
+		buttons := make([]tgbotapi.InlineKeyboardButton, 0, len(languages))
+		for _, lang := range languages {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(lang.name, "lang:"+lang.code))
+		}
+		return tgbotapi.NewInlineKeyboardMarkup(buttons)
+	}()
 
-	// Payment duration selection
-	durationKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("30 дней", "duration:30"),
-			tgbotapi.NewInlineKeyboardButtonData("90 дней", "duration:90"),
-			tgbotapi.NewInlineKeyboardButtonData("180 дней", "duration:180"),
-		),
-		tgbotapi.NewInlineKeyboardRow(goToMenuButton),
-	)
+	// Menu button factory
+	menuButton = func(tr *Translator, lang string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonMenu), MenuCmd.Command)
+	}
+
+	// Payment duration selection keyboard factory
+	durationKeyboard = func(tr *Translator, lang string, durations []int) *tgbotapi.InlineKeyboardMarkup {
+		buttons := make([]tgbotapi.InlineKeyboardButton, 0, len(durations))
+		for _, d := range durations {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(tr.Tf(lang, msgButtonDuration, d), fmt.Sprintf("duration:%d", d)))
+		}
+		rows := buttonRows(buttons, 3)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(menuButton(tr, lang)))
+		return &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: rows}
+	}
 
-	// Device count keyboard factory
-	deviceCountKeyboardForDuration = func(duration int) *tgbotapi.InlineKeyboardMarkup {
-		return &tgbotapi.InlineKeyboardMarkup{
-			InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
-				{
-					tgbotapi.NewInlineKeyboardButtonData("1", fmt.Sprintf("devices:1:%d", duration)),
-					tgbotapi.NewInlineKeyboardButtonData("2", fmt.Sprintf("devices:2:%d", duration)),
-					tgbotapi.NewInlineKeyboardButtonData("3", fmt.Sprintf("devices:3:%d", duration)),
-				},
-				{
-					tgbotapi.NewInlineKeyboardButtonData("4", fmt.Sprintf("devices:4:%d", duration)),
-					tgbotapi.NewInlineKeyboardButtonData("5", fmt.Sprintf("devices:5:%d", duration)),
-				},
-				{goToMenuButton},
-			},
+	// Device count keyboard factory: 3 buttons per row for small limits, 5 per row for larger ones
+	deviceCountKeyboardForDuration = func(tr *Translator, lang string, duration int, maxDevices int) *tgbotapi.InlineKeyboardMarkup {
+		if maxDevices > maxDeviceCountButtons {
+			maxDevices = maxDeviceCountButtons
 		}
+		buttons := make([]tgbotapi.InlineKeyboardButton, 0, maxDevices)
+		for n := 1; n <= maxDevices; n++ {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(n), fmt.Sprintf("devices:%d:%d", n, duration)))
+		}
+		perRow := 3
+		if maxDevices > 9 {
+			perRow = 5
+		}
+		rows := buttonRows(buttons, perRow)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(menuButton(tr, lang)))
+		return &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: rows}
 	}
 
-	// Admin keyboard
-	adminKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("📋 Ожидающие оплаты", "admin:pending"),
-		),
-		tgbotapi.NewInlineKeyboardRow(goToMenuButton),
-	)
+	// Renewal keyboard factory: suggested plan is carried in callback data as renew:<duration>:<devices>
+	renewKeyboard = func(tr *Translator, lang string, duration int, deviceCount int) tgbotapi.InlineKeyboardMarkup {
+		return tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonRenew), fmt.Sprintf("renew:%d:%d", duration, deviceCount)),
+			),
+			tgbotapi.NewInlineKeyboardRow(menuButton(tr, lang)),
+		)
+	}
+
+	// Admin keyboard factory
+	localizedAdminKeyboard = func(tr *Translator, lang string) *tgbotapi.InlineKeyboardMarkup {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminPending), "admin:pending"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminPayments), "admin:payments:approved"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminAudit), "admin:audit"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminStats), "admin:stats"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminBroadcast), "admin:broadcast"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminBan), "admin:ban"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminSchema), "admin:schema"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminHealth), "admin:health"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr.T(lang, msgButtonAdminReconcile), "admin:reconcile"),
+			),
+			tgbotapi.NewInlineKeyboardRow(menuButton(tr, lang)),
+		)
+		return &keyboard
+	}
 )
 
 func init() {
-	StartCmd.keyboard = &mainMenuKeyboard
-	MenuCmd.keyboard = &mainMenuKeyboard
-	HelpCmd.keyboard = &helpKeyboard
+	StartCmd.keyboard = localizedMainMenuKeyboard
+	MenuCmd.keyboard = localizedMainMenuKeyboard
+	HelpCmd.keyboard = localizedHelpKeyboard
+}
+
+// buttonRows lays out buttons in rows of at most perRow buttons
+func buttonRows(buttons []tgbotapi.InlineKeyboardButton, perRow int) [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for len(buttons) > perRow {
+		rows = append(rows, buttons[:perRow])
+		buttons = buttons[perRow:]
+	}
+	if len(buttons) > 0 {
+		rows = append(rows, buttons)
+	}
+	return rows
 }
@@ -0,0 +1,13 @@
+package telegram
+
+import "strings"
+
+// markdownEscaper escapes characters that start entities in Telegram legacy Markdown
+var markdownEscaper = strings.NewReplacer(`_`, `\_`, `*`, `\*`, "`", "\\`", `[`, `\[`)
+
+// escapeMarkdown escapes dynamic value inserted into a Markdown message outside of entities,
+// so e.g. a username with "_" neither breaks formatting nor fails the whole message.
+// Inside `code` entities characters can't be escaped and are shown as is
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
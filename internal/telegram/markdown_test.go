@@ -0,0 +1,41 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "alice", want: "alice"},
+		{in: "john_doe", want: `john\_doe`},
+		{in: "__init__", want: `\_\_init\_\_`},
+		{in: "*bold*", want: `\*bold\*`},
+		{in: "`code`", want: "\\`code\\`"},
+		{in: "[link](url)", want: `\[link](url)`},
+		{in: "PROMO_2026*", want: `PROMO\_2026\*`},
+		{in: "тихий лес 42", want: "тихий лес 42"},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := escapeMarkdown(tt.in); got != tt.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAdminPaymentNotificationTextEscapesUsername(t *testing.T) {
+	payment := &storage.Payment{DurationDays: 30, DeviceCount: 1, Amount: 30000, ReferenceCode: "AB12_CD", PromoCode: "SPRING_SALE"}
+	text := adminPaymentNotificationText(NewTranslator(), defaultLanguage, payment, "john_doe")
+
+	for _, want := range []string{`@john\_doe`, `SPRING\_SALE`, "`AB12_CD`"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("notification doesn't contain %q:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package telegram
+
+import (
+	"io"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/provisioning"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// ErrUserBlocked is returned for notifications that can't be delivered because user has blocked the bot
+// or their chat no longer exists. Such notifications aren't retried
+var ErrUserBlocked = errors.New("user has blocked the bot")
+
+// handleUndelivered handles notification that failed to deliver: user who has blocked the bot is marked blocked
+// and notification is dropped, otherwise it's queued for retry
+func (b *Bot) handleUndelivered(chatID int64, text string, device *storage.Device, config []byte, sendErr error) {
+	if isBlockedError(sendErr) {
+		b.markBlocked(chatID, sendErr)
+		return
+	}
+	b.enqueueNotification(chatID, text, device, config, sendErr)
+}
+
+// markBlocked records that user can't receive messages, so scheduler and broadcasts skip them
+// until they write to the bot again
+func (b *Bot) markBlocked(chatID int64, sendErr error) {
+	log.Printf("Chat %d is unreachable, marking user blocked: %v", chatID, sendErr)
+	if err := b.repo.MarkUserBlocked(b.opsCtx, chatID); err != nil {
+		log.Printf("failed to mark user %d blocked: %v", chatID, err)
+	}
+}
+
+// enqueueNotification stores notification that failed to deliver, scheduler retries it later.
+// config of device is sent as QR code and file after text, device is nil for plain text notifications.
+// Config contains private key, so only device is stored and config itself is kept in memory
+// until delivered. After restart it is rebuilt from the device, if its private key is stored
+func (b *Bot) enqueueNotification(chatID int64, text string, device *storage.Device, config []byte, sendErr error) {
+	notification := &storage.Notification{
+		ChatID:    chatID,
+		Text:      text,
+		Attempts:  1,
+		LastError: sendErr.Error(),
+	}
+	if device != nil {
+		notification.DeviceID = &device.ID
+		notification.DeviceName = device.DeviceName
+	}
+	if err := b.repo.EnqueueNotification(b.opsCtx, notification); err != nil {
+		log.Printf("failed to enqueue notification for chat %d: %v", chatID, err)
+		return
+	}
+	if device != nil {
+		b.stateMutex.Lock()
+		b.queuedConfigs[notification.ID] = config
+		b.stateMutex.Unlock()
+	}
+	log.Printf("Notification %d for chat %d queued for retry: %v", notification.ID, chatID, sendErr)
+}
+
+// sendConfig sends text followed by config QR code and file of the device, queueing everything for retry
+// if text or file can't be delivered. QR code is best effort, file has the same content
+func (b *Bot) sendConfig(chatID int64, text string, device *storage.Device, content []byte) {
+	if err := b.deliver(chatID, text, device.DeviceName, content); err != nil {
+		b.handleUndelivered(chatID, text, device, content, err)
+	}
+}
+
+// DeliverNotification sends queued notification, it is called by scheduler on retry.
+// ErrUserBlocked is returned if user has blocked the bot since notification was queued
+func (b *Bot) DeliverNotification(notification *storage.Notification) error {
+	text := notification.Text
+	config, err := b.queuedConfig(notification)
+	if err != nil {
+		return err
+	}
+	if notification.DeviceID != nil && config == nil {
+		// Config can't be rebuilt on retry
+		text += b.tr.T(b.chatLanguage(notification.ChatID), msgConfigLost)
+	}
+	err = b.deliver(notification.ChatID, text, notification.DeviceName, config)
+	if err == nil || isBlockedError(err) {
+		b.DropNotification(notification)
+	}
+	if isBlockedError(err) {
+		b.markBlocked(notification.ChatID, err)
+		return errors.Wrap(ErrUserBlocked, err.Error())
+	}
+	return err
+}
+
+// DropNotification forgets config of queued notification, it is called by scheduler when notification
+// is given up. Config contains private key and mustn't stay in memory once it won't be delivered
+func (b *Bot) DropNotification(notification *storage.Notification) {
+	b.stateMutex.Lock()
+	delete(b.queuedConfigs, notification.ID)
+	b.stateMutex.Unlock()
+}
+
+// queuedConfig returns config of queued notification: the one kept in memory since it was queued,
+// otherwise rebuilt from its device. nil is returned for plain text notifications
+// and for configs that can't be rebuilt: device is revoked or its private key isn't stored
+func (b *Bot) queuedConfig(notification *storage.Notification) ([]byte, error) {
+	if notification.Config != "" {
+		// Queued by older version that stored configs in the outbox
+		return []byte(notification.Config), nil
+	}
+	if notification.DeviceID == nil {
+		return nil, nil
+	}
+	b.stateMutex.Lock()
+	config, ok := b.queuedConfigs[notification.ID]
+	b.stateMutex.Unlock()
+	if ok {
+		return config, nil
+	}
+
+	device, err := b.repo.GetDeviceByID(b.opsCtx, *notification.DeviceID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get device %d", *notification.DeviceID)
+	}
+	if device == nil || device.RevokedAt != nil {
+		return nil, nil
+	}
+	cfg, _, err := b.wireguard.RecreateConfig(b.opsCtx, device)
+	if errors.Is(err, provisioning.ErrPrivateKeyNotStored) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to recreate config of device %d", device.ID)
+	}
+	return io.ReadAll(cfg)
+}
+
+// deliver sends text and, if config is set, its QR code and file named by deviceName
+func (b *Bot) deliver(chatID int64, text, deviceName string, config []byte) error {
+	var qr tgbotapi.Chattable
+	if config != nil {
+		if qr = b.createQR(chatID, config); qr == nil {
+			// Config file is delivered anyway
+			text += b.tr.T(b.chatLanguage(chatID), msgQRUnavailable)
+		}
+	}
+	if _, err := b.sender.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		return errors.Wrap(err, "failed to send text")
+	}
+	if config == nil {
+		return nil
+	}
+	if qr != nil {
+		if err := b.send(qr); err != nil {
+			log.Printf("failed to send config QR code to chat %d: %v", chatID, err)
+		}
+	}
+	if _, err := b.sender.Send(b.createFile(chatID, deviceName, config)); err != nil {
+		return errors.Wrap(err, "failed to send config file")
+	}
+	return nil
+}
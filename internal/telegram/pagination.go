@@ -0,0 +1,62 @@
+package telegram
+
+import (
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// listPageSize is the number of item buttons on a single page of an inline keyboard list.
+// Telegram rejects keyboards with too many buttons, so long lists are always paginated
+const listPageSize = 10
+
+// listPage describes a single page of a list: items [start, end) on page index out of count pages
+type listPage struct {
+	index int
+	count int
+	start int
+	end   int
+}
+
+// paginate splits total items into pages of size and returns page with given index,
+// clamped to existing pages. An empty list has a single empty page
+func paginate(total, index, size int) listPage {
+	count := (total + size - 1) / size
+	if count == 0 {
+		count = 1
+	}
+	if index >= count {
+		index = count - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+
+	start := index * size
+	end := start + size
+	if end > total {
+		end = total
+	}
+	return listPage{index: index, count: count, start: start, end: end}
+}
+
+// label returns page position for list header, empty for single page lists
+func (p listPage) label(tr *Translator, lang string) string {
+	if p.count <= 1 {
+		return ""
+	}
+	return tr.Tf(lang, msgPageLabel, p.index+1, p.count)
+}
+
+// navRow returns ◀/▶ buttons switching pages, callback data is callbackPrefix followed by page index.
+// Returns nil for single page lists
+func (p listPage) navRow(callbackPrefix string) []tgbotapi.InlineKeyboardButton {
+	var row []tgbotapi.InlineKeyboardButton
+	if p.index > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("◀️", fmt.Sprintf("%s%d", callbackPrefix, p.index-1)))
+	}
+	if p.index+1 < p.count {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("▶️", fmt.Sprintf("%s%d", callbackPrefix, p.index+1)))
+	}
+	return row
+}
@@ -0,0 +1,55 @@
+package telegram
+
+import "testing"
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name         string
+		total, index int
+		want         listPage
+	}{
+		{name: "empty list", total: 0, index: 0, want: listPage{index: 0, count: 1, start: 0, end: 0}},
+		{name: "empty list with page out of range", total: 0, index: 3, want: listPage{index: 0, count: 1, start: 0, end: 0}},
+		{name: "single partial page", total: 3, index: 0, want: listPage{index: 0, count: 1, start: 0, end: 3}},
+		{name: "single full page", total: 10, index: 0, want: listPage{index: 0, count: 1, start: 0, end: 10}},
+		{name: "first of two pages", total: 11, index: 0, want: listPage{index: 0, count: 2, start: 0, end: 10}},
+		{name: "last partial page", total: 11, index: 1, want: listPage{index: 1, count: 2, start: 10, end: 11}},
+		{name: "last full page", total: 30, index: 2, want: listPage{index: 2, count: 3, start: 20, end: 30}},
+		{name: "page after the last", total: 25, index: 5, want: listPage{index: 2, count: 3, start: 20, end: 25}},
+		{name: "negative page", total: 25, index: -1, want: listPage{index: 0, count: 3, start: 0, end: 10}},
+	}
+	for _, tt := range tests {
+		if got := paginate(tt.total, tt.index, 10); got != tt.want {
+			t.Errorf("%s: paginate(%d, %d, 10) = %+v, want %+v", tt.name, tt.total, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestListPageNavigation(t *testing.T) {
+	tests := []struct {
+		name      string
+		page      listPage
+		wantLabel string
+		wantData  []string
+	}{
+		{name: "single page", page: paginate(5, 0, 10), wantLabel: "", wantData: nil},
+		{name: "first page", page: paginate(25, 0, 10), wantLabel: " (стр. 1/3)", wantData: []string{"list:1"}},
+		{name: "middle page", page: paginate(25, 1, 10), wantLabel: " (стр. 2/3)", wantData: []string{"list:0", "list:2"}},
+		{name: "last page", page: paginate(25, 2, 10), wantLabel: " (стр. 3/3)", wantData: []string{"list:1"}},
+	}
+	for _, tt := range tests {
+		if label := tt.page.label(NewTranslator(), defaultLanguage); label != tt.wantLabel {
+			t.Errorf("%s: label() = %q, want %q", tt.name, label, tt.wantLabel)
+		}
+		row := tt.page.navRow("list:")
+		if len(row) != len(tt.wantData) {
+			t.Errorf("%s: navRow() has %d buttons, want %d", tt.name, len(row), len(tt.wantData))
+			continue
+		}
+		for i, button := range row {
+			if button.CallbackData == nil || *button.CallbackData != tt.wantData[i] {
+				t.Errorf("%s: button %d callback data %v, want %s", tt.name, i, button.CallbackData, tt.wantData[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package telegram
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// paymentTexts holds payment instructions and static QR caption, which depend on the payment provider.
+// Empty values mean catalog defaults in user's language.
+// Placeholders {amount} and {code} are replaced with payment amount and reference code
+type paymentTexts struct {
+	instructions string
+	qrCaption    string
+}
+
+// paymentTextsFromEnv reads PAYMENT_INSTRUCTIONS and PAYMENT_QR_CAPTION environment variables,
+// unset values are left empty to fall back to catalog defaults. "\n" in values is replaced with line break,
+// so multiline instructions can be set in a single line
+func paymentTextsFromEnv() paymentTexts {
+	var texts paymentTexts
+	if v := strings.TrimSpace(os.Getenv("PAYMENT_INSTRUCTIONS")); v != "" {
+		texts.instructions = strings.ReplaceAll(v, `\n`, "\n")
+	}
+	if v := strings.TrimSpace(os.Getenv("PAYMENT_QR_CAPTION")); v != "" {
+		texts.qrCaption = strings.ReplaceAll(v, `\n`, "\n")
+	}
+	return texts
+}
+
+// instructionsText returns configured payment instructions or the default ones in lang
+func (t paymentTexts) instructionsText(tr *Translator, lang string) string {
+	if t.instructions != "" {
+		return t.instructions
+	}
+	return tr.T(lang, msgPaymentDefaultInstructions)
+}
+
+// qrCaptionText returns configured static QR caption or the default one in lang
+func (t paymentTexts) qrCaptionText(tr *Translator, lang string) string {
+	if t.qrCaption != "" {
+		return t.qrCaption
+	}
+	return tr.T(lang, msgPaymentDefaultQRCaption)
+}
+
+// render replaces placeholders in text with values of the payment
+func (t paymentTexts) render(text string, payment *storage.Payment) string {
+	return strings.NewReplacer(
+		"{amount}", fmt.Sprintf("%.2f", float64(payment.Amount)/100.0),
+		"{code}", payment.ReferenceCode,
+	).Replace(text)
+}
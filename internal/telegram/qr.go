@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	"github.com/yeqown/go-qrcode"
+)
+
+const (
+	defaultQRLogoPath = "assets/logo-min.png"
+	defaultQRWidth    = 7
+)
+
+type qrConfig struct {
+	logoPath string // PNG logo drawn in the middle of QR codes, empty to render without logo
+	width    uint8  // Width of a single QR block in pixels
+}
+
+// qrConfigFromEnv reads QR code settings from QR_LOGO_PATH and QR_WIDTH environment variables.
+// Unset QR_LOGO_PATH falls back to the bundled logo, empty one disables the logo.
+// A missing logo file is not an error, QR codes are rendered without it
+func qrConfigFromEnv() (qrConfig, error) {
+	cfg := qrConfig{logoPath: defaultQRLogoPath, width: defaultQRWidth}
+	if v, ok := os.LookupEnv("QR_LOGO_PATH"); ok {
+		cfg.logoPath = v
+	}
+	if v := os.Getenv("QR_WIDTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 255 {
+			return cfg, errors.Errorf("invalid QR_WIDTH value: %s, must be in [1, 255]", v)
+		}
+		cfg.width = uint8(n)
+	}
+	if cfg.logoPath != "" {
+		if _, err := os.Stat(cfg.logoPath); err != nil {
+			log.Printf("QR logo '%s' is not available, QR codes are rendered without logo: %v", cfg.logoPath, err)
+			cfg.logoPath = ""
+		}
+	}
+	return cfg, nil
+}
+
+// createQR renders content into a QR code photo, nil if it can't be generated
+func (b *Bot) createQR(chatID int64, content []byte) tgbotapi.Chattable {
+	buf, err := b.generateQR(content)
+	if err != nil {
+		log.Printf("failed to create qr code: %v", err)
+		return nil
+	}
+	name := strconv.FormatInt(time.Now().Unix(), 10)
+	return tgbotapi.NewPhoto(chatID, tgbotapi.FileReader{
+		Name:   name + ".png",
+		Reader: buf,
+	})
+}
+
+// generateQR renders content into a PNG QR code
+func (b *Bot) generateQR(content []byte) (*bytes.Buffer, error) {
+	options := []qrcode.ImageOption{
+		qrcode.WithQRWidth(b.qr.width),
+		qrcode.WithBuiltinImageEncoder(qrcode.PNG_FORMAT),
+	}
+	if b.qr.logoPath != "" {
+		options = append(options, qrcode.WithLogoImageFilePNG(b.qr.logoPath))
+	}
+	qrc, err := qrcode.New(string(content), options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create qr code")
+	}
+	buf := &bytes.Buffer{}
+	if err := qrc.SaveTo(buf); err != nil {
+		return nil, errors.Wrap(err, "failed to read new qr code")
+	}
+	return buf, nil
+}
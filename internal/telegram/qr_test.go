@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// oversizedConfig doesn't fit into a QR code
+var oversizedConfig = []byte("[Interface]\nPrivateKey = " + strings.Repeat("A", 5000) + "\n")
+
+func TestCreateQR(t *testing.T) {
+	bot, _ := newTestBot(t)
+	if qr := bot.createQR(1, []byte("[Interface]\nAddress = 10.0.0.2/32\n")); qr == nil {
+		t.Errorf("createQR() = nil for valid config")
+	}
+	if qr := bot.createQR(1, oversizedConfig); qr != nil {
+		t.Errorf("createQR() = %T for config that doesn't fit into QR code, want nil", qr)
+	}
+}
+
+func TestDeliverConfigWithoutQR(t *testing.T) {
+	bot, sender := newTestBot(t)
+
+	if err := bot.deliver(1, "config is ready", "device_1", oversizedConfig); err != nil {
+		t.Fatalf("deliver() failed: %v", err)
+	}
+
+	sent := sender.messages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want text and config file", len(sent))
+	}
+	msg, ok := sent[0].(tgbotapi.MessageConfig)
+	if !ok || msg.Text != "config is ready"+bot.tr.T(defaultLanguage, msgQRUnavailable) {
+		t.Errorf("first message %+v, want text with QR unavailable note", sent[0])
+	}
+	if !sentConfigFile(sender, 1) {
+		t.Errorf("config file wasn't sent")
+	}
+}
+
+func TestPaymentConfirmWithoutQR(t *testing.T) {
+	bot, _ := newTestBot(t)
+	ctx := context.Background()
+	from := testUser(100, "alice")
+	user, err := bot.repo.GetOrCreateUser(ctx, from.ID, from.UserName)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	// Neither bank requisites nor PAYMENT_QR_PATH are configured
+	resps, err := bot.handlePaymentConfirm(ctx, from.ID, 1, user, 1, 30, "", "")
+	if err != nil {
+		t.Fatalf("handlePaymentConfirm() failed: %v", err)
+	}
+	if len(resps) != 1 {
+		t.Fatalf("got %d responses, want payment details only", len(resps))
+	}
+	edit, ok := resps[0].(tgbotapi.EditMessageTextConfig)
+	if !ok || !strings.Contains(edit.Text, "QR-код") || edit.ReplyMarkup == nil {
+		t.Fatalf("response %+v, want payment details with QR note and buttons", resps[0])
+	}
+
+	// Payment stays valid, user can pay by requisites
+	payments, err := bot.repo.GetPaymentsByUserIDAndStatus(ctx, user.ID, storage.PaymentStatusCreated)
+	if err != nil {
+		t.Fatalf("failed to get payments: %v", err)
+	}
+	if len(payments) != 1 || !strings.Contains(edit.Text, payments[0].ReferenceCode) {
+		t.Errorf("created payments %v, want the one shown to user", payments)
+	}
+}
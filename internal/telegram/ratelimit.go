@@ -0,0 +1,113 @@
+package telegram
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRateLimitPerMinute = 30
+	defaultRateLimitBurst     = 10
+
+	// rateLimitPruneSize is the bucket count above which idle buckets are dropped
+	rateLimitPruneSize = 10000
+)
+
+// tokenBucket tracks request budget of a single user
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	notified bool // User was already told to slow down
+}
+
+// rateLimiter is a per-user token bucket limiter keyed by Telegram ID
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens per second
+	burst   float64
+	buckets map[int64]*tokenBucket
+}
+
+// newRateLimiter creates limiter allowing perMinute requests per minute with given burst.
+// Zero perMinute disables limiting
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[int64]*tokenBucket),
+	}
+}
+
+// rateLimiterFromEnv creates limiter from RATE_LIMIT_PER_MINUTE and RATE_LIMIT_BURST
+// environment variables, falling back to defaults for unset values
+func rateLimiterFromEnv() (*rateLimiter, error) {
+	perMinute, burst := defaultRateLimitPerMinute, defaultRateLimitBurst
+	if v := os.Getenv("RATE_LIMIT_PER_MINUTE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid RATE_LIMIT_PER_MINUTE value: %s", v)
+		}
+		perMinute = n
+	}
+	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, errors.Errorf("invalid RATE_LIMIT_BURST value: %s", v)
+		}
+		burst = n
+	}
+	return newRateLimiter(perMinute, burst), nil
+}
+
+// allow takes a token from user's bucket. It reports whether request is allowed and,
+// for rejected requests, whether this is the first rejection since user was last allowed
+func (l *rateLimiter) allow(userID int64, now time.Time) (allowed bool, firstRejection bool) {
+	if l == nil {
+		return true, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[userID]
+	if !ok {
+		if len(l.buckets) >= rateLimitPruneSize {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[userID] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.updated).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		firstRejection = !bucket.notified
+		bucket.notified = true
+		return false, firstRejection
+	}
+	bucket.tokens--
+	bucket.notified = false
+	return true, false
+}
+
+// prune drops buckets that have refilled completely, they are equal to fresh ones
+func (l *rateLimiter) prune(now time.Time) {
+	for id, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, id)
+		}
+	}
+}
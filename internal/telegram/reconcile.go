@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/storage"
+	"github.com/skoret/wireguard-bot/internal/wireguard"
+)
+
+// maxReconcileListed limits devices and peers listed per server in reconciliation report
+const maxReconcileListed = 10
+
+// logReconcile writes reconciliation results to log, including orphan peers omitted from admin report
+func logReconcile(results []wireguard.ServerReconcile) {
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("reconciliation of server %q failed: %v", result.Server.ID, result.Err)
+			continue
+		}
+		report := result.Report
+		log.Printf("reconciled server %q: %d active devices, %d peers restored, %d failed, %d orphan peers",
+			result.Server.ID, report.Checked, len(report.Restored), len(report.Failed), len(report.Orphans))
+		for _, key := range report.Orphans {
+			log.Printf("orphan peer %s on server %q has no active device", key, result.Server.ID)
+		}
+	}
+}
+
+// reconcileText describes reconciliation results for admins
+func (b *Bot) reconcileText(lang string, results []wireguard.ServerReconcile) string {
+	var sb strings.Builder
+	sb.WriteString(b.tr.T(lang, msgReconcileTitle))
+	orphans := false
+	for _, result := range results {
+		name := result.Server.Name
+		if name == "" {
+			name = b.tr.T(lang, msgHealthDefaultServer)
+		}
+		if result.Err != nil {
+			sb.WriteString(fmt.Sprintf("\n❌ %s: %s\n", name, result.Err.Error()))
+			continue
+		}
+
+		report := result.Report
+		if len(report.Restored) == 0 && len(report.Failed) == 0 && len(report.Orphans) == 0 {
+			sb.WriteString(b.tr.Tf(lang, msgReconcileOK, name, report.Checked))
+			continue
+		}
+		sb.WriteString(b.tr.Tf(lang, msgReconcileServer, name, report.Checked))
+		for i, device := range report.Restored {
+			if i == maxReconcileListed {
+				sb.WriteString(b.tr.Tf(lang, msgReconcileMore, len(report.Restored)-i))
+				break
+			}
+			sb.WriteString(b.tr.Tf(lang, msgReconcileRestored, device.ID, device.DeviceName, device.AssignedIP))
+		}
+		for i, device := range report.Failed {
+			if i == maxReconcileListed {
+				sb.WriteString(b.tr.Tf(lang, msgReconcileMore, len(report.Failed)-i))
+				break
+			}
+			sb.WriteString(b.tr.Tf(lang, msgReconcileFailed, device.ID, device.DeviceName, device.AssignedIP))
+		}
+		orphans = orphans || len(report.Orphans) > 0
+		for i, key := range report.Orphans {
+			if i == maxReconcileListed {
+				sb.WriteString(b.tr.Tf(lang, msgReconcileMore, len(report.Orphans)-i))
+				break
+			}
+			sb.WriteString(b.tr.Tf(lang, msgReconcileOrphan, key))
+		}
+	}
+	if orphans {
+		sb.WriteString(b.tr.T(lang, msgReconcileOrphansNote))
+	}
+	return sb.String()
+}
+
+// handleAdminReconcile compares active devices with peers on WireGuard interfaces,
+// re-adds missing peers and reports orphan ones
+func (b *Bot) handleAdminReconcile(ctx context.Context, chatID int64, msgID int, user *storage.User) (responses, error) {
+	results := b.wireguard.Reconcile(ctx)
+	logReconcile(results)
+	log.Printf("Devices reconciled by %s", user.Username)
+
+	lang := userLanguage(user)
+	text := b.reconcileText(lang, results)
+	if runes := []rune(text); len(runes) > maxMessageLength {
+		text = string(runes[:maxMessageLength])
+	}
+	res := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	res.ReplyMarkup = localizedAdminKeyboard(b.tr, lang)
+	return responses{res}, nil
+}
@@ -0,0 +1,104 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/skoret/wireguard-bot/internal/provisioning"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// regionChoice reports whether users choose a server, i.e. more than one server is configured
+func (b *Bot) regionChoice() bool {
+	return len(b.wireguard.Servers()) > 1
+}
+
+// findServer returns configured server with the ID, false if there is no such server
+func (b *Bot) findServer(serverID string) (provisioning.Server, bool) {
+	for _, server := range b.wireguard.Servers() {
+		if server.ID == serverID {
+			return server, true
+		}
+	}
+	return provisioning.Server{}, false
+}
+
+// regionLine returns order summary line with chosen server, empty if users don't choose servers
+func (b *Bot) regionLine(lang string, serverID string) string {
+	if !b.regionChoice() {
+		return ""
+	}
+	server, ok := b.findServer(serverID)
+	if !ok {
+		return ""
+	}
+	return b.tr.Tf(lang, msgRegionLine, server.Name)
+}
+
+// regionKeyboard lists servers to choose for the selected plan
+func (b *Bot) regionKeyboard(lang string, deviceCount int, duration int) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, server := range b.wireguard.Servers() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🌍 "+server.Name, fmt.Sprintf("region:%d:%d:%s", deviceCount, duration, server.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(menuButton(b.tr, lang)))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &keyboard
+}
+
+// regionSelectionText is shown above region keyboard
+func (b *Bot) regionSelectionText(lang string, duration int, deviceCount int) string {
+	return b.tr.Tf(lang, msgRegionSelection, duration, deviceCount)
+}
+
+// planStep returns the step following device count selection: region selection if users choose servers,
+// order summary otherwise
+func (b *Bot) planStep(lang string, duration int, deviceCount int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if b.regionChoice() {
+		return b.regionSelectionText(lang, duration, deviceCount), b.regionKeyboard(lang, deviceCount, duration)
+	}
+	text, keyboard := b.orderSummary(lang, duration, deviceCount, "")
+	return text, &keyboard
+}
+
+// handleRegionSelection shows order summary for the chosen server. Outdated plan or server
+// falls back to the previous steps of the payment flow
+func (b *Bot) handleRegionSelection(ctx context.Context, chatID int64, msgID int, user *storage.User, deviceCount int, duration int, serverID string) (responses, error) {
+	plans := b.billing.Plans()
+	if _, ok := b.findServer(serverID); !ok || !plans.AllowsDuration(duration) || !plans.AllowsDeviceCount(deviceCount) {
+		return b.handleDeviceCountSelection(ctx, chatID, msgID, user, deviceCount, duration)
+	}
+
+	text, keyboard := b.orderSummary(userLanguage(user), duration, deviceCount, serverID)
+	edit := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	edit.ReplyMarkup = &keyboard
+	return responses{edit}, nil
+}
+
+// callbackDataMaxLen is Telegram limit of inline button callback data, in bytes
+const callbackDataMaxLen = 64
+
+// appliedPromoMarker stands in confirm callback data for promo code applied in the chat.
+// Promo code itself is kept in chat state, with it callback data could exceed callbackDataMaxLen
+const appliedPromoMarker = "*"
+
+// confirmCallbackData returns order confirmation callback data: confirm:<devices>:<duration>[:<promo>[:<server>]],
+// promo part is appliedPromoMarker if promo code applied in the chat is used, empty otherwise
+func confirmCallbackData(deviceCount int, duration int, withPromo bool, serverID string) string {
+	data := fmt.Sprintf("confirm:%d:%d", deviceCount, duration)
+	promo := ""
+	if withPromo {
+		promo = appliedPromoMarker
+	}
+	if promo != "" || serverID != "" {
+		data += ":" + promo
+	}
+	if serverID != "" {
+		data += ":" + serverID
+	}
+	return data
+}
@@ -0,0 +1,93 @@
+package telegram
+
+// pendingInput describes a text message the bot expects next in a chat
+type pendingInput struct {
+	action  string // What the next text message is used for
+	id      int64  // Related entity ID (payment ID, etc.)
+	msgID   int    // Message that requested the input
+	caption bool   // Message that requested the input has media, its text is a caption
+
+	// Selected plan and server, for promo code input
+	deviceCount int
+	duration    int
+	serverID    string
+}
+
+const (
+	inputRejectionReason = "rejection_reason"
+	inputPromoCode       = "promo_code"
+	inputBroadcastText   = "broadcast_text"
+	inputPublicKey       = "public_key"
+	inputBanUsername     = "ban_username"
+	inputApproveAmount   = "approve_amount"
+)
+
+// setPendingInput remembers which text input is expected next in chat
+func (b *Bot) setPendingInput(chatID int64, input pendingInput) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	b.pendingInputs[chatID] = input
+}
+
+// popPendingInput returns and clears expected text input for chat
+func (b *Bot) popPendingInput(chatID int64) (pendingInput, bool) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	input, ok := b.pendingInputs[chatID]
+	if ok {
+		delete(b.pendingInputs, chatID)
+	}
+	return input, ok
+}
+
+// markPendingInputCaption notes that message which requested the input in chat has media,
+// so it must be edited by caption
+func (b *Bot) markPendingInputCaption(chatID int64, msgID int) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	if input, ok := b.pendingInputs[chatID]; ok && input.msgID == msgID {
+		input.caption = true
+		b.pendingInputs[chatID] = input
+	}
+}
+
+// clearPendingInput drops expected text input for chat
+func (b *Bot) clearPendingInput(chatID int64) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	delete(b.pendingInputs, chatID)
+}
+
+// setAppliedPromo remembers promo code applied to the order summary in chat, so it's
+// not carried in callback data
+func (b *Bot) setAppliedPromo(chatID int64, code string) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	b.appliedPromos[chatID] = code
+}
+
+// appliedPromo returns promo code applied to the order summary in chat
+func (b *Bot) appliedPromo(chatID int64) (string, bool) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	code, ok := b.appliedPromos[chatID]
+	return code, ok
+}
+
+// setBroadcastDraft remembers broadcast text awaiting admin confirmation in chat
+func (b *Bot) setBroadcastDraft(chatID int64, text string) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	b.broadcastDrafts[chatID] = text
+}
+
+// popBroadcastDraft returns and clears broadcast text awaiting confirmation in chat
+func (b *Bot) popBroadcastDraft(chatID int64) (string, bool) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	text, ok := b.broadcastDrafts[chatID]
+	if ok {
+		delete(b.broadcastDrafts, chatID)
+	}
+	return text, ok
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
@@ -16,17 +19,53 @@ import (
 	"github.com/skoret/wireguard-bot/internal/wireguard"
 )
 
+// defaultShutdownTimeout is how long running handlers are waited for on shutdown
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownCancelGrace is how long cancelled handlers are waited for to roll back after shutdown timeout
+const shutdownCancelGrace = 5 * time.Second
+
+// Sender is the part of Telegram API used to send messages and make requests.
+// *tgbotapi.BotAPI satisfies it, tests may capture outgoing messages with their own implementation
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
 type Bot struct {
-	wg            *sync.WaitGroup
-	api           *tgbotapi.BotAPI
-	wireguard     wireguard.Wireguard
-	admins        map[string]struct{}      // Admin usernames
-	adminChatIDs  map[string]int64         // Admin username -> chat_id mapping
-	adminMutex    sync.RWMutex             // Mutex for adminChatIDs access
-	repo          *storage.Repository
-	billing       *billing.Service
-	access        *access.Service
-	paymentQRPath string // Path to static payment QR code image
+	inFlight        int64 // Number of running update handlers, accessed atomically
+	wg              *sync.WaitGroup
+	opsCtx          context.Context    // Context of update handlers, cancelled when shutdown timeout expires
+	cancelOps       context.CancelFunc // Cancels opsCtx
+	shutdownTimeout time.Duration
+	api             *tgbotapi.BotAPI // Used for receiving updates only, everything is sent through sender
+	sender          Sender
+	wireguard       wireguard.Wireguard
+	admins          map[string]struct{}    // Admin usernames, normalized
+	adminIDs        map[int64]struct{}     // Admin Telegram user IDs
+	adminChatIDs    map[int64]int64        // Admin Telegram user ID -> chat_id mapping
+	adminMutex      sync.RWMutex           // Mutex for adminChatIDs access
+	pendingInputs   map[int64]pendingInput // chat_id -> expected text input
+	broadcastDrafts map[int64]string       // chat_id -> broadcast text awaiting confirmation
+	appliedPromos   map[int64]string       // chat_id -> promo code applied to the order summary
+	queuedConfigs   map[int64][]byte       // Queued notification ID -> config, configs aren't stored in the outbox
+	stateMutex      sync.Mutex             // Mutex for pendingInputs, broadcastDrafts, appliedPromos and queuedConfigs access
+	repo            *storage.Repository
+	billing         *billing.Service
+	access          *access.Service
+	paymentQRPath   string             // Path to static payment QR code image
+	paymentTexts    paymentTexts       // Payment instructions and static QR caption
+	paymentToken    string             // Telegram Payments provider token, empty if payments are reviewed manually
+	webhook         *paymentWebhook    // Payment provider notifications endpoint, nil if disabled
+	requireProof    bool               // Payments go to review only with attached proof
+	limiter         *rateLimiter       // Per-user update rate limiter, nil if disabled
+	cooldown        *provisionCooldown // Per-user device provisioning cooldown, nil if disabled
+	configTexts     *configTextCache   // Recently sent configs for "Показать текстом" button
+	updates         *updateDedup       // Recently handled update IDs, redelivered updates are skipped
+	broadcastCfg    broadcastConfig
+	qr              qrConfig
+	tr              *Translator // Message catalog for user-facing texts
 }
 
 // NewBot creates new Bot instance
@@ -43,26 +82,118 @@ func NewBot(token string, repo *storage.Repository, billingService *billing.Serv
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create wireguard client")
 	}
+	// Fail fast on misconfigured servers rather than on the first device creation
+	for _, health := range wguard.HealthCheck(context.Background()) {
+		if health.Err != nil {
+			wguard.Close()
+			return nil, errors.Wrapf(health.Err, "health check of WireGuard server %q failed", health.Server.ID)
+		}
+	}
+	// Peers missing on the interface (e.g. after restoring old interface config) are re-added before users notice
+	if v := os.Getenv("RECONCILE_ON_STARTUP"); v != "" {
+		reconcile, err := strconv.ParseBool(v)
+		if err != nil {
+			wguard.Close()
+			return nil, errors.Errorf("invalid RECONCILE_ON_STARTUP value: %s", v)
+		}
+		if reconcile {
+			logReconcile(wguard.Reconcile(context.Background()))
+		}
+	}
+
+	bot, err := NewBotWithSender(api, wguard, repo, billingService, accessService, paymentQRPath)
+	if err != nil {
+		return nil, err
+	}
+	bot.api = api
+	return bot, nil
+}
 
+// NewBotWithSender creates Bot that sends everything through sender and provisions devices with wguard,
+// without connecting to Telegram. Such Bot can't Run, its updates are passed to handle directly
+func NewBotWithSender(sender Sender, wguard wireguard.Wireguard, repo *storage.Repository, billingService *billing.Service, accessService *access.Service, paymentQRPath string) (*Bot, error) {
 	var admins map[string]struct{}
 	if usernames := os.Getenv("ADMIN_USERNAMES"); len(usernames) != 0 {
 		users := strings.Split(usernames, ",")
 		admins = make(map[string]struct{}, len(users))
 		for _, user := range users {
-			admins[strings.TrimSpace(user)] = struct{}{}
+			if user = normalizeUsername(user); user != "" {
+				admins[user] = struct{}{}
+			}
+		}
+	}
+
+	adminIDs, err := adminIDsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	limiter, err := rateLimiterFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cooldown, err := provisionCooldownFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	broadcastCfg, err := broadcastConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	qr, err := qrConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := paymentWebhookFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	shutdownTimeout := defaultShutdownTimeout
+	if v := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid SHUTDOWN_TIMEOUT_SECONDS value: %s", v)
+		}
+		shutdownTimeout = time.Duration(n) * time.Second
+	}
+	requireProof := false
+	if v := os.Getenv("REQUIRE_PROOF"); v != "" {
+		requireProof, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.Errorf("invalid REQUIRE_PROOF value: %s", v)
 		}
 	}
+	opsCtx, cancelOps := context.WithCancel(context.Background())
 
 	bot := &Bot{
-		wg:            &sync.WaitGroup{},
-		api:           api,
-		wireguard:     wguard,
-		admins:        admins,
-		adminChatIDs:  make(map[string]int64),
-		repo:          repo,
-		billing:       billingService,
-		access:        accessService,
-		paymentQRPath: paymentQRPath,
+		wg:              &sync.WaitGroup{},
+		opsCtx:          opsCtx,
+		cancelOps:       cancelOps,
+		shutdownTimeout: shutdownTimeout,
+		sender:          sender,
+		wireguard:       wguard,
+		admins:          admins,
+		adminIDs:        adminIDs,
+		adminChatIDs:    make(map[int64]int64),
+		pendingInputs:   make(map[int64]pendingInput),
+		broadcastDrafts: make(map[int64]string),
+		appliedPromos:   make(map[int64]string),
+		queuedConfigs:   make(map[int64][]byte),
+		repo:            repo,
+		billing:         billingService,
+		access:          accessService,
+		paymentQRPath:   paymentQRPath,
+		paymentTexts:    paymentTextsFromEnv(),
+		paymentToken:    strings.TrimSpace(os.Getenv("TELEGRAM_PAYMENT_TOKEN")),
+		webhook:         webhook,
+		requireProof:    requireProof,
+		limiter:         limiter,
+		cooldown:        cooldown,
+		configTexts:     newConfigTextCache(),
+		updates:         newUpdateDedup(updateDedupWindow),
+		broadcastCfg:    broadcastCfg,
+		qr:              qr,
+		tr:              NewTranslator(),
 	}
 
 	if err := bot.setMyCommands(); err != nil {
@@ -72,23 +203,85 @@ func NewBot(token string, repo *storage.Repository, billingService *billing.Serv
 	return bot, nil
 }
 
-// SendNotification sends a notification message to a user
+// SendNotification sends a notification message to a user.
+// Undelivered notification is queued and retried by scheduler, unless user has blocked the bot
 func (b *Bot) SendNotification(chatID int64, text string) error {
 	msg := tgbotapi.NewMessage(chatID, text)
-	_, err := b.api.Send(msg)
+	_, err := b.sender.Send(msg)
+	if err != nil {
+		b.handleUndelivered(chatID, text, nil, nil, err)
+	}
+	return err
+}
+
+// SendExpiryReminder reminds user that subscription ends in daysLeft days
+func (b *Bot) SendExpiryReminder(user *storage.User, sub *storage.Subscription, daysLeft int) error {
+	lang := userLanguage(user)
+	text := b.tr.Tf(lang, msgExpiryReminder, daysLeft, sub.EndsAt.Format("02.01.2006"))
+	return b.sendRenewalReminder(user.TelegramID, lang, text, sub)
+}
+
+// SendGraceReminder tells user that subscription has ended and can be renewed until graceEnd
+func (b *Bot) SendGraceReminder(user *storage.User, sub *storage.Subscription, graceEnd time.Time) error {
+	lang := userLanguage(user)
+	text := b.tr.Tf(lang, msgGraceReminder, graceEnd.Format("02.01.2006"))
+	return b.sendRenewalReminder(user.TelegramID, lang, text, sub)
+}
+
+// sendRenewalReminder sends subscription reminder with a button that opens order summary
+// for the subscription plan. Undelivered reminder is queued as plain text without the button
+func (b *Bot) sendRenewalReminder(chatID int64, lang string, text string, sub *storage.Subscription) error {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = renewKeyboard(b.tr, lang, sub.DurationDays, sub.DeviceLimit)
+	_, err := b.sender.Send(msg)
+	if err != nil {
+		b.handleUndelivered(chatID, text, nil, nil, err)
+	}
 	return err
 }
 
+// SendPaymentExpired notifies user in their language that payment request has expired.
+// Payment status is the one it had before expiry
+func (b *Bot) SendPaymentExpired(user *storage.User, payment *storage.Payment) error {
+	key := msgPaymentExpired
+	if payment.Status == storage.PaymentStatusPendingReview {
+		key = msgPaymentExpiredUnreviewed
+	}
+	return b.SendNotification(user.TelegramID, b.tr.Tf(userLanguage(user), key, payment.ReferenceCode))
+}
+
+// RevokeExpiredDevice removes peer of device of expired subscription from WireGuard and marks device revoked,
+// so its addresses can be reused. Device stays active if the peer can't be removed
+func (b *Bot) RevokeExpiredDevice(ctx context.Context, device *storage.Device) error {
+	return b.revokeDevice(ctx, device, "scheduler", "subscription expired")
+}
+
 func (b *Bot) Run(ctx context.Context) error {
+	if b.api == nil {
+		return errors.New("bot created without Telegram connection can't receive updates")
+	}
 	// wait all running handlers to finish and close wg connection
 	defer func() {
-		b.wg.Wait()
+		b.drain()
 		if err := b.wireguard.Close(); err != nil {
 			log.Printf("failed to close wireguard connection: %v", err)
 		}
 	}()
 
+	if b.webhook != nil {
+		server := b.startPaymentWebhook()
+		defer b.stopPaymentWebhook(server)
+	}
+
+	// Continue after the last update received before restart, so Telegram doesn't redeliver it
+	lastUpdateID, err := b.repo.GetLastUpdateID(ctx)
+	if err != nil {
+		log.Printf("failed to load last update id, starting from pending updates: %v", err)
+	}
 	config := tgbotapi.NewUpdate(0)
+	if lastUpdateID > 0 {
+		config.Offset = lastUpdateID + 1
+	}
 	config.Timeout = 30
 
 	// Start polling Telegram for updates
@@ -98,9 +291,14 @@ func (b *Bot) Run(ctx context.Context) error {
 	for {
 		select {
 		case update := <-updates:
+			if err := b.repo.SetLastUpdateID(b.opsCtx, update.UpdateID); err != nil {
+				log.Printf("failed to record last update id %d: %v", update.UpdateID, err)
+			}
 			b.wg.Add(1)
+			atomic.AddInt64(&b.inFlight, 1)
 			go func() {
 				defer b.wg.Done()
+				defer atomic.AddInt64(&b.inFlight, -1)
 				if errs := b.handle(&update); errs != nil {
 					for _, err := range errs {
 						log.Printf("error occured: %s", err.Error())
@@ -115,25 +313,108 @@ func (b *Bot) Run(ctx context.Context) error {
 	}
 }
 
-func (b *Bot) isAdmin(user string) bool {
-	if len(b.admins) == 0 {
+// drain waits for running handlers to finish. If they don't finish within shutdown timeout,
+// their context is cancelled, so provisioning that hasn't committed yet rolls back,
+// and handlers still running after that are abandoned
+func (b *Bot) drain() {
+	defer b.cancelOps()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	if n := atomic.LoadInt64(&b.inFlight); n > 0 {
+		log.Printf("waiting up to %v for %d running handlers", b.shutdownTimeout, n)
+	}
+	select {
+	case <-done:
+		return
+	case <-time.After(b.shutdownTimeout):
+	}
+
+	log.Printf("shutdown timeout expired, cancelling %d running handlers", atomic.LoadInt64(&b.inFlight))
+	b.cancelOps()
+	select {
+	case <-done:
+	case <-time.After(shutdownCancelGrace):
+		log.Printf("abandoned %d running handlers on shutdown", atomic.LoadInt64(&b.inFlight))
+	}
+}
+
+// isAdmin reports whether user is listed in ADMIN_IDS or ADMIN_USERNAMES
+func (b *Bot) isAdmin(user *storage.User) bool {
+	if user == nil {
+		return false
+	}
+	return b.isAdminIdentity(user.TelegramID, user.Username)
+}
+
+// isAdminIdentity reports whether Telegram user with given ID and username is admin.
+// ID is checked first, since username can be changed or unset
+func (b *Bot) isAdminIdentity(telegramID int64, username string) bool {
+	if _, ok := b.adminIDs[telegramID]; ok {
+		return true
+	}
+	if username == "" {
 		return false
 	}
-	_, ok := b.admins[user]
+	_, ok := b.admins[normalizeUsername(username)]
 	return ok
 }
 
-func notAdminMsg(chatID int64) []tgbotapi.Chattable {
+// adminIDsFromEnv parses ADMIN_IDS: comma-separated numeric Telegram user IDs
+func adminIDsFromEnv() (map[int64]struct{}, error) {
+	v := os.Getenv("ADMIN_IDS")
+	if v == "" {
+		return nil, nil
+	}
+	ids := make(map[int64]struct{})
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, errors.Errorf("invalid ADMIN_IDS value: %s", v)
+		}
+		ids[id] = struct{}{}
+	}
+	return ids, nil
+}
+
+// normalizeUsername strips leading @ and lowercases username, Telegram usernames are case-insensitive
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+func (b *Bot) notAdminMsg(chatID int64) []tgbotapi.Chattable {
 	return []tgbotapi.Chattable{
-		tgbotapi.NewMessage(chatID, "❌ У вас нет прав администратора."),
+		tgbotapi.NewMessage(chatID, b.tr.T(b.chatLanguage(chatID), msgNotAdmin)),
 	}
 }
 
 func (b *Bot) handle(update *tgbotapi.Update) []error {
-	log.Printf("new update: %+v", update)
+	// Redelivered update would repeat its side effects, e.g. create the same payment twice
+	if !b.updates.first(update.UpdateID) {
+		log.Printf("skipping duplicate update %d", update.UpdateID)
+		return nil
+	}
+	// Message texts and captions aren't logged, they may hold configs with private keys
+	log.Printf("new update %d", update.UpdateID)
 	var res []tgbotapi.Chattable
 	var err error
 	errs := make([]error, 0)
+	if limited, notice := b.checkRateLimit(update); limited {
+		if notice != nil {
+			if err := b.send(notice); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errs
+	}
 	switch {
 	case update.Message != nil:
 		msg := update.Message
@@ -143,6 +424,8 @@ func (b *Bot) handle(update *tgbotapi.Update) []error {
 	case update.CallbackQuery != nil:
 		query := update.CallbackQuery
 		res, err = b.handleQuery(query)
+	case update.PreCheckoutQuery != nil:
+		err = b.handlePreCheckoutQuery(update.PreCheckoutQuery)
 	default:
 		errs = append(errs, errors.New("unable to handle such update"))
 	}
@@ -157,6 +440,46 @@ func (b *Bot) handle(update *tgbotapi.Update) []error {
 	return errs
 }
 
+// checkRateLimit reports whether update exceeds sender's rate limit and should be dropped.
+// Slow down notice is returned only for the first dropped update in a row. Admins aren't limited
+func (b *Bot) checkRateLimit(update *tgbotapi.Update) (bool, tgbotapi.Chattable) {
+	var from *tgbotapi.User
+	switch {
+	case update.Message != nil && update.Message.SuccessfulPayment != nil:
+		// User has been charged, the payment must be processed
+		return false, nil
+	case update.Message != nil:
+		from = update.Message.From
+	case update.CallbackQuery != nil:
+		from = update.CallbackQuery.From
+	}
+	if from == nil || b.isAdminIdentity(int64(from.ID), from.UserName) {
+		return false, nil
+	}
+
+	allowed, first := b.limiter.allow(int64(from.ID), time.Now())
+	if allowed {
+		return false, nil
+	}
+	log.Printf("rate limit exceeded for user %d, dropping update %d", from.ID, update.UpdateID)
+
+	if update.CallbackQuery != nil {
+		// Answer callback anyway so the button stops spinning
+		callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
+		if first {
+			callback.Text = b.tr.T(b.chatLanguage(int64(from.ID)), msgRateLimited)
+		}
+		if _, err := b.sender.Request(callback); err != nil {
+			log.Printf("failed to answer rate limited callback query: %v", err)
+		}
+		return true, nil
+	}
+	if first {
+		return true, tgbotapi.NewMessage(update.Message.Chat.ID, b.tr.T(b.chatLanguage(int64(from.ID)), msgRateLimited))
+	}
+	return true, nil
+}
+
 func (b *Bot) send(c tgbotapi.Chattable) error {
 	if c == nil {
 		return nil
@@ -210,25 +533,39 @@ func (b *Bot) send(c tgbotapi.Chattable) error {
 		}
 	}
 	
-	msg, err := b.api.Send(c)
+	msg, err := b.sender.Send(c)
 	if err != nil {
 		log.Printf("ERROR sending message: %v", err)
 		return err
 	}
-	log.Printf("send msg: %+v", msg)
+	log.Printf("sent message %d to chat %d", msg.MessageID, sentChatID(msg))
 	return nil
 }
 
+// sentChatID returns chat ID of sent message, 0 if Telegram hasn't returned the chat
+func sentChatID(msg tgbotapi.Message) int64 {
+	if msg.Chat == nil {
+		return 0
+	}
+	return msg.Chat.ID
+}
+
 // registerAdmin registers admin chat_id when they send /start
-func (b *Bot) registerAdmin(username string, chatID int64) {
-	if username == "" {
+func (b *Bot) registerAdmin(user *storage.User, chatID int64) {
+	if !b.isAdmin(user) {
 		return
 	}
 	b.adminMutex.Lock()
-	defer b.adminMutex.Unlock()
-	if _, isAdmin := b.admins[username]; isAdmin {
-		b.adminChatIDs[username] = chatID
-		log.Printf("Admin registered: %s -> chat_id: %d", username, chatID)
+	known := b.adminChatIDs[user.TelegramID] == chatID
+	b.adminChatIDs[user.TelegramID] = chatID
+	b.adminMutex.Unlock()
+	log.Printf("Admin registered: %s (%d) -> chat_id: %d", user.Username, user.TelegramID, chatID)
+
+	// Admin commands are visible in the command menu of admin chats only
+	if !known {
+		if err := b.setAdminCommands(chatID); err != nil {
+			log.Printf("failed to set admin commands for chat %d: %v", chatID, err)
+		}
 	}
 }
 
@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "admin", want: "admin"},
+		{in: "@Admin", want: "admin"},
+		{in: "ADMIN", want: "admin"},
+		{in: " @Admin_Bot ", want: "admin_bot"},
+		{in: "@", want: ""},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeUsername(tt.in); got != tt.want {
+			t.Errorf("normalizeUsername(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsAdminIdentity(t *testing.T) {
+	t.Setenv("ADMIN_USERNAMES", " @Admin ,BOSS,,")
+	t.Setenv("ADMIN_IDS", "42")
+	bot, err := NewBotWithSender(&fakeSender{}, nil, nil, nil, nil, "")
+	if err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+	t.Cleanup(bot.cancelOps)
+
+	tests := []struct {
+		id       int64
+		username string
+		want     bool
+	}{
+		{id: 1, username: "admin", want: true},
+		{id: 1, username: "@admin", want: true},
+		{id: 1, username: "ADMIN", want: true},
+		{id: 1, username: "Boss", want: true},
+		{id: 42, username: "", want: true},
+		{id: 42, username: "someone", want: true},
+		{id: 1, username: "", want: false},
+		{id: 1, username: "administrator", want: false},
+		{id: 1, username: "@", want: false},
+	}
+	for _, tt := range tests {
+		if got := bot.isAdminIdentity(tt.id, tt.username); got != tt.want {
+			t.Errorf("isAdminIdentity(%d, %q) = %v, want %v", tt.id, tt.username, got, tt.want)
+		}
+	}
+}
+
+// Texts of sent and received messages may hold configs with private keys, so they aren't logged
+func TestSendAndUpdateLogsOmitText(t *testing.T) {
+	bot, sender := newTestBot(t)
+	user := testUser(100, "user")
+	config := "[Interface]\nPrivateKey = " + testConfigPrivateKey + "\n"
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	if err := bot.send(tgbotapi.NewMessage(user.ID, config)); err != nil {
+		t.Fatalf("send() failed: %v", err)
+	}
+	if errs := bot.handle(messageUpdate(1, user, config)); len(errs) > 0 {
+		t.Fatalf("handle() failed: %v", errs)
+	}
+
+	if len(sender.sentTo(user.ID)) < 2 {
+		t.Fatalf("messages aren't sent: %q", sender.sentTo(user.ID))
+	}
+	if !strings.Contains(logs.String(), "sent message") || !strings.Contains(logs.String(), "new message") {
+		t.Errorf("messages aren't logged, log capture doesn't work:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), testConfigPrivateKey) {
+		t.Errorf("private key is logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,150 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/billing"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+const defaultPaymentWebhookPath = "/payments/webhook"
+
+// paymentWebhookReadHeaderTimeout bounds time to read request headers, so idle clients can't hold connections open
+const paymentWebhookReadHeaderTimeout = 10 * time.Second
+
+// paymentWebhook is HTTP endpoint receiving payment provider notifications
+type paymentWebhook struct {
+	addr     string
+	path     string
+	provider billing.WebhookProvider
+}
+
+// paymentWebhookFromEnv reads PAYMENT_WEBHOOK_ADDR, PAYMENT_WEBHOOK_PATH, PAYMENT_WEBHOOK_PROVIDER
+// and PAYMENT_WEBHOOK_SECRET environment variables. Webhook is disabled when PAYMENT_WEBHOOK_ADDR is unset
+func paymentWebhookFromEnv() (*paymentWebhook, error) {
+	addr := strings.TrimSpace(os.Getenv("PAYMENT_WEBHOOK_ADDR"))
+	if addr == "" {
+		return nil, nil
+	}
+	name := strings.TrimSpace(os.Getenv("PAYMENT_WEBHOOK_PROVIDER"))
+	if name == "" {
+		name = billing.WebhookProviderYooMoney
+	}
+	provider, err := billing.NewWebhookProvider(name, os.Getenv("PAYMENT_WEBHOOK_SECRET"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid payment webhook configuration")
+	}
+	path := strings.TrimSpace(os.Getenv("PAYMENT_WEBHOOK_PATH"))
+	if path == "" {
+		path = defaultPaymentWebhookPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.Errorf("invalid PAYMENT_WEBHOOK_PATH value: %s, must start with /", path)
+	}
+	return &paymentWebhook{addr: addr, path: path, provider: provider}, nil
+}
+
+// startPaymentWebhook starts HTTP server for payment provider notifications,
+// returned server is shut down by caller
+func (b *Bot) startPaymentWebhook() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(b.webhook.path, b.handlePaymentWebhook)
+	server := &http.Server{Addr: b.webhook.addr, Handler: mux, ReadHeaderTimeout: paymentWebhookReadHeaderTimeout}
+	go func() {
+		log.Printf("payment webhook of %s listening on %s%s", b.webhook.provider.Name(), b.webhook.addr, b.webhook.path)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("payment webhook server failed: %v", err)
+		}
+	}()
+	return server
+}
+
+// stopPaymentWebhook waits for running notifications to be handled within shutdown timeout
+func (b *Bot) stopPaymentWebhook(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("failed to stop payment webhook server: %v", err)
+	}
+}
+
+// handlePaymentWebhook approves payment matching provider notification. Every notification with valid
+// signature is acknowledged, ones that can't be approved are reported to admins for manual review
+func (b *Bot) handlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	notification, err := b.webhook.provider.ParseNotification(r.PostForm)
+	if errors.Is(err, billing.ErrInvalidSignature) {
+		log.Printf("payment webhook from %s rejected: %v", r.RemoteAddr, err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to parse payment notification: %v", err)
+		b.notifyAdmins(func(lang string) string {
+			return b.tr.Tf(lang, msgAdminWebhookParseFailed, b.webhook.provider.Name(), err.Error())
+		})
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	log.Printf("payment notification %s: %d kopecks with comment %q", notification.OperationID, notification.Amount, notification.Comment)
+
+	b.confirmWebhookPayment(b.opsCtx, notification)
+	w.WriteHeader(http.StatusOK)
+}
+
+// confirmWebhookPayment approves payment reported by provider and provisions the first device the same way
+// admin approval does. Payments that can't be approved automatically are reported to admins
+func (b *Bot) confirmWebhookPayment(ctx context.Context, notification *billing.PaymentNotification) {
+	provider := b.webhook.provider.Name()
+	payment, err := b.billing.ConfirmWebhookPayment(ctx, provider, notification)
+	switch {
+	case err == nil:
+	case errors.Is(err, billing.ErrPaymentAlreadyProcessed) && payment != nil && payment.Status == storage.PaymentStatusApproved:
+		log.Printf("payment %d of operation %s is already approved", payment.ID, notification.OperationID)
+		return
+	case errors.Is(err, billing.ErrSecondApprovalRequired):
+		b.notifyAdmins(func(lang string) string {
+			return b.tr.Tf(lang, msgAdminWebhookSecondApproval, payment.ReferenceCode, float64(notification.Amount)/100.0)
+		})
+		return
+	default:
+		log.Printf("failed to confirm payment of operation %s: %v", notification.OperationID, err)
+		b.notifyAdmins(func(lang string) string {
+			code := b.tr.T(lang, msgAdminWebhookNoPayment)
+			if payment != nil {
+				code = payment.ReferenceCode
+			}
+			return b.tr.Tf(lang, msgAdminWebhookNotConfirmed,
+				notification.Comment, code, float64(notification.Amount)/100.0,
+				provider, notification.OperationID, billingErrorText(b.tr, lang, err))
+		})
+		return
+	}
+
+	user, err := b.repo.GetUserByID(ctx, payment.UserID)
+	if err != nil || user == nil {
+		log.Printf("user %d of payment %d not found: %v", payment.UserID, payment.ID, err)
+		return
+	}
+	b.notifyAdmins(func(lang string) string {
+		return b.tr.Tf(lang, msgAdminWebhookPaid,
+			provider, user.Username, payment.ReferenceCode, float64(payment.Amount)/100.0, payment.DurationDays, payment.DeviceCount,
+			notification.OperationID)
+	})
+
+	b.provisionApprovedPayment(ctx, payment, user)
+}
@@ -1,7 +1,9 @@
 package configs
 
 import (
+	"embed"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -15,10 +17,12 @@ type ClientConfig struct {
 	Address    string
 	PrivateKey string
 	DNS        []string
+	MTU        int // Omitted when zero
 
-	PublicKey  string
-	AllowedIPs []string
-	Endpoint   string
+	PublicKey           string
+	AllowedIPs          []string
+	Endpoint            string
+	PersistentKeepalive int // In seconds, omitted when zero
 }
 
 type ServerConfig struct {
@@ -36,33 +40,62 @@ type PeerConfig struct {
 	AllowedIPs []string
 }
 
+// redacted replaces private keys when configs are formatted for logs
+const redacted = "***"
+
+// String formats client config with private key redacted, so config is safe to log
+func (c ClientConfig) String() string {
+	c.PrivateKey = redactKey(c.PrivateKey)
+	return fmt.Sprintf("%+v", clientConfigFields(c))
+}
+
+// String formats server config with private key redacted, so config is safe to log
+func (c ServerConfig) String() string {
+	c.PrivateKey = redactKey(c.PrivateKey)
+	return fmt.Sprintf("%+v", serverConfigFields(c))
+}
+
+// clientConfigFields and serverConfigFields have no String method, so fmt prints their fields
+type (
+	clientConfigFields ClientConfig
+	serverConfigFields ServerConfig
+)
+
+func redactKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return redacted
+}
+
 const (
 	clientTmplFile = "client.tmpl"
 	serverTmplFile = "server.tmpl"
 )
 
+// Templates are built into the binary. They used to be read at package init from
+// internal/wireguard/configs relative to working directory, so the binary run from another
+// directory and tests of every package importing configs panicked
+//
+//go:embed client.tmpl server.tmpl
+var templates embed.FS
+
 var (
-	// Get templates folder from env or use default (internal/wireguard/configs)
-	tmplFolder = func() string {
-		if folder := os.Getenv("TEMPLATES_FOLDER"); folder != "" {
-			return folder
-		}
-		// Default: use internal/wireguard/configs relative to current working directory
-		// In production, templates should be in the same directory as the binary
-		return "internal/wireguard/configs"
-	}()
-	clientTmpl = template.Must(
-		template.New(clientTmplFile).
-			Funcs(template.FuncMap{"join": strings.Join}).
-			ParseFiles(filepath.Join(tmplFolder, clientTmplFile)),
-	)
-	serverTmpl = template.Must(
-		template.New(serverTmplFile).
-			Funcs(template.FuncMap{"join": strings.Join}).
-			ParseFiles(filepath.Join(tmplFolder, serverTmplFile)),
-	)
+	// Optional TEMPLATES_FOLDER overrides built-in templates with files of the folder
+	tmplFolder = os.Getenv("TEMPLATES_FOLDER")
+	clientTmpl = parseTemplate(clientTmplFile)
+	serverTmpl = parseTemplate(serverTmplFile)
 )
 
+// parseTemplate parses template from TEMPLATES_FOLDER if it's set, built-in template otherwise
+func parseTemplate(name string) *template.Template {
+	tmpl := template.New(name).Funcs(template.FuncMap{"join": strings.Join})
+	if tmplFolder != "" {
+		return template.Must(tmpl.ParseFiles(filepath.Join(tmplFolder, name)))
+	}
+	return template.Must(tmpl.ParseFS(templates, name))
+}
+
 func ProcessClientConfig(cfg ClientConfig) (io.Reader, error) {
 	return processConfig(cfg)
 }
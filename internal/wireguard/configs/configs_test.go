@@ -0,0 +1,52 @@
+package configs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testPrivateKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+
+func TestConfigStringRedactsPrivateKey(t *testing.T) {
+	client := ClientConfig{Address: "10.0.0.2/32", PrivateKey: testPrivateKey, PublicKey: "server"}
+	server := ServerConfig{Address: "10.0.0.1/24", PrivateKey: testPrivateKey, Peers: []PeerConfig{{PublicKey: "peer"}}}
+
+	for _, formatted := range []string{
+		client.String(), fmt.Sprint(client), fmt.Sprintf("%v", client), fmt.Sprintf("%+v", client), fmt.Sprintf("%+v", &client),
+		server.String(), fmt.Sprint(server), fmt.Sprintf("%+v", server), fmt.Sprintf("%+v", &server),
+	} {
+		if strings.Contains(formatted, testPrivateKey) {
+			t.Errorf("formatted config contains private key: %s", formatted)
+		}
+		if !strings.Contains(formatted, redacted) {
+			t.Errorf("formatted config has no redacted key: %s", formatted)
+		}
+	}
+
+	if s := (ClientConfig{Address: "10.0.0.2/32"}).String(); strings.Contains(s, redacted) {
+		t.Errorf("config without private key is formatted with redacted key: %s", s)
+	}
+}
+
+func TestProcessClientConfigKeepsPrivateKey(t *testing.T) {
+	reader, err := ProcessClientConfig(ClientConfig{
+		Address:    "10.0.0.2/32",
+		PrivateKey: testPrivateKey,
+		DNS:        []string{"8.8.8.8"},
+		PublicKey:  "server",
+		AllowedIPs: []string{"0.0.0.0/0"},
+		Endpoint:   "127.0.0.1:51820",
+	})
+	if err != nil {
+		t.Fatalf("ProcessClientConfig() failed: %v", err)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if !strings.Contains(string(content), "PrivateKey = "+testPrivateKey) {
+		t.Errorf("config has no private key:\n%s", content)
+	}
+}
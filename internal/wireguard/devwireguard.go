@@ -2,20 +2,35 @@ package wireguard
 
 import (
 	"context"
+	"io"
 	"log"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"github.com/skoret/wireguard-bot/internal/provisioning"
-	cfgs "github.com/skoret/wireguard-bot/internal/wireguard/configs"
 	"github.com/skoret/wireguard-bot/internal/storage"
+	cfgs "github.com/skoret/wireguard-bot/internal/wireguard/configs"
 )
 
-// DevProvisioner is a mock provisioner for development/testing
-type DevProvisioner struct{}
+// devSubnet is the fake subnet dev provisioner assigns client addresses from, .1 is the server
+var devSubnet = net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(24, 32)}
+
+// DevProvisioner is a mock provisioner for development/testing. It doesn't touch WireGuard,
+// but stores devices in DB like real provisioners, assigning the lowest free address of devSubnet,
+// so device limits and listings work in dev mode. Each server gets its own provisioner and address space
+type DevProvisioner struct {
+	repo   *storage.Repository
+	server string
+	mu     sync.Mutex // Serializes address allocation and device insertion
+}
 
-// NewDevProvisioner creates a new dev provisioner
-func NewDevProvisioner(repo *storage.Repository) (provisioning.Provisioner, error) {
-	log.Println("--- create dummy dev provisioner ---")
-	return &DevProvisioner{}, nil
+// NewDevProvisioner creates a new dev provisioner for the server
+func NewDevProvisioner(repo *storage.Repository, server provisioning.Server) (provisioning.Provisioner, error) {
+	log.Printf("--- create dummy dev provisioner for server %q ---", server.ID)
+	return &DevProvisioner{repo: repo, server: server.ID}, nil
 }
 
 func (d *DevProvisioner) Close() error {
@@ -25,46 +40,181 @@ func (d *DevProvisioner) Close() error {
 
 func (d *DevProvisioner) CreateDeviceWithNewKeys(ctx context.Context, userID, subscriptionID int64, deviceName string) (*provisioning.ConfigResult, error) {
 	log.Printf("dev provisioner creates dummy config for user %d, subscription %d, device %s", userID, subscriptionID, deviceName)
-	cfg := cfgs.ClientConfig{
-		Address:    "10.0.0.1/32",
-		PrivateKey: "dummy_private_key",
-		DNS:        []string{"8.8.8.8"},
-		PublicKey:  "dummy_public_key",
-		AllowedIPs: []string{"0.0.0.0/0"},
-		Endpoint:   "127.0.0.1:51820",
+	if result, err := d.existingDevice(ctx, subscriptionID, deviceName, ""); result != nil || err != nil {
+		return result, err
 	}
-	reader, err := cfgs.ProcessClientConfig(cfg)
+	pri, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate private key")
+	}
+	pub := pri.PublicKey().String()
+
+	ip, err := d.createDevice(ctx, pub, userID, subscriptionID, deviceName, false)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := devConfig(ip, pri.String())
 	if err != nil {
 		return nil, err
 	}
 	return &provisioning.ConfigResult{
 		ConfigReader: reader,
-		PublicKey:    "dummy_public_key",
-		AssignedIP:   "10.0.0.1",
+		PublicKey:    pub,
+		AssignedIP:   ip,
 	}, nil
 }
 
 func (d *DevProvisioner) CreateDeviceWithPublicKey(ctx context.Context, key string, userID, subscriptionID int64, deviceName string) (*provisioning.ConfigResult, error) {
 	log.Printf("dev provisioner creates dummy config for public key %s, user %d, subscription %d, device %s", key, userID, subscriptionID, deviceName)
-	cfg := cfgs.ClientConfig{
-		Address:    "10.0.0.1/32",
-		PrivateKey: "",
-		DNS:        []string{"8.8.8.8"},
-		PublicKey:  "dummy_server_public_key",
-		AllowedIPs: []string{"0.0.0.0/0"},
-		Endpoint:   "127.0.0.1:51820",
+	if result, err := d.existingDevice(ctx, subscriptionID, deviceName, key); result != nil || err != nil {
+		return result, err
 	}
-	reader, err := cfgs.ProcessClientConfig(cfg)
+	ip, err := d.createDevice(ctx, key, userID, subscriptionID, deviceName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := devConfig(ip, "")
 	if err != nil {
 		return nil, err
 	}
 	return &provisioning.ConfigResult{
 		ConfigReader: reader,
-		AssignedIP:   "10.0.0.1",
+		PublicKey:    key,
+		AssignedIP:   ip,
+		ImportedKey:  true,
 	}, nil
 }
 
+// RecreateConfig returns dummy config of device, config of device with imported public key
+// has no private key like the one issued on its creation
+func (d *DevProvisioner) RecreateConfig(ctx context.Context, device *storage.Device) (*provisioning.ConfigResult, error) {
+	log.Printf("dev provisioner recreates dummy config for device %d", device.ID)
+	_, imported, err := d.repo.GetDeviceKey(ctx, device.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get device key")
+	}
+	privateKey := "dummy_private_key"
+	if imported {
+		privateKey = ""
+	}
+	reader, err := devConfig(device.AssignedIP, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &provisioning.ConfigResult{
+		ConfigReader: reader,
+		PublicKey:    device.PeerPublicKey,
+		AssignedIP:   device.AssignedIP,
+		ImportedKey:  imported,
+	}, nil
+}
+
+func (d *DevProvisioner) RestoreDevice(ctx context.Context, peerPublicKey, assignedIP, assignedIPv6 string) error {
+	log.Printf("dev provisioner restores device with key %s, ip %s", peerPublicKey, assignedIP)
+	return nil
+}
+
+func (d *DevProvisioner) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// Reconcile has nothing to compare, dev provisioner has no WireGuard interface
+func (d *DevProvisioner) Reconcile(ctx context.Context) (*provisioning.ReconcileReport, error) {
+	return &provisioning.ReconcileReport{}, nil
+}
+
+// DeviceStats isn't supported, dev provisioner has no WireGuard interface
+func (d *DevProvisioner) DeviceStats(ctx context.Context, peerPublicKey string) (*provisioning.DeviceStats, error) {
+	return nil, provisioning.ErrStatsUnsupported
+}
+
 func (d *DevProvisioner) RevokeDevice(ctx context.Context, peerPublicKey string) error {
 	log.Printf("dev provisioner revokes device with key %s", peerPublicKey)
 	return nil
 }
+
+// existingDevice returns dummy config of active device with the name in the subscription, nil if there is none,
+// like LocalProvisioner does for repeated creation. publicKey is empty for generated keys
+func (d *DevProvisioner) existingDevice(ctx context.Context, subscriptionID int64, deviceName, publicKey string) (*provisioning.ConfigResult, error) {
+	device, err := d.repo.GetActiveDeviceByName(ctx, subscriptionID, deviceName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check existing device")
+	}
+	if device == nil {
+		return nil, nil
+	}
+	if device.ServerID != d.server || (publicKey != "" && device.PeerPublicKey != publicKey) {
+		return nil, errors.Wrapf(provisioning.ErrDeviceExists, "subscription %d, device %s", subscriptionID, deviceName)
+	}
+	return d.RecreateConfig(ctx, device)
+}
+
+// createDevice stores device with the lowest free address of devSubnet and returns the address.
+// Subscription device limit is checked under the same lock, ErrDeviceLimitReached is returned when it's reached
+func (d *DevProvisioner) createDevice(ctx context.Context, publicKey string, userID, subscriptionID int64, deviceName string, imported bool) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscription, err := d.repo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get subscription")
+	}
+	if subscription == nil {
+		return "", errors.Errorf("subscription %d not found", subscriptionID)
+	}
+	count, err := d.repo.CountActiveDevicesBySubscription(ctx, subscriptionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to count devices")
+	}
+	if count >= subscription.DeviceLimit {
+		return "", errors.Wrapf(provisioning.ErrDeviceLimitReached, "subscription %d", subscriptionID)
+	}
+
+	assigned, err := d.repo.GetActiveDeviceIPs(ctx, d.server)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get assigned IPs")
+	}
+	used := make(map[string]bool, len(assigned))
+	for _, ip := range assigned {
+		used[ip] = true
+	}
+
+	ip := ""
+	for host := 2; host < 255; host++ {
+		candidate := net.IPv4(devSubnet.IP[0], devSubnet.IP[1], devSubnet.IP[2], byte(host)).String()
+		if !used[candidate] {
+			ip = candidate
+			break
+		}
+	}
+	if ip == "" {
+		return "", provisioning.ErrIPPoolExhausted
+	}
+
+	device := &storage.Device{
+		UserID:         userID,
+		SubscriptionID: subscriptionID,
+		DeviceName:     deviceName,
+		PeerPublicKey:  publicKey,
+		AssignedIP:     ip,
+		ServerID:       d.server,
+	}
+	if err := d.repo.CreateDevice(ctx, device, imported); err != nil {
+		return "", errors.Wrap(err, "failed to create device")
+	}
+	return ip, nil
+}
+
+// devConfig returns dummy client config for address, private key may be empty
+func devConfig(ip, privateKey string) (io.Reader, error) {
+	return cfgs.ProcessClientConfig(cfgs.ClientConfig{
+		Address:    ip + "/32",
+		PrivateKey: privateKey,
+		DNS:        []string{"8.8.8.8"},
+		PublicKey:  "dummy_server_public_key",
+		AllowedIPs: []string{"0.0.0.0/0"},
+		Endpoint:   "127.0.0.1:51820",
+	})
+}
@@ -0,0 +1,175 @@
+package wireguard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/skoret/wireguard-bot/internal/provisioning"
+	"github.com/skoret/wireguard-bot/internal/storage"
+)
+
+// newTestWireguard returns dev provisioner backed by in-memory database and subscription with given device limit
+func newTestWireguard(t *testing.T, deviceLimit int) (Wireguard, *storage.Repository, *storage.Subscription) {
+	t.Helper()
+	ctx := context.Background()
+	repo, err := storage.NewRepository(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := repo.Migrate(ctx); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	user, err := repo.GetOrCreateUser(ctx, 1, "user")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	now := repo.Clock().Now()
+	subscription := &storage.Subscription{
+		UserID:       user.ID,
+		DurationDays: 30,
+		DeviceLimit:  deviceLimit,
+		Status:       storage.SubscriptionStatusActive,
+		StartsAt:     now,
+		EndsAt:       now.AddDate(0, 0, 30),
+	}
+	if err := repo.CreateSubscription(ctx, subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	provisioner, err := NewDevProvisioner(repo, provisioning.Server{})
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	return NewWireguardFromProvisioner(provisioner), repo, subscription
+}
+
+var privateKeyLine = regexp.MustCompile(`(?m)^PrivateKey = (\S+)$`)
+
+func TestCreateConfigDoesNotLogPrivateKey(t *testing.T) {
+	wg, _, subscription := newTestWireguard(t, 1)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	cfg, _, _, err := wg.CreateConfigForNewKeys(context.Background(), "", subscription.UserID, subscription.ID, "device_1")
+	if err != nil {
+		t.Fatalf("CreateConfigForNewKeys() failed: %v", err)
+	}
+	content, err := io.ReadAll(cfg)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	match := privateKeyLine.FindSubmatch(content)
+	if match == nil {
+		t.Fatalf("config has no private key:\n%s", content)
+	}
+	privateKey := string(match[1])
+
+	if !strings.Contains(logs.String(), "device_1") {
+		t.Errorf("device creation isn't logged, log capture doesn't work:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), privateKey) {
+		t.Errorf("private key is logged:\n%s", logs.String())
+	}
+}
+
+func TestCreateConfigConcurrentDeviceLimit(t *testing.T) {
+	wg, repo, subscription := newTestWireguard(t, 1)
+	ctx := context.Background()
+
+	const requests = 2
+	var group sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, requests)
+	for i := 0; i < requests; i++ {
+		group.Add(1)
+		go func(i int) {
+			defer group.Done()
+			<-start
+			_, _, _, errs[i] = wg.CreateConfigForNewKeys(ctx, "", subscription.UserID, subscription.ID, fmt.Sprintf("device_%d", i+1))
+		}(i)
+	}
+	close(start)
+	group.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, provisioning.ErrDeviceLimitReached):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of %d concurrent requests succeeded, want exactly one: %v", succeeded, requests, errs)
+	}
+	count, err := repo.CountActiveDevicesBySubscription(ctx, subscription.ID)
+	if err != nil {
+		t.Fatalf("failed to count devices: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("subscription has %d devices, limit is 1", count)
+	}
+}
+
+func TestCreateConfigAssignsLowestFreeAddress(t *testing.T) {
+	wg, repo, subscription := newTestWireguard(t, 3)
+	ctx := context.Background()
+
+	create := func(deviceName string) (string, string) {
+		t.Helper()
+		_, publicKey, ip, err := wg.CreateConfigForNewKeys(ctx, "", subscription.UserID, subscription.ID, deviceName)
+		if err != nil {
+			t.Fatalf("CreateConfigForNewKeys(%s) failed: %v", deviceName, err)
+		}
+		return publicKey, ip
+	}
+
+	keys := make(map[string]string)
+	for i, want := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		name := fmt.Sprintf("device_%d", i+1)
+		key, ip := create(name)
+		if ip != want {
+			t.Errorf("%s got %s, want %s", name, ip, want)
+		}
+		keys[key] = ip
+	}
+
+	// Devices are stored like by real provisioners
+	devices, err := repo.GetActiveDevicesByUserID(ctx, subscription.UserID)
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+	if len(devices) != len(keys) {
+		t.Fatalf("%d devices stored, want %d", len(devices), len(keys))
+	}
+	for _, device := range devices {
+		if ip, ok := keys[device.PeerPublicKey]; !ok || device.AssignedIP != ip {
+			t.Errorf("stored device %s with key %s and address %s doesn't match created one", device.DeviceName, device.PeerPublicKey, device.AssignedIP)
+		}
+	}
+
+	// Address of revoked device is reused
+	for _, device := range devices {
+		if device.DeviceName == "device_2" {
+			if err := repo.RevokeDevice(ctx, device.ID); err != nil {
+				t.Fatalf("failed to revoke device: %v", err)
+			}
+		}
+	}
+	if _, ip := create("device_4"); ip != "10.0.0.3" {
+		t.Errorf("device_4 got %s, want freed 10.0.0.3", ip)
+	}
+}
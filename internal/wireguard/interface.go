@@ -3,6 +3,7 @@ package wireguard
 import (
 	"context"
 	"io"
+	"log"
 	"os"
 	"strconv"
 
@@ -13,63 +14,155 @@ import (
 )
 
 // Wireguard is a wrapper around Provisioner interface
-// It maintains backward compatibility while using the new provisioning abstraction
+// It maintains backward compatibility while using the new provisioning abstraction.
+// Server ID selects the server to provision on, empty ID means the default (first configured) server
 type Wireguard interface {
 	io.Closer
-	CreateConfigForNewKeys(ctx context.Context, userID, subscriptionID int64, deviceName string) (io.Reader, string, string, error)
-	CreateConfigForPublicKey(ctx context.Context, key string, userID, subscriptionID int64, deviceName string) (io.Reader, string, error)
+	Servers() []provisioning.Server
+	CreateConfigForNewKeys(ctx context.Context, serverID string, userID, subscriptionID int64, deviceName string) (io.Reader, string, string, error)
+	CreateConfigForPublicKey(ctx context.Context, serverID string, key string, userID, subscriptionID int64, deviceName string) (io.Reader, string, error)
+	ResyncDevice(ctx context.Context, device *storage.Device) error
+	RevokeDevice(ctx context.Context, device *storage.Device) error
+	RecreateConfig(ctx context.Context, device *storage.Device) (io.Reader, bool, error)
+	DeviceStats(ctx context.Context, device *storage.Device) (*provisioning.DeviceStats, error)
+	HealthCheck(ctx context.Context) []ServerHealth
+	Reconcile(ctx context.Context) []ServerReconcile
 	// Legacy methods for backward compatibility (deprecated)
 	CreateConfigForNewKeysLegacy() (io.Reader, error)
 	CreateConfigForPublicKeyLegacy(key string) (io.Reader, error)
 }
 
-// wireguardWrapper wraps Provisioner to implement Wireguard interface
+// ServerHealth is the health check result of a server, Err is nil if the server is healthy
+type ServerHealth struct {
+	Server provisioning.Server
+	Err    error
+}
+
+// ServerReconcile is the reconciliation result of a server, Err is set if it couldn't be done
+type ServerReconcile struct {
+	Server provisioning.Server
+	Report *provisioning.ReconcileReport
+	Err    error
+}
+
+// ErrUnknownServer is returned for server ID missing in configuration
+var ErrUnknownServer = errors.New("unknown WireGuard server")
+
+// wireguardWrapper wraps a Provisioner per server to implement Wireguard interface
 type wireguardWrapper struct {
-	provisioner provisioning.Provisioner
+	servers      []provisioning.Server
+	provisioners map[string]provisioning.Provisioner
 }
 
-// NewWireguard creates a new Wireguard instance using Provisioner
+// NewWireguard creates a new Wireguard instance using a Provisioner per configured server
 // Provisioner selection:
 //   - DEV_MODE=true → DevProvisioner (for testing, mock implementation)
 //   - otherwise → LocalProvisioner (local WireGuard via wgctrl)
 func NewWireguard(repo *storage.Repository) (Wireguard, error) {
-	var provisioner provisioning.Provisioner
-	var err error
-
-	// Check if using dev mode
-	if devMode, _ := strconv.ParseBool(os.Getenv("DEV_MODE")); devMode {
-		// Use dev provisioner (mock for testing)
-		provisioner, err = NewDevProvisioner(repo)
-	} else {
-		// Use local provisioner (local WireGuard via wgctrl)
-		provisioner, err = provisioning.NewLocalProvisioner(repo)
+	servers, err := provisioning.LoadServers()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load servers")
+	}
+	devMode, _ := strconv.ParseBool(os.Getenv("DEV_MODE"))
+
+	provisioners := make(map[string]provisioning.Provisioner, len(servers))
+	for _, server := range servers {
+		var provisioner provisioning.Provisioner
+		if devMode {
+			// Use dev provisioner (mock for testing)
+			provisioner, err = NewDevProvisioner(repo, server)
+		} else {
+			// Use local provisioner (local WireGuard via wgctrl)
+			provisioner, err = provisioning.NewLocalProvisioner(repo, server)
+		}
+		if err != nil {
+			for _, p := range provisioners {
+				p.Close()
+			}
+			return nil, errors.Wrapf(err, "failed to create provisioner for server %q", server.ID)
+		}
+		provisioners[server.ID] = provisioner
 	}
 
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create provisioner")
+	// Devices created before multiple servers were configured belong to the first server
+	if servers[0].ID != "" {
+		moved, err := repo.AssignDevicesToServer(context.Background(), "", servers[0].ID)
+		if err != nil {
+			for _, p := range provisioners {
+				p.Close()
+			}
+			return nil, err
+		}
+		if moved > 0 {
+			log.Printf("Assigned %d existing device(s) to server %s", moved, servers[0].ID)
+		}
 	}
 
-	return NewWireguardFromProvisioner(provisioner), nil
+	return &wireguardWrapper{servers: servers, provisioners: provisioners}, nil
 }
 
-// NewWireguardFromProvisioner creates a Wireguard instance from a Provisioner
+// NewWireguardFromProvisioner creates a single server Wireguard instance from a Provisioner
 func NewWireguardFromProvisioner(provisioner provisioning.Provisioner) Wireguard {
 	return &wireguardWrapper{
-		provisioner: provisioner,
+		servers:      []provisioning.Server{{}},
+		provisioners: map[string]provisioning.Provisioner{"": provisioner},
 	}
 }
 
-// Close closes the wireguard instance
+// Close closes provisioners of all servers
 func (w *wireguardWrapper) Close() error {
-	if w.provisioner != nil {
-		return w.provisioner.Close()
+	var result error
+	for id, provisioner := range w.provisioners {
+		if err := provisioner.Close(); err != nil && result == nil {
+			result = errors.Wrapf(err, "failed to close provisioner of server %q", id)
+		}
 	}
-	return nil
+	return result
+}
+
+// Servers returns configured servers, the first one is the default
+func (w *wireguardWrapper) Servers() []provisioning.Server {
+	return w.servers
+}
+
+// HealthCheck checks every configured server, results are in the order of Servers
+func (w *wireguardWrapper) HealthCheck(ctx context.Context) []ServerHealth {
+	results := make([]ServerHealth, len(w.servers))
+	for i, server := range w.servers {
+		results[i] = ServerHealth{Server: server, Err: w.provisioners[server.ID].HealthCheck(ctx)}
+	}
+	return results
+}
+
+// Reconcile repairs DB and interface desync on every configured server, results are in the order of Servers
+func (w *wireguardWrapper) Reconcile(ctx context.Context) []ServerReconcile {
+	results := make([]ServerReconcile, len(w.servers))
+	for i, server := range w.servers {
+		report, err := w.provisioners[server.ID].Reconcile(ctx)
+		results[i] = ServerReconcile{Server: server, Report: report, Err: err}
+	}
+	return results
+}
+
+// provisioner returns provisioner of the server, empty ID selects the default server
+func (w *wireguardWrapper) provisioner(serverID string) (provisioning.Provisioner, error) {
+	if serverID == "" {
+		serverID = w.servers[0].ID
+	}
+	provisioner, ok := w.provisioners[serverID]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownServer, "server %q", serverID)
+	}
+	return provisioner, nil
 }
 
 // CreateConfigForNewKeys creates a config for new keys
-func (w *wireguardWrapper) CreateConfigForNewKeys(ctx context.Context, userID, subscriptionID int64, deviceName string) (io.Reader, string, string, error) {
-	result, err := w.provisioner.CreateDeviceWithNewKeys(ctx, userID, subscriptionID, deviceName)
+func (w *wireguardWrapper) CreateConfigForNewKeys(ctx context.Context, serverID string, userID, subscriptionID int64, deviceName string) (io.Reader, string, string, error) {
+	provisioner, err := w.provisioner(serverID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	result, err := provisioner.CreateDeviceWithNewKeys(ctx, userID, subscriptionID, deviceName)
 	if err != nil {
 		return nil, "", "", err
 	}
@@ -77,24 +170,70 @@ func (w *wireguardWrapper) CreateConfigForNewKeys(ctx context.Context, userID, s
 }
 
 // CreateConfigForPublicKey creates a config for existing public key
-func (w *wireguardWrapper) CreateConfigForPublicKey(ctx context.Context, key string, userID, subscriptionID int64, deviceName string) (io.Reader, string, error) {
-	result, err := w.provisioner.CreateDeviceWithPublicKey(ctx, key, userID, subscriptionID, deviceName)
+func (w *wireguardWrapper) CreateConfigForPublicKey(ctx context.Context, serverID string, key string, userID, subscriptionID int64, deviceName string) (io.Reader, string, error) {
+	provisioner, err := w.provisioner(serverID)
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := provisioner.CreateDeviceWithPublicKey(ctx, key, userID, subscriptionID, deviceName)
 	if err != nil {
 		return nil, "", err
 	}
 	return result.ConfigReader, result.AssignedIP, nil
 }
 
+// ResyncDevice re-adds existing device peer to its server interface without changing its key or addresses
+func (w *wireguardWrapper) ResyncDevice(ctx context.Context, device *storage.Device) error {
+	provisioner, err := w.provisioner(device.ServerID)
+	if err != nil {
+		return err
+	}
+	return provisioner.RestoreDevice(ctx, device.PeerPublicKey, device.AssignedIP, device.AssignedIPv6)
+}
+
+// RevokeDevice removes device peer from its server interface, device record is left to the caller
+func (w *wireguardWrapper) RevokeDevice(ctx context.Context, device *storage.Device) error {
+	provisioner, err := w.provisioner(device.ServerID)
+	if err != nil {
+		return err
+	}
+	return provisioner.RevokeDevice(ctx, device.PeerPublicKey)
+}
+
+// DeviceStats returns statistics of device peer on its server
+func (w *wireguardWrapper) DeviceStats(ctx context.Context, device *storage.Device) (*provisioning.DeviceStats, error) {
+	provisioner, err := w.provisioner(device.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	return provisioner.DeviceStats(ctx, device.PeerPublicKey)
+}
+
+// RecreateConfig rebuilds config of existing device, provisioning.ErrPrivateKeyNotStored
+// is returned if the device private key isn't stored. Returns true if config has no private key
+// because device was created with user supplied public key
+func (w *wireguardWrapper) RecreateConfig(ctx context.Context, device *storage.Device) (io.Reader, bool, error) {
+	provisioner, err := w.provisioner(device.ServerID)
+	if err != nil {
+		return nil, false, err
+	}
+	result, err := provisioner.RecreateConfig(ctx, device)
+	if err != nil {
+		return nil, false, err
+	}
+	return result.ConfigReader, result.ImportedKey, nil
+}
+
 // Legacy methods
 
 func (w *wireguardWrapper) CreateConfigForNewKeysLegacy() (io.Reader, error) {
 	ctx := context.Background()
-	reader, _, _, err := w.CreateConfigForNewKeys(ctx, 0, 0, "legacy")
+	reader, _, _, err := w.CreateConfigForNewKeys(ctx, "", 0, 0, "legacy")
 	return reader, err
 }
 
 func (w *wireguardWrapper) CreateConfigForPublicKeyLegacy(key string) (io.Reader, error) {
 	ctx := context.Background()
-	reader, _, err := w.CreateConfigForPublicKey(ctx, key, 0, 0, "legacy")
+	reader, _, err := w.CreateConfigForPublicKey(ctx, "", key, 0, 0, "legacy")
 	return reader, err
 }